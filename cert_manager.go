@@ -0,0 +1,233 @@
+package routeit
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The [CertKeyPaths] are the locations of a PEM encoded certificate (chain)
+// and its private key on disk.
+type CertKeyPaths struct {
+	CertPath string
+	KeyPath  string
+}
+
+type CertManagerConfig struct {
+	// The certificates the server can present to clients. The certificate
+	// presented is chosen using the server name the client asks for during
+	// the TLS handshake (SNI), which is matched against the DNS names of each
+	// certificate. Wildcard names such as "*.example.com" match a single
+	// label, so "api.example.com" but not "example.com" or
+	// "v1.api.example.com". Exact names are preferred over wildcards, and
+	// earlier certificates are preferred over later ones with the same name.
+	// The first certificate is used when the client does not send a server
+	// name, or when no certificate matches it. At least one is required.
+	Certificates []CertKeyPaths
+	// How often the certificate and key files are checked for changes. When
+	// any of the files has changed, all of the certificates are loaded again
+	// and replace the current certificates once they have all loaded
+	// successfully. Defaults to 1 minute.
+	PollInterval time.Duration
+}
+
+// A [CertManager] provides the certificates for a TLS server, picking between
+// several certificates using the server name the client asks for and
+// reloading them when their files change on disk. This means certificates can
+// be renewed without restarting the server. Set [HttpConfig.CertManager] for
+// the server to use the manager and watch its files for changes, in which
+// case the result of each reload is logged through the server's logger. The
+// manager can also be used with other servers through
+// [CertManager.TlsConfig], in which case [CertManager.Reload] must be called
+// to pick up changes.
+type CertManager struct {
+	paths    []CertKeyPaths
+	interval time.Duration
+	certs    atomic.Pointer[certSet]
+	// Guards against reloads running at the same time, which would race to
+	// replace the certificates.
+	mu sync.Mutex
+}
+
+// The certificates currently being served, indexed by the names they are
+// valid for.
+type certSet struct {
+	fallback  *tls.Certificate
+	exact     map[string]*tls.Certificate
+	wildcards map[string]*tls.Certificate
+	// The modification time and size of each file when it was loaded, used to
+	// spot that a file has changed.
+	files map[string]fileStamp
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// Constructs a new certificate manager, loading each of the certificates.
+// This will panic if no certificates are given or any of them cannot be
+// loaded, since the server cannot serve HTTPS requests without them.
+func NewCertManager(conf CertManagerConfig) *CertManager {
+	if len(conf.Certificates) == 0 {
+		panic("cannot create a certificate manager without any certificates")
+	}
+	if conf.PollInterval == 0 {
+		conf.PollInterval = time.Minute
+	}
+	cm := &CertManager{paths: conf.Certificates, interval: conf.PollInterval}
+	if err := cm.Reload(); err != nil {
+		panic(err)
+	}
+	return cm
+}
+
+// Returns a TLS config that presents the manager's certificates. Like
+// [NewTlsConfigForCertAndKey], this relies on the defaults of [crypto/tls].
+func (cm *CertManager) TlsConfig() *tls.Config {
+	return cm.apply(&tls.Config{})
+}
+
+// Picks the certificate for the server name the client asked for. This is
+// used as [tls.Config.GetCertificate] and always uses the most recently
+// loaded certificates.
+func (cm *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return cm.certs.Load().pick(hello.ServerName), nil
+}
+
+// Loads all of the certificates again, replacing the certificates being served
+// once they have all loaded successfully. If any fail to load, the current
+// certificates continue to be served and the error is returned.
+func (cm *CertManager) Reload() error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	set, err := loadCertSet(cm.paths)
+	if err != nil {
+		return err
+	}
+	cm.certs.Store(set)
+	return nil
+}
+
+// Uses the manager's certificates in place of any certificates in the config.
+func (cm *CertManager) apply(conf *tls.Config) *tls.Config {
+	conf = conf.Clone()
+	conf.Certificates = nil
+	conf.GetCertificate = cm.GetCertificate
+	return conf
+}
+
+// Checks the files for changes every poll interval until done is closed,
+// reloading the certificates whenever they have changed.
+func (cm *CertManager) watch(done <-chan struct{}, log *logger) {
+	ticker := time.NewTicker(cm.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		if !cm.certs.Load().changed() {
+			continue
+		}
+		if err := cm.Reload(); err != nil {
+			log.Error("Failed to reload TLS certificates, continuing with the current certificates", "err", err)
+			continue
+		}
+		log.Info("Reloaded TLS certificates", slog.Any("names", cm.certs.Load().names()))
+	}
+}
+
+func loadCertSet(paths []CertKeyPaths) (*certSet, error) {
+	set := &certSet{
+		exact:     map[string]*tls.Certificate{},
+		wildcards: map[string]*tls.Certificate{},
+		files:     map[string]fileStamp{},
+	}
+	for _, p := range paths {
+		// The files are stat'd before they are read, so a file that changes
+		// while we load it will be seen as changed and loaded again.
+		for _, f := range []string{p.CertPath, p.KeyPath} {
+			info, err := os.Stat(f)
+			if err != nil {
+				return nil, fmt.Errorf(`failed to load X509 key pair: %w`, err)
+			}
+			set.files[f] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+		cert, err := tls.LoadX509KeyPair(p.CertPath, p.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to load X509 key pair: %w`, err)
+		}
+		if cert.Leaf == nil {
+			return nil, errors.New("failed to load X509 key pair: certificate could not be parsed")
+		}
+		set.add(&cert)
+	}
+	return set, nil
+}
+
+func (set *certSet) add(cert *tls.Certificate) {
+	if set.fallback == nil {
+		set.fallback = cert
+	}
+	for _, name := range cert.Leaf.DNSNames {
+		name = strings.ToLower(name)
+		index := set.exact
+		if rest, ok := strings.CutPrefix(name, "*."); ok {
+			name, index = rest, set.wildcards
+		}
+		if _, exists := index[name]; !exists {
+			index[name] = cert
+		}
+	}
+}
+
+// Picks the certificate for the server name, which is empty when the client
+// did not send one.
+func (set *certSet) pick(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return set.fallback
+	}
+	if cert, ok := set.exact[name]; ok {
+		return cert
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := set.wildcards[parent]; ok {
+			return cert
+		}
+	}
+	return set.fallback
+}
+
+func (set *certSet) changed() bool {
+	for f, stamp := range set.files {
+		info, err := os.Stat(f)
+		if err != nil || !info.ModTime().Equal(stamp.modTime) || info.Size() != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// The names the certificates are valid for, used to log which certificates
+// are being served.
+func (set *certSet) names() []string {
+	names := make([]string, 0, len(set.exact)+len(set.wildcards))
+	for name := range set.exact {
+		names = append(names, name)
+	}
+	for name := range set.wildcards {
+		names = append(names, "*."+name)
+	}
+	slices.Sort(names)
+	return names
+}
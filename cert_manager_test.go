@@ -0,0 +1,183 @@
+package routeit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertManagerGetCertificate(t *testing.T) {
+	dir := t.TempDir()
+	cm := NewCertManager(CertManagerConfig{
+		Certificates: []CertKeyPaths{
+			writeTestCert(t, dir, "default", "example.com", "www.example.com"),
+			writeTestCert(t, dir, "wildcard", "*.example.com"),
+			writeTestCert(t, dir, "other", "example.org", "*.example.com"),
+		},
+	})
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"", "default"},
+		{"example.com", "default"},
+		{"www.example.com", "default"},
+		{"WWW.Example.COM.", "default"},
+		{"api.example.com", "wildcard"},
+		{"v1.api.example.com", "default"},
+		{"example.org", "other"},
+		{"unknown.net", "default"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.serverName, func(t *testing.T) {
+			cert, err := cm.GetCertificate(&tls.ClientHelloInfo{ServerName: tc.serverName})
+
+			if err != nil {
+				t.Fatalf(`err = %v, wanted nil`, err)
+			}
+			if got := cert.Leaf.Subject.CommonName; got != tc.want {
+				t.Errorf(`certificate = %#q, wanted %#q`, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCertManagerReload(t *testing.T) {
+	commonName := func(t *testing.T, cm *CertManager) string {
+		t.Helper()
+		cert, _ := cm.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+		return cert.Leaf.Subject.CommonName
+	}
+
+	t.Run("replaces certificates", func(t *testing.T) {
+		dir := t.TempDir()
+		cm := NewCertManager(CertManagerConfig{
+			Certificates: []CertKeyPaths{writeTestCert(t, dir, "old", "example.com")},
+		})
+		writeTestCert(t, dir, "new", "example.com")
+
+		if err := cm.Reload(); err != nil {
+			t.Fatalf(`Reload() = %v, wanted nil`, err)
+		}
+
+		if got := commonName(t, cm); got != "new" {
+			t.Errorf(`certificate = %#q, wanted "new"`, got)
+		}
+	})
+
+	t.Run("keeps certificates on failure", func(t *testing.T) {
+		dir := t.TempDir()
+		paths := writeTestCert(t, dir, "old", "example.com")
+		cm := NewCertManager(CertManagerConfig{Certificates: []CertKeyPaths{paths}})
+		os.WriteFile(paths.KeyPath, []byte("not a key"), 0o600)
+
+		if err := cm.Reload(); err == nil {
+			t.Fatal(`Reload() = nil, wanted an error`)
+		}
+
+		if got := commonName(t, cm); got != "old" {
+			t.Errorf(`certificate = %#q, wanted "old"`, got)
+		}
+	})
+
+	t.Run("watches for changes", func(t *testing.T) {
+		dir := t.TempDir()
+		cm := NewCertManager(CertManagerConfig{
+			Certificates: []CertKeyPaths{writeTestCert(t, dir, "old", "example.com")},
+			PollInterval: 5 * time.Millisecond,
+		})
+		done := make(chan struct{})
+		defer close(done)
+		go cm.watch(done, newLogger(slog.DiscardHandler, false, nil))
+		// Ensure the modification time changes even on file systems with
+		// coarse timestamps.
+		paths := writeTestCert(t, dir, "new", "example.com")
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(paths.CertPath, future, future)
+
+		deadline := time.Now().Add(time.Second)
+		for commonName(t, cm) != "new" {
+			if time.Now().After(deadline) {
+				t.Fatal("certificates were not reloaded")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func TestNewCertManagerPanics(t *testing.T) {
+	tests := []struct {
+		name string
+		conf CertManagerConfig
+	}{
+		{name: "no certificates"},
+		{
+			name: "missing files",
+			conf: CertManagerConfig{Certificates: []CertKeyPaths{{CertPath: "missing.crt", KeyPath: "missing.key"}}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected panic")
+				}
+			}()
+			NewCertManager(tc.conf)
+		})
+	}
+}
+
+// Writes a self-signed certificate for the DNS names, and its key, to the
+// directory, overwriting any previous certificate with the same DNS names.
+// The name is used as the certificate's common name so tests can tell
+// certificates apart.
+func writeTestCert(t *testing.T, dir, name string, dnsNames ...string) CertKeyPaths {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	paths := CertKeyPaths{
+		CertPath: filepath.Join(dir, dnsNames[0]+".crt"),
+		KeyPath:  filepath.Join(dir, dnsNames[0]+".key"),
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(paths.CertPath, certPem, 0o600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(paths.KeyPath, keyPem, 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return paths
+}
@@ -0,0 +1,165 @@
+package routeit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+)
+
+// The [ClientCertMode] controls whether clients are asked for a certificate
+// during the TLS handshake, and what happens when they do not send one.
+type ClientCertMode int
+
+const (
+	// Clients are asked for a certificate, but may choose not to send one.
+	// Certificates that are sent must be signed by one of the client CAs.
+	// This suits servers where only some routes require a client certificate,
+	// which can then be enforced using [ClientCertMiddleware].
+	ClientCertOptional ClientCertMode = iota
+	// Clients must send a certificate signed by one of the client CAs, and
+	// the TLS handshake fails if they do not.
+	ClientCertRequired
+)
+
+type ClientCertConfig struct {
+	// The certificate authorities that client certificates must be signed by.
+	CAs *x509.CertPool
+	// The paths of PEM encoded certificate authorities that client
+	// certificates must be signed by, which are added to
+	// [ClientCertConfig.CAs]. Server setup will panic if any of these cannot
+	// be loaded.
+	CAFiles []string
+	// Whether clients must send a certificate. Defaults to
+	// [ClientCertOptional].
+	Mode ClientCertMode
+}
+
+// The [ClientIdentity] describes the client that made the request, taken from
+// the certificate the client sent during the TLS handshake. This is only ever
+// built from certificates that have been verified against the client CAs.
+type ClientIdentity struct {
+	// The subject of the certificate, such as its common name and
+	// organisation.
+	Subject pkix.Name
+	// The subject alternative names of the certificate.
+	DNSNames       []string
+	EmailAddresses []string
+	IPAddresses    []net.IP
+	URIs           []*url.URL
+	// The SPIFFE ID of the client, which is the first URI subject alternative
+	// name with the "spiffe" scheme, such as
+	// "spiffe://example.org/ns/default/sa/api". This is nil if the
+	// certificate does not have one.
+	SpiffeID *url.URL
+	// The certificate the identity was taken from.
+	Certificate *x509.Certificate
+}
+
+type ClientCertAuthConfig struct {
+	// Decides whether the request requires a client certificate, which is
+	// commonly based on the route of the request. Requests that require a
+	// certificate and do not have a verified certificate are rejected with a
+	// 401: Unauthorized response. When nil, all requests require a client
+	// certificate.
+	RequireFunc func(req *Request) bool
+	// Decides whether the client is allowed to make the request, once it has
+	// been found to have a verified certificate. This is only called for
+	// requests that require a certificate, and requests that are not allowed
+	// are rejected with a 403: Forbidden response. When nil, all clients with
+	// a verified certificate are allowed.
+	Authorise func(req *Request, id *ClientIdentity) bool
+}
+
+type clientIdentityKey struct{}
+
+// Returns middleware that authenticates clients using the certificate they
+// sent during the TLS handshake. The identity of clients with a verified
+// certificate is stored on the request, and can be accessed using
+// [ClientIdentityFrom]. The server should be configured to ask clients for
+// certificates using [HttpConfig.ClientCerts], otherwise clients will never
+// send one.
+func ClientCertMiddleware(cc ClientCertAuthConfig) Middleware {
+	return func(c Chain, rw *ResponseWriter, req *Request) error {
+		id, hasId := clientIdentity(req.Tls())
+		if hasId {
+			req.NewContextValue(clientIdentityKey{}, id)
+		}
+		if cc.RequireFunc != nil && !cc.RequireFunc(req) {
+			return c.Proceed(rw, req)
+		}
+		if !hasId {
+			return ErrUnauthorized()
+		}
+		if cc.Authorise != nil && !cc.Authorise(req, id) {
+			return ErrForbidden()
+		}
+		return c.Proceed(rw, req)
+	}
+}
+
+// Access the identity of the client, as found by [ClientCertMiddleware]. This
+// returns false if the client did not send a verified certificate, or the
+// middleware has not been registered.
+func ClientIdentityFrom(req *Request) (*ClientIdentity, bool) {
+	return ContextValueAs[*ClientIdentity](req, clientIdentityKey{})
+}
+
+// Builds the identity from the verified certificate of the connection.
+// Certificates that have not been verified, which can be the case when the
+// server is configured to ask for certificates without verifying them, are
+// ignored since they could have been created by anyone.
+func clientIdentity(state *tls.ConnectionState) (*ClientIdentity, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := state.VerifiedChains[0][0]
+	id := &ClientIdentity{
+		Subject:        cert.Subject,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		IPAddresses:    cert.IPAddresses,
+		URIs:           cert.URIs,
+		Certificate:    cert,
+	}
+	for _, uri := range cert.URIs {
+		if uri.Scheme == "spiffe" {
+			id.SpiffeID = uri
+			break
+		}
+	}
+	return id, true
+}
+
+// Asks clients for certificates when they connect, verifying them against the
+// client CAs. This will panic if any of the CA files cannot be loaded.
+func (cc ClientCertConfig) apply(conf *tls.Config) *tls.Config {
+	pool := cc.CAs
+	if pool == nil {
+		pool = x509.NewCertPool()
+	} else {
+		pool = pool.Clone()
+	}
+	for _, f := range cc.CAFiles {
+		pem, err := os.ReadFile(f)
+		if err != nil {
+			panic(fmt.Errorf(`failed to load client CA: %w`, err))
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			panic(fmt.Errorf(`failed to load client CA: no certificates found in %#q`, f))
+		}
+	}
+
+	conf = conf.Clone()
+	conf.ClientCAs = pool
+	switch cc.Mode {
+	case ClientCertRequired:
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		conf.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return conf
+}
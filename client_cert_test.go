@@ -0,0 +1,210 @@
+package routeit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientCertMiddleware(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://example.org/ns/default/sa/api")
+	web, _ := url.Parse("https://example.org/api")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "api", Organization: []string{"Example"}},
+		DNSNames: []string{"api.example.org"},
+		URIs:     []*url.URL{web, spiffe},
+	}
+	verified := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	onlyAdmin := func(req *Request) bool { return req.Path() == "/admin" }
+	tests := []struct {
+		name         string
+		path         string
+		conf         ClientCertAuthConfig
+		tls          *tls.ConnectionState
+		wantProceed  bool
+		wantStatus   HttpStatus
+		wantIdentity bool
+	}{
+		{
+			name:         "verified certificate",
+			path:         "/admin",
+			tls:          verified,
+			wantProceed:  true,
+			wantIdentity: true,
+		},
+		{
+			name:       "plain http",
+			path:       "/admin",
+			wantStatus: StatusUnauthorized,
+		},
+		{
+			name:       "no certificate",
+			path:       "/admin",
+			tls:        &tls.ConnectionState{},
+			wantStatus: StatusUnauthorized,
+		},
+		{
+			name:       "unverified certificate",
+			path:       "/admin",
+			tls:        unverified,
+			wantStatus: StatusUnauthorized,
+		},
+		{
+			name:        "route not requiring certificate",
+			path:        "/public",
+			conf:        ClientCertAuthConfig{RequireFunc: onlyAdmin},
+			tls:         &tls.ConnectionState{},
+			wantProceed: true,
+		},
+		{
+			name:         "route not requiring certificate still has identity",
+			path:         "/public",
+			conf:         ClientCertAuthConfig{RequireFunc: onlyAdmin},
+			tls:          verified,
+			wantProceed:  true,
+			wantIdentity: true,
+		},
+		{
+			name: "authorised",
+			path: "/admin",
+			conf: ClientCertAuthConfig{Authorise: func(req *Request, id *ClientIdentity) bool {
+				return id.SpiffeID != nil && id.SpiffeID.Path == "/ns/default/sa/api"
+			}},
+			tls:          verified,
+			wantProceed:  true,
+			wantIdentity: true,
+		},
+		{
+			name: "not authorised",
+			path: "/admin",
+			conf: ClientCertAuthConfig{Authorise: func(req *Request, id *ClientIdentity) bool {
+				return id.Subject.CommonName == "admin"
+			}},
+			tls:          verified,
+			wantStatus:   StatusForbidden,
+			wantIdentity: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := NewTestRequest(t, tc.path, GET, TestRequestOptions{TlsConnectionState: tc.tls})
+
+			_, proceeded, err := TestMiddleware(ClientCertMiddleware(tc.conf), req)
+
+			if proceeded != tc.wantProceed {
+				t.Errorf(`proceeded = %t, wanted %t`, proceeded, tc.wantProceed)
+			}
+			if tc.wantProceed && err != nil {
+				t.Errorf(`err = %v, wanted nil`, err)
+			}
+			if !tc.wantProceed {
+				httpErr, ok := err.(*HttpError)
+				if !ok || httpErr.status != tc.wantStatus {
+					t.Errorf(`err = %v, wanted %d`, err, tc.wantStatus.code)
+				}
+			}
+			id, hasId := ClientIdentityFrom(req.req)
+			if hasId != tc.wantIdentity {
+				t.Fatalf(`has identity = %t, wanted %t`, hasId, tc.wantIdentity)
+			}
+			if !hasId {
+				return
+			}
+			if id.Subject.CommonName != "api" || id.DNSNames[0] != "api.example.org" {
+				t.Errorf(`identity = %+v, wanted the certificate's subject and names`, id)
+			}
+			if id.SpiffeID != spiffe {
+				t.Errorf(`SpiffeID = %v, wanted %v`, id.SpiffeID, spiffe)
+			}
+		})
+	}
+}
+
+func TestClientCertMiddlewareEndToEnd(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "api"}}
+	srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+	srv.RegisterMiddleware(ClientCertMiddleware(ClientCertAuthConfig{}))
+	srv.RegisterRoutes(RouteRegistry{"/whoami": Get(func(rw *ResponseWriter, req *Request) error {
+		id, _ := ClientIdentityFrom(req)
+		rw.Text(id.Subject.CommonName)
+		return nil
+	})})
+
+	t.Run("verified certificate", func(t *testing.T) {
+		client := NewTestTlsClient(srv, &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+
+		res := client.Get("/whoami")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, "api")
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		client := NewTestTlsClient(srv, &tls.ConnectionState{})
+
+		res := client.Get("/whoami")
+
+		res.AssertStatusCode(t, StatusUnauthorized)
+	})
+}
+
+func TestClientCertConfigApply(t *testing.T) {
+	paths := writeTestCert(t, t.TempDir(), "ca", "ca.example.org")
+
+	tests := []struct {
+		name     string
+		conf     ClientCertConfig
+		wantAuth tls.ClientAuthType
+	}{
+		{
+			name:     "optional by default",
+			conf:     ClientCertConfig{CAFiles: []string{paths.CertPath}},
+			wantAuth: tls.VerifyClientCertIfGiven,
+		},
+		{
+			name:     "required",
+			conf:     ClientCertConfig{CAs: x509.NewCertPool(), CAFiles: []string{paths.CertPath}, Mode: ClientCertRequired},
+			wantAuth: tls.RequireAndVerifyClientCert,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			in := &tls.Config{ServerName: "example.org"}
+
+			out := tc.conf.apply(in)
+
+			if out.ClientAuth != tc.wantAuth {
+				t.Errorf(`ClientAuth = %v, wanted %v`, out.ClientAuth, tc.wantAuth)
+			}
+			if out.ClientCAs == nil || out.ClientCAs.Equal(x509.NewCertPool()) {
+				t.Error("expected the CA file to be added to the client CAs")
+			}
+			if out.ServerName != "example.org" || in.ClientCAs != nil {
+				t.Error("expected the rest of the config to be copied and the original left alone")
+			}
+		})
+	}
+
+	t.Run("rejects files without certificates", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "empty.crt")
+		os.WriteFile(path, []byte("not a certificate"), 0o600)
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic")
+			}
+		}()
+
+		ClientCertConfig{CAFiles: []string{path}}.apply(&tls.Config{})
+	})
+}
@@ -0,0 +1,171 @@
+package routeit
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sets the ETag header of the response to a strong entity tag (RFC-9110 Sec
+// 8.8.3), which identifies the exact version of the content being sent. Strong
+// tags must change whenever any byte of the content changes. The tag is
+// quoted for you, and cannot contain quotes, whitespace or control
+// characters. Responses to GET and HEAD requests with an entity tag are
+// checked against the If-None-Match and If-Match headers of the request, so
+// clients that already have this version receive 304: Not Modified instead of
+// the content again.
+func (rw *ResponseWriter) ETag(tag string) {
+	rw.headers.Set("ETag", `"`+validEntityTag(tag)+`"`)
+}
+
+// Sets the ETag header of the response to a weak entity tag, which only
+// changes when the meaning of the content changes, such as a page that
+// includes the current time. Weak tags are enough for clients to avoid
+// downloading the content again, but do not match the If-Match or If-Range
+// headers, which need the content to be byte for byte the same.
+func (rw *ResponseWriter) WeakETag(tag string) {
+	rw.headers.Set("ETag", `W/"`+validEntityTag(tag)+`"`)
+}
+
+// Sets the Last-Modified header of the response (RFC-9110 Sec 8.8.2), which
+// allows clients to ask for the content only if it has changed since they
+// last saw it using the If-Modified-Since header. Times in the future are
+// replaced with the current time, and the header is only precise to the
+// second.
+func (rw *ResponseWriter) LastModified(t time.Time) {
+	if now := time.Now(); t.After(now) {
+		t = now
+	}
+	rw.headers.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+}
+
+// Evaluates the conditional headers of the request (RFC-9110 Sec 13.2)
+// against the ETag and Last-Modified headers already set on the response.
+// This is done for you once the handler returns a successful response to a
+// GET or HEAD request, so handlers only need to set the validators. Calling
+// this sooner avoids the work of building a response the client already has,
+// in which case the response becomes 304: Not Modified and true is returned,
+// after which the handler should return without setting a body.
+//
+// Handlers that change a resource, such as those for PUT or DELETE requests,
+// must call this themselves with the validators of the resource as it
+// currently is, before they change it. When the If-Match, If-Unmodified-Since
+// or If-None-Match headers rule out the change, a 412: Precondition Failed
+// error is returned, which the handler should return as is. This prevents
+// clients from overwriting changes they have not seen. A resource that does
+// not exist yet should have no validators set.
+//
+// The request's conditions are ignored once the response has started
+// streaming, or if it does not have a 2xx status.
+func (rw *ResponseWriter) CheckPreconditions(req *Request) (bool, error) {
+	if !rw.s.Is2xx() || rw.streaming() || rw.hijacked() {
+		return false, nil
+	}
+	safe := req.mthd == GET || req.mthd == HEAD
+	etag := rw.validator("ETag")
+	// A successful response to a GET request means there is a current
+	// representation, otherwise the handler tells us through the validators.
+	exists := safe || etag != "" || rw.validator("Last-Modified") != ""
+
+	h := req.Headers()
+	if match, found := h.All("If-Match"); found {
+		if !matchesEntityTag(match, etag, exists, true) {
+			return false, ErrPreconditionFailed()
+		}
+	} else if since, found := h.Last("If-Unmodified-Since"); found {
+		if modified, ok := rw.modifiedSince(since); ok && modified {
+			return false, ErrPreconditionFailed()
+		}
+	}
+
+	if noneMatch, found := h.All("If-None-Match"); found {
+		if matchesEntityTag(noneMatch, etag, exists, false) {
+			if !safe {
+				return false, ErrPreconditionFailed()
+			}
+			rw.notModified()
+			return true, nil
+		}
+	} else if since, found := h.Last("If-Modified-Since"); found && safe {
+		if modified, ok := rw.modifiedSince(since); ok && !modified {
+			rw.notModified()
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Turns the response into a 304: Not Modified. The response keeps the
+// headers the client needs to update its cached copy, such as the ETag and
+// Cache-Control, but loses those that describe the body.
+func (rw *ResponseWriter) notModified() {
+	rw.Status(StatusNotModified)
+	rw.clear()
+	delete(rw.headers.headers, "content-range")
+}
+
+// Reports whether the response's Last-Modified header is later than the given
+// HTTP date. The result is not ok if either time is missing or invalid, in
+// which case the condition must be ignored.
+func (rw *ResponseWriter) modifiedSince(raw string) (modified bool, ok bool) {
+	lastModified := rw.validator("Last-Modified")
+	if lastModified == "" {
+		return false, false
+	}
+	t, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false, false
+	}
+	since, err := http.ParseTime(strings.TrimSpace(raw))
+	if err != nil {
+		return false, false
+	}
+	return t.After(since), true
+}
+
+func (rw *ResponseWriter) validator(key string) string {
+	vals, _ := rw.headers.headers.All(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(vals[0])
+}
+
+// Reports whether any of the entity tags in the header values match the
+// response's tag. "*" matches any current representation. The strong
+// comparison only matches tags that are both strong and identical, while the
+// weak comparison ignores whether the tags are weak (RFC-9110 Sec 8.8.3.2).
+func matchesEntityTag(vals []string, etag string, exists, strong bool) bool {
+	for _, v := range vals {
+		for _, tag := range splitQuoted(v, ',') {
+			switch {
+			case tag == "*":
+				if exists {
+					return true
+				}
+			case etag == "":
+			case strong:
+				if !strings.HasPrefix(tag, "W/") && tag == etag {
+					return true
+				}
+			default:
+				if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Entity tags may contain any visible character other than a quote (RFC-9110
+// Sec 8.8.3).
+func validEntityTag(tag string) string {
+	for i := 0; i < len(tag); i++ {
+		if c := tag[i]; c == '"' || c <= ' ' || c == 0x7f {
+			panic(fmt.Errorf("invalid entity tag: %q", tag))
+		}
+	}
+	return tag
+}
@@ -0,0 +1,178 @@
+package routeit
+
+import (
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestConditionalRequests(t *testing.T) {
+	const (
+		modified = "Tue, 01 Jul 2025 10:00:00 GMT"
+		earlier  = "Mon, 30 Jun 2025 10:00:00 GMT"
+		later    = "Wed, 02 Jul 2025 10:00:00 GMT"
+	)
+	lastModified, _ := time.Parse(time.RFC1123, modified)
+	srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+	srv.RegisterRoutes(RouteRegistry{
+		"/strong": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.ETag("v1")
+			rw.LastModified(lastModified)
+			rw.Headers().Set("Cache-Control", "no-cache")
+			rw.Text("hello")
+			return nil
+		}),
+		"/weak": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.WeakETag("v1")
+			rw.Text("hello")
+			return nil
+		}),
+		"/none": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Text("hello")
+			return nil
+		}),
+		"/missing": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.ETag("v1")
+			return ErrNotFound()
+		}),
+		"/resource": MultiMethod(MultiMethodHandler{
+			Put: func(rw *ResponseWriter, req *Request) error {
+				if q, _ := req.Queries().First("exists"); q == "true" {
+					rw.ETag("v1")
+				}
+				if _, err := rw.CheckPreconditions(req); err != nil {
+					return err
+				}
+				rw.ETag("v2")
+				rw.Status(StatusOK)
+				rw.Text("updated")
+				return nil
+			},
+		}),
+	})
+	client := NewTestClient(srv)
+
+	t.Run("safe methods", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			path    string
+			headers []string
+			want    HttpStatus
+		}{
+			{"no conditions", "/strong", nil, StatusOK},
+			{"If-None-Match matches", "/strong", []string{"If-None-Match", `"v1"`}, StatusNotModified},
+			{"If-None-Match one of many", "/strong", []string{"If-None-Match", `"v0", W/"v1"`}, StatusNotModified},
+			{"If-None-Match weak", "/weak", []string{"If-None-Match", `"v1"`}, StatusNotModified},
+			{"If-None-Match star", "/none", []string{"If-None-Match", "*"}, StatusNotModified},
+			{"If-None-Match differs", "/strong", []string{"If-None-Match", `"v0"`}, StatusOK},
+			{"If-None-Match without validator", "/none", []string{"If-None-Match", `"v1"`}, StatusOK},
+			{"If-Modified-Since same", "/strong", []string{"If-Modified-Since", modified}, StatusNotModified},
+			{"If-Modified-Since later", "/strong", []string{"If-Modified-Since", later}, StatusNotModified},
+			{"If-Modified-Since earlier", "/strong", []string{"If-Modified-Since", earlier}, StatusOK},
+			{"If-Modified-Since invalid", "/strong", []string{"If-Modified-Since", "yesterday"}, StatusOK},
+			{"If-Modified-Since without validator", "/none", []string{"If-Modified-Since", later}, StatusOK},
+			{
+				"If-None-Match takes precedence",
+				"/strong",
+				[]string{"If-None-Match", `"v0"`, "If-Modified-Since", later},
+				StatusOK,
+			},
+			{"If-Match matches", "/strong", []string{"If-Match", `"v1"`}, StatusOK},
+			{"If-Match star", "/none", []string{"If-Match", "*"}, StatusOK},
+			{"If-Match differs", "/strong", []string{"If-Match", `"v0"`}, StatusPreconditionFailed},
+			{"If-Match weak", "/weak", []string{"If-Match", `W/"v1"`}, StatusPreconditionFailed},
+			{"If-Unmodified-Since later", "/strong", []string{"If-Unmodified-Since", later}, StatusOK},
+			{"If-Unmodified-Since earlier", "/strong", []string{"If-Unmodified-Since", earlier}, StatusPreconditionFailed},
+			{
+				"If-Match takes precedence",
+				"/strong",
+				[]string{"If-Match", `"v1"`, "If-Unmodified-Since", earlier},
+				StatusOK,
+			},
+			{"error response", "/missing", []string{"If-None-Match", `"v1"`}, StatusNotFound},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res := client.Get(tc.path, tc.headers...)
+
+				res.AssertStatusCode(t, tc.want)
+				if tc.want == StatusNotModified {
+					res.AssertBodyEmpty(t)
+					res.RefuteHeaderPresent(t, "Content-Length")
+					res.RefuteHeaderPresent(t, "Content-Type")
+				}
+			})
+		}
+	})
+
+	t.Run("not modified keeps cache headers", func(t *testing.T) {
+		res := client.Head("/strong", "If-None-Match", `"v1"`)
+
+		res.AssertStatusCode(t, StatusNotModified)
+		res.AssertHeaderMatchesString(t, "ETag", `"v1"`)
+		res.AssertHeaderMatchesString(t, "Last-Modified", modified)
+		res.AssertHeaderMatchesString(t, "Cache-Control", "no-cache")
+	})
+
+	t.Run("unsafe methods", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			path    string
+			headers []string
+			want    HttpStatus
+		}{
+			{"If-Match matches", "/resource?exists=true", []string{"If-Match", `"v1"`}, StatusOK},
+			{"If-Match stale", "/resource?exists=true", []string{"If-Match", `"v0"`}, StatusPreconditionFailed},
+			{"If-Match star missing", "/resource", []string{"If-Match", "*"}, StatusPreconditionFailed},
+			{"If-None-Match star exists", "/resource?exists=true", []string{"If-None-Match", "*"}, StatusPreconditionFailed},
+			{"If-None-Match star missing", "/resource", []string{"If-None-Match", "*"}, StatusOK},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res := client.PutText(tc.path, "body", tc.headers...)
+
+				res.AssertStatusCode(t, tc.want)
+			})
+		}
+	})
+}
+
+func TestValidators(t *testing.T) {
+	t.Run("entity tags", func(t *testing.T) {
+		rw := newResponse()
+
+		rw.ETag("abc-123")
+		if got := rw.validator("ETag"); got != `"abc-123"` {
+			t.Errorf(`ETag = %q, wanted "abc-123" quoted`, got)
+		}
+		rw.WeakETag("abc")
+		if got := rw.validator("ETag"); got != `W/"abc"` {
+			t.Errorf(`ETag = %q, wanted W/"abc"`, got)
+		}
+	})
+
+	t.Run("invalid entity tags", func(t *testing.T) {
+		for _, tag := range []string{`a"b`, "a b", "a\nb"} {
+			t.Run(tag, func(t *testing.T) {
+				defer func() {
+					if recover() == nil {
+						t.Error("expected panic")
+					}
+				}()
+
+				newResponse().ETag(tag)
+			})
+		}
+	})
+
+	t.Run("last modified in the future", func(t *testing.T) {
+		rw := newResponse()
+
+		rw.LastModified(time.Now().Add(time.Hour))
+
+		got, err := time.Parse(time.RFC1123, rw.validator("Last-Modified"))
+		if err != nil || got.After(time.Now()) {
+			t.Errorf(`Last-Modified = %q, wanted no later than now`, rw.validator("Last-Modified"))
+		}
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log/slog"
+	"net"
+	"net/netip"
 	"time"
 )
 
@@ -13,20 +15,68 @@ const (
 	MiB              = 1024 * KiB
 )
 
-// The [RequestSize] type is used to define what size request the server is
-// willing to accept from the client. It accounts for the entire request -
-// including the request line and headers.
+// The [RequestSize] type is used to define what size requests the server is
+// willing to accept from the client.
 type RequestSize uint32
 
 type ServerConfig struct {
-	// The maximum request size (headers, request line and body inclusive) that
-	// the server will accept. Anything above this will be rejected.
+	// The maximum request body size that the server will accept. Requests
+	// with bodies larger than this will be rejected with a 413: Content Too
+	// Large response. The request line and headers are limited separately by
+	// [ServerConfig.MaxHeaderSize]. Defaults to 1 KiB.
 	RequestSize RequestSize
+	// The maximum combined size of the request line and headers that the
+	// server will accept. Requests whose request line alone exceeds this are
+	// rejected with a 414: URI Too Long response, otherwise requests that
+	// exceed this are rejected with a 431: Request Header Fields Too Large
+	// response. Defaults to 32 KiB.
+	MaxHeaderSize RequestSize
 	// The read deadline to leave the connection with the client open for.
 	ReadDeadline time.Duration
 	// The write deadline that the connection is left open with the client
 	// for responses.
 	WriteDeadline time.Duration
+	// HTTP/1.1 connections are persistent by default, meaning the client can
+	// send multiple requests over the same connection. The IdleTimeout is how
+	// long the server will keep an idle connection open while waiting for the
+	// client's next request. Once the first byte of the next request arrives,
+	// [ServerConfig.ReadDeadline] applies to the rest of the request. Defaults
+	// to the ReadDeadline.
+	IdleTimeout time.Duration
+	// The maximum number of requests that will be served over a single
+	// persistent connection. Once reached, the server will include the
+	// "Connection: close" header in its response and close the connection.
+	// Set to 1 to disable persistent connections entirely. Defaults to 1000.
+	MaxRequestsPerConnection uint
+	// The maximum number of requests a client may have in flight at once over
+	// a single HTTP/2 connection. Further requests are refused, and the
+	// client retries them once an earlier request has finished. Only
+	// relevant when [HttpConfig.EnableHttp2] is set. Defaults to 100.
+	MaxConcurrentStreams uint32
+	// The maximum number of connections the server will serve at once. Once
+	// reached, new connections wait up to [ServerConfig.OverloadQueueTimeout]
+	// for another connection to close, after which they are sent a 503:
+	// Service Unavailable response and closed. TLS connections are closed
+	// without a response, since it would require completing the handshake.
+	// Defaults to 0, meaning there is no limit.
+	MaxConnections uint
+	// The maximum number of requests the server will handle at once, across
+	// all connections. Once reached, new requests wait up to
+	// [ServerConfig.OverloadQueueTimeout] for another request to finish,
+	// after which they are rejected with a 503: Service Unavailable response.
+	// Requests whose handler has taken over the connection, such as
+	// WebSockets, count towards this limit until the handler returns.
+	// Defaults to 0, meaning there is no limit.
+	MaxInFlightRequests uint
+	// How long connections and requests wait for capacity once
+	// [ServerConfig.MaxConnections] or [ServerConfig.MaxInFlightRequests] has
+	// been reached. Defaults to 0, meaning excess work is rejected straight
+	// away.
+	OverloadQueueTimeout time.Duration
+	// How long clients that are turned away because the server is at capacity
+	// are told to wait before trying again, through the Retry-After header.
+	// Rounded up to the nearest second. Defaults to 1 second.
+	OverloadRetryAfter time.Duration
 	// A global namespace that **all** routes are registered under. Common
 	// examples include /api. Does not need to include a leading slash. The
 	// global namespace may not contain dynamic routing segments - e.g. a
@@ -41,6 +91,9 @@ type ServerConfig struct {
 	// this allows the server to dynamically write to disk and serve files from
 	// there, though this is discouraged. The path is interpreted as a relative
 	// path, not an absolute path, regardless of the presence of a leading slash.
+	// Files in the directory are served ahead of any route registered under
+	// the same path. Use [Server.RegisterStatic] to serve files from
+	// elsewhere, such as files embedded in the binary.
 	StaticDir string
 	// Enables debug information, such as logs. Do not enable for production
 	// servers. Example behaviour includes logging request bodies for 4xx or
@@ -72,6 +125,19 @@ type ServerConfig struct {
 	// this defaults to [".localhost", "127.0.0.1", "[::1]"] if no list is
 	// specified.
 	AllowedHosts []string
+	// The address ranges of reverse proxies and load balancers that sit in
+	// front of the server, in CIDR notation such as "10.0.0.0/8". Single
+	// addresses, such as "10.0.0.5", are also accepted. Requests received from
+	// these ranges have their Forwarded (RFC-7239) header, or their
+	// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers when
+	// there is no Forwarded header, read to find the client's address, the
+	// scheme and the host the client used. These are then used by
+	// [Request.ClientIP], [Request.Scheme] and [Request.Host], the
+	// AllowedHosts validation, the request logs and the HTTPS upgrade
+	// controlled by [HttpConfig.UpgradeToHttps]. The headers are ignored for
+	// requests from anywhere else, since they could have been sent by the
+	// client. Server setup will panic if a range is invalid.
+	TrustedProxies []string
 	// When enabled, the server will only return responses to the client that
 	// strictly match the client's Accept header, if it is included in the
 	// request. If the application code returns a non-compliant response
@@ -131,7 +197,10 @@ type ServerConfig struct {
 // communication to HTTPS, controlled using [HttpConfig.UpgradeToHttps] and
 // [HttpConfig.UpgradeInstructionMaxAge]. If a HTTP port is not selected, but
 // UpgradeToHttps is enabled, the server will listen for HTTP messages on port
-// 80.
+// 80. In place of a port, the server can be given an address to listen on,
+// such as a specific interface or a Unix domain socket, or a [net.Listener]
+// that has already been opened. Only one of the port, address and listener
+// may be set for each of HTTP and HTTPS.
 type HttpConfig struct {
 	// This is the port that the HTTP listener will listen on. If the entire
 	// [HttpConfig] is left empty, this will default to port 8080. If a
@@ -144,12 +213,75 @@ type HttpConfig struct {
 	// provided, the server by default listens on port 443 for HTTPS requests,
 	// which can be changed with this property if required.
 	HttpsPort uint16
+	// The address the HTTP listener binds to, in place of
+	// [HttpConfig.HttpPort]. This is either "host:port", such as
+	// "127.0.0.1:8080" to only accept connections from the local machine, or
+	// the path of a Unix domain socket, such as "/run/app.sock". Paths
+	// without a "/" must be prefixed with "unix:". Use port 0 to let the
+	// kernel choose a free port, which can be found using [Server.Addrs].
+	HttpAddr string
+	// The address the HTTPS listener binds to, in place of
+	// [HttpConfig.HttpsPort]. This takes the same form as
+	// [HttpConfig.HttpAddr], and requires [HttpConfig.TlsConfig] to be set.
+	HttpsAddr string
+	// A listener the server accepts HTTP connections from, in place of
+	// opening its own. This is useful for sockets that are opened by another
+	// process, such as a supervisor, or by tests. The server closes the
+	// listener when it shuts down.
+	HttpListener net.Listener
+	// A listener the server accepts HTTPS connections from, in place of
+	// opening its own. The server performs the TLS handshake itself, so this
+	// should accept plain TCP connections. Requires [HttpConfig.TlsConfig] to
+	// be set. The server closes the listener when it shuts down.
+	HttpsListener net.Listener
+	// Set this flag to accept connections from listeners passed to the
+	// process by its parent, in place of the configured ports, addresses and
+	// listeners. This follows the socket activation convention used by
+	// systemd, and is how [Server.HandOver] passes its listeners to the
+	// process that replaces it. Listeners named "http" or "https" through
+	// LISTEN_FDNAMES are used for that protocol. Otherwise, the first
+	// listener is used for HTTP and the second for HTTPS, or the first for
+	// HTTPS if the server is not configured to listen for HTTP. When no
+	// listeners have been passed, the server listens as configured.
+	InheritListeners bool
+	// The address ranges of load balancers that send a PROXY protocol header
+	// (version 1 or 2) ahead of each connection, in CIDR notation such as
+	// "10.0.0.0/8". Single addresses, such as "10.0.0.5", are also accepted.
+	// The header holds the address of the client that connected to the load
+	// balancer, which is then reported by [Request.ClientIP]. Connections
+	// from these ranges are closed unless they start with a valid header,
+	// while connections from anywhere else are served as normal and any
+	// header they send is not trusted. Server setup will panic if a range is
+	// invalid.
+	ProxyProtocolTrustedRanges []string
 	// The TLS config for the server. This is required if the server wishes to
 	// receive and respond to HTTPS messages. When provided with no ports
 	// configured, the server will listen for HTTPS messages on port 443, and
 	// will not expect HTTP messages. Configure the [HttpConfig.HttpPort]
 	// explicitly if it is desirable to listen to both HTTP and HTTPS requests.
 	TlsConfig *tls.Config
+	// Provides the certificates for HTTPS requests, in place of any
+	// certificates in [HttpConfig.TlsConfig]. The manager's certificate files
+	// are watched for changes while the server is running, so renewed
+	// certificates are served without restarting the server. The rest of the
+	// TLS config is used as normal if it is set, otherwise the defaults of
+	// [crypto/tls] are used. See [CertManager] for how certificates are
+	// chosen.
+	CertManager *CertManager
+	// Asks clients for a certificate during the TLS handshake, which is
+	// verified against the given certificate authorities. The client's
+	// identity can then be checked using [ClientCertMiddleware]. Server setup
+	// will panic if this is set without a TLS config or
+	// [HttpConfig.CertManager].
+	ClientCerts *ClientCertConfig
+	// Set this flag to serve HTTP/2 (RFC-9113) as well as HTTP/1.1. HTTPS
+	// clients choose HTTP/2 during the TLS handshake, while plain HTTP
+	// clients must start the connection with the HTTP/2 preface, known as
+	// prior knowledge, since upgrading a HTTP/1.1 connection to HTTP/2 is not
+	// supported. Requests made over HTTP/2 are served by the same routes,
+	// middleware and error handlers as those made over HTTP/1.1, though
+	// handlers cannot take over the connection using [ResponseWriter.Hijack].
+	EnableHttp2 bool
 	// Set this flag if you want the server to instruct clients to upgrade
 	// their HTTP messages to HTTPS. Enabling this flag with a valid TLS config
 	// and no HTTP port selected will default to the server listening for plain
@@ -165,13 +297,29 @@ type HttpConfig struct {
 
 // The internal server config, which only stores the necessary values
 type serverConfig struct {
-	HttpPort      uint16
-	HttpsPort     uint16
-	RequestSize   RequestSize
-	ReadDeadline  time.Duration
-	WriteDeadline time.Duration
-	Namespace     string
-	Debug         bool
+	HttpPort                 uint16
+	HttpsPort                uint16
+	HttpAddr                 string
+	HttpsAddr                string
+	HttpListener             net.Listener
+	HttpsListener            net.Listener
+	InheritListeners         bool
+	EnableHttp2              bool
+	ProxyProtocolTrusted     []netip.Prefix
+	TrustedProxies           []netip.Prefix
+	RequestSize              RequestSize
+	MaxHeaderSize            RequestSize
+	ReadDeadline             time.Duration
+	WriteDeadline            time.Duration
+	IdleTimeout              time.Duration
+	MaxRequestsPerConnection uint
+	MaxConcurrentStreams     uint32
+	MaxConnections           uint
+	MaxInFlightRequests      uint
+	OverloadQueueTimeout     time.Duration
+	OverloadRetryAfter       time.Duration
+	Namespace                string
+	Debug                    bool
 	handlingConfig
 }
 
@@ -200,13 +348,27 @@ func NewTlsConfigForCertAndKey(certPath, keyPath string) *tls.Config {
 
 func (sc ServerConfig) internalise() serverConfig {
 	out := serverConfig{
-		HttpPort:      sc.HttpPort,
-		HttpsPort:     sc.HttpsPort,
-		RequestSize:   sc.RequestSize,
-		ReadDeadline:  sc.ReadDeadline,
-		WriteDeadline: sc.WriteDeadline,
-		Namespace:     sc.Namespace,
-		Debug:         sc.Debug,
+		HttpPort:                 sc.HttpPort,
+		HttpsPort:                sc.HttpsPort,
+		HttpAddr:                 sc.HttpAddr,
+		HttpsAddr:                sc.HttpsAddr,
+		HttpListener:             sc.HttpListener,
+		HttpsListener:            sc.HttpsListener,
+		InheritListeners:         sc.InheritListeners,
+		EnableHttp2:              sc.EnableHttp2,
+		RequestSize:              sc.RequestSize,
+		MaxHeaderSize:            sc.MaxHeaderSize,
+		ReadDeadline:             sc.ReadDeadline,
+		WriteDeadline:            sc.WriteDeadline,
+		IdleTimeout:              sc.IdleTimeout,
+		MaxRequestsPerConnection: sc.MaxRequestsPerConnection,
+		MaxConcurrentStreams:     sc.MaxConcurrentStreams,
+		MaxConnections:           sc.MaxConnections,
+		MaxInFlightRequests:      sc.MaxInFlightRequests,
+		OverloadQueueTimeout:     sc.OverloadQueueTimeout,
+		OverloadRetryAfter:       sc.OverloadRetryAfter,
+		Namespace:                sc.Namespace,
+		Debug:                    sc.Debug,
 		handlingConfig: handlingConfig{
 			StrictClientAcceptance: sc.StrictClientAcceptance,
 			AllowTraceRequests:     sc.AllowTraceRequests,
@@ -216,27 +378,48 @@ func (sc ServerConfig) internalise() serverConfig {
 		if sc.HttpsPort != 0 {
 			panic("cannot choose a https port without a tls config")
 		}
+		if sc.HttpsAddr != "" || sc.HttpsListener != nil {
+			panic("cannot choose a https address or listener without a tls config")
+		}
 		if sc.UpgradeToHttps {
 			panic("cannot upgrade to https without a tls config")
 		}
 	}
 
+	if countSet(sc.HttpPort != 0, sc.HttpAddr != "", sc.HttpListener != nil) > 1 {
+		panic("cannot choose more than one of a http port, address and listener")
+	}
+	if countSet(sc.HttpsPort != 0, sc.HttpsAddr != "", sc.HttpsListener != nil) > 1 {
+		panic("cannot choose more than one of a https port, address and listener")
+	}
+	for _, r := range sc.ProxyProtocolTrustedRanges {
+		out.ProxyProtocolTrusted = append(out.ProxyProtocolTrusted, parseTrustedRange(r))
+	}
+	for _, r := range sc.TrustedProxies {
+		out.TrustedProxies = append(out.TrustedProxies, parseTrustedRange(r))
+	}
+	hasHttp := sc.HttpPort != 0 || sc.HttpAddr != "" || sc.HttpListener != nil
+	hasHttps := sc.HttpsPort != 0 || sc.HttpsAddr != "" || sc.HttpsListener != nil
+
 	if sc.RequestSize == 0 {
 		out.RequestSize = KiB
 	}
+	if sc.MaxHeaderSize == 0 {
+		out.MaxHeaderSize = 32 * KiB
+	}
 	if sc.TlsConfig != nil {
-		if sc.HttpsPort == 0 {
+		if !hasHttps {
 			// We are using TLS so require a HTTPS port. If not supplied, we
 			// default to 443
 			out.HttpsPort = 443
 		}
-		if sc.UpgradeToHttps && sc.HttpPort == 0 {
+		if sc.UpgradeToHttps && !hasHttp {
 			// Since we are explicitly upgrading HTTP to HTTPS, we need to
 			// listen for HTTP requests. We don't have a port to listen on, so
 			// default to 80
 			out.HttpPort = 80
 		}
-	} else if sc.HttpPort == 0 && sc.HttpsPort == 0 {
+	} else if !hasHttp {
 		// No ports have been provided and we are not using TLS, so default to
 		// HTTP over port 8080
 		out.HttpPort = 8080
@@ -247,5 +430,41 @@ func (sc ServerConfig) internalise() serverConfig {
 	if sc.WriteDeadline == 0 {
 		out.WriteDeadline = 10 * time.Second
 	}
+	if sc.IdleTimeout == 0 {
+		out.IdleTimeout = out.ReadDeadline
+	}
+	if sc.MaxRequestsPerConnection == 0 {
+		out.MaxRequestsPerConnection = 1000
+	}
+	if sc.MaxConcurrentStreams == 0 {
+		out.MaxConcurrentStreams = 100
+	}
+	if sc.OverloadRetryAfter == 0 {
+		out.OverloadRetryAfter = time.Second
+	}
 	return out
 }
+
+// Counts how many of a set of mutually exclusive options have been set.
+func countSet(set ...bool) int {
+	n := 0
+	for _, b := range set {
+		if b {
+			n++
+		}
+	}
+	return n
+}
+
+// Parses an address range in CIDR notation, or a single address, panicking if
+// it is invalid.
+func parseTrustedRange(r string) netip.Prefix {
+	if prefix, err := netip.ParsePrefix(r); err == nil {
+		return prefix.Masked()
+	}
+	addr, err := netip.ParseAddr(r)
+	if err != nil {
+		panic(fmt.Errorf("invalid trusted address range %q", r))
+	}
+	return netip.PrefixFrom(addr, addr.BitLen())
+}
@@ -0,0 +1,99 @@
+package routeit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sktylr/routeit/internal/http2"
+)
+
+// A conn is a connection that requests are read from and responses are
+// written to. Requests that do not arrive over the network, such as those made
+// by the [TestClient], are served on a conn without an underlying network
+// connection or writer.
+type conn struct {
+	rwc      net.Conn
+	br       *bufio.Reader
+	bw       *bufio.Writer
+	addr     net.Addr
+	tlsState *tls.ConnectionState
+	// The number of requests that have been fully served on the connection.
+	served uint
+	// Whether the connection is waiting for its next request, and whether it
+	// has been closed by the server. Both are guarded by the server's mutex.
+	idle    bool
+	closing bool
+	// Set once a handler has taken over the connection, after which the
+	// server must no longer read from, write to or close it.
+	hijacked bool
+	// Set for connections that speak HTTP/2, guarded by the server's mutex.
+	h2 *http2.Conn
+}
+
+func newConn(rwc net.Conn, writeTimeout time.Duration) *conn {
+	c := &conn{
+		rwc:  rwc,
+		br:   bufio.NewReader(rwc),
+		bw:   bufio.NewWriter(deadlineWriter{rwc, writeTimeout}),
+		addr: rwc.RemoteAddr(),
+	}
+	if tlsConn, ok := rwc.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		c.tlsState = &state
+	}
+	return c
+}
+
+// The deadlineWriter extends the write deadline of the connection before
+// every write. Responses are buffered, so each write is a sizeable portion of
+// the response and a client that does not read it quickly enough is cut off,
+// without limiting how long a streamed response can last for overall.
+type deadlineWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (dw deadlineWriter) Write(p []byte) (int, error) {
+	if err := dw.conn.SetWriteDeadline(time.Now().Add(dw.timeout)); err != nil {
+		return 0, err
+	}
+	return dw.conn.Write(p)
+}
+
+// Watches the connection for the client going away while a request is being
+// handled, calling onClose if it does. This must only be used once the request
+// has been read in full, and the returned function must be called to stop
+// watching before anything else is read from the connection. It is safe to
+// call the returned function more than once. Data that
+// arrives while watching, such as a pipelined request, is left buffered for
+// the next read and stops the watch, since the client is evidently still
+// there.
+func (c *conn) watchForClose(onClose func()) (stop func()) {
+	if c.rwc == nil {
+		return func() {}
+	}
+
+	var stopping atomic.Bool
+	done := make(chan struct{})
+	// The request may take much longer to handle than it took to read, so the
+	// read deadline must not apply while we wait.
+	c.rwc.SetReadDeadline(time.Time{})
+	go func() {
+		defer close(done)
+		if _, err := c.br.Peek(1); err != nil && !stopping.Load() {
+			onClose()
+		}
+	}()
+
+	return sync.OnceFunc(func() {
+		stopping.Store(true)
+		// Interrupt the pending read, which leaves the reader ready to be
+		// used again since it does not hold on to the error once returned.
+		c.rwc.SetReadDeadline(time.Unix(1, 0))
+		<-done
+	})
+}
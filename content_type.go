@@ -15,12 +15,13 @@ var (
 	CTApplicationXml            = ContentType{part: "application", subtype: "xml"}
 	CTApplicationZip            = ContentType{part: "application", subtype: "zip"}
 
-	CTTextCss        = ContentType{part: "text", subtype: "css"}
-	CTTextCsv        = ContentType{part: "text", subtype: "csv"}
-	CTTextHtml       = ContentType{part: "text", subtype: "html"}
-	CTTextJavaScript = ContentType{part: "text", subtype: "javascript"}
-	CTTextMarkdown   = ContentType{part: "text", subtype: "markdown"}
-	CTTextPlain      = ContentType{part: "text", subtype: "plain"}
+	CTTextCss         = ContentType{part: "text", subtype: "css"}
+	CTTextCsv         = ContentType{part: "text", subtype: "csv"}
+	CTTextEventStream = ContentType{part: "text", subtype: "event-stream"}
+	CTTextHtml        = ContentType{part: "text", subtype: "html"}
+	CTTextJavaScript  = ContentType{part: "text", subtype: "javascript"}
+	CTTextMarkdown    = ContentType{part: "text", subtype: "markdown"}
+	CTTextPlain       = ContentType{part: "text", subtype: "plain"}
 
 	CTImageAvif = ContentType{part: "image", subtype: "avif"}
 	CTImageGif  = ContentType{part: "image", subtype: "gif"}
@@ -319,6 +319,17 @@ func (eh *errorHandler) RegisterHandler(s HttpStatus, h ErrorResponseHandler) {
 }
 
 func (eh *errorHandler) HandleErrors(r any, rw *ResponseWriter, req *Request) *ResponseWriter {
+	if rw.streaming() || rw.hijacked() {
+		// The status and headers have already been sent to the client, so we
+		// cannot tell them about the error through the response. Instead, the
+		// response is cut short so the client knows it is incomplete. Error
+		// handlers are not run, since they cannot change the response either.
+		// The same applies once the handler has taken over the connection.
+		if r != nil {
+			rw.abort(r)
+		}
+		return rw
+	}
 	var err error
 	if r != nil {
 		switch e := r.(type) {
@@ -0,0 +1,191 @@
+package routeit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often a keep-alive comment is sent on an event stream that is otherwise
+// quiet, if not configured.
+const defaultEventStreamKeepAlive = 15 * time.Second
+
+// An Event is a single Server-Sent Event. Only the fields that are set are
+// sent to the client. The data may span multiple lines, which the client joins
+// back together when it receives the event.
+//
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+type Event struct {
+	// The type of the event, which the client uses to decide which listener
+	// to dispatch the event to. Clients treat events without a type as
+	// "message" events.
+	Name string
+	// The ID of the event. Clients send the ID of the last event they received
+	// in the Last-Event-ID header when reconnecting, so the stream can resume
+	// from where it left off.
+	Id string
+	// The payload of the event.
+	Data string
+	// How long the client should wait before reconnecting if the connection
+	// is lost. This is sent with millisecond precision.
+	Retry time.Duration
+}
+
+type EventStreamConfig struct {
+	// How often a comment is sent to the client while no events are being
+	// sent, to stop proxies and clients from treating the connection as idle
+	// and closing it. Defaults to 15 seconds. A negative value disables
+	// keep-alive comments.
+	KeepAlive time.Duration
+}
+
+// The function that produces the events of an event stream. It should send
+// events for as long as it needs to and return once it is finished or the
+// client has gone away, which is signalled by the request's context being
+// cancelled.
+type EventStreamFunc func(ew *EventWriter, req *Request) error
+
+// An EventWriter sends Server-Sent Events to the client. It is safe for
+// concurrent use.
+type EventWriter struct {
+	mu sync.Mutex
+	rw *ResponseWriter
+	// Reset whenever something is sent, so that keep-alive comments are only
+	// sent while the stream is quiet.
+	keepAlive *time.Ticker
+	interval  time.Duration
+}
+
+// Creates a handler that responds to GET requests with a stream of
+// Server-Sent Events. The response is sent with the text/event-stream content
+// type and each event is flushed to the client as soon as it is sent. The
+// handler is routed and passes through middleware like any other handler, so
+// it can be protected by authentication, CORS and similar middleware.
+//
+// The stream is open for as long as fn is running. Clients that disconnect
+// cause the request's context to be cancelled, after which fn should return.
+// Since the response has already started once fn is called, errors returned
+// from fn cut the stream short rather than being turned into error responses.
+// When using the [TestClient], streams are ended once the server's write
+// deadline is reached, which can be reduced using [TestClient.WithTestConfig].
+func EventStream(conf EventStreamConfig, fn EventStreamFunc) Handler {
+	interval := conf.KeepAlive
+	if interval == 0 {
+		interval = defaultEventStreamKeepAlive
+	}
+
+	return Get(func(rw *ResponseWriter, req *Request) error {
+		rw.Headers().Set("Content-Type", CTTextEventStream.string())
+		// Event streams must not be cached, and reverse proxies such as nginx
+		// must not buffer the stream, otherwise events are delayed.
+		rw.Headers().Set("Cache-Control", "no-cache")
+		rw.Headers().Set("X-Accel-Buffering", "no")
+		if req.Method() == HEAD {
+			return nil
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+
+		ew := &EventWriter{rw: rw, interval: interval}
+		if interval > 0 {
+			ew.keepAlive = time.NewTicker(interval)
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-ew.keepAlive.C:
+						ew.Comment("keep-alive")
+					case <-req.Context().Done():
+						return
+					case <-done:
+						return
+					}
+				}
+			}()
+			defer func() {
+				ew.keepAlive.Stop()
+				close(done)
+				wg.Wait()
+			}()
+		}
+		err := fn(ew, req)
+		if req.Context().Err() != nil {
+			// The client has gone away, or the stream has been cut off, so
+			// there is nobody left to tell about the error.
+			return nil
+		}
+		return err
+	})
+}
+
+// Sends an event to the client. Carriage returns and line feeds are removed
+// from the name and ID of the event, since they would otherwise end the field
+// early.
+func (ew *EventWriter) Send(e Event) error {
+	var sb strings.Builder
+	if e.Name != "" {
+		sb.WriteString("event: " + sanitiseEventField(e.Name) + "\n")
+	}
+	if e.Id != "" {
+		// The ID may not contain a NULL character, otherwise the client
+		// ignores it (HTML Standard Sec 9.2.6).
+		id := strings.ReplaceAll(sanitiseEventField(e.Id), "\x00", "")
+		sb.WriteString("id: " + id + "\n")
+	}
+	if e.Retry > 0 {
+		sb.WriteString(fmt.Sprintf("retry: %d\n", e.Retry.Milliseconds()))
+	}
+	if e.Data != "" {
+		data := strings.ReplaceAll(e.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for line := range strings.SplitSeq(data, "\n") {
+			sb.WriteString("data: " + line + "\n")
+		}
+	}
+	if sb.Len() == 0 {
+		return nil
+	}
+	sb.WriteString("\n")
+	return ew.write(sb.String())
+}
+
+// Shorthand for sending an event that only contains data.
+func (ew *EventWriter) Data(data string) error {
+	return ew.Send(Event{Data: data})
+}
+
+// Sends a comment to the client, which clients ignore. Comments are typically
+// used to keep the connection alive, which the [EventWriter] already does
+// while the stream is quiet.
+func (ew *EventWriter) Comment(text string) error {
+	var sb strings.Builder
+	for line := range strings.Lines(text) {
+		sb.WriteString(": " + sanitiseEventField(line) + "\n")
+	}
+	if sb.Len() == 0 {
+		sb.WriteString(":\n")
+	}
+	sb.WriteString("\n")
+	return ew.write(sb.String())
+}
+
+func (ew *EventWriter) write(s string) error {
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if ew.keepAlive != nil {
+		ew.keepAlive.Reset(ew.interval)
+	}
+	if _, err := ew.rw.Write([]byte(s)); err != nil {
+		return err
+	}
+	return ew.rw.Flush()
+}
+
+func sanitiseEventField(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
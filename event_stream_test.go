@@ -0,0 +1,204 @@
+package routeit
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventWriterSend(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Event
+		want string
+	}{
+		{
+			name: "data only",
+			in:   Event{Data: "hello"},
+			want: "data: hello\n\n",
+		},
+		{
+			name: "all fields",
+			in:   Event{Name: "update", Id: "42", Data: "hello", Retry: 3 * time.Second},
+			want: "event: update\nid: 42\nretry: 3000\ndata: hello\n\n",
+		},
+		{
+			name: "multi-line data",
+			in:   Event{Data: "first\nsecond\r\nthird\rfourth"},
+			want: "data: first\ndata: second\ndata: third\ndata: fourth\n\n",
+		},
+		{
+			name: "trailing new line in data",
+			in:   Event{Data: "hello\n"},
+			want: "data: hello\ndata: \n\n",
+		},
+		{
+			name: "strips new lines from name and id",
+			in:   Event{Name: "up\ndate", Id: "4\r\n2\x00", Data: "hello"},
+			want: "event: update\nid: 42\ndata: hello\n\n",
+		},
+		{
+			name: "empty event",
+			in:   Event{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rw := newResponse()
+			ew := &EventWriter{rw: rw}
+
+			if err := ew.Send(tc.in); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+
+			if string(rw.bdy) != tc.want {
+				t.Errorf(`body = %q, wanted %q`, rw.bdy, tc.want)
+			}
+		})
+	}
+}
+
+func TestEventWriterComment(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "", want: ":\n\n"},
+		{in: "keep-alive", want: ": keep-alive\n\n"},
+		{in: "first\nsecond", want: ": first\n: second\n\n"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			rw := newResponse()
+			ew := &EventWriter{rw: rw}
+
+			if err := ew.Comment(tc.in); err != nil {
+				t.Fatalf("Comment() error = %v", err)
+			}
+
+			if string(rw.bdy) != tc.want {
+				t.Errorf(`body = %q, wanted %q`, rw.bdy, tc.want)
+			}
+		})
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	t.Run("test client", func(t *testing.T) {
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{
+			"/events": EventStream(EventStreamConfig{}, func(ew *EventWriter, req *Request) error {
+				ew.Send(Event{Name: "greeting", Data: "hello"})
+				return ew.Data("world")
+			}),
+		})
+		client := NewTestClient(srv)
+
+		res := client.Get("/events")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertHeaderMatchesString(t, "Content-Type", "text/event-stream")
+		res.AssertHeaderMatchesString(t, "Cache-Control", "no-cache")
+		res.AssertHeaderMatchesString(t, "Transfer-Encoding", "chunked")
+		res.AssertBodyMatchesString(t, "event: greeting\ndata: hello\n\ndata: world\n\n")
+	})
+
+	t.Run("HEAD request", func(t *testing.T) {
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{
+			"/events": EventStream(EventStreamConfig{}, func(ew *EventWriter, req *Request) error {
+				t.Error("did not expect event stream function to be called")
+				return nil
+			}),
+		})
+
+		res := NewTestClient(srv).Head("/events")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertHeaderMatchesString(t, "Content-Type", "text/event-stream")
+		res.AssertBodyEmpty(t)
+	})
+
+	t.Run("middleware can reject stream", func(t *testing.T) {
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterMiddleware(func(c Chain, rw *ResponseWriter, req *Request) error {
+			if _, found := req.Headers().First("Authorization"); !found {
+				return ErrUnauthorized()
+			}
+			return c.Proceed(rw, req)
+		})
+		srv.RegisterRoutes(RouteRegistry{
+			"/events": EventStream(EventStreamConfig{}, func(ew *EventWriter, req *Request) error {
+				return ew.Data("secret")
+			}),
+		})
+		client := NewTestClient(srv)
+
+		client.Get("/events").AssertStatusCode(t, StatusUnauthorized)
+		client.Get("/events", "Authorization", "Bearer token").AssertBodyMatchesString(t, "data: secret\n\n")
+	})
+
+	t.Run("stream is ended at write deadline with test client", func(t *testing.T) {
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{
+			"/events": EventStream(EventStreamConfig{}, func(ew *EventWriter, req *Request) error {
+				ew.Data("hello")
+				<-req.Context().Done()
+				return req.Context().Err()
+			}),
+		})
+		client := NewTestClient(srv).WithTestConfig(TestConfig{WriteDeadline: 20 * time.Millisecond})
+
+		res := client.Get("/events")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyStartsWithString(t, "data: hello\n\n")
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		disconnected := make(chan struct{})
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{
+			"/events": EventStream(EventStreamConfig{KeepAlive: 10 * time.Millisecond}, func(ew *EventWriter, req *Request) error {
+				ew.Send(Event{Id: "1", Data: "hello"})
+				<-req.Context().Done()
+				close(disconnected)
+				return nil
+			}),
+		})
+		client, server := net.Pipe()
+		defer client.Close()
+		go srv.handleNewConnection(server)
+		go client.Write([]byte("GET /events HTTP/1.1\r\nHost: localhost\r\nAccept: text/event-stream\r\n\r\n"))
+
+		res, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		events := bufio.NewReader(res.Body)
+		var got []string
+		for len(got) < 4 {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatalf("failed to read event stream: %v", err)
+			}
+			got = append(got, line)
+		}
+
+		if want := "id: 1\ndata: hello\n\n: keep-alive\n"; strings.Join(got, "") != want {
+			t.Errorf(`stream = %q, wanted %q`, strings.Join(got, ""), want)
+		}
+		client.Close()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second):
+			t.Fatal("expected request context to be cancelled once the client disconnected")
+		}
+	})
+}
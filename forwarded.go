@@ -0,0 +1,198 @@
+package routeit
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// A forwardedHop is one proxy's account of the request it received, as given
+// by an element of the Forwarded header (RFC-7239) or an entry of the
+// X-Forwarded-* headers. The node is the address of whoever connected to the
+// proxy, while the protocol and host are those the request was made with.
+type forwardedHop struct {
+	node  string
+	proto string
+	host  string
+}
+
+// Applies the Forwarded, or X-Forwarded-*, headers to the request when it was
+// received from a trusted proxy. Each proxy adds its own hop to the end of the
+// headers, so we walk backwards from the latest hop for as long as the hops
+// were added by trusted proxies. The first untrusted address we reach is the
+// client, and the protocol and host of that hop are those the client used.
+// Anything before that hop could have been sent by the client, so cannot be
+// trusted.
+func (req *Request) applyForwarded(peer net.Addr, trusted []netip.Prefix) {
+	tcpAddr, ok := peer.(*net.TCPAddr)
+	if !ok {
+		return
+	}
+	addr, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok || !isTrusted(addr.Unmap(), trusted) {
+		return
+	}
+	hops := forwardedHops(req.headers)
+	if len(hops) == 0 {
+		return
+	}
+
+	hop := hops[len(hops)-1]
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop = hops[i]
+		client, ok := parseForwardedNode(hop.node)
+		if !ok {
+			// The proxy does not know, or will not say, who connected to it,
+			// so the last address we know of is the best we can do.
+			break
+		}
+		req.ip = client.String()
+		if !isTrusted(client, trusted) {
+			break
+		}
+	}
+	if hop.proto == "http" || hop.proto == "https" {
+		req.scheme = hop.proto
+	}
+	req.forwardedHost = hop.host
+	req.forwarded = true
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Collects the hops from the Forwarded header, falling back to the
+// X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Host headers when the
+// request does not have a Forwarded header.
+func forwardedHops(h *RequestHeaders) []forwardedHop {
+	if values, ok := h.All("Forwarded"); ok {
+		var hops []forwardedHop
+		for _, v := range values {
+			for _, elem := range splitQuoted(v, ',') {
+				hops = append(hops, parseForwardedElement(elem))
+			}
+		}
+		return hops
+	}
+
+	nodes := forwardedList(h, "X-Forwarded-For")
+	protos := forwardedList(h, "X-Forwarded-Proto")
+	hosts := forwardedList(h, "X-Forwarded-Host")
+	hops := make([]forwardedHop, 0, len(nodes))
+	for i, node := range nodes {
+		hops = append(hops, forwardedHop{
+			node:  node,
+			proto: strings.ToLower(alignedEntry(protos, i, len(nodes))),
+			host:  alignedEntry(hosts, i, len(nodes)),
+		})
+	}
+	return hops
+}
+
+// Parses a single element of the Forwarded header, such as
+// `for=192.0.2.60;proto=http;by=203.0.113.43`.
+func parseForwardedElement(elem string) forwardedHop {
+	var hop forwardedHop
+	for _, pair := range splitQuoted(elem, ';') {
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		val = unquote(strings.TrimSpace(val))
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "for":
+			hop.node = val
+		case "proto":
+			hop.proto = strings.ToLower(val)
+		case "host":
+			hop.host = val
+		}
+	}
+	return hop
+}
+
+func forwardedList(h *RequestHeaders, key string) []string {
+	values, _ := h.All(key)
+	var entries []string
+	for _, v := range values {
+		for entry := range strings.SplitSeq(v, ",") {
+			entries = append(entries, strings.TrimSpace(entry))
+		}
+	}
+	return entries
+}
+
+// Proxies that append to X-Forwarded-For do not always append to the
+// X-Forwarded-Proto and X-Forwarded-Host headers. When these line up with the
+// X-Forwarded-For entries, the entry for the same hop is used, otherwise the
+// latest entry is used, since it was set by the proxy closest to the server.
+func alignedEntry(entries []string, i, n int) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	if len(entries) == n {
+		return entries[i]
+	}
+	return entries[len(entries)-1]
+}
+
+// Parses the node identifier of a Forwarded element, which is an IP address
+// that may have a port, with IPv6 addresses wrapped in brackets. The node may
+// also be "unknown" or an obfuscated identifier, neither of which are
+// addresses.
+func parseForwardedNode(node string) (netip.Addr, bool) {
+	if addrPort, err := netip.ParseAddrPort(node); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	node = strings.TrimSuffix(strings.TrimPrefix(node, "["), "]")
+	addr, err := netip.ParseAddr(node)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// Splits s on each occurrence of sep that is not within a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			quoted = !quoted
+		case !quoted && s[i] == sep:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// Removes the quotes from a quoted string, along with any backslashes used to
+// escape the characters within it.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var sb strings.Builder
+	escaped := false
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
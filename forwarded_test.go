@@ -0,0 +1,152 @@
+package routeit
+
+import (
+	"crypto/tls"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestApplyForwarded(t *testing.T) {
+	trusted := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+	proxy := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 56324}
+	tests := []struct {
+		name       string
+		peer       net.Addr
+		headers    []string
+		tls        bool
+		wantIp     string
+		wantScheme string
+		wantHost   string
+	}{
+		{
+			name:       "untrusted peer ignores headers",
+			peer:       &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+			headers:    []string{"X-Forwarded-For", "198.51.100.1", "X-Forwarded-Proto", "https"},
+			wantIp:     "192.0.2.1",
+			wantScheme: "http",
+		},
+		{
+			name:       "trusted peer without headers",
+			peer:       proxy,
+			wantIp:     "10.0.0.1",
+			wantScheme: "http",
+		},
+		{
+			name:       "x-forwarded headers",
+			peer:       proxy,
+			headers:    []string{"X-Forwarded-For", "198.51.100.1", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "example.com"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "https",
+			wantHost:   "example.com",
+		},
+		{
+			name:       "x-forwarded-for skips trusted hops",
+			peer:       proxy,
+			headers:    []string{"X-Forwarded-For", "203.0.113.7, 198.51.100.1, 10.1.1.1"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "http",
+		},
+		{
+			name:       "x-forwarded-for ignores spoofed entries",
+			peer:       proxy,
+			headers:    []string{"X-Forwarded-For", "10.9.9.9, 198.51.100.1"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "http",
+		},
+		{
+			name:       "x-forwarded-proto uses latest entry when unaligned",
+			peer:       proxy,
+			headers:    []string{"X-Forwarded-For", "198.51.100.1, 10.1.1.1", "X-Forwarded-Proto", "HTTPS"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "https",
+		},
+		{
+			name:       "forwarded header",
+			peer:       proxy,
+			headers:    []string{"Forwarded", `for=198.51.100.1;proto=https;host="example.com:8443"`},
+			wantIp:     "198.51.100.1",
+			wantScheme: "https",
+			wantHost:   "example.com:8443",
+		},
+		{
+			name:       "forwarded header takes precedence",
+			peer:       proxy,
+			headers:    []string{"Forwarded", "for=198.51.100.1", "X-Forwarded-For", "203.0.113.7"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "http",
+		},
+		{
+			name:       "forwarded ipv6 with port",
+			peer:       proxy,
+			headers:    []string{"Forwarded", `For="[2001:db8:cafe::17]:4711", for=2001:db8::1`},
+			wantIp:     "2001:db8:cafe::17",
+			wantScheme: "http",
+		},
+		{
+			name:       "forwarded across header lines",
+			peer:       proxy,
+			headers:    []string{"Forwarded", "for=198.51.100.1;proto=https", "Forwarded", "for=10.1.1.1;proto=http"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "https",
+		},
+		{
+			name:       "forwarded unknown node stops at last known address",
+			peer:       proxy,
+			headers:    []string{"Forwarded", "for=198.51.100.1, for=unknown;proto=https"},
+			wantIp:     "10.0.0.1",
+			wantScheme: "https",
+		},
+		{
+			name:       "ignores unrecognised proto",
+			peer:       proxy,
+			tls:        true,
+			headers:    []string{"X-Forwarded-For", "198.51.100.1", "X-Forwarded-Proto", "gopher"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "https",
+		},
+		{
+			name:       "ipv4 mapped peer",
+			peer:       &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 56324},
+			headers:    []string{"X-Forwarded-For", "198.51.100.1"},
+			wantIp:     "198.51.100.1",
+			wantScheme: "http",
+		},
+		{
+			name:       "non tcp peer",
+			peer:       &net.UnixAddr{Name: "/tmp/routeit.sock", Net: "unix"},
+			headers:    []string{"X-Forwarded-For", "198.51.100.1"},
+			wantIp:     "10.0.0.1",
+			wantScheme: "http",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ip := "10.0.0.1"
+			if tcp, ok := tc.peer.(*net.TCPAddr); ok {
+				ip = tcp.IP.String()
+			}
+			opts := TestRequestOptions{Headers: tc.headers, Ip: ip}
+			if tc.tls {
+				opts.TlsConnectionState = &tls.ConnectionState{}
+			}
+			req := NewTestRequest(t, "/", GET, opts).req
+
+			req.applyForwarded(tc.peer, trusted)
+
+			if req.ClientIP() != tc.wantIp {
+				t.Errorf(`ClientIP() = %#q, wanted %#q`, req.ClientIP(), tc.wantIp)
+			}
+			if req.Scheme() != tc.wantScheme {
+				t.Errorf(`Scheme() = %#q, wanted %#q`, req.Scheme(), tc.wantScheme)
+			}
+			if req.forwardedHost != tc.wantHost {
+				t.Errorf(`forwardedHost = %#q, wanted %#q`, req.forwardedHost, tc.wantHost)
+			}
+		})
+	}
+}
@@ -1,12 +1,5 @@
 package routeit
 
-import (
-	"net/http"
-	"os"
-	"path"
-	"strings"
-)
-
 type HandlerFunc func(rw *ResponseWriter, req *Request) error
 
 type Handler struct {
@@ -126,83 +119,57 @@ func MultiMethod(mmh MultiMethodHandler) Handler {
 	return h
 }
 
+// Creates a handler that responds to every method using the same function,
+// leaving it to decide how to respond to each.
+func anyMethod(fn HandlerFunc) Handler {
+	return Handler{
+		get:     fn,
+		head:    fn,
+		post:    fn,
+		put:     fn,
+		delete:  fn,
+		patch:   fn,
+		options: fn,
+		trace:   fn,
+		allowed: []HttpMethod{GET, HEAD, POST, PUT, DELETE, PATCH, OPTIONS},
+	}
+}
+
 func (h *Handler) handle(rw *ResponseWriter, req *Request) error {
-	switch req.Method() {
+	fn := h.forMethod(req.Method())
+	if fn == nil {
+		return ErrMethodNotAllowed(h.allowed...)
+	}
+	// Clients that expect 100-continue are only asked for the body once we
+	// know the request has made it past middleware to a handler that will
+	// accept it.
+	if err := req.readPendingBody(); err != nil {
+		return err
+	}
+	return fn(rw, req)
+}
+
+func (h *Handler) forMethod(m HttpMethod) HandlerFunc {
+	switch m {
 	case GET:
-		if h.get != nil {
-			return h.get(rw, req)
-		}
+		return h.get
 	case HEAD:
-		if h.head != nil {
-			return h.head(rw, req)
-		}
+		return h.head
 	case POST:
-		if h.post != nil {
-			return h.post(rw, req)
-		}
+		return h.post
 	case PUT:
-		if h.put != nil {
-			return h.put(rw, req)
-		}
+		return h.put
 	case DELETE:
-		if h.delete != nil {
-			return h.delete(rw, req)
-		}
+		return h.delete
 	case PATCH:
-		if h.patch != nil {
-			return h.patch(rw, req)
-		}
+		return h.patch
 	case OPTIONS:
-		if h.options != nil {
-			return h.options(rw, req)
-		}
+		return h.options
 	case TRACE:
-		if h.trace != nil {
-			return h.trace(rw, req)
-		}
-	}
-
-	return ErrMethodNotAllowed(h.allowed...)
-}
-
-// Dynamically loads static assets from disk.
-func staticLoader(namespace []string) *Handler {
-	h := Get(func(rw *ResponseWriter, req *Request) error {
-		url, _ := req.uri.RemoveNamespace(namespace)
-		path := path.Join(url...)
-
-		// First determine the file's presence. This allows us to return more
-		// meaningful errors - e.g. if the file is not present we can map that
-		// to a 404.
-		if _, err := os.Stat(path); err != nil {
-			return err
-		}
-
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-
-		cType := parseContentType(http.DetectContentType(data))
-		if cType.part == "text" && cType.subtype == "plain" {
-			// [net/http.DetectContentType] typically cannot infer the content
-			// type of CSS or JS files, and instead returns text/plain.
-			// Browsers will typically not trust stylesheets or scripts that
-			// have text/plain content type, so we need to adjust for the
-			// proper content type here. This works by reading the file
-			// extension, so it requires that the file extension allows for
-			// more accurate inference.
-			if strings.HasSuffix(path, ".css") {
-				cType.subtype = "css"
-			} else if strings.HasSuffix(path, ".js") {
-				cType.subtype = "javascript"
-			}
-		}
-		rw.RawWithContentType(data, cType)
-
+		return h.trace
+	default:
 		return nil
-	})
-	return &h
+	}
 }
 
 // After all middleware is processed, the last piece is for the server to
@@ -218,12 +185,22 @@ func coreHandler(handler *Handler, conf handlingConfig) HandlerFunc {
 			return ErrMethodNotAllowed(handler.allowed...)
 		}
 		err := handler.handle(rw, req)
-		if !conf.StrictClientAcceptance || err != nil {
+		// Streamed responses have their content type checked before they
+		// start streaming, since it is too late to reject them afterwards.
+		// Handlers that take over the connection respond to the client
+		// themselves.
+		if err != nil || rw.streaming() || rw.hijacked() {
 			return err
 		}
-		if !req.AcceptsContentType(rw.ct) {
+		if conf.StrictClientAcceptance && !req.AcceptsContentType(rw.ct) {
 			return ErrNotAcceptable()
 		}
-		return nil
+		// Handlers that set an ETag or Last-Modified get conditional GET
+		// requests for free. Other methods must be checked by the handler
+		// before it acts on the request, since it is too late afterwards.
+		if req.mthd == GET || req.mthd == HEAD {
+			_, err = rw.CheckPreconditions(req)
+		}
+		return err
 	}
 }
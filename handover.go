@@ -0,0 +1,124 @@
+package routeit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/sktylr/routeit/internal/socket"
+)
+
+// The environment variable holding the file descriptor that a process started
+// by [Server.HandOver] writes to once it is ready for connections.
+const envHandOverReady = "ROUTEIT_HANDOVER_READY_FD"
+
+// Builds the command that starts the process the listeners are handed over to,
+// which is a copy of the current process.
+var handOverCommand = func() (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	return cmd, nil
+}
+
+// HandOver restarts the server without dropping connections, such as when
+// deploying a new version of the binary. A new copy of the process is started
+// with the same arguments and environment, and is passed the server's
+// listeners. Once the new process has started listening, this server is shut
+// down gracefully using [Server.Shutdown], so requests that are already in
+// flight are completed while new connections are accepted by the new process.
+// The new process must set [HttpConfig.InheritListeners] to pick up the
+// listeners, otherwise it fails to bind to the ports this server is using.
+//
+// The context bounds both how long to wait for the new process to be ready
+// and how long to wait for this server to shut down. If the new process exits
+// or the context expires before the new process is ready, the new process is
+// killed and this server continues to serve connections. Otherwise, the new
+// process is returned and is left running once this process exits.
+func (s *Server) HandOver(ctx context.Context) (*os.Process, error) {
+	if !s.started.Load() {
+		return nil, errors.New("server has not been started")
+	}
+	s.mu.Lock()
+	if s.inShutdown.Load() {
+		s.mu.Unlock()
+		return nil, errors.New("server is shutting down")
+	}
+	files, err := s.sock.Files()
+	s.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hand over listeners: %w", err)
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	cmd, err := handOverCommand()
+	if err != nil {
+		return nil, err
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(
+		socket.ChildEnv(os.Environ(), s.sockNames),
+		fmt.Sprintf("%s=%d", envHandOverReady, 3+len(files)),
+	)
+	err = cmd.Start()
+	// The new process holds its own copy of the write end of the pipe, so we
+	// read EOF if it exits before saying it is ready.
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start new process: %w", err)
+	}
+	s.log.Info("Handing over listeners to new process", "pid", cmd.Process.Pid)
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		s.log.Error("New process did not become ready, continuing to serve", "err", err)
+		return nil, fmt.Errorf("new process did not become ready: %w", err)
+	}
+
+	s.log.Info("New process is ready, shutting down", "pid", cmd.Process.Pid)
+	return cmd.Process, s.Shutdown(ctx)
+}
+
+// Tells the process that started this one using [Server.HandOver] that the
+// server is ready for connections, if it was started that way.
+func notifyHandOverReady() error {
+	v, ok := os.LookupEnv(envHandOverReady)
+	if !ok {
+		return nil
+	}
+	os.Unsetenv(envHandOverReady)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q", envHandOverReady, v)
+	}
+	f := os.NewFile(uintptr(fd), "handover-ready")
+	defer f.Close()
+	_, err = f.Write([]byte{1})
+	return err
+}
@@ -0,0 +1,236 @@
+package routeit
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sktylr/routeit/internal/socket"
+)
+
+// Set in the environment of the child process started by TestHandOver.
+const envHandOverChild = "ROUTEIT_TEST_HANDOVER_CHILD"
+
+func TestHandOver(t *testing.T) {
+	newServer := func(t *testing.T, routes RouteRegistry) *Server {
+		srv := NewServer(ServerConfig{
+			Debug:          true,
+			LoggingHandler: slog.DiscardHandler,
+			HttpConfig: HttpConfig{
+				HttpAddr:         "127.0.0.1:0",
+				HttpsAddr:        "127.0.0.1:0",
+				TlsConfig:        newTestTlsConfig(t),
+				InheritListeners: true,
+			},
+		})
+		srv.RegisterRoutes(routes)
+		return srv
+	}
+	useChild := func(t *testing.T, args ...string) {
+		original := handOverCommand
+		t.Cleanup(func() { handOverCommand = original })
+		handOverCommand = func() (*exec.Cmd, error) {
+			return exec.Command(os.Args[0], args...), nil
+		}
+		t.Setenv(envHandOverChild, "1")
+	}
+	// Fetches the PID of the process serving the request, over a new
+	// connection each time.
+	pid := func(t *testing.T, scheme string, addr net.Addr) int {
+		t.Helper()
+		client := &http.Client{Transport: &http.Transport{
+			DisableKeepAlives: true,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		}}
+		res, err := client.Get(scheme + "://" + addr.String() + "/pid")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		pid, err := strconv.Atoi(string(body))
+		if err != nil {
+			t.Fatalf("invalid pid %q: %v", body, err)
+		}
+		return pid
+	}
+
+	t.Run("hands over listeners and drains", func(t *testing.T) {
+		useChild(t, "-test.run=^TestHandOverChild$")
+		entered, release := make(chan struct{}), make(chan struct{})
+		srv := newServer(t, RouteRegistry{
+			"/pid": Get(pidHandler),
+			"/slow": Get(func(rw *ResponseWriter, req *Request) error {
+				close(entered)
+				<-release
+				rw.Text("done")
+				return nil
+			}),
+		})
+		started := make(chan error, 1)
+		go func() { started <- srv.Start() }()
+		addrs := awaitAddrs(t, srv)
+		slow := make(chan *http.Response, 1)
+		go func() {
+			res, err := http.Get("http://" + addrs[0].String() + "/slow")
+			if err != nil {
+				t.Errorf("in-flight request failed: %v", err)
+			}
+			slow <- res
+		}()
+		<-entered
+
+		type handOver struct {
+			proc *os.Process
+			err  error
+		}
+		handedOver := make(chan handOver, 1)
+		go func() {
+			proc, err := srv.HandOver(context.Background())
+			handedOver <- handOver{proc, err}
+		}()
+		// New connections are accepted by the child while the in-flight
+		// request holds up the parent.
+		deadline := time.Now().Add(5 * time.Second)
+		for pid(t, "http", addrs[0]) == os.Getpid() {
+			if time.Now().After(deadline) {
+				t.Fatal("child did not start accepting connections")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(release)
+		res := <-handedOver
+
+		if res.err != nil {
+			t.Fatalf("HandOver failed: %v", res.err)
+		}
+		defer func() {
+			res.proc.Signal(syscall.SIGTERM)
+			res.proc.Wait()
+		}()
+		if r := <-slow; r == nil || r.StatusCode != 200 {
+			t.Errorf("expected in-flight request to complete")
+		}
+		if err := <-started; err != nil {
+			t.Errorf("Start() error = %v, wanted nil", err)
+		}
+		if got := pid(t, "http", addrs[0]); got != res.proc.Pid {
+			t.Errorf(`http served by %d, wanted child %d`, got, res.proc.Pid)
+		}
+		if got := pid(t, "https", addrs[1]); got != res.proc.Pid {
+			t.Errorf(`https served by %d, wanted child %d`, got, res.proc.Pid)
+		}
+	})
+
+	t.Run("keeps serving if child exits", func(t *testing.T) {
+		useChild(t, "-test.run=^$")
+		srv := newServer(t, RouteRegistry{"/pid": Get(pidHandler)})
+		go srv.Start()
+		t.Cleanup(func() { srv.Shutdown(context.Background()) })
+		addrs := awaitAddrs(t, srv)
+
+		proc, err := srv.HandOver(context.Background())
+
+		if err == nil {
+			t.Errorf("expected HandOver to fail, child %d started", proc.Pid)
+		}
+		if got := pid(t, "https", addrs[1]); got != os.Getpid() {
+			t.Errorf(`https served by %d, wanted parent %d`, got, os.Getpid())
+		}
+	})
+
+	t.Run("not started", func(t *testing.T) {
+		if _, err := NewServer(ServerConfig{}).HandOver(context.Background()); err == nil {
+			t.Error("expected HandOver to fail")
+		}
+	})
+}
+
+func TestPickInherited(t *testing.T) {
+	first, second := &net.TCPListener{}, &net.TCPListener{}
+	tests := []struct {
+		name  string
+		ls    []socket.InheritedListener
+		proto string
+		index int
+		want  net.Listener
+	}{
+		{name: "none inherited", proto: "http"},
+		{
+			name:  "by name",
+			ls:    []socket.InheritedListener{{Name: "https", Listener: first}, {Name: "http", Listener: second}},
+			proto: "http",
+			want:  second,
+		},
+		{
+			name:  "named for another protocol",
+			ls:    []socket.InheritedListener{{Name: "https", Listener: first}},
+			proto: "http",
+		},
+		{
+			name:  "by position when unnamed",
+			ls:    []socket.InheritedListener{{Listener: first}, {Listener: second}},
+			proto: "https",
+			index: 1,
+			want:  second,
+		},
+		{
+			name:  "by position when named after something else",
+			ls:    []socket.InheritedListener{{Name: "app.socket", Listener: first}},
+			proto: "http",
+			want:  first,
+		},
+		{
+			name:  "position out of range",
+			ls:    []socket.InheritedListener{{Listener: first}},
+			proto: "https",
+			index: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := pickInherited(tc.ls, tc.proto, tc.index); got != tc.want {
+				t.Errorf(`pickInherited() = %p, wanted %p`, got, tc.want)
+			}
+		})
+	}
+}
+
+// Run as a child process by TestHandOver, serving from the listeners it was
+// handed until it is told to shut down.
+func TestHandOverChild(t *testing.T) {
+	if os.Getenv(envHandOverChild) == "" {
+		t.Skip("only run as a child process")
+	}
+	srv := NewServer(ServerConfig{
+		Debug:          true,
+		LoggingHandler: slog.DiscardHandler,
+		HttpConfig: HttpConfig{
+			HttpAddr:         "127.0.0.1:0",
+			HttpsAddr:        "127.0.0.1:0",
+			TlsConfig:        newTestTlsConfig(t),
+			InheritListeners: true,
+		},
+	})
+	srv.RegisterRoutes(RouteRegistry{"/pid": Get(pidHandler)})
+	srv.ShutdownOnSignal(time.Second)
+
+	if err := srv.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+}
+
+func pidHandler(rw *ResponseWriter, req *Request) error {
+	rw.Text(strconv.Itoa(os.Getpid()))
+	return nil
+}
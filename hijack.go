@@ -0,0 +1,63 @@
+package routeit
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+var (
+	errHijackUnsupported = errors.New("response is not sent over a connection that can be taken over")
+	errHijacked          = errors.New("connection has been taken over by the handler")
+)
+
+// Hijack takes over the connection the request was received on, for handlers
+// that implement protocols other than HTTP, such as custom upgrades or
+// tunnels. The returned reader holds anything the client sent after the
+// request that the server has already read from the connection, so it should
+// be read from instead of the connection. [WebSocket] is built on top of
+// Hijack.
+//
+// Once the connection has been taken over, the server no longer reads from,
+// writes to or closes it, and the response is never sent - the handler must
+// respond to the request over the connection itself, and close it once it is
+// finished. The status of the response is still used when logging the
+// request, so it is worth setting it to reflect what was sent to the client.
+// Errors returned by the handler after it has taken over the connection are
+// logged, but cannot be passed to the error handlers.
+//
+// Taking over the connection lifts the server's write deadline, so the
+// timeout middleware waits for the handler to return however long it takes.
+// The read and write deadlines of the connection are cleared, and it is up to
+// the handler to set its own. Hijack fails with the cause of the request's
+// context if the write deadline has already passed, in which case the handler
+// should return and let the server respond. The request's context is no longer
+// cancelled when the client disconnects, and [Server.Shutdown] neither waits
+// for nor closes connections that have been taken over.
+//
+// Hijack fails if the response has started streaming, or when using the
+// [TestClient], since there is no connection to take over.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.Reader, error) {
+	st := &rw.stream
+	if st.hijacked {
+		return nil, nil, errHijacked
+	}
+	if st.started {
+		return nil, nil, errors.New("cannot take over the connection once the response has started")
+	}
+	if st.hijack == nil {
+		return nil, nil, errHijackUnsupported
+	}
+	rwc, br, err := st.hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	st.hijacked = true
+	return rwc, br, nil
+}
+
+// Reports whether the connection the response would have been sent over has
+// been taken over by the handler.
+func (rw *ResponseWriter) hijacked() bool {
+	return rw.stream.hijacked
+}
@@ -0,0 +1,190 @@
+package routeit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHijack(t *testing.T) {
+	serve := func(t *testing.T, conf ServerConfig, h HandlerFunc) (net.Conn, *bufio.Reader, chan struct{}) {
+		t.Helper()
+		conf.Debug = true
+		conf.LoggingHandler = slog.DiscardHandler
+		srv := NewServer(conf)
+		srv.RegisterRoutes(RouteRegistry{"/tunnel": Get(h)})
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		done := make(chan struct{})
+		go func() {
+			srv.handleNewConnection(server)
+			close(done)
+		}()
+		return client, bufio.NewReader(client), done
+	}
+
+	t.Run("hands over connection and buffered bytes", func(t *testing.T) {
+		handed := make(chan net.Conn, 1)
+		conn, br, done := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+			rwc, hbr, err := rw.Hijack()
+			if err != nil {
+				return err
+			}
+			rw.Status(StatusSwitchingProtocols)
+			rwc.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: echo\r\n\r\n"))
+			line, err := hbr.ReadString('\n')
+			if err != nil {
+				return err
+			}
+			rwc.Write([]byte(line))
+			handed <- rwc
+			return nil
+		})
+
+		// The first line of the tunnelled protocol is sent along with the
+		// request, so the server has already buffered it by the time the
+		// handler takes over.
+		go conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\nhello\n"))
+		res, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if res.StatusCode != 101 {
+			t.Errorf(`status = %d, wanted 101`, res.StatusCode)
+		}
+		line, err := br.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read echoed line: %v", err)
+		}
+		if line != "hello\n" {
+			t.Errorf(`echoed = %q, wanted "hello\n"`, line)
+		}
+
+		<-done
+		// The server must not close or write to the connection once it has
+		// been taken over.
+		rwc := <-handed
+		go rwc.Write([]byte("still open\n"))
+		if line, err := br.ReadString('\n'); err != nil || line != "still open\n" {
+			t.Errorf(`read after handler returned = %q, %v, wanted "still open\n"`, line, err)
+		}
+		rwc.Close()
+	})
+
+	t.Run("lifts write deadline", func(t *testing.T) {
+		conn, br, _ := serve(t, ServerConfig{WriteDeadline: 20 * time.Millisecond}, func(rw *ResponseWriter, req *Request) error {
+			rwc, _, err := rw.Hijack()
+			if err != nil {
+				return err
+			}
+			defer rwc.Close()
+			time.Sleep(50 * time.Millisecond)
+			if err := req.Context().Err(); err != nil {
+				t.Errorf(`context error = %v, wanted nil`, err)
+			}
+			_, err = rwc.Write([]byte("late\n"))
+			return err
+		})
+
+		go conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		line, err := br.ReadString('\n')
+
+		if err != nil || line != "late\n" {
+			t.Errorf(`read = %q, %v, wanted "late\n"`, line, err)
+		}
+	})
+
+	t.Run("fails once write deadline has passed", func(t *testing.T) {
+		// The handler is abandoned by the server once the deadline passes, so
+		// it may still be running once the response has been sent.
+		hijackErr := make(chan error, 1)
+		conn, br, _ := serve(t, ServerConfig{WriteDeadline: 20 * time.Millisecond}, func(rw *ResponseWriter, req *Request) error {
+			<-req.Context().Done()
+			_, _, err := rw.Hijack()
+			hijackErr <- err
+			return err
+		})
+
+		go conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if res.StatusCode != 503 {
+			t.Errorf(`status = %d, wanted 503`, res.StatusCode)
+		}
+		if err := <-hijackErr; !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf(`Hijack() error = %v, wanted %v`, err, context.DeadlineExceeded)
+		}
+	})
+
+	t.Run("fails once response has started", func(t *testing.T) {
+		var hijackErr error
+		conn, br, _ := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+			rw.Flush()
+			_, _, hijackErr = rw.Hijack()
+			return nil
+		})
+
+		go conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		io.ReadAll(res.Body)
+
+		if res.StatusCode != 200 {
+			t.Errorf(`status = %d, wanted 200`, res.StatusCode)
+		}
+		if hijackErr == nil {
+			t.Error("expected Hijack() to fail")
+		}
+	})
+
+	t.Run("fails more than once", func(t *testing.T) {
+		var second error
+		conn, br, _ := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+			rwc, _, err := rw.Hijack()
+			if err != nil {
+				return err
+			}
+			defer rwc.Close()
+			_, _, second = rw.Hijack()
+			return nil
+		})
+
+		go conn.Write([]byte("GET /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		if _, err := br.ReadByte(); err != io.EOF {
+			t.Errorf(`expected connection to be closed by the handler, got %v`, err)
+		}
+
+		if !errors.Is(second, errHijacked) {
+			t.Errorf(`second Hijack() error = %v, wanted %v`, second, errHijacked)
+		}
+	})
+
+	t.Run("unsupported by test client", func(t *testing.T) {
+		var hijackErr error
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{"/tunnel": Get(func(rw *ResponseWriter, req *Request) error {
+			_, _, hijackErr = rw.Hijack()
+			rw.Text("not hijacked")
+			return nil
+		})})
+
+		res := NewTestClient(srv).Get("/tunnel")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, "not hijacked")
+		if !errors.Is(hijackErr, errHijackUnsupported) {
+			t.Errorf(`Hijack() error = %v, wanted %v`, hijackErr, errHijackUnsupported)
+		}
+	})
+}
@@ -35,6 +35,11 @@ func hostValidationMiddleware(allowed []string) Middleware {
 
 	return func(c Chain, rw *ResponseWriter, req *Request) error {
 		host, hasHost := req.Headers().First("Host")
+		if req.forwardedHost != "" {
+			// The Host header was set by the trusted proxy, which has told us
+			// the host the client actually requested.
+			host, hasHost = req.forwardedHost, true
+		}
 		if !hasHost {
 			return ErrBadRequest()
 		}
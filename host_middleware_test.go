@@ -78,6 +78,7 @@ func TestHostValidationMiddleware(t *testing.T) {
 		name          string
 		allowedHosts  []string
 		hostHeader    string
+		forwardedHost string
 		wantProceeded bool
 		wantErr       bool
 		wantHost      string
@@ -127,6 +128,21 @@ func TestHostValidationMiddleware(t *testing.T) {
 			wantProceeded: false,
 			wantErr:       true,
 		},
+		{
+			name:          "matches host from trusted proxy",
+			allowedHosts:  []string{"example.com"},
+			hostHeader:    "internal.local",
+			forwardedHost: "example.com:443",
+			wantProceeded: true,
+			wantHost:      "example.com",
+		},
+		{
+			name:          "rejects unmatched host from trusted proxy",
+			allowedHosts:  []string{"internal.local"},
+			hostHeader:    "internal.local",
+			forwardedHost: "bad.com",
+			wantErr:       true,
+		},
 		{
 			name:         "rejects if allowed host list is empty",
 			allowedHosts: []string{},
@@ -145,6 +161,7 @@ func TestHostValidationMiddleware(t *testing.T) {
 					return []string{"Host", tc.hostHeader}
 				}(),
 			})
+			req.req.forwardedHost = tc.forwardedHost
 
 			_, proceeded, err := TestMiddleware(hostValidationMiddleware(tc.allowedHosts), req)
 
@@ -0,0 +1,173 @@
+package routeit
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sktylr/routeit/internal/headers"
+	"github.com/sktylr/routeit/internal/hpack"
+	"github.com/sktylr/routeit/internal/http2"
+)
+
+// Serves a connection that speaks HTTP/2, either because the client chose it
+// during the TLS handshake or because it started the connection with the
+// HTTP/2 preface over plain text. Each stream carries a single request, which
+// is served by the same routing, middleware and error handling as requests
+// made over HTTP/1.1.
+func (s *Server) serveHttp2(c *conn) {
+	h2 := http2.NewConn(c.rwc, c.br, c.bw, http2.Config{
+		MaxConcurrentStreams: s.conf.MaxConcurrentStreams,
+		MaxHeaderListSize:    uint32(s.conf.MaxHeaderSize),
+		IdleTimeout:          s.conf.IdleTimeout,
+		WriteTimeout:         s.conf.WriteDeadline,
+		SetIdle:              func(idle bool) bool { return s.setIdle(c, idle) },
+	}, func(st *http2.Stream) { s.handleHttp2Stream(c, st) })
+	s.mu.Lock()
+	c.h2 = h2
+	s.mu.Unlock()
+
+	if err := h2.Serve(); err != nil {
+		s.log.Warn("Closing HTTP/2 connection", "err", err)
+	}
+}
+
+// Serves the request carried by a single HTTP/2 stream. The stream is reset if
+// the handler fails after its response has started streaming, which is how
+// HTTP/2 clients are told a response is incomplete.
+func (s *Server) handleHttp2Stream(c *conn, st *http2.Stream) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	// The stream is reset by the client if it no longer wants the response.
+	stop := context.AfterFunc(st.Context(), func() { cancel(errClientDisconnected) })
+	defer stop()
+	timer := time.AfterFunc(s.conf.WriteDeadline, func() { cancel(context.DeadlineExceeded) })
+	defer timer.Stop()
+
+	var rw *ResponseWriter
+	req, httpErr := s.readHttp2Request(st, ctx)
+	if httpErr != nil {
+		rw = newResponse()
+		httpErr.toResponse(rw)
+	} else {
+		s.setConnDetails(req, c.addr, c.tlsState)
+		rw = newResponseForMethod(req.mthd)
+		bw := bufio.NewWriter(st)
+		rw.stream = responseStream{
+			bw:      bw,
+			discard: req.mthd == HEAD,
+			start:   s.startStream(ctx, req, timer),
+			begin: func(rw *ResponseWriter) (io.WriteCloser, error) {
+				if err := st.WriteHeaders(http2Fields(rw), false); err != nil {
+					return nil, err
+				}
+				return http2Body{bw, st}, nil
+			},
+		}
+		rw = s.serveRequest(ctx, req, rw)
+	}
+
+	if err := writeHttp2Response(st, rw); err != nil {
+		s.log.Debug("Failed to respond to HTTP/2 stream", "stream", st.ID, "err", err)
+	}
+}
+
+// Reads the request carried by a stream. HTTP/2 requests carry the same
+// information as HTTP/1.1 requests, just encoded differently, so the request
+// is rewritten as a HTTP/1.1 message and parsed as one. The body is read in
+// full up front, since HTTP/2 has no need for the client to wait for
+// 100-continue.
+func (s *Server) readHttp2Request(st *http2.Stream, ctx context.Context) (*Request, *HttpError) {
+	st.SetReadDeadline(time.Now().Add(s.conf.ReadDeadline))
+	body, httpErr := readLimitedBody(st, s.conf.RequestSize)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+
+	host := http2.Field(st.Fields, ":authority")
+	if host == "" {
+		host = http2.Field(st.Fields, "host")
+	}
+	raw := newRawRequest(http2.Field(st.Fields, ":method"), http2.Field(st.Fields, ":path"), host)
+	for _, f := range st.Fields {
+		if strings.HasPrefix(f.Name, ":") {
+			continue
+		}
+		// The length is already known from the DATA frames, but a client
+		// that says otherwise has sent a malformed request (RFC-9113 Sec
+		// 8.1.1).
+		if f.Name == "content-length" && f.Value != strconv.Itoa(len(body)) {
+			return nil, ErrBadRequest().WithMessage("Content-Length does not match the length of the body")
+		}
+		raw.header(f.Name, f.Value)
+	}
+	trailers := headers.NewHeaders()
+	for _, f := range st.Trailers() {
+		trailers.Append(f.Name, f.Value)
+	}
+	limits := requestLimits{maxHeaderSize: s.conf.MaxHeaderSize, maxBodySize: s.conf.RequestSize}
+	return raw.parse(body, trailers, limits, ctx)
+}
+
+// Sends the response, or completes it if it has been streamed.
+func writeHttp2Response(st *http2.Stream, rw *ResponseWriter) error {
+	stream := &rw.stream
+	if !stream.started {
+		if len(rw.bdy) == 0 {
+			return st.WriteHeaders(http2Fields(rw), true)
+		}
+		if err := st.WriteHeaders(http2Fields(rw), false); err != nil {
+			return err
+		}
+		return st.WriteData(rw.bdy, true)
+	}
+	if stream.err != nil {
+		st.Reset(http2.ErrCodeInternal)
+		return stream.err
+	}
+	if stream.discard {
+		return st.Close()
+	}
+	if err := stream.body.Close(); err != nil {
+		st.Reset(http2.ErrCodeInternal)
+		return err
+	}
+	return nil
+}
+
+// Builds the header fields of the response. HTTP/2 has no status line, so the
+// status is sent as a pseudo-header field instead, and headers that only make
+// sense for a HTTP/1.1 connection, such as Connection and Transfer-Encoding,
+// are dropped.
+func http2Fields(rw *ResponseWriter) []hpack.Field {
+	rw.setDate()
+	fields := []hpack.Field{{Name: ":status", Value: strconv.Itoa(int(rw.s.code))}}
+	rw.headers.headers.Each(func(key, val string) {
+		if http2.IsConnectionSpecific(key) {
+			return
+		}
+		fields = append(fields, hpack.Field{Name: strings.ToLower(key), Value: val})
+	})
+	return fields
+}
+
+// The http2Body streams the body of a response through the response's buffer,
+// and ends the stream once closed.
+type http2Body struct {
+	bw *bufio.Writer
+	st *http2.Stream
+}
+
+func (b http2Body) Write(p []byte) (int, error) {
+	return b.bw.Write(p)
+}
+
+func (b http2Body) Close() error {
+	if err := b.bw.Flush(); err != nil {
+		return err
+	}
+	return b.st.Close()
+}
@@ -0,0 +1,294 @@
+package routeit
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttp2(t *testing.T) {
+	routes := RouteRegistry{
+		"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Headers().Set("X-Scheme", req.Scheme())
+			rw.Text("Hello!")
+			return nil
+		}),
+		"/echo": Post(func(rw *ResponseWriter, req *Request) error {
+			body, err := req.BodyFromRaw(CTTextPlain)
+			if err != nil {
+				return err
+			}
+			rw.RawWithContentType(body, CTTextPlain)
+			return nil
+		}),
+		"/stream": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Headers().Set("Content-Type", "text/plain")
+			for i := range 3 {
+				if _, err := rw.Write([]byte(strings.Repeat(string(rune('a'+i)), 40_000))); err != nil {
+					return err
+				}
+				if err := rw.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+		"/fails": Get(func(rw *ResponseWriter, req *Request) error {
+			return ErrImATeapot()
+		}),
+	}
+	start := func(t *testing.T, conf HttpConfig) net.Addr {
+		t.Helper()
+		srv := NewServer(ServerConfig{
+			Debug:          true,
+			LoggingHandler: slog.DiscardHandler,
+			RequestSize:    MiB,
+			HttpConfig:     conf,
+		})
+		srv.RegisterRoutes(routes)
+		go srv.Start()
+		t.Cleanup(func() { srv.Shutdown(context.Background()) })
+		return awaitAddrs(t, srv)[0]
+	}
+	tlsClient := func(addr net.Addr) *http.Client {
+		return &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, addr.Network(), addr.String())
+			},
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			ForceAttemptHTTP2: true,
+		}}
+	}
+	h2cClient := func() *http.Client {
+		protocols := new(http.Protocols)
+		protocols.SetUnencryptedHTTP2(true)
+		return &http.Client{Transport: &http.Transport{Protocols: protocols}}
+	}
+	get := func(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+		t.Helper()
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("failed to make request: %v", err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		return res, string(body)
+	}
+
+	t.Run("negotiated over tls", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpsAddr: "127.0.0.1:0", TlsConfig: newTestTlsConfig(t), EnableHttp2: true})
+
+		res, body := get(t, tlsClient(addr), "https://localhost/hello")
+
+		if res.ProtoMajor != 2 {
+			t.Errorf(`protocol = %s, wanted HTTP/2.0`, res.Proto)
+		}
+		if body != "Hello!" {
+			t.Errorf(`body = %#q, wanted "Hello!"`, body)
+		}
+		if got := res.Header.Get("X-Scheme"); got != "https" {
+			t.Errorf(`scheme = %#q, wanted "https"`, got)
+		}
+		if res.Header.Get("Date") == "" {
+			t.Error("expected Date header")
+		}
+	})
+
+	t.Run("not negotiated unless enabled", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpsAddr: "127.0.0.1:0", TlsConfig: newTestTlsConfig(t)})
+
+		res, body := get(t, tlsClient(addr), "https://localhost/hello")
+
+		if res.ProtoMajor != 1 {
+			t.Errorf(`protocol = %s, wanted HTTP/1.1`, res.Proto)
+		}
+		if body != "Hello!" {
+			t.Errorf(`body = %#q, wanted "Hello!"`, body)
+		}
+	})
+
+	t.Run("prior knowledge", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+		client := h2cClient()
+
+		for range 3 {
+			res, body := get(t, client, "http://"+addr.String()+"/hello")
+
+			if res.ProtoMajor != 2 {
+				t.Errorf(`protocol = %s, wanted HTTP/2.0`, res.Proto)
+			}
+			if body != "Hello!" {
+				t.Errorf(`body = %#q, wanted "Hello!"`, body)
+			}
+			if got := res.Header.Get("X-Scheme"); got != "http" {
+				t.Errorf(`scheme = %#q, wanted "http"`, got)
+			}
+		}
+	})
+
+	t.Run("http/1.1 alongside prior knowledge", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+
+		res, body := get(t, http.DefaultClient, "http://"+addr.String()+"/hello")
+
+		if res.ProtoMajor != 1 {
+			t.Errorf(`protocol = %s, wanted HTTP/1.1`, res.Proto)
+		}
+		if body != "Hello!" {
+			t.Errorf(`body = %#q, wanted "Hello!"`, body)
+		}
+	})
+
+	t.Run("request body larger than the flow control window", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+		want := bytes.Repeat([]byte("0123456789"), 30_000)
+
+		res, err := h2cClient().Post("http://"+addr.String()+"/echo", "text/plain", bytes.NewReader(want))
+		if err != nil {
+			t.Fatalf("failed to make request: %v", err)
+		}
+		defer res.Body.Close()
+		got, _ := io.ReadAll(res.Body)
+
+		if res.StatusCode != 201 {
+			t.Errorf(`status = %d, wanted 201`, res.StatusCode)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf(`len(body) = %d, wanted %d`, len(got), len(want))
+		}
+	})
+
+	t.Run("request body too large", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+
+		res, err := h2cClient().Post("http://"+addr.String()+"/echo", "text/plain", bytes.NewReader(make([]byte, 2*MiB)))
+		if err != nil {
+			t.Fatalf("failed to make request: %v", err)
+		}
+		res.Body.Close()
+
+		if res.StatusCode != 413 {
+			t.Errorf(`status = %d, wanted 413`, res.StatusCode)
+		}
+	})
+
+	t.Run("streamed response", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+
+		res, body := get(t, h2cClient(), "http://"+addr.String()+"/stream")
+
+		want := strings.Repeat("a", 40_000) + strings.Repeat("b", 40_000) + strings.Repeat("c", 40_000)
+		if body != want {
+			t.Errorf(`len(body) = %d, wanted %d`, len(body), len(want))
+		}
+		if res.Header.Get("Transfer-Encoding") != "" || len(res.TransferEncoding) != 0 {
+			t.Error("expected no Transfer-Encoding over HTTP/2")
+		}
+	})
+
+	t.Run("error handling", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+		client := h2cClient()
+
+		tests := []struct {
+			path string
+			want int
+		}{
+			{"/fails", 418},
+			{"/missing", 404},
+		}
+		for _, tc := range tests {
+			res, _ := get(t, client, "http://"+addr.String()+tc.path)
+
+			if res.StatusCode != tc.want {
+				t.Errorf(`%s status = %d, wanted %d`, tc.path, res.StatusCode, tc.want)
+			}
+		}
+	})
+
+	t.Run("head request", func(t *testing.T) {
+		addr := start(t, HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true})
+
+		res, err := h2cClient().Head("http://" + addr.String() + "/hello")
+		if err != nil {
+			t.Fatalf("failed to make request: %v", err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		if len(body) != 0 {
+			t.Errorf(`body = %#q, wanted empty`, body)
+		}
+		if res.ContentLength != 6 {
+			t.Errorf(`Content-Length = %d, wanted 6`, res.ContentLength)
+		}
+	})
+
+	t.Run("shutdown waits for in-flight streams", func(t *testing.T) {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		srv := NewServer(ServerConfig{
+			Debug:          true,
+			LoggingHandler: slog.DiscardHandler,
+			HttpConfig:     HttpConfig{HttpAddr: "127.0.0.1:0", EnableHttp2: true},
+		})
+		srv.RegisterRoutes(RouteRegistry{"/slow": Get(func(rw *ResponseWriter, req *Request) error {
+			close(entered)
+			<-release
+			rw.Text("Done")
+			return nil
+		})})
+		go srv.Start()
+		addr := awaitAddrs(t, srv)[0]
+
+		type result struct {
+			body string
+			err  error
+		}
+		done := make(chan result, 1)
+		go func() {
+			res, err := h2cClient().Get("http://" + addr.String() + "/slow")
+			if err != nil {
+				done <- result{err: err}
+				return
+			}
+			defer res.Body.Close()
+			body, err := io.ReadAll(res.Body)
+			done <- result{string(body), err}
+		}()
+		<-entered
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- srv.Shutdown(context.Background()) }()
+		select {
+		case <-shutdown:
+			t.Fatal("expected Shutdown() to wait for the in-flight stream")
+		case <-time.After(50 * time.Millisecond):
+		}
+		close(release)
+
+		r := <-done
+		if r.err != nil || r.body != "Done" {
+			t.Errorf(`response = %#q, %v, wanted "Done"`, r.body, r.err)
+		}
+		select {
+		case err := <-shutdown:
+			if err != nil {
+				t.Errorf("Shutdown() error = %v, wanted nil", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Shutdown() to return once the stream finished")
+		}
+	})
+}
@@ -0,0 +1,205 @@
+package routeit
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Wraps a handler written for net/http, so that libraries that ship as a
+// [http.Handler], such as net/http/pprof or a metrics exporter, can be
+// registered alongside routeit's own handlers. The wrapped handler responds to
+// every request method, and all of the server's middleware runs around it as
+// it would for any other handler.
+//
+// The handler is given the path of the request after any URL rewrites, in
+// full, so handlers that expect to be mounted under a prefix (such as
+// "/debug/pprof/") should be registered under the same namespace. The query
+// string is passed on exactly as the client sent it, followed by any query
+// added by URL rewrites. Routes
+// still need to be registered for each path the handler serves, which can be
+// done using dynamic path segments. The client's address is given as the
+// RemoteAddr, with a port of 0 since routeit does not track the client's port.
+//
+// The response is buffered until the handler returns or flushes it using
+// [http.Flusher], at which point it starts streaming as if written using
+// [ResponseWriter.Write]. Headers set by middleware are kept unless the handler
+// sets a header of the same name. The handler can take over the connection
+// using [http.Hijacker] where [ResponseWriter.Hijack] would allow it.
+func HttpHandler(h http.Handler) Handler {
+	fn := func(rw *ResponseWriter, req *Request) error {
+		w := &httpResponseWriter{rw: rw, header: http.Header{}}
+		h.ServeHTTP(w, newHttpRequest(req))
+		return w.finish()
+	}
+	return anyMethod(fn)
+}
+
+// Converts the request into one that net/http handlers understand. The request
+// has already been parsed and validated, so this cannot fail.
+func newHttpRequest(req *Request) *http.Request {
+	u := &url.URL{Path: req.Path(), RawQuery: req.uri.rawQuery}
+	// The path's segments do not record a trailing slash, which handlers such
+	// as [http.FileServer] rely on to tell directories apart from files.
+	if !req.uri.rewritten && strings.HasSuffix(req.uri.rawPath, "/") && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	if !req.uri.rewritten {
+		// Keeps any escaping used by the client, such as an encoded "/",
+		// which cannot be recovered from the decoded path alone.
+		u.RawPath = req.uri.rawPath
+	}
+
+	header := http.Header{}
+	req.headers.headers.Each(func(key, val string) {
+		if !strings.EqualFold(key, "Host") {
+			header.Add(key, val)
+		}
+	})
+	trailer := http.Header{}
+	req.trailers.headers.Each(trailer.Add)
+
+	r := &http.Request{
+		Method:        req.mthd.name,
+		URL:           u,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          http.NoBody,
+		ContentLength: int64(len(req.body)),
+		Host:          req.host,
+		RemoteAddr:    net.JoinHostPort(req.ip, "0"),
+		RequestURI:    u.RequestURI(),
+		TLS:           req.tlsState,
+		Trailer:       trailer,
+	}
+	if len(req.body) != 0 {
+		r.Body = readCloser{bytes.NewReader(req.body)}
+	}
+	return r.WithContext(req.Context())
+}
+
+type readCloser struct {
+	*bytes.Reader
+}
+
+func (readCloser) Close() error {
+	return nil
+}
+
+// The httpResponseWriter captures the response written by a net/http handler
+// and writes it to the routeit response.
+type httpResponseWriter struct {
+	rw          *ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	buf         []byte
+}
+
+func (w *httpResponseWriter) Header() http.Header {
+	return w.header
+}
+
+// Commits the status and headers to the response. Informational statuses are
+// ignored, since routeit does not send interim responses on behalf of
+// handlers.
+func (w *httpResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader || (code >= 100 && code < 200) {
+		return
+	}
+	w.wroteHeader = true
+	w.rw.Status(httpStatusFromCode(code))
+	setHttpHeaders(w.rw, w.header)
+}
+
+func (w *httpResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.rw.streaming() {
+		return w.rw.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+// Implements [http.Flusher], starting to stream the response if it has not
+// already started.
+func (w *httpResponseWriter) Flush() {
+	w.FlushError()
+}
+
+// Used by [http.ResponseController] in preference to Flush, so that handlers
+// can find out whether the response could be flushed.
+func (w *httpResponseWriter) FlushError() error {
+	w.WriteHeader(http.StatusOK)
+	if !w.rw.streaming() {
+		buf := w.buf
+		w.buf = nil
+		if _, err := w.rw.Write(buf); err != nil {
+			return err
+		}
+	}
+	return w.rw.Flush()
+}
+
+// Implements [http.Hijacker]. Writes to the returned connection are buffered
+// and must be flushed by the handler.
+func (w *httpResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, br, err := w.rw.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, bufio.NewReadWriter(br, bufio.NewWriter(conn)), nil
+}
+
+// Completes the response once the handler has returned. Handlers that never
+// write a status respond with 200: OK, as they would under net/http.
+func (w *httpResponseWriter) finish() error {
+	if w.rw.hijacked() || w.rw.streaming() {
+		return nil
+	}
+	w.WriteHeader(http.StatusOK)
+	if !w.rw.s.allowsBody() {
+		return nil
+	}
+	setHttpBody(w.rw, w.buf, w.header.Get("Content-Type"))
+	return nil
+}
+
+// Builds the status for a code given to us through net/http. Invalid codes
+// cause a panic once used, as they would in net/http.
+func httpStatusFromCode(code int) HttpStatus {
+	if code < 0 || code > 999 {
+		code = 0
+	}
+	return HttpStatus{code: uint16(code), msg: http.StatusText(code)}
+}
+
+// Copies headers given to us through net/http to the response, replacing any
+// headers of the same name that have already been set.
+func setHttpHeaders(rw *ResponseWriter, h http.Header) {
+	headers := rw.headers.headers
+	for key, vals := range h {
+		delete(headers, strings.ToLower(key))
+		for _, val := range vals {
+			headers.Append(key, val)
+		}
+	}
+}
+
+// Sets a body given to us through net/http. The Content-Type is kept as is,
+// since parameters other than the charset, such as a multipart boundary,
+// would otherwise be lost. Bodies without a Content-Type have it sniffed, as
+// net/http would.
+func setHttpBody(rw *ResponseWriter, body []byte, ct string) {
+	switch {
+	case ct != "":
+		rw.RawWithContentType(body, parseContentType(ct))
+		rw.headers.Set("Content-Type", ct)
+	case len(body) != 0:
+		rw.Raw(body)
+	}
+}
@@ -0,0 +1,138 @@
+package routeit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHttpHandler(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Path", r.URL.Path)
+		w.Header().Set("X-Query", r.URL.Query().Get("q"))
+		w.Header().Set("X-Agent", r.Header.Get("User-Agent"))
+		w.Header().Set("X-Host", r.Host)
+		w.Header().Set("X-Override", "handler")
+		fmt.Fprintf(w, "body=%s", body)
+	})
+	srv := NewServer(ServerConfig{Debug: true})
+	srv.RegisterMiddleware(func(c Chain, rw *ResponseWriter, req *Request) error {
+		rw.Headers().Set("X-Middleware", "ran")
+		rw.Headers().Set("X-Override", "middleware")
+		return c.Proceed(rw, req)
+	})
+	srv.RegisterRoutesUnderNamespace("/mounted", RouteRegistry{
+		"/:name": HttpHandler(echo),
+		"/status": HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "multipart/mixed; boundary=abc")
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte("--abc--"))
+		})),
+		"/empty": HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})),
+		"/stream": HttpHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("first,"))
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Write([]byte("second"))
+		})),
+		"/not-found": HttpHandler(http.NotFoundHandler()),
+	})
+	client := NewTestClient(srv)
+
+	t.Run("translates request", func(t *testing.T) {
+		res := client.PostText("/mounted/thing?q=hello%20world", "ping", "User-Agent", "tester")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, "body=ping")
+		res.AssertHeaderMatchesString(t, "X-Method", "POST")
+		res.AssertHeaderMatchesString(t, "X-Path", "/mounted/thing")
+		res.AssertHeaderMatchesString(t, "X-Query", "hello world")
+		res.AssertHeaderMatchesString(t, "X-Agent", "tester")
+		res.AssertHeaderMatchesString(t, "X-Host", "localhost")
+		res.AssertHeaderMatchesString(t, "Content-Type", "text/plain; charset=utf-8")
+	})
+
+	t.Run("keeps trailing slash", func(t *testing.T) {
+		res := client.Get("/mounted/thing/")
+
+		res.AssertHeaderMatchesString(t, "X-Path", "/mounted/thing/")
+	})
+
+	t.Run("keeps middleware headers", func(t *testing.T) {
+		res := client.Get("/mounted/thing")
+
+		res.AssertHeaderMatchesString(t, "X-Middleware", "ran")
+		res.AssertHeaderMatchesString(t, "X-Override", "handler")
+	})
+
+	t.Run("responds to every method", func(t *testing.T) {
+		for _, res := range []*TestResponse{
+			client.Get("/mounted/thing"),
+			client.PutText("/mounted/thing", "a"),
+			client.PatchText("/mounted/thing", "a"),
+			client.Delete("/mounted/thing"),
+		} {
+			res.AssertStatusCode(t, StatusOK)
+		}
+	})
+
+	t.Run("head", func(t *testing.T) {
+		res := client.Head("/mounted/thing")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyEmpty(t)
+		res.AssertHeaderMatchesString(t, "Content-Length", "5")
+	})
+
+	t.Run("status and content type", func(t *testing.T) {
+		res := client.Get("/mounted/status")
+
+		res.AssertStatusCode(t, StatusAccepted)
+		res.AssertBodyMatchesString(t, "--abc--")
+		res.AssertHeaderMatchesString(t, "Content-Type", "multipart/mixed; boundary=abc")
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		res := client.Get("/mounted/empty")
+
+		res.AssertStatusCode(t, StatusNoContent)
+		res.AssertBodyEmpty(t)
+	})
+
+	t.Run("streams once flushed", func(t *testing.T) {
+		res := client.Get("/mounted/stream")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, "first,second")
+		res.AssertHeaderMatchesString(t, "Transfer-Encoding", "chunked")
+	})
+
+	t.Run("error statuses are not handled by routeit", func(t *testing.T) {
+		res := client.Get("/mounted/not-found")
+
+		res.AssertStatusCode(t, StatusNotFound)
+		res.AssertBodyMatchesString(t, "404 page not found\n")
+	})
+	t.Run("serves directories with a file server", func(t *testing.T) {
+		files := fstest.MapFS{"dir/a.txt": {Data: []byte("a")}}
+		srv := NewServer(ServerConfig{Debug: true})
+		srv.RegisterRoutes(RouteRegistry{
+			"/fs/:dir": HttpHandler(http.StripPrefix("/fs", http.FileServer(http.FS(files)))),
+		})
+		client := NewTestClient(srv)
+
+		res := client.Get("/fs/dir/")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyContainsString(t, "a.txt")
+	})
+}
@@ -13,10 +13,13 @@ import (
 // client to remember to use HTTPS for all future requests to this host, which
 // is done using the Strict-Transport-Security header (RFC-6797). This header
 // tells the client how long to cache the HTTPS upgrade instruction for, and
-// also to respect this instruction on all subdomains of the host.
+// also to respect this instruction on all subdomains of the host. Requests
+// received from a trusted proxy use the scheme the client used to reach the
+// proxy, since the proxy may have terminated TLS itself, and are redirected to
+// the default HTTPS port of the proxy rather than the server's own port.
 func upgradeToHttpsMiddleware(httpsPort uint16, maxAge time.Duration) Middleware {
 	return func(c Chain, rw *ResponseWriter, req *Request) error {
-		if req.tlsState != nil {
+		if req.scheme == "https" {
 			// Inform the client that HTTPS is the preferred option using the
 			// HTTP Strict Transport Security (HSTS) headers. Browsers will
 			// cache this for as long as specified in max-age and will
@@ -29,6 +32,9 @@ func upgradeToHttpsMiddleware(httpsPort uint16, maxAge time.Duration) Middleware
 		host := req.host
 		endpoint := req.RawPath()
 		location := fmt.Sprintf("https://%s:%d%s", host, httpsPort, endpoint)
+		if req.forwarded {
+			location = fmt.Sprintf("https://%s%s", host, endpoint)
+		}
 
 		// The request is over HTTP so we inform the client to redirect to the
 		// equivalent HTTPS resource.
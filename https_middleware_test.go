@@ -10,6 +10,7 @@ func TestUpgradeToHttpsMiddleware(t *testing.T) {
 	tests := []struct {
 		name        string
 		opts        TestRequestOptions
+		scheme      string
 		validateRes func(t *testing.T, res *TestResponse)
 		wantProceed bool
 	}{
@@ -32,12 +33,35 @@ func TestUpgradeToHttpsMiddleware(t *testing.T) {
 				res.AssertHeaderMatchesString(t, "Location", "https://example.com:443/foo")
 			},
 		},
+		{
+			name:   "https from trusted proxy uses HSTS",
+			opts:   TestRequestOptions{Headers: []string{"Host", "example.com"}},
+			scheme: "https",
+			validateRes: func(t *testing.T, res *TestResponse) {
+				val := "max-age=31536000; includeSubdomains"
+				res.AssertHeaderMatchesString(t, "Strict-Transport-Security", val)
+			},
+			wantProceed: true,
+		},
+		{
+			name:   "http from trusted proxy redirected to default port",
+			opts:   TestRequestOptions{Headers: []string{"Host", "example.com"}},
+			scheme: "http",
+			validateRes: func(t *testing.T, res *TestResponse) {
+				res.AssertStatusCode(t, StatusMovedPermanently)
+				res.AssertHeaderMatchesString(t, "Location", "https://example.com/foo")
+			},
+		},
 	}
 	mware := upgradeToHttpsMiddleware(443, 365*24*time.Hour)
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			req := NewTestRequest(t, "/foo", GET, tc.opts)
+			if tc.scheme != "" {
+				req.req.scheme = tc.scheme
+				req.req.forwarded = true
+			}
 
 			res, proceeded, err := TestMiddleware(mware, req)
 
@@ -0,0 +1,171 @@
+// Package chunked implements the chunked transfer coding described in
+// RFC-9112 Sec 7.1. A chunked message body is made up of a series of chunks,
+// each prefixed by its size in hexadecimal, followed by a zero-sized "last
+// chunk" and an optional trailer section.
+//
+//	chunked-body   = *chunk
+//	                 last-chunk
+//	                 trailer-section
+//	                 CRLF
+//
+//	chunk          = chunk-size [ chunk-ext ] CRLF
+//	                 chunk-data CRLF
+//	chunk-size     = 1*HEXDIG
+//	last-chunk     = 1*("0") [ chunk-ext ] CRLF
+//
+// https://www.rfc-editor.org/rfc/rfc9112.html#name-chunked-transfer-coding
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/sktylr/routeit/internal/headers"
+)
+
+var (
+	// Returned when the decoded body, or the trailer section, exceeds the
+	// maximum size allowed by the caller.
+	ErrTooLarge = errors.New("chunked body exceeds maximum size")
+	// Returned whenever the body does not follow the chunked syntax.
+	ErrMalformed = errors.New("malformed chunked body")
+)
+
+// Chunk size lines are made up of the hexadecimal size and any chunk
+// extensions. There is no limit to their length in the spec, but we don't
+// want clients to be able to send arbitrarily long lines, so we cap them.
+const maxChunkLineLength = 4096
+
+// Decodes a chunked body from the reader, returning the decoded body and any
+// trailer fields. The reader is only advanced as far as the end of the
+// chunked body. The decoded body may be at most maxSize bytes and the trailer
+// section may be at most maxTrailerSize bytes, otherwise [ErrTooLarge] is
+// returned. Chunk extensions are parsed for validity but otherwise ignored,
+// since routeit does not define any extensions of its own.
+func Decode(br *bufio.Reader, maxSize, maxTrailerSize int) ([]byte, headers.Headers, error) {
+	var body []byte
+	for {
+		line, err := readLine(br, maxChunkLineLength, ErrMalformed)
+		if err != nil {
+			return nil, nil, err
+		}
+		size, err := parseChunkSize(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		if size == 0 {
+			break
+		}
+		if size > uint64(maxSize-len(body)) {
+			return nil, nil, ErrTooLarge
+		}
+
+		start := len(body)
+		body = append(body, make([]byte, size)...)
+		if _, err := io.ReadFull(br, body[start:]); err != nil {
+			return nil, nil, unexpectedEOF(err)
+		}
+		crlf, err := readLine(br, 0, ErrMalformed)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(crlf) != 0 {
+			return nil, nil, ErrMalformed
+		}
+	}
+
+	trailers, err := readTrailers(br, maxTrailerSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	return body, trailers, nil
+}
+
+// Parses the chunk size and validates any chunk extensions that follow it.
+//
+//	chunk-ext      = *( BWS ";" BWS chunk-ext-name
+//	                    [ BWS "=" BWS chunk-ext-val ] )
+func parseChunkSize(line []byte) (uint64, error) {
+	sizeRaw, exts, hasExts := bytes.Cut(line, []byte(";"))
+	sizeRaw = bytes.TrimRight(sizeRaw, " \t")
+	if len(sizeRaw) == 0 || len(sizeRaw) > 16 {
+		return 0, ErrMalformed
+	}
+	size, err := strconv.ParseUint(string(sizeRaw), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid chunk size %q", ErrMalformed, sizeRaw)
+	}
+	if !hasExts {
+		return size, nil
+	}
+	for ext := range strings.SplitSeq(string(exts), ";") {
+		name, _, _ := strings.Cut(ext, "=")
+		if strings.TrimSpace(name) == "" {
+			return 0, fmt.Errorf("%w: invalid chunk extension %q", ErrMalformed, ext)
+		}
+	}
+	return size, nil
+}
+
+// Reads the trailer section, which follows the same syntax as the header
+// section, up until the terminating blank line.
+func readTrailers(br *bufio.Reader, max int) (headers.Headers, error) {
+	trailers := headers.NewHeaders()
+	remaining := max
+	for {
+		line, err := readLine(br, remaining, ErrTooLarge)
+		if err != nil {
+			return nil, err
+		}
+		remaining -= len(line) + 2
+		if len(line) == 0 {
+			return trailers, nil
+		}
+		key, val, found := strings.Cut(string(line), ":")
+		if !found || key == "" || strings.TrimSpace(key) != key {
+			return nil, fmt.Errorf("%w: invalid trailer field %q", ErrMalformed, line)
+		}
+		trailers.Append(key, strings.TrimSpace(val))
+	}
+}
+
+// Reads a CRLF terminated line of at most max bytes (excluding the CRLF) and
+// returns it without the CRLF. If the line is too long, errTooLong is
+// returned.
+func readLine(br *bufio.Reader, max int, errTooLong error) ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := br.ReadSlice('\n')
+		if len(line)+len(frag) > max+2 {
+			return nil, errTooLong
+		}
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrMalformed
+	}
+	return line[:len(line)-2], nil
+}
+
+// The body ending early is always unexpected, since the chunked syntax tells
+// us exactly how much more data there is to come.
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
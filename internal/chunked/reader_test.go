@@ -0,0 +1,185 @@
+package chunked
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			in           string
+			want         string
+			wantTrailers map[string][]string
+			wantRest     string
+		}{
+			{
+				name: "single chunk",
+				in:   "5\r\nhello\r\n0\r\n\r\n",
+				want: "hello",
+			},
+			{
+				name: "multiple chunks",
+				in:   "5\r\nhello\r\n1\r\n \r\n5\r\nworld\r\n0\r\n\r\n",
+				want: "hello world",
+			},
+			{
+				name: "empty body",
+				in:   "0\r\n\r\n",
+				want: "",
+			},
+			{
+				name: "hexadecimal sizes",
+				in:   "a\r\n0123456789\r\nA\r\nabcdefghij\r\n0\r\n\r\n",
+				want: "0123456789abcdefghij",
+			},
+			{
+				name: "chunk data containing CRLF",
+				in:   "4\r\na\r\nb\r\n0\r\n\r\n",
+				want: "a\r\nb",
+			},
+			{
+				name: "ignores chunk extensions",
+				in:   "5;name=value\r\nhello\r\n6 ; foo ; bar=\"baz\"\r\n world\r\n0;last\r\n\r\n",
+				want: "hello world",
+			},
+			{
+				name:         "parses trailers",
+				in:           "5\r\nhello\r\n0\r\nExpires: never\r\nX-Checksum:  abc \r\n\r\n",
+				want:         "hello",
+				wantTrailers: map[string][]string{"Expires": {"never"}, "X-Checksum": {"abc"}},
+			},
+			{
+				name:     "does not consume past the end of the body",
+				in:       "5\r\nhello\r\n0\r\n\r\nGET / HTTP/1.1\r\n",
+				want:     "hello",
+				wantRest: "GET / HTTP/1.1\r\n",
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				br := bufio.NewReader(strings.NewReader(tc.in))
+
+				body, trailers, err := Decode(br, 1024, 1024)
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(body) != tc.want {
+					t.Errorf(`body = %q, wanted %q`, body, tc.want)
+				}
+				if len(trailers) != len(tc.wantTrailers) {
+					t.Errorf(`len(trailers) = %d, wanted %d`, len(trailers), len(tc.wantTrailers))
+				}
+				for k, want := range tc.wantTrailers {
+					got, _ := trailers.All(k)
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf(`trailers[%q] = %v, wanted %v`, k, got, want)
+					}
+				}
+				rest, _ := io.ReadAll(br)
+				if string(rest) != tc.wantRest {
+					t.Errorf(`remaining = %q, wanted %q`, rest, tc.wantRest)
+				}
+			})
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			in      string
+			max     int
+			wantErr error
+		}{
+			{
+				name:    "non hexadecimal size",
+				in:      "z\r\nhello\r\n0\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "missing size",
+				in:      "\r\nhello\r\n0\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "negative size",
+				in:      "-5\r\nhello\r\n0\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "size overflows",
+				in:      "fffffffffffffffff\r\nhello\r\n0\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "chunk longer than size",
+				in:      "3\r\nhello\r\n0\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "bare line feed",
+				in:      "5\nhello\n0\n\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "empty chunk extension",
+				in:      "5;\r\nhello\r\n0\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "invalid trailer",
+				in:      "5\r\nhello\r\n0\r\nnot a trailer\r\n\r\n",
+				wantErr: ErrMalformed,
+			},
+			{
+				name:    "missing last chunk",
+				in:      "5\r\nhello\r\n",
+				wantErr: io.ErrUnexpectedEOF,
+			},
+			{
+				name:    "truncated chunk",
+				in:      "5\r\nhel",
+				wantErr: io.ErrUnexpectedEOF,
+			},
+			{
+				name:    "missing final CRLF",
+				in:      "5\r\nhello\r\n0\r\n",
+				wantErr: io.ErrUnexpectedEOF,
+			},
+			{
+				name:    "body too large",
+				in:      "5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n",
+				max:     8,
+				wantErr: ErrTooLarge,
+			},
+			{
+				name:    "trailers too large",
+				in:      "5\r\nhello\r\n0\r\nX-Big: " + strings.Repeat("a", 2048) + "\r\n\r\n",
+				wantErr: ErrTooLarge,
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				max := tc.max
+				if max == 0 {
+					max = 1024
+				}
+				br := bufio.NewReader(strings.NewReader(tc.in))
+
+				_, _, err := Decode(br, max, 1024)
+
+				if !errors.Is(err, tc.wantErr) {
+					t.Errorf(`err = %v, wanted %v`, err, tc.wantErr)
+				}
+			})
+		}
+	})
+}
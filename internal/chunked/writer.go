@@ -0,0 +1,45 @@
+package chunked
+
+import (
+	"fmt"
+	"io"
+)
+
+// A Writer encodes everything written to it using the chunked transfer coding
+// before passing it on to the underlying writer. Each call to Write produces
+// a single chunk, so callers wanting larger chunks should buffer the data
+// before it reaches the Writer. The body is only complete once Close has been
+// called.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Writes p as a single chunk. Empty writes are ignored, since a zero-sized
+// chunk marks the end of the body.
+func (cw *Writer) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := fmt.Fprintf(cw.w, "%x\r\n", len(p)); err != nil {
+		return 0, err
+	}
+	n, err := cw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if _, err := io.WriteString(cw.w, "\r\n"); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// Writes the last chunk and an empty trailer section, completing the body.
+// This does not close the underlying writer.
+func (cw *Writer) Close() error {
+	_, err := io.WriteString(cw.w, "0\r\n\r\n")
+	return err
+}
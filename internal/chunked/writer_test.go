@@ -0,0 +1,75 @@
+package chunked
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{
+			name: "no writes",
+			want: "0\r\n\r\n",
+		},
+		{
+			name:   "single write",
+			writes: []string{"hello"},
+			want:   "5\r\nhello\r\n0\r\n\r\n",
+		},
+		{
+			name:   "multiple writes",
+			writes: []string{"hello", " ", "world"},
+			want:   "5\r\nhello\r\n1\r\n \r\n5\r\nworld\r\n0\r\n\r\n",
+		},
+		{
+			name:   "ignores empty writes",
+			writes: []string{"hello", "", "world"},
+			want:   "5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n",
+		},
+		{
+			name:   "hexadecimal sizes",
+			writes: []string{"0123456789abcdefghij"},
+			want:   "14\r\n0123456789abcdefghij\r\n0\r\n\r\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cw := NewWriter(&buf)
+
+			for _, w := range tc.writes {
+				n, err := cw.Write([]byte(w))
+				if err != nil {
+					t.Fatalf("Write(%q) error = %v", w, err)
+				}
+				if n != len(w) {
+					t.Errorf("Write(%q) = %d, wanted %d", w, n, len(w))
+				}
+			}
+			if err := cw.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+
+			if buf.String() != tc.want {
+				t.Errorf(`encoded = %q, wanted %q`, buf.String(), tc.want)
+			}
+			body, _, err := Decode(bufio.NewReader(&buf), 1024, 1024)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			var want bytes.Buffer
+			for _, w := range tc.writes {
+				want.WriteString(w)
+			}
+			if !bytes.Equal(body, want.Bytes()) {
+				t.Errorf(`decoded = %q, wanted %q`, body, want.Bytes())
+			}
+		})
+	}
+}
@@ -41,6 +41,17 @@ func (h Headers) WriteTo(writer io.Writer) (int64, error) {
 	return total, nil
 }
 
+// Calls fn with each key and value, sanitised in the same way as when they are
+// written. Keys are given in their original case.
+func (h Headers) Each(fn func(key, val string)) {
+	for _, v := range h {
+		key := strings.Map(sanitiseHeader, strings.TrimSpace(v.original))
+		for _, val := range v.vals {
+			fn(key, strings.Map(sanitiseHeader, val))
+		}
+	}
+}
+
 // Sets a key-value pair in the headers. This is a case insensitive operation
 // that will create a new entry in the map if needed or update an existing
 // entry if already present.
@@ -106,6 +117,24 @@ func (h Headers) ContentLength() uint {
 	return uint(cLen)
 }
 
+// Reports whether any of the comma-separated values of the header contain the
+// given token. Tokens are compared case-insensitively, which is the case for
+// list-based headers such as Connection or Transfer-Encoding.
+func (h Headers) ContainsToken(key, token string) bool {
+	vals, found := h.All(key)
+	if !found {
+		return false
+	}
+	for _, val := range vals {
+		for t := range strings.SplitSeq(val, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func sanitiseHeader(r rune) rune {
 	// We only allow printable ASCII characters, which are between 32 and 126
 	// (decimal) inclusive. Additionally, the HTAB character is allowed.
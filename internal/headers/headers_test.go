@@ -187,6 +187,62 @@ func TestContentLength(t *testing.T) {
 	}
 }
 
+func TestContainsToken(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    []string
+		token string
+		want  bool
+	}{
+		{
+			name:  "not present",
+			token: "close",
+		},
+		{
+			name:  "exact match",
+			in:    []string{"close"},
+			token: "close",
+			want:  true,
+		},
+		{
+			name:  "case insensitive",
+			in:    []string{"Close"},
+			token: "close",
+			want:  true,
+		},
+		{
+			name:  "comma separated list",
+			in:    []string{"keep-alive, Upgrade"},
+			token: "upgrade",
+			want:  true,
+		},
+		{
+			name:  "multiple header values",
+			in:    []string{"keep-alive", "close"},
+			token: "close",
+			want:  true,
+		},
+		{
+			name:  "does not match substrings",
+			in:    []string{"closed"},
+			token: "close",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h := NewHeaders()
+			for _, v := range tc.in {
+				h.Append("Connection", v)
+			}
+
+			if got := h.ContainsToken("Connection", tc.token); got != tc.want {
+				t.Errorf(`ContainsToken(%#q) = %t, wanted %t`, tc.token, got, tc.want)
+			}
+		})
+	}
+}
+
 func verifyHeaderPresentAndMatches(t *testing.T, h Headers, key string, want []string) {
 	t.Helper()
 	got, exists := h.All(key)
@@ -0,0 +1,361 @@
+// Package hpack implements HPACK, the header compression format used by
+// HTTP/2 (RFC-7541). The decoder supports the full format, including the
+// dynamic table and Huffman coding. The encoder only ever refers to the static
+// table and never adds to the dynamic table, which keeps it free of state
+// while still producing valid header blocks.
+package hpack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// A Field is a single header field, such as "content-type: text/plain". Names
+// are lower case, and pseudo-header fields, such as ":path", start with a
+// colon.
+type Field struct {
+	Name  string
+	Value string
+	// Set for fields that must never be added to a dynamic table, such as
+	// those holding credentials (RFC-7541 Sec 6.2.3).
+	Sensitive bool
+}
+
+// The size of the field as counted towards the size of the dynamic table and
+// the size of a header list (RFC-7541 Sec 4.1).
+func (f Field) Size() uint32 {
+	return uint32(len(f.Name) + len(f.Value) + 32)
+}
+
+func (f Field) String() string {
+	return fmt.Sprintf("%s: %s", f.Name, f.Value)
+}
+
+var (
+	// Returned for header blocks that do not follow the format, after which
+	// the decoder cannot be used any further since its dynamic table may no
+	// longer match the encoder's.
+	ErrInvalid = errors.New("hpack: invalid header block")
+	// Returned when a string in a header block is longer than allowed.
+	ErrStringTooLong = errors.New("hpack: string too long")
+	// Returned when the fields of a header block add up to more than the
+	// decoder's header list limit.
+	ErrListTooLarge = errors.New("hpack: header list too large")
+)
+
+// The largest string the decoder accepts, unless told otherwise.
+const defaultMaxStringLength = 64 * 1024
+
+// The dynamic table holds the fields most recently added by the encoder, with
+// the newest field first (RFC-7541 Sec 2.3.2). Fields are evicted from the end
+// once the table grows beyond its maximum size.
+type dynamicTable struct {
+	fields  []Field
+	size    uint32
+	maxSize uint32
+}
+
+func (t *dynamicTable) add(f Field) {
+	t.fields = append([]Field{f}, t.fields...)
+	t.size += f.Size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(size uint32) {
+	t.maxSize = size
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	for t.size > t.maxSize && len(t.fields) != 0 {
+		last := t.fields[len(t.fields)-1]
+		t.fields = t.fields[:len(t.fields)-1]
+		t.size -= last.Size()
+	}
+}
+
+// A Decoder decodes header blocks sent by a single peer. Each peer has its own
+// dynamic table, so a decoder must only ever be used for one connection and
+// header blocks must be decoded in the order they were received.
+type Decoder struct {
+	table dynamicTable
+	// The largest size the peer may use for its dynamic table, as set by our
+	// SETTINGS_HEADER_TABLE_SIZE.
+	maxTableSize    uint32
+	maxStringLength int
+	// The largest header list, as measured by the size of its fields, that a
+	// single header block may decode to. Zero means there is no limit.
+	maxListSize uint32
+	// Set once a header block fails to decode.
+	err error
+}
+
+// Creates a decoder that allows the peer a dynamic table of up to
+// maxTableSize bytes.
+func NewDecoder(maxTableSize uint32) *Decoder {
+	return &Decoder{
+		table:           dynamicTable{maxSize: maxTableSize},
+		maxTableSize:    maxTableSize,
+		maxStringLength: defaultMaxStringLength,
+	}
+}
+
+// Limits the length of each name and value the decoder accepts, which stops a
+// peer from using a small Huffman encoded string to make us allocate a large
+// one.
+func (d *Decoder) SetMaxStringLength(n int) {
+	d.maxStringLength = n
+}
+
+// Limits the total size of the fields each header block decodes to (RFC-7541
+// Sec 4.1). Indexed fields take a single byte but can refer to large entries
+// of the dynamic table, so without a limit a small block can decode to a
+// header list thousands of times its size. Decoding stops as soon as the limit
+// is passed.
+func (d *Decoder) SetMaxHeaderListSize(n uint32) {
+	d.maxListSize = n
+}
+
+// Decodes a complete header block. Once this has failed, all later calls fail
+// with the same error, since the dynamic table may be out of step with the
+// peer's.
+func (d *Decoder) Decode(block []byte) ([]Field, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	fields, err := d.decode(block)
+	if err != nil {
+		d.err = err
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (d *Decoder) decode(b []byte) ([]Field, error) {
+	var fields []Field
+	var listSize uint64
+	emit := func(f Field) error {
+		listSize += uint64(f.Size())
+		if d.maxListSize != 0 && listSize > uint64(d.maxListSize) {
+			return ErrListTooLarge
+		}
+		fields = append(fields, f)
+		return nil
+	}
+	// Dynamic table size updates may only appear at the start of a block
+	// (RFC-7541 Sec 4.2).
+	sizeUpdateAllowed := true
+	for len(b) != 0 {
+		c := b[0]
+		switch {
+		case c&0x80 != 0:
+			// Indexed field (RFC-7541 Sec 6.1).
+			idx, rest, err := readInt(b, 7)
+			if err != nil {
+				return nil, err
+			}
+			f, err := d.at(idx)
+			if err != nil {
+				return nil, err
+			}
+			if err := emit(f); err != nil {
+				return nil, err
+			}
+			b = rest
+		case c&0xc0 == 0x40:
+			// Literal field with incremental indexing (RFC-7541 Sec 6.2.1).
+			f, rest, err := d.readLiteral(b, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(f)
+			if err := emit(f); err != nil {
+				return nil, err
+			}
+			b = rest
+		case c&0xe0 == 0x20:
+			// Dynamic table size update (RFC-7541 Sec 6.3).
+			if !sizeUpdateAllowed {
+				return nil, ErrInvalid
+			}
+			size, rest, err := readInt(b, 5)
+			if err != nil {
+				return nil, err
+			}
+			if size > uint64(d.maxTableSize) {
+				return nil, ErrInvalid
+			}
+			d.table.setMaxSize(uint32(size))
+			b = rest
+			continue
+		default:
+			// Literal field without indexing, or never indexed (RFC-7541 Sec
+			// 6.2.2 and 6.2.3).
+			f, rest, err := d.readLiteral(b, 4)
+			if err != nil {
+				return nil, err
+			}
+			f.Sensitive = c&0xf0 == 0x10
+			if err := emit(f); err != nil {
+				return nil, err
+			}
+			b = rest
+		}
+		sizeUpdateAllowed = false
+	}
+	return fields, nil
+}
+
+// Looks up the field at an index of the combined static and dynamic tables
+// (RFC-7541 Sec 2.3.3).
+func (d *Decoder) at(idx uint64) (Field, error) {
+	switch {
+	case idx == 0:
+		return Field{}, ErrInvalid
+	case idx <= uint64(len(staticTable)):
+		return staticTable[idx-1], nil
+	case idx-uint64(len(staticTable)) <= uint64(len(d.table.fields)):
+		return d.table.fields[idx-uint64(len(staticTable))-1], nil
+	default:
+		return Field{}, ErrInvalid
+	}
+}
+
+// Reads a literal field, whose name is either given by an index with the
+// given prefix length or follows as a string.
+func (d *Decoder) readLiteral(b []byte, prefix uint8) (Field, []byte, error) {
+	idx, rest, err := readInt(b, prefix)
+	if err != nil {
+		return Field{}, nil, err
+	}
+	var f Field
+	if idx == 0 {
+		if f.Name, rest, err = d.readString(rest); err != nil {
+			return Field{}, nil, err
+		}
+	} else {
+		named, err := d.at(idx)
+		if err != nil {
+			return Field{}, nil, err
+		}
+		f.Name = named.Name
+	}
+	if f.Value, rest, err = d.readString(rest); err != nil {
+		return Field{}, nil, err
+	}
+	return f, rest, nil
+}
+
+// Reads a string literal (RFC-7541 Sec 5.2).
+func (d *Decoder) readString(b []byte) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, ErrInvalid
+	}
+	huffman := b[0]&0x80 != 0
+	n, rest, err := readInt(b, 7)
+	if err != nil {
+		return "", nil, err
+	}
+	if n > uint64(len(rest)) {
+		return "", nil, ErrInvalid
+	}
+	raw := rest[:n]
+	rest = rest[n:]
+	if !huffman {
+		if len(raw) > d.maxStringLength {
+			return "", nil, ErrStringTooLong
+		}
+		return string(raw), rest, nil
+	}
+	// Huffman codes are at least 5 bits long, which bounds how long the
+	// decoded string can be.
+	if len(raw)*8/5 > d.maxStringLength {
+		return "", nil, ErrStringTooLong
+	}
+	decoded, err := huffmanDecode(make([]byte, 0, len(raw)*8/5), raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return string(decoded), rest, nil
+}
+
+// Reads an integer with an N-bit prefix (RFC-7541 Sec 5.1). The prefix is in
+// the low bits of the first byte, and values that do not fit in the prefix
+// continue over the following bytes, 7 bits at a time.
+func readInt(b []byte, prefix uint8) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, ErrInvalid
+	}
+	max := uint64(1)<<prefix - 1
+	n := uint64(b[0]) & max
+	b = b[1:]
+	if n < max {
+		return n, b, nil
+	}
+	for shift := uint(0); ; shift += 7 {
+		// Anything beyond a 32 bit integer is far larger than any index or
+		// length we could accept.
+		if len(b) == 0 || shift > 28 {
+			return 0, nil, ErrInvalid
+		}
+		c := b[0]
+		b = b[1:]
+		n += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return n, b, nil
+		}
+	}
+}
+
+// Appends an integer with an N-bit prefix, with the first byte's bits above
+// the prefix set to flags.
+func appendInt(dst []byte, flags byte, prefix uint8, n uint64) []byte {
+	max := uint64(1)<<prefix - 1
+	if n < max {
+		return append(dst, flags|byte(n))
+	}
+	dst = append(dst, flags|byte(max))
+	n -= max
+	for n >= 0x80 {
+		dst = append(dst, byte(n&0x7f)|0x80)
+		n >>= 7
+	}
+	return append(dst, byte(n))
+}
+
+// Appends a string literal, Huffman encoding it when that makes it shorter.
+func appendString(dst []byte, s string) []byte {
+	if n := huffmanEncodedLen(s); n < len(s) {
+		dst = appendInt(dst, 0x80, 7, uint64(n))
+		return huffmanEncode(dst, s)
+	}
+	dst = appendInt(dst, 0, 7, uint64(len(s)))
+	return append(dst, s...)
+}
+
+// An Encoder encodes header blocks. Fields are encoded using the static table
+// where possible and are otherwise sent as literals that are not added to the
+// dynamic table, so the encoder needs no state and is safe to share.
+type Encoder struct{}
+
+// Appends the header block for the fields to dst.
+func (Encoder) Encode(dst []byte, fields []Field) []byte {
+	for _, f := range fields {
+		idx, exact := staticIndex(f)
+		switch {
+		case exact && !f.Sensitive:
+			dst = appendInt(dst, 0x80, 7, uint64(idx))
+			continue
+		case f.Sensitive:
+			// Never indexed, so intermediaries do not index it either.
+			dst = appendInt(dst, 0x10, 4, uint64(idx))
+		default:
+			dst = appendInt(dst, 0x00, 4, uint64(idx))
+		}
+		if idx == 0 {
+			dst = appendString(dst, f.Name)
+		}
+		dst = appendString(dst, f.Value)
+	}
+	return dst
+}
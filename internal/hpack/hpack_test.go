@@ -0,0 +1,247 @@
+package hpack
+
+import (
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestDecoder(t *testing.T) {
+	// The request examples of RFC-7541 Appendix C.3 and C.4, which are
+	// decoded in order on the same connection.
+	tests := []struct {
+		name   string
+		blocks []string
+		want   [][]Field
+	}{
+		{
+			name: "without huffman coding",
+			blocks: []string{
+				"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+				"8286 84be 5808 6e6f 2d63 6163 6865",
+				"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+			},
+			want: [][]Field{
+				{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: ":authority", Value: "www.example.com"}},
+				{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: ":authority", Value: "www.example.com"}, {Name: "cache-control", Value: "no-cache"}},
+				{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "https"}, {Name: ":path", Value: "/index.html"}, {Name: ":authority", Value: "www.example.com"}, {Name: "custom-key", Value: "custom-value"}},
+			},
+		},
+		{
+			name: "with huffman coding",
+			blocks: []string{
+				"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+				"8286 84be 5886 a8eb 1064 9cbf",
+				"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+			},
+			want: [][]Field{
+				{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: ":authority", Value: "www.example.com"}},
+				{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "http"}, {Name: ":path", Value: "/"}, {Name: ":authority", Value: "www.example.com"}, {Name: "cache-control", Value: "no-cache"}},
+				{{Name: ":method", Value: "GET"}, {Name: ":scheme", Value: "https"}, {Name: ":path", Value: "/index.html"}, {Name: ":authority", Value: "www.example.com"}, {Name: "custom-key", Value: "custom-value"}},
+			},
+		},
+		{
+			name:   "never indexed",
+			blocks: []string{"1008 7061 7373 776f 7264 0673 6563 7265 74"},
+			want:   [][]Field{{{Name: "password", Value: "secret", Sensitive: true}}},
+		},
+		{
+			name:   "table size update evicts entries",
+			blocks: []string{"400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65", "20"},
+			want:   [][]Field{{{Name: "custom-key", Value: "custom-value"}}, nil},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(4096)
+
+			for i, block := range tc.blocks {
+				got, err := d.Decode(mustHex(t, block))
+
+				if err != nil {
+					t.Fatalf(`block %d: err = %v, wanted nil`, i, err)
+				}
+				if !slices.Equal(got, tc.want[i]) {
+					t.Errorf(`block %d = %v, wanted %v`, i, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   string
+		wantErr error
+	}{
+		{"index zero", "80", ErrInvalid},
+		{"index beyond tables", "be", ErrInvalid},
+		{"truncated integer", "ff", ErrInvalid},
+		{"integer overflow", "ff ff ff ff ff ff 01", ErrInvalid},
+		{"truncated string", "0085 6162", ErrInvalid},
+		{"size update after field", "82 20", ErrInvalid},
+		{"size update beyond setting", "3fe2 1f", ErrInvalid},
+		{"huffman end of string symbol", "0084 ffff ffff 00", ErrInvalid},
+		{"huffman padding too long", "0082 ffff 00", ErrInvalid},
+		{"huffman padding not ones", "0081 00 00", ErrInvalid},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDecoder(4096)
+
+			_, err := d.Decode(mustHex(t, tc.block))
+
+			if err == nil {
+				t.Fatal(`err = nil, wanted an error`)
+			}
+			if !errors.Is(err, tc.wantErr) && !errors.Is(err, ErrInvalidHuffman) {
+				t.Errorf(`err = %v, wanted %v`, err, tc.wantErr)
+			}
+			if _, again := d.Decode([]byte{0x82}); again == nil {
+				t.Error("expected the decoder to keep failing")
+			}
+		})
+	}
+
+	t.Run("header list too large", func(t *testing.T) {
+		d := NewDecoder(4096)
+		d.SetMaxHeaderListSize(100)
+		// Adds "ab: cd" to the dynamic table, then refers to it twice. Each
+		// field counts as 36 bytes.
+		block := mustHex(t, "4002 6162 0263 64be be")
+
+		_, err := d.Decode(block)
+
+		if !errors.Is(err, ErrListTooLarge) {
+			t.Errorf(`err = %v, wanted %v`, err, ErrListTooLarge)
+		}
+	})
+
+	t.Run("header list at limit", func(t *testing.T) {
+		d := NewDecoder(4096)
+		d.SetMaxHeaderListSize(108)
+
+		fields, err := d.Decode(mustHex(t, "4002 6162 0263 64be be"))
+
+		if err != nil || len(fields) != 3 {
+			t.Errorf(`Decode() = %v, %v, wanted 3 fields`, fields, err)
+		}
+	})
+
+	t.Run("string too long", func(t *testing.T) {
+		d := NewDecoder(4096)
+		d.SetMaxStringLength(3)
+
+		_, err := d.Decode(mustHex(t, "0004 6162 6364 00"))
+
+		if !errors.Is(err, ErrStringTooLong) {
+			t.Errorf(`err = %v, wanted %v`, err, ErrStringTooLong)
+		}
+	})
+}
+
+func TestEncoder(t *testing.T) {
+	fields := []Field{
+		{Name: ":status", Value: "200"},
+		{Name: ":status", Value: "418"},
+		{Name: "content-type", Value: "text/plain"},
+		{Name: "x-request-id", Value: "abc123"},
+		{Name: "set-cookie", Value: "session=secret", Sensitive: true},
+		{Name: "date", Value: ""},
+		{Name: "x-binary", Value: "\x00\xff\x7f"},
+	}
+
+	block := Encoder{}.Encode(nil, fields)
+
+	if block[0] != 0x88 {
+		t.Errorf(`first byte = %#x, wanted the static index of ":status: 200"`, block[0])
+	}
+	got, err := NewDecoder(4096).Decode(block)
+	if err != nil {
+		t.Fatalf(`err = %v, wanted nil`, err)
+	}
+	if !slices.Equal(got, fields) {
+		t.Errorf(`decoded = %v, wanted %v`, got, fields)
+	}
+}
+
+func TestInt(t *testing.T) {
+	// The integer examples of RFC-7541 Appendix C.1.
+	tests := []struct {
+		n      uint64
+		prefix uint8
+		want   string
+	}{
+		{10, 5, "0a"},
+		{1337, 5, "1f9a0a"},
+		{42, 8, "2a"},
+	}
+
+	for _, tc := range tests {
+		got := appendInt(nil, 0, tc.prefix, tc.n)
+
+		if hex.EncodeToString(got) != tc.want {
+			t.Errorf(`appendInt(%d) = %x, wanted %s`, tc.n, got, tc.want)
+		}
+		n, rest, err := readInt(got, tc.prefix)
+		if err != nil || n != tc.n || len(rest) != 0 {
+			t.Errorf(`readInt(%x) = %d, %x, %v, wanted %d`, got, n, rest, err, tc.n)
+		}
+	}
+}
+
+func TestHuffman(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"www.example.com", "f1e3c2e5f23a6ba0ab90f4ff"},
+		{"no-cache", "a8eb10649cbf"},
+		{"custom-key", "25a849e95ba97d7f"},
+		{"", ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got := huffmanEncode(nil, tc.in)
+
+			if hex.EncodeToString(got) != tc.want {
+				t.Errorf(`huffmanEncode(%q) = %x, wanted %s`, tc.in, got, tc.want)
+			}
+			if n := huffmanEncodedLen(tc.in); n != len(got) {
+				t.Errorf(`huffmanEncodedLen(%q) = %d, wanted %d`, tc.in, n, len(got))
+			}
+			decoded, err := huffmanDecode(nil, got)
+			if err != nil || string(decoded) != tc.in {
+				t.Errorf(`huffmanDecode(%x) = %q, %v, wanted %q`, got, decoded, err, tc.in)
+			}
+		})
+	}
+
+	t.Run("all symbols", func(t *testing.T) {
+		var all []byte
+		for i := range 256 {
+			all = append(all, byte(i))
+		}
+
+		decoded, err := huffmanDecode(nil, huffmanEncode(nil, string(all)))
+
+		if err != nil || string(decoded) != string(all) {
+			t.Errorf(`round trip = %q, %v, wanted every symbol`, decoded, err)
+		}
+	})
+}
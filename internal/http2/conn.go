@@ -0,0 +1,983 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sktylr/routeit/internal/hpack"
+)
+
+var (
+	// Returned when reading from or writing to a stream that has been reset
+	// by either side.
+	ErrStreamReset = errors.New("http2: stream reset")
+	// Returned when writing to a stream that has already been ended.
+	ErrStreamClosed = errors.New("http2: stream closed")
+	// Returned when using a stream whose connection has been closed.
+	ErrConnClosed = errors.New("http2: connection closed")
+)
+
+// The Handler is called in its own goroutine for each stream the client opens,
+// once the request's header block has been received. The handler should send
+// a response on the stream, which is reset if the handler returns without
+// ending the stream.
+type Handler func(st *Stream)
+
+type Config struct {
+	// The most streams the client may have open at once. Further streams are
+	// refused. Defaults to 100.
+	MaxConcurrentStreams uint32
+	// The largest header list the client may send, which is advertised to the
+	// client. Header blocks that are larger than this before being
+	// decompressed close the connection, since they must be decoded to keep
+	// the compression state in step with the client. Defaults to 32 KiB.
+	MaxHeaderListSize uint32
+	// How long the connection may go without any open streams before it is
+	// closed. Defaults to no timeout.
+	IdleTimeout time.Duration
+	// How long a stream waits for the client to let it send more data,
+	// through flow control, before the stream is reset. Defaults to no
+	// timeout.
+	WriteTimeout time.Duration
+	// Called when the connection goes from having open streams to having
+	// none (idle is true), and back again (idle is false). When this returns
+	// false, the connection is shut down as if [Conn.Shutdown] was called
+	// and any stream that was being opened is refused. This is called while
+	// the connection's state is locked, so must not call back into the
+	// connection.
+	SetIdle func(idle bool) bool
+}
+
+// A Conn is the server side of a HTTP/2 connection. The connection is read
+// from by [Conn.Serve], while frames are written by the handlers of each
+// stream, one frame (or header block) at a time.
+type Conn struct {
+	rwc     net.Conn
+	br      *bufio.Reader
+	conf    Config
+	handler Handler
+	dec     *hpack.Decoder
+
+	// Guards writing frames to bw, so frames from different streams are not
+	// interleaved. Must not be acquired while holding wmu.
+	wmu sync.Mutex
+	bw  *bufio.Writer
+
+	// Guards everything below, with cond signalled whenever a stream's state
+	// or a flow control window changes.
+	mu   sync.Mutex
+	cond *sync.Cond
+	// The streams that have a running handler.
+	streams map[uint32]*Stream
+	// The highest stream ID the client has used, which is also the last
+	// stream we have processed.
+	lastStreamID uint32
+	// How much data we may send on the connection, and the client on each
+	// stream, as set by the client's SETTINGS.
+	sendWindow       int64
+	peerInitialWin   int64
+	peerMaxFrameSize uint32
+	// Set once our SETTINGS have been sent, which must be the first frame on
+	// the connection.
+	prefaced  bool
+	goingAway bool
+	closed    bool
+
+	handlers sync.WaitGroup
+	// Set while a header block is spread over a HEADERS frame and its
+	// CONTINUATION frames.
+	continuing *headerBlock
+}
+
+type headerBlock struct {
+	streamID  uint32
+	endStream bool
+	frag      []byte
+}
+
+// Creates the server side of a connection. The reader must be positioned at
+// the start of the client's connection preface, and the writer must write to
+// the same connection.
+func NewConn(rwc net.Conn, br *bufio.Reader, bw *bufio.Writer, conf Config, handler Handler) *Conn {
+	if conf.MaxConcurrentStreams == 0 {
+		conf.MaxConcurrentStreams = 100
+	}
+	if conf.MaxHeaderListSize == 0 {
+		conf.MaxHeaderListSize = 32 * 1024
+	}
+	if conf.SetIdle == nil {
+		conf.SetIdle = func(bool) bool { return true }
+	}
+	dec := hpack.NewDecoder(4096)
+	dec.SetMaxStringLength(int(conf.MaxHeaderListSize))
+	dec.SetMaxHeaderListSize(conf.MaxHeaderListSize)
+	c := &Conn{
+		rwc:              rwc,
+		br:               br,
+		bw:               bw,
+		conf:             conf,
+		handler:          handler,
+		dec:              dec,
+		streams:          map[uint32]*Stream{},
+		sendWindow:       defaultWindowSize,
+		peerInitialWin:   defaultWindowSize,
+		peerMaxFrameSize: minMaxFrameSize,
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Serves the connection until either side closes it. The client's preface is
+// read, our SETTINGS are sent and then frames are read and acted upon one at a
+// time. Once the connection is closed, this waits for the handlers of any open
+// streams to return. The returned error is nil when the connection was closed
+// gracefully.
+func (c *Conn) Serve() error {
+	defer c.handlers.Wait()
+	defer c.close()
+
+	c.setReadDeadline()
+	preface := make([]byte, len(Preface))
+	if _, err := io.ReadFull(c.br, preface); err != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.goingAway {
+			return nil
+		}
+		return err
+	}
+	if string(preface) != Preface {
+		return errors.New("http2: invalid connection preface")
+	}
+	settings := appendSettings(nil,
+		Setting{SettingMaxConcurrentStreams, c.conf.MaxConcurrentStreams},
+		Setting{SettingMaxHeaderListSize, c.conf.MaxHeaderListSize},
+	)
+	if err := c.writeFrame(FrameHeader{Type: FrameSettings}, settings); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.prefaced = true
+	away := c.goingAway
+	keep := away || c.conf.SetIdle(true)
+	c.mu.Unlock()
+	if away {
+		// We were shut down before we could send anything.
+		c.writeGoAway(0, ErrCodeNo, "")
+		return nil
+	}
+	if !keep {
+		c.Shutdown()
+	}
+
+	var buf []byte
+	for first := true; ; first = false {
+		c.setReadDeadline()
+		h, payload, err := ReadFrame(c.br, minMaxFrameSize, buf)
+		if err == nil && first && h.Type != FrameSettings {
+			err = ConnError{ErrCodeProtocol, "connection preface not followed by settings"}
+		}
+		if err == nil {
+			err = c.processFrame(h, payload)
+		}
+		var se streamError
+		if errors.As(err, &se) {
+			c.resetStream(se.id, se.code)
+			continue
+		}
+		if err != nil {
+			return c.fail(err)
+		}
+		buf = payload
+		c.mu.Lock()
+		done := c.goingAway && len(c.streams) == 0
+		c.mu.Unlock()
+		if done {
+			return nil
+		}
+	}
+}
+
+// Shuts the connection down gracefully. The client is told, through a GOAWAY
+// frame, that no new streams will be processed, while streams that are
+// already open are served as normal. The connection is closed once they have
+// all finished. This is safe to call more than once.
+func (c *Conn) Shutdown() {
+	c.mu.Lock()
+	if c.goingAway || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.goingAway = true
+	if !c.prefaced {
+		// Our SETTINGS must be the first frame we send, so the GOAWAY is left
+		// to be sent once they have been.
+		c.mu.Unlock()
+		c.rwc.SetReadDeadline(time.Unix(1, 0))
+		return
+	}
+	last, idle := c.lastStreamID, len(c.streams) == 0
+	c.mu.Unlock()
+	c.writeGoAway(last, ErrCodeNo, "")
+	if idle {
+		// Nothing is left to wait for, so interrupt the pending read.
+		c.rwc.SetReadDeadline(time.Unix(1, 0))
+	}
+}
+
+// Handles the error that ended the connection. Connection errors are sent to
+// the client before the connection is closed, while a client that goes away
+// or stays idle for too long is not an error.
+func (c *Conn) fail(err error) error {
+	var ce ConnError
+	if errors.As(err, &ce) {
+		c.mu.Lock()
+		last := c.lastStreamID
+		c.mu.Unlock()
+		c.writeGoAway(last, ce.Code, ce.Reason)
+		return err
+	}
+	c.mu.Lock()
+	idle, goingAway := len(c.streams) == 0, c.goingAway
+	c.mu.Unlock()
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF):
+		return nil
+	case errors.As(err, &netErr) && netErr.Timeout() && (idle || goingAway):
+		if !goingAway {
+			c.writeGoAway(c.lastStreamID, ErrCodeNo, "")
+		}
+		return nil
+	}
+	return err
+}
+
+func (c *Conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	for _, st := range c.streams {
+		st.cancel()
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.rwc.Close()
+}
+
+// Times out reads while the connection is idle. Reads never time out while
+// streams are open, since the client may have nothing to send while it waits
+// for a response.
+func (c *Conn) setReadDeadline() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setReadDeadlineLocked()
+}
+
+func (c *Conn) setReadDeadlineLocked() {
+	switch {
+	case c.closed:
+	case c.goingAway && len(c.streams) == 0:
+		c.rwc.SetReadDeadline(time.Unix(1, 0))
+	case len(c.streams) == 0 && c.conf.IdleTimeout != 0:
+		c.rwc.SetReadDeadline(time.Now().Add(c.conf.IdleTimeout))
+	default:
+		c.rwc.SetReadDeadline(time.Time{})
+	}
+}
+
+func (c *Conn) processFrame(h FrameHeader, payload []byte) error {
+	if c.continuing != nil && h.Type != FrameContinuation {
+		return ConnError{ErrCodeProtocol, "header block interrupted by another frame"}
+	}
+	switch h.Type {
+	case FrameData:
+		return c.processData(h, payload)
+	case FrameHeaders:
+		return c.processHeaders(h, payload)
+	case FrameContinuation:
+		return c.processContinuation(h, payload)
+	case FramePriority:
+		if h.StreamID == 0 {
+			return ConnError{ErrCodeProtocol, "priority frame on stream 0"}
+		}
+		if len(payload) != 5 {
+			return streamError{h.StreamID, ErrCodeFrameSize}
+		}
+		// Priorities are advisory, and we serve every stream as soon as we
+		// can.
+		return nil
+	case FrameRSTStream:
+		return c.processRSTStream(h, payload)
+	case FrameSettings:
+		return c.processSettings(h, payload)
+	case FramePushPromise:
+		return ConnError{ErrCodeProtocol, "clients cannot push streams"}
+	case FramePing:
+		if h.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "ping frame on a stream"}
+		}
+		if len(payload) != 8 {
+			return ConnError{ErrCodeFrameSize, "ping frame must be 8 bytes"}
+		}
+		if h.Flags.Has(FlagAck) {
+			return nil
+		}
+		return c.writeFrame(FrameHeader{Type: FramePing, Flags: FlagAck}, payload)
+	case FrameGoAway:
+		if h.StreamID != 0 {
+			return ConnError{ErrCodeProtocol, "goaway frame on a stream"}
+		}
+		// The client will not open any more streams, so the connection can
+		// be closed once the open streams have finished.
+		c.mu.Lock()
+		c.goingAway = true
+		c.mu.Unlock()
+		return nil
+	case FrameWindowUpdate:
+		return c.processWindowUpdate(h, payload)
+	default:
+		// Frames of unknown types must be ignored (RFC-9113 Sec 4.1).
+		return nil
+	}
+}
+
+func (c *Conn) processData(h FrameHeader, payload []byte) error {
+	if h.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "data frame on stream 0"}
+	}
+	// The whole frame, including padding, counts towards flow control. We
+	// buffer data as soon as it arrives, so the connection's window is given
+	// straight back and each stream's window bounds how much is buffered.
+	size := uint32(len(payload))
+	data, err := stripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	if size != 0 {
+		if err := c.writeWindowUpdate(0, size); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	st, ok := c.streams[h.StreamID]
+	if !ok || st.remoteClosed || st.reset {
+		c.mu.Unlock()
+		if h.StreamID > c.lastStreamID {
+			return ConnError{ErrCodeProtocol, "data frame on idle stream"}
+		}
+		if ok && st.reset {
+			// Frames sent before the client saw the reset are ignored.
+			return nil
+		}
+		return streamError{h.StreamID, ErrCodeStreamClosed}
+	}
+	if int64(size) > st.recvWindow {
+		c.mu.Unlock()
+		return streamError{h.StreamID, ErrCodeFlowControl}
+	}
+	st.recvWindow -= int64(size)
+	// Padding is never read, so it is given back straight away.
+	st.unacked += int64(size) - int64(len(data))
+	st.body.Write(data)
+	if h.Flags.Has(FlagEndStream) {
+		st.remoteClosed = true
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Conn) processHeaders(h FrameHeader, payload []byte) error {
+	if h.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "headers frame on stream 0"}
+	}
+	frag, err := stripPadding(h, payload)
+	if err != nil {
+		return err
+	}
+	if h.Flags.Has(FlagPriority) {
+		if len(frag) < 5 {
+			return ConnError{ErrCodeFrameSize, "headers frame too short for its priority"}
+		}
+		if binary.BigEndian.Uint32(frag)&(1<<31-1) == h.StreamID {
+			return streamError{h.StreamID, ErrCodeProtocol}
+		}
+		frag = frag[5:]
+	}
+	block := &headerBlock{
+		streamID:  h.StreamID,
+		endStream: h.Flags.Has(FlagEndStream),
+		frag:      append([]byte(nil), frag...),
+	}
+	if !h.Flags.Has(FlagEndHeaders) {
+		c.continuing = block
+		return c.checkBlockSize(block)
+	}
+	return c.processHeaderBlock(block)
+}
+
+func (c *Conn) processContinuation(h FrameHeader, payload []byte) error {
+	block := c.continuing
+	if block == nil || block.streamID != h.StreamID {
+		return ConnError{ErrCodeProtocol, "unexpected continuation frame"}
+	}
+	block.frag = append(block.frag, payload...)
+	if err := c.checkBlockSize(block); err != nil {
+		return err
+	}
+	if !h.Flags.Has(FlagEndHeaders) {
+		return nil
+	}
+	c.continuing = nil
+	return c.processHeaderBlock(block)
+}
+
+// Decompressed header lists are never smaller than their header block, so a
+// block that is already too large cannot be accepted. It still has to be
+// decoded to keep the decoder in step with the client, so we give up on the
+// connection rather than buffer it.
+func (c *Conn) checkBlockSize(block *headerBlock) error {
+	if uint32(len(block.frag)) > c.conf.MaxHeaderListSize {
+		return ConnError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+	return nil
+}
+
+func (c *Conn) processHeaderBlock(block *headerBlock) error {
+	// The decoder stops as soon as the header list grows beyond the limit,
+	// which leaves its dynamic table out of step with the client's, so the
+	// connection cannot be used any further.
+	fields, err := c.dec.Decode(block.frag)
+	if err != nil {
+		return ConnError{ErrCodeCompression, err.Error()}
+	}
+
+	c.mu.Lock()
+	if st, ok := c.streams[block.streamID]; ok {
+		c.mu.Unlock()
+		return c.processTrailers(st, block, fields)
+	}
+	if block.streamID <= c.lastStreamID {
+		c.mu.Unlock()
+		// The stream has finished. Trailers sent before the client saw the
+		// stream end are ignored.
+		return nil
+	}
+	if block.streamID%2 == 0 {
+		c.mu.Unlock()
+		return ConnError{ErrCodeProtocol, "clients must use odd stream identifiers"}
+	}
+	if c.goingAway {
+		// Streams opened after we have told the client we are going away are
+		// ignored, and the client knows to retry them elsewhere.
+		c.mu.Unlock()
+		return nil
+	}
+	c.lastStreamID = block.streamID
+	if uint32(len(c.streams)) >= c.conf.MaxConcurrentStreams {
+		c.mu.Unlock()
+		return streamError{block.streamID, ErrCodeRefusedStream}
+	}
+	if err := checkFields(fields, false); err != nil {
+		c.mu.Unlock()
+		return streamError{block.streamID, ErrCodeProtocol}
+	}
+	if len(c.streams) == 0 && !c.conf.SetIdle(false) {
+		c.mu.Unlock()
+		c.Shutdown()
+		return streamError{block.streamID, ErrCodeRefusedStream}
+	}
+	st := c.newStream(block.streamID, fields)
+	st.remoteClosed = block.endStream
+	c.streams[st.ID] = st
+	c.handlers.Add(1)
+	c.mu.Unlock()
+
+	go c.runHandler(st)
+	return nil
+}
+
+// A second header block on a stream holds the request's trailers, and must end
+// the stream (RFC-9113 Sec 8.1).
+func (c *Conn) processTrailers(st *Stream, block *headerBlock, fields []hpack.Field) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if st.reset {
+		return nil
+	}
+	if st.remoteClosed {
+		return streamError{st.ID, ErrCodeStreamClosed}
+	}
+	if !block.endStream || checkFields(fields, true) != nil {
+		return streamError{st.ID, ErrCodeProtocol}
+	}
+	st.trailers = fields
+	st.remoteClosed = true
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *Conn) processRSTStream(h FrameHeader, payload []byte) error {
+	if len(payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "rst_stream frame must be 4 bytes"}
+	}
+	if h.StreamID == 0 {
+		return ConnError{ErrCodeProtocol, "rst_stream frame on stream 0"}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h.StreamID > c.lastStreamID {
+		return ConnError{ErrCodeProtocol, "rst_stream frame on idle stream"}
+	}
+	if st, ok := c.streams[h.StreamID]; ok {
+		st.reset = true
+		st.cancel()
+		c.cond.Broadcast()
+	}
+	return nil
+}
+
+func (c *Conn) processSettings(h FrameHeader, payload []byte) error {
+	if h.StreamID != 0 {
+		return ConnError{ErrCodeProtocol, "settings frame on a stream"}
+	}
+	if h.Flags.Has(FlagAck) {
+		if len(payload) != 0 {
+			return ConnError{ErrCodeFrameSize, "settings acknowledgement with a payload"}
+		}
+		return nil
+	}
+	settings, err := parseSettings(payload)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	for _, s := range settings {
+		switch s.ID {
+		case SettingEnablePush:
+			if s.Val > 1 {
+				c.mu.Unlock()
+				return ConnError{ErrCodeProtocol, "invalid enable push setting"}
+			}
+		case SettingInitialWindowSize:
+			if s.Val > maxWindowSize {
+				c.mu.Unlock()
+				return ConnError{ErrCodeFlowControl, "initial window size too large"}
+			}
+			// The change applies to the windows of every open stream, which
+			// may leave them negative (RFC-9113 Sec 6.9.2).
+			delta := int64(s.Val) - c.peerInitialWin
+			for _, st := range c.streams {
+				st.sendWindow += delta
+				if st.sendWindow > maxWindowSize {
+					c.mu.Unlock()
+					return ConnError{ErrCodeFlowControl, "stream window too large"}
+				}
+			}
+			c.peerInitialWin = int64(s.Val)
+		case SettingMaxFrameSize:
+			if s.Val < minMaxFrameSize || s.Val > maxMaxFrameSize {
+				c.mu.Unlock()
+				return ConnError{ErrCodeProtocol, "invalid max frame size"}
+			}
+			c.peerMaxFrameSize = s.Val
+		}
+		// We never add to the client's dynamic table, so its size does not
+		// matter, and the remaining settings are advisory.
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	return c.writeFrame(FrameHeader{Type: FrameSettings, Flags: FlagAck}, nil)
+}
+
+func (c *Conn) processWindowUpdate(h FrameHeader, payload []byte) error {
+	if len(payload) != 4 {
+		return ConnError{ErrCodeFrameSize, "window_update frame must be 4 bytes"}
+	}
+	incr := int64(binary.BigEndian.Uint32(payload) & (1<<31 - 1))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h.StreamID == 0 {
+		if incr == 0 {
+			return ConnError{ErrCodeProtocol, "window_update with no increment"}
+		}
+		c.sendWindow += incr
+		if c.sendWindow > maxWindowSize {
+			return ConnError{ErrCodeFlowControl, "connection window too large"}
+		}
+		c.cond.Broadcast()
+		return nil
+	}
+	if h.StreamID > c.lastStreamID {
+		return ConnError{ErrCodeProtocol, "window_update on idle stream"}
+	}
+	st, ok := c.streams[h.StreamID]
+	if !ok || st.reset {
+		return nil
+	}
+	if incr == 0 {
+		return streamError{h.StreamID, ErrCodeProtocol}
+	}
+	st.sendWindow += incr
+	if st.sendWindow > maxWindowSize {
+		return streamError{h.StreamID, ErrCodeFlowControl}
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+func (c *Conn) runHandler(st *Stream) {
+	defer c.handlers.Done()
+	defer c.finishStream(st)
+	c.handler(st)
+}
+
+// Tidies up once a stream's handler has returned. A stream the handler did
+// not end is reset, and the client is told to stop sending a request body
+// that nothing will read (RFC-9113 Sec 8.1).
+func (c *Conn) finishStream(st *Stream) {
+	c.mu.Lock()
+	code, reset := ErrCodeNo, false
+	switch {
+	case st.reset || c.closed:
+	case !st.localClosed:
+		code, reset = ErrCodeInternal, true
+	case !st.remoteClosed:
+		reset = true
+	}
+	st.reset = true
+	st.cancel()
+	if st.readTimer != nil {
+		st.readTimer.Stop()
+	}
+	delete(c.streams, st.ID)
+	idle := len(c.streams) == 0
+	keep := !idle || c.conf.SetIdle(true)
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	if reset {
+		c.writeRSTStream(st.ID, code)
+	}
+	if !keep {
+		c.Shutdown()
+	}
+	if idle {
+		c.setReadDeadline()
+	}
+}
+
+// Resets a stream following a stream error.
+func (c *Conn) resetStream(id uint32, code ErrCode) {
+	c.mu.Lock()
+	if st, ok := c.streams[id]; ok {
+		st.reset = true
+		st.cancel()
+		c.cond.Broadcast()
+	}
+	c.mu.Unlock()
+	c.writeRSTStream(id, code)
+}
+
+// Waits for the condition to be signalled, or the deadline to pass, which is
+// reported by returning false. The deadline is ignored when zero. Must be
+// called while holding mu.
+func (c *Conn) waitUntil(deadline time.Time) bool {
+	if !deadline.IsZero() {
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		t := time.AfterFunc(time.Until(deadline), func() {
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		})
+		defer t.Stop()
+	}
+	c.cond.Wait()
+	return true
+}
+
+// Writes a single frame and sends it straight away.
+func (c *Conn) writeFrame(h FrameHeader, payload []byte) error {
+	return c.write(func(w *bufio.Writer) error {
+		return WriteFrame(w, h, payload)
+	})
+}
+
+// Writes frames using fn, which is given sole use of the connection, then
+// sends them. The connection is closed if they cannot be sent, since the
+// client may have received part of a frame.
+func (c *Conn) write(fn func(w *bufio.Writer) error) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	err := fn(c.bw)
+	if err == nil {
+		err = c.bw.Flush()
+	}
+	if err != nil {
+		c.rwc.Close()
+	}
+	return err
+}
+
+func (c *Conn) writeWindowUpdate(id, incr uint32) error {
+	return c.writeFrame(FrameHeader{Type: FrameWindowUpdate, StreamID: id}, binary.BigEndian.AppendUint32(nil, incr))
+}
+
+func (c *Conn) writeRSTStream(id uint32, code ErrCode) error {
+	return c.writeFrame(FrameHeader{Type: FrameRSTStream, StreamID: id}, binary.BigEndian.AppendUint32(nil, uint32(code)))
+}
+
+func (c *Conn) writeGoAway(last uint32, code ErrCode, reason string) error {
+	payload := binary.BigEndian.AppendUint32(nil, last)
+	payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(FrameHeader{Type: FrameGoAway}, payload)
+}
+
+// A Stream is a single request and response exchanged over the connection.
+type Stream struct {
+	ID uint32
+	// The request's header fields, including the pseudo-header fields such
+	// as ":method" and ":path", in the order they were sent.
+	Fields []hpack.Field
+
+	conn   *Conn
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Everything below is guarded by the connection's mutex.
+	body     bytes.Buffer
+	trailers []hpack.Field
+	// Whether each side has ended the stream, and whether either side has
+	// reset it.
+	remoteClosed bool
+	localClosed  bool
+	reset        bool
+	// How much the client may send before we give it more room, and how much
+	// has been read since we last did.
+	recvWindow int64
+	unacked    int64
+	// How much we may send before the client gives us more room.
+	sendWindow   int64
+	readDeadline time.Time
+	readTimer    *time.Timer
+}
+
+// Must be called while holding the connection's mutex.
+func (c *Conn) newStream(id uint32, fields []hpack.Field) *Stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Stream{
+		ID:         id,
+		Fields:     fields,
+		conn:       c,
+		ctx:        ctx,
+		cancel:     cancel,
+		recvWindow: defaultWindowSize,
+		sendWindow: c.peerInitialWin,
+	}
+}
+
+// The stream's context is cancelled once the stream has been reset or the
+// connection has closed, which means the client is no longer waiting for the
+// response.
+func (st *Stream) Context() context.Context {
+	return st.ctx
+}
+
+// Reads the request body, returning [io.EOF] once the client has ended the
+// stream.
+func (st *Stream) Read(p []byte) (int, error) {
+	c := st.conn
+	c.mu.Lock()
+	for st.body.Len() == 0 {
+		switch {
+		case st.reset:
+			c.mu.Unlock()
+			return 0, ErrStreamReset
+		case st.remoteClosed:
+			c.mu.Unlock()
+			return 0, io.EOF
+		case c.closed:
+			c.mu.Unlock()
+			return 0, ErrConnClosed
+		}
+		if !c.waitUntil(st.readDeadline) {
+			c.mu.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+	n, _ := st.body.Read(p)
+	// The client is given room to send more once we have read a good portion
+	// of what it has sent, rather than after every read.
+	st.unacked += int64(n)
+	var incr int64
+	if !st.remoteClosed && st.unacked >= defaultWindowSize/2 {
+		incr, st.unacked = st.unacked, 0
+		st.recvWindow += incr
+	}
+	c.mu.Unlock()
+	if incr != 0 {
+		c.writeWindowUpdate(st.ID, uint32(incr))
+	}
+	return n, nil
+}
+
+// Sets the deadline for reading the request body, after which
+// [os.ErrDeadlineExceeded] is returned. A zero value means reads do not time
+// out.
+func (st *Stream) SetReadDeadline(t time.Time) {
+	c := st.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st.readDeadline = t
+	c.cond.Broadcast()
+}
+
+// The request's trailer fields, which are only available once the body has
+// been read in full.
+func (st *Stream) Trailers() []hpack.Field {
+	c := st.conn
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return st.trailers
+}
+
+// Sends the header fields of the response, or the response's trailers if
+// they follow the body. Set endStream if nothing else will be sent on the
+// stream.
+func (st *Stream) WriteHeaders(fields []hpack.Field, endStream bool) error {
+	c := st.conn
+	c.mu.Lock()
+	if err := st.writableLocked(); err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	st.localClosed = endStream
+	maxFrame := int(c.peerMaxFrameSize)
+	c.mu.Unlock()
+
+	block := hpack.Encoder{}.Encode(nil, fields)
+	return c.write(func(w *bufio.Writer) error {
+		// Header blocks must be sent as one HEADERS frame followed by as many
+		// CONTINUATION frames as needed, with nothing in between.
+		h := FrameHeader{Type: FrameHeaders, StreamID: st.ID}
+		if endStream {
+			h.Flags |= FlagEndStream
+		}
+		for {
+			frag := block[:min(len(block), maxFrame)]
+			block = block[len(frag):]
+			if len(block) == 0 {
+				h.Flags |= FlagEndHeaders
+			}
+			if err := WriteFrame(w, h, frag); err != nil {
+				return err
+			}
+			if len(block) == 0 {
+				return nil
+			}
+			h = FrameHeader{Type: FrameContinuation, StreamID: st.ID}
+		}
+	})
+}
+
+// Write sends p as the next part of the response body.
+func (st *Stream) Write(p []byte) (int, error) {
+	if err := st.WriteData(p, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Ends the stream once the response has been sent in full.
+func (st *Stream) Close() error {
+	return st.WriteData(nil, true)
+}
+
+// Sends p as the next part of the response body, ending the stream with it
+// if endStream is set. This waits for the client to make room for the data
+// through flow control, and resets the stream if the client takes longer than
+// the configured write timeout to do so.
+func (st *Stream) WriteData(p []byte, endStream bool) error {
+	c := st.conn
+	for {
+		c.mu.Lock()
+		var deadline time.Time
+		if c.conf.WriteTimeout != 0 {
+			deadline = time.Now().Add(c.conf.WriteTimeout)
+		}
+		for {
+			if err := st.writableLocked(); err != nil {
+				c.mu.Unlock()
+				return err
+			}
+			if len(p) == 0 || (st.sendWindow > 0 && c.sendWindow > 0) {
+				break
+			}
+			if !c.waitUntil(deadline) {
+				c.mu.Unlock()
+				st.Reset(ErrCodeFlowControl)
+				return os.ErrDeadlineExceeded
+			}
+		}
+		n := min(int64(len(p)), st.sendWindow, c.sendWindow, int64(c.peerMaxFrameSize))
+		st.sendWindow -= n
+		c.sendWindow -= n
+		last := endStream && n == int64(len(p))
+		if last {
+			st.localClosed = true
+		}
+		c.mu.Unlock()
+
+		h := FrameHeader{Type: FrameData, StreamID: st.ID}
+		if last {
+			h.Flags |= FlagEndStream
+		}
+		if err := c.writeFrame(h, p[:n]); err != nil {
+			return err
+		}
+		p = p[n:]
+		if len(p) == 0 {
+			return nil
+		}
+	}
+}
+
+// Resets the stream, telling the client that it will not be completed.
+func (st *Stream) Reset(code ErrCode) {
+	c := st.conn
+	c.mu.Lock()
+	if st.reset || (st.localClosed && st.remoteClosed) || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	st.reset = true
+	st.cancel()
+	c.cond.Broadcast()
+	c.mu.Unlock()
+	c.writeRSTStream(st.ID, code)
+}
+
+func (st *Stream) writableLocked() error {
+	switch {
+	case st.reset:
+		return ErrStreamReset
+	case st.conn.closed:
+		return ErrConnClosed
+	case st.localClosed:
+		return ErrStreamClosed
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package http2
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sktylr/routeit/internal/hpack"
+)
+
+type frame struct {
+	FrameHeader
+	payload []byte
+}
+
+// A testClient drives a connection by sending it raw frames, as a client
+// would, and collecting every frame the connection sends back.
+type testClient struct {
+	t      *testing.T
+	c      *Conn
+	conn   net.Conn
+	frames chan frame
+	served chan error
+}
+
+func newTestClient(t *testing.T, conf Config, handler Handler) *testClient {
+	t.Helper()
+	server, client := net.Pipe()
+	c := NewConn(server, bufio.NewReader(server), bufio.NewWriter(server), conf, handler)
+	tc := &testClient{t: t, c: c, conn: client, frames: make(chan frame, 64), served: make(chan error, 1)}
+	go func() { tc.served <- c.Serve() }()
+	go func() {
+		defer close(tc.frames)
+		for {
+			h, payload, err := ReadFrame(client, maxMaxFrameSize, nil)
+			if err != nil {
+				return
+			}
+			tc.frames <- frame{h, payload}
+		}
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	tc.write([]byte(Preface))
+	tc.writeFrame(FrameHeader{Type: FrameSettings}, nil)
+	if f := tc.next(); f.Type != FrameSettings || f.Flags.Has(FlagAck) {
+		t.Fatalf(`first frame = %+v, wanted settings`, f.FrameHeader)
+	}
+	if f := tc.next(); f.Type != FrameSettings || !f.Flags.Has(FlagAck) {
+		t.Fatalf(`second frame = %+v, wanted settings acknowledgement`, f.FrameHeader)
+	}
+	return tc
+}
+
+func (tc *testClient) write(b []byte) {
+	tc.t.Helper()
+	if _, err := tc.conn.Write(b); err != nil {
+		tc.t.Fatalf("failed to write: %v", err)
+	}
+}
+
+func (tc *testClient) writeFrame(h FrameHeader, payload []byte) {
+	tc.t.Helper()
+	if err := WriteFrame(tc.conn, h, payload); err != nil {
+		tc.t.Fatalf("failed to write frame: %v", err)
+	}
+}
+
+func (tc *testClient) writeHeaders(id uint32, flags Flags, fields ...hpack.Field) {
+	tc.t.Helper()
+	tc.writeFrame(FrameHeader{Type: FrameHeaders, Flags: flags | FlagEndHeaders, StreamID: id}, hpack.Encoder{}.Encode(nil, fields))
+}
+
+func (tc *testClient) next() frame {
+	tc.t.Helper()
+	select {
+	case f, ok := <-tc.frames:
+		if !ok {
+			tc.t.Fatal("connection closed while waiting for a frame")
+		}
+		return f
+	case <-time.After(time.Second):
+		tc.t.Fatal("timed out waiting for a frame")
+	}
+	return frame{}
+}
+
+// Waits for the next frame of the given type, skipping any others such as
+// WINDOW_UPDATEs.
+func (tc *testClient) expect(typ FrameType) frame {
+	tc.t.Helper()
+	for {
+		if f := tc.next(); f.Type == typ {
+			return f
+		}
+	}
+}
+
+func (tc *testClient) expectGoAway(code ErrCode) {
+	tc.t.Helper()
+	f := tc.expect(FrameGoAway)
+	if got := ErrCode(binary.BigEndian.Uint32(f.payload[4:])); got != code {
+		tc.t.Errorf(`goaway code = %d, wanted %d`, got, code)
+	}
+}
+
+func (tc *testClient) expectReset(id uint32, code ErrCode) {
+	tc.t.Helper()
+	f := tc.expect(FrameRSTStream)
+	if f.StreamID != id {
+		tc.t.Errorf(`rst_stream stream = %d, wanted %d`, f.StreamID, id)
+	}
+	if got := ErrCode(binary.BigEndian.Uint32(f.payload)); got != code {
+		tc.t.Errorf(`rst_stream code = %d, wanted %d`, got, code)
+	}
+}
+
+func requestFields(method, path string) []hpack.Field {
+	return []hpack.Field{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":authority", Value: "localhost"},
+		{Name: ":path", Value: path},
+	}
+}
+
+// Responds with the request's path, followed by its body.
+func echoHandler(st *Stream) {
+	body, err := io.ReadAll(st)
+	if err != nil {
+		st.Reset(ErrCodeInternal)
+		return
+	}
+	st.WriteHeaders([]hpack.Field{{Name: ":status", Value: "200"}}, false)
+	st.Write([]byte(Field(st.Fields, ":path")))
+	st.WriteData(body, true)
+}
+
+func TestConn(t *testing.T) {
+	readResponse := func(tc *testClient, id uint32) ([]hpack.Field, string) {
+		t.Helper()
+		h := tc.expect(FrameHeaders)
+		if h.StreamID != id {
+			t.Fatalf(`headers stream = %d, wanted %d`, h.StreamID, id)
+		}
+		fields, err := hpack.NewDecoder(4096).Decode(h.payload)
+		if err != nil {
+			t.Fatalf("failed to decode response headers: %v", err)
+		}
+		var body []byte
+		for {
+			f := tc.expect(FrameData)
+			body = append(body, f.payload...)
+			if f.Flags.Has(FlagEndStream) {
+				return fields, string(body)
+			}
+		}
+	}
+
+	t.Run("serves request", func(t *testing.T) {
+		tc := newTestClient(t, Config{}, echoHandler)
+
+		tc.writeHeaders(1, 0, requestFields("POST", "/echo")...)
+		tc.writeFrame(FrameHeader{Type: FrameData, StreamID: 1}, []byte("Hello"))
+		tc.writeFrame(FrameHeader{Type: FrameData, StreamID: 1, Flags: FlagEndStream | FlagPadded}, []byte("\x03, world!\x00\x00\x00"))
+		fields, body := readResponse(tc, 1)
+
+		if len(fields) != 1 || fields[0].Value != "200" {
+			t.Errorf(`fields = %v, wanted ":status: 200"`, fields)
+		}
+		if body != "/echoHello, world!" {
+			t.Errorf(`body = %#q, wanted "/echoHello, world!"`, body)
+		}
+	})
+
+	t.Run("header block split over continuation frames", func(t *testing.T) {
+		tc := newTestClient(t, Config{}, echoHandler)
+		block := hpack.Encoder{}.Encode(nil, requestFields("GET", "/split"))
+
+		tc.writeFrame(FrameHeader{Type: FrameHeaders, StreamID: 1, Flags: FlagEndStream}, block[:3])
+		tc.writeFrame(FrameHeader{Type: FrameContinuation, StreamID: 1}, block[3:5])
+		tc.writeFrame(FrameHeader{Type: FrameContinuation, StreamID: 1, Flags: FlagEndHeaders}, block[5:])
+		_, body := readResponse(tc, 1)
+
+		if body != "/split" {
+			t.Errorf(`body = %#q, wanted "/split"`, body)
+		}
+	})
+
+	t.Run("trailers", func(t *testing.T) {
+		trailers := make(chan []hpack.Field, 1)
+		tc := newTestClient(t, Config{}, func(st *Stream) {
+			io.ReadAll(st)
+			trailers <- st.Trailers()
+			st.WriteHeaders([]hpack.Field{{Name: ":status", Value: "204"}}, true)
+		})
+
+		tc.writeHeaders(1, 0, requestFields("POST", "/")...)
+		tc.writeFrame(FrameHeader{Type: FrameData, StreamID: 1}, []byte("body"))
+		tc.writeHeaders(1, FlagEndStream, hpack.Field{Name: "checksum", Value: "abc"})
+
+		got := <-trailers
+		if len(got) != 1 || got[0].Name != "checksum" || got[0].Value != "abc" {
+			t.Errorf(`trailers = %v, wanted "checksum: abc"`, got)
+		}
+	})
+
+	t.Run("responds to ping", func(t *testing.T) {
+		tc := newTestClient(t, Config{}, echoHandler)
+
+		tc.writeFrame(FrameHeader{Type: FramePing}, []byte("12345678"))
+		f := tc.expect(FramePing)
+
+		if !f.Flags.Has(FlagAck) || string(f.payload) != "12345678" {
+			t.Errorf(`ping = %+v %q, wanted acknowledgement of "12345678"`, f.FrameHeader, f.payload)
+		}
+	})
+
+	t.Run("waits for flow control", func(t *testing.T) {
+		tc := newTestClient(t, Config{}, func(st *Stream) {
+			st.WriteHeaders([]hpack.Field{{Name: ":status", Value: "200"}}, false)
+			st.WriteData(make([]byte, 100), true)
+		})
+		settings := appendSettings(nil, Setting{SettingInitialWindowSize, 60})
+		tc.writeFrame(FrameHeader{Type: FrameSettings}, settings)
+		tc.expect(FrameSettings)
+
+		tc.writeHeaders(1, FlagEndStream, requestFields("GET", "/")...)
+		tc.expect(FrameHeaders)
+		first := tc.expect(FrameData)
+		tc.writeFrame(FrameHeader{Type: FrameWindowUpdate, StreamID: 1}, binary.BigEndian.AppendUint32(nil, 40))
+		second := tc.expect(FrameData)
+
+		if len(first.payload) != 60 || first.Flags.Has(FlagEndStream) {
+			t.Errorf(`first frame = %d bytes, wanted 60 without ending the stream`, len(first.payload))
+		}
+		if len(second.payload) != 40 || !second.Flags.Has(FlagEndStream) {
+			t.Errorf(`second frame = %d bytes, wanted 40 ending the stream`, len(second.payload))
+		}
+	})
+
+	t.Run("refuses streams beyond the limit", func(t *testing.T) {
+		release := make(chan struct{})
+		tc := newTestClient(t, Config{MaxConcurrentStreams: 1}, func(st *Stream) {
+			<-release
+			st.WriteHeaders([]hpack.Field{{Name: ":status", Value: "200"}}, true)
+		})
+
+		tc.writeHeaders(1, FlagEndStream, requestFields("GET", "/")...)
+		tc.writeHeaders(3, FlagEndStream, requestFields("GET", "/")...)
+
+		tc.expectReset(3, ErrCodeRefusedStream)
+		close(release)
+	})
+
+	t.Run("resets stream the handler does not end", func(t *testing.T) {
+		tc := newTestClient(t, Config{}, func(st *Stream) {})
+
+		tc.writeHeaders(1, FlagEndStream, requestFields("GET", "/")...)
+
+		tc.expectReset(1, ErrCodeInternal)
+	})
+
+	t.Run("cancels stream reset by client", func(t *testing.T) {
+		cancelled := make(chan struct{})
+		tc := newTestClient(t, Config{}, func(st *Stream) {
+			<-st.Context().Done()
+			close(cancelled)
+		})
+
+		tc.writeHeaders(1, FlagEndStream, requestFields("GET", "/")...)
+		tc.writeFrame(FrameHeader{Type: FrameRSTStream, StreamID: 1}, binary.BigEndian.AppendUint32(nil, uint32(ErrCodeCancel)))
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("expected the stream's context to be cancelled")
+		}
+	})
+
+	t.Run("malformed requests", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			fields []hpack.Field
+		}{
+			{"missing path", requestFields("GET", "")[:3]},
+			{"upper case name", append(requestFields("GET", "/"), hpack.Field{Name: "Accept", Value: "*/*"})},
+			{"connection specific header", append(requestFields("GET", "/"), hpack.Field{Name: "connection", Value: "keep-alive"})},
+			{"te other than trailers", append(requestFields("GET", "/"), hpack.Field{Name: "te", Value: "gzip"})},
+			{"pseudo-header after regular header", append([]hpack.Field{{Name: "accept", Value: "*/*"}}, requestFields("GET", "/")...)},
+			{"unknown pseudo-header", append(requestFields("GET", "/"), hpack.Field{Name: ":protocol", Value: "websocket"})},
+			{"line break in value", append(requestFields("GET", "/"), hpack.Field{Name: "x-foo", Value: "a\r\nb"})},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				client := newTestClient(t, Config{}, echoHandler)
+
+				client.writeHeaders(1, FlagEndStream, tc.fields...)
+
+				client.expectReset(1, ErrCodeProtocol)
+			})
+		}
+	})
+
+	t.Run("connection errors", func(t *testing.T) {
+		// A large field is added to the dynamic table once, then referred to
+		// by one byte indexes until the header list is far larger than the
+		// block.
+		bomb := append([]byte{0x40, 0x05}, "x-big"...)
+		bomb = append(bomb, 0x7f, 0xa1, 0x1e)
+		bomb = append(bomb, strings.Repeat("a", 4000)...)
+		bomb = append(bomb, bytes.Repeat([]byte{0xbe}, 10)...)
+		tests := []struct {
+			name  string
+			frame FrameHeader
+			body  []byte
+			want  ErrCode
+		}{
+			{"data on stream 0", FrameHeader{Type: FrameData}, []byte("x"), ErrCodeProtocol},
+			{"data on idle stream", FrameHeader{Type: FrameData, StreamID: 5}, []byte("x"), ErrCodeProtocol},
+			{"even stream", FrameHeader{Type: FrameHeaders, StreamID: 2, Flags: FlagEndHeaders}, hpack.Encoder{}.Encode(nil, requestFields("GET", "/")), ErrCodeProtocol},
+			{"push promise", FrameHeader{Type: FramePushPromise, StreamID: 1}, make([]byte, 4), ErrCodeProtocol},
+			{"invalid header block", FrameHeader{Type: FrameHeaders, StreamID: 1, Flags: FlagEndHeaders}, []byte{0xff}, ErrCodeCompression},
+			{"header list too large", FrameHeader{Type: FrameHeaders, StreamID: 1, Flags: FlagEndHeaders}, bomb, ErrCodeCompression},
+			{"zero window update", FrameHeader{Type: FrameWindowUpdate}, make([]byte, 4), ErrCodeProtocol},
+			{"short ping", FrameHeader{Type: FramePing}, make([]byte, 4), ErrCodeFrameSize},
+			{"oversized frame", FrameHeader{Type: FrameData, StreamID: 1}, make([]byte, minMaxFrameSize+1), ErrCodeFrameSize},
+			{"continuation without headers", FrameHeader{Type: FrameContinuation, StreamID: 1, Flags: FlagEndHeaders}, nil, ErrCodeProtocol},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				client := newTestClient(t, Config{}, echoHandler)
+
+				go WriteFrame(client.conn, tc.frame, tc.body)
+
+				client.expectGoAway(tc.want)
+			})
+		}
+	})
+
+	t.Run("first frame must be settings", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		c := NewConn(server, bufio.NewReader(server), bufio.NewWriter(server), Config{}, echoHandler)
+		go c.Serve()
+		br := bufio.NewReader(client)
+
+		go func() {
+			client.Write([]byte(Preface))
+			WriteFrame(client, FrameHeader{Type: FramePing}, make([]byte, 8))
+		}()
+
+		for {
+			h, payload, err := ReadFrame(br, maxMaxFrameSize, nil)
+			if err != nil {
+				t.Fatalf("failed to read frame: %v", err)
+			}
+			if h.Type != FrameGoAway {
+				continue
+			}
+			if got := ErrCode(binary.BigEndian.Uint32(payload[4:])); got != ErrCodeProtocol {
+				t.Errorf(`goaway code = %d, wanted %d`, got, ErrCodeProtocol)
+			}
+			return
+		}
+	})
+
+	t.Run("shutdown finishes open streams", func(t *testing.T) {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		tc := newTestClient(t, Config{}, func(st *Stream) {
+			close(entered)
+			<-release
+			st.WriteHeaders([]hpack.Field{{Name: ":status", Value: "200"}}, true)
+		})
+		tc.writeHeaders(1, FlagEndStream, requestFields("GET", "/")...)
+		<-entered
+
+		go tc.c.Shutdown()
+		tc.expectGoAway(ErrCodeNo)
+		close(release)
+		tc.expect(FrameHeaders)
+
+		select {
+		case err := <-tc.served:
+			if err != nil {
+				t.Errorf("Serve() error = %v, wanted nil", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Serve() to return once the stream finished")
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package http2
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/sktylr/routeit/internal/hpack"
+)
+
+var errMalformed = errors.New("http2: malformed header list")
+
+// Header fields that only make sense for a single HTTP/1.1 connection, which
+// must not be sent over HTTP/2 (RFC-9113 Sec 8.2.2).
+var connectionSpecific = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// Reports whether a header field is specific to a HTTP/1.1 connection and
+// must not be sent over HTTP/2.
+func IsConnectionSpecific(name string) bool {
+	return connectionSpecific[strings.ToLower(name)]
+}
+
+// Checks that a request's header list, or its trailers, are well formed
+// (RFC-9113 Sec 8.2 and 8.3.1). Requests that are not are treated as a stream
+// error.
+func checkFields(fields []hpack.Field, trailers bool) error {
+	seen := map[string]bool{}
+	regular := false
+	for _, f := range fields {
+		if !validName(f.Name) || !validValue(f.Value) {
+			return errMalformed
+		}
+		if !strings.HasPrefix(f.Name, ":") {
+			regular = true
+			if connectionSpecific[f.Name] || (f.Name == "te" && f.Value != "trailers") {
+				return errMalformed
+			}
+			continue
+		}
+		// Pseudo-header fields must come first, each at most once, and are
+		// not allowed in trailers.
+		if trailers || regular || seen[f.Name] {
+			return errMalformed
+		}
+		switch f.Name {
+		case ":method", ":scheme", ":authority", ":path":
+		default:
+			return errMalformed
+		}
+		if f.Name == ":path" && f.Value == "" {
+			return errMalformed
+		}
+		seen[f.Name] = true
+	}
+	if trailers {
+		return nil
+	}
+	if !seen[":method"] {
+		return errMalformed
+	}
+	// CONNECT requests have no scheme or path, while all others must.
+	if method := Field(fields, ":method"); method == "CONNECT" {
+		if seen[":scheme"] || seen[":path"] || !seen[":authority"] {
+			return errMalformed
+		}
+	} else if !seen[":scheme"] || !seen[":path"] {
+		return errMalformed
+	}
+	return nil
+}
+
+// Returns the value of the first field with the given name, or an empty string
+// if there is none.
+func Field(fields []hpack.Field, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Field names are tokens that must be lower case, except for the colon that
+// starts pseudo-header fields.
+func validName(name string) bool {
+	name = strings.TrimPrefix(name, ":")
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Field values must not contain line breaks or NUL, nor start or end with
+// whitespace.
+func validValue(v string) bool {
+	if strings.ContainsAny(v, "\x00\r\n") {
+		return false
+	}
+	return v == "" || (v[0] != ' ' && v[0] != '\t' && v[len(v)-1] != ' ' && v[len(v)-1] != '\t')
+}
@@ -0,0 +1,215 @@
+// Package http2 implements the server side of HTTP/2 (RFC-9113): the framing
+// layer, stream states, flow control and SETTINGS negotiation. Header blocks
+// are compressed using the hpack package. What a request means and how to
+// respond to it is left to the handler of each stream, so the same request
+// handling can be shared with HTTP/1.1.
+package http2
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// The connection preface every client sends before its first frame
+// (RFC-9113 Sec 3.4).
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// The size of the header at the start of every frame.
+const frameHeaderLen = 9
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+type Flags uint8
+
+const (
+	FlagEndStream  Flags = 0x1
+	FlagAck        Flags = 0x1
+	FlagEndHeaders Flags = 0x4
+	FlagPadded     Flags = 0x8
+	FlagPriority   Flags = 0x20
+)
+
+func (f Flags) Has(flag Flags) bool {
+	return f&flag != 0
+}
+
+// The ErrCode explains why a stream or connection was closed (RFC-9113 Sec 7).
+type ErrCode uint32
+
+const (
+	ErrCodeNo                 ErrCode = 0x0
+	ErrCodeProtocol           ErrCode = 0x1
+	ErrCodeInternal           ErrCode = 0x2
+	ErrCodeFlowControl        ErrCode = 0x3
+	ErrCodeSettingsTimeout    ErrCode = 0x4
+	ErrCodeStreamClosed       ErrCode = 0x5
+	ErrCodeFrameSize          ErrCode = 0x6
+	ErrCodeRefusedStream      ErrCode = 0x7
+	ErrCodeCancel             ErrCode = 0x8
+	ErrCodeCompression        ErrCode = 0x9
+	ErrCodeConnect            ErrCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrCode = 0xb
+	ErrCodeInadequateSecurity ErrCode = 0xc
+	ErrCodeHTTP11Required     ErrCode = 0xd
+)
+
+// The identifiers of each setting that can be sent in a SETTINGS frame
+// (RFC-9113 Sec 6.5.2).
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type Setting struct {
+	ID  SettingID
+	Val uint32
+}
+
+const (
+	// The size of frame payloads both peers can send before agreeing to
+	// anything larger, and the smallest maximum either can choose.
+	minMaxFrameSize = 1 << 14
+	maxMaxFrameSize = 1<<24 - 1
+	// The flow control window every stream and connection starts with.
+	defaultWindowSize = 65535
+	maxWindowSize     = 1<<31 - 1
+)
+
+// A ConnError is a connection error (RFC-9113 Sec 5.4.1), after which the
+// connection is closed with a GOAWAY frame holding the code.
+type ConnError struct {
+	Code   ErrCode
+	Reason string
+}
+
+func (e ConnError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+// A streamError is a stream error (RFC-9113 Sec 5.4.2), after which the stream
+// is closed with a RST_STREAM frame holding the code. The connection carries
+// on as normal.
+type streamError struct {
+	id   uint32
+	code ErrCode
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d", e.id, e.code)
+}
+
+// The FrameHeader holds everything in a frame that precedes its payload.
+type FrameHeader struct {
+	Length   uint32
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+}
+
+// Reads the next frame, returning its header and payload. The payload is read
+// into buf if it is large enough, and is only valid until the next frame is
+// read. Frames larger than maxSize are a [ConnError].
+func ReadFrame(r io.Reader, maxSize uint32, buf []byte) (FrameHeader, []byte, error) {
+	var raw [frameHeaderLen]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return FrameHeader{}, nil, err
+	}
+	h := FrameHeader{
+		Length: uint32(raw[0])<<16 | uint32(raw[1])<<8 | uint32(raw[2]),
+		Type:   FrameType(raw[3]),
+		Flags:  Flags(raw[4]),
+		// The most significant bit is reserved and must be ignored.
+		StreamID: binary.BigEndian.Uint32(raw[5:]) & (1<<31 - 1),
+	}
+	if h.Length > maxSize {
+		return h, nil, ConnError{ErrCodeFrameSize, "frame larger than the maximum frame size"}
+	}
+	if uint32(cap(buf)) < h.Length {
+		buf = make([]byte, h.Length)
+	}
+	payload := buf[:h.Length]
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return h, nil, err
+	}
+	return h, payload, nil
+}
+
+// Writes a frame with the given header and payload. The length in the header
+// is taken from the payload.
+func WriteFrame(w io.Writer, h FrameHeader, payload []byte) error {
+	var raw [frameHeaderLen]byte
+	n := len(payload)
+	raw[0], raw[1], raw[2] = byte(n>>16), byte(n>>8), byte(n)
+	raw[3] = byte(h.Type)
+	raw[4] = byte(h.Flags)
+	binary.BigEndian.PutUint32(raw[5:], h.StreamID&(1<<31-1))
+	if _, err := w.Write(raw[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Removes the padding from the payload of a DATA, HEADERS or PUSH_PROMISE
+// frame with the PADDED flag set (RFC-9113 Sec 6.1).
+func stripPadding(h FrameHeader, payload []byte) ([]byte, error) {
+	if !h.Flags.Has(FlagPadded) {
+		return payload, nil
+	}
+	if len(payload) == 0 {
+		return nil, ConnError{ErrCodeFrameSize, "padded frame without a pad length"}
+	}
+	pad := int(payload[0])
+	payload = payload[1:]
+	if pad > len(payload) {
+		return nil, ConnError{ErrCodeProtocol, "padding longer than the frame"}
+	}
+	return payload[:len(payload)-pad], nil
+}
+
+// Parses the payload of a SETTINGS frame.
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, ConnError{ErrCodeFrameSize, "settings frame length not a multiple of 6"}
+	}
+	settings := make([]Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, Setting{
+			ID:  SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Val: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+	return settings, nil
+}
+
+func appendSettings(dst []byte, settings ...Setting) []byte {
+	for _, s := range settings {
+		dst = binary.BigEndian.AppendUint16(dst, uint16(s.ID))
+		dst = binary.BigEndian.AppendUint32(dst, s.Val)
+	}
+	return dst
+}
@@ -0,0 +1,104 @@
+package http2
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+)
+
+func TestReadFrame(t *testing.T) {
+	t.Run("round trip", func(t *testing.T) {
+		var buf bytes.Buffer
+		want := FrameHeader{Length: 5, Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 7}
+
+		WriteFrame(&buf, want, []byte("hello"))
+		got, payload, err := ReadFrame(&buf, minMaxFrameSize, nil)
+
+		if err != nil {
+			t.Fatalf("ReadFrame() error = %v", err)
+		}
+		if got != want {
+			t.Errorf(`header = %+v, wanted %+v`, got, want)
+		}
+		if string(payload) != "hello" {
+			t.Errorf(`payload = %q, wanted "hello"`, payload)
+		}
+	})
+
+	t.Run("ignores reserved bit", func(t *testing.T) {
+		raw := []byte{0, 0, 0, byte(FramePing), 0, 0x80, 0, 0, 3}
+
+		h, _, err := ReadFrame(bytes.NewReader(raw), minMaxFrameSize, nil)
+
+		if err != nil || h.StreamID != 3 {
+			t.Errorf(`stream = %d, %v, wanted 3`, h.StreamID, err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			name string
+			raw  []byte
+			want error
+		}{
+			{"too large", []byte{0, 0x40, 1, 0, 0, 0, 0, 0, 1}, ConnError{ErrCodeFrameSize, "frame larger than the maximum frame size"}},
+			{"truncated header", []byte{0, 0, 1}, io.ErrUnexpectedEOF},
+			{"truncated payload", []byte{0, 0, 4, 0, 0, 0, 0, 0, 1, 'a'}, io.ErrUnexpectedEOF},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				_, _, err := ReadFrame(bytes.NewReader(tc.raw), minMaxFrameSize, nil)
+
+				if !errors.Is(err, tc.want) {
+					t.Errorf(`ReadFrame() error = %v, wanted %v`, err, tc.want)
+				}
+			})
+		}
+	})
+}
+
+func TestStripPadding(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   Flags
+		payload string
+		want    string
+		wantErr bool
+	}{
+		{name: "not padded", payload: "\x02abc", want: "\x02abc"},
+		{name: "padded", flags: FlagPadded, payload: "\x02abc\x00\x00", want: "abc"},
+		{name: "only padding", flags: FlagPadded, payload: "\x01\x00", want: ""},
+		{name: "missing pad length", flags: FlagPadded, wantErr: true},
+		{name: "padding too long", flags: FlagPadded, payload: "\x05abc", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := stripPadding(FrameHeader{Flags: tc.flags}, []byte(tc.payload))
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf(`stripPadding() error = %v, wanted error %t`, err, tc.wantErr)
+			}
+			if string(got) != tc.want {
+				t.Errorf(`stripPadding() = %q, wanted %q`, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSettings(t *testing.T) {
+	want := []Setting{{SettingMaxConcurrentStreams, 100}, {SettingInitialWindowSize, 1 << 20}}
+
+	got, err := parseSettings(appendSettings(nil, want...))
+
+	if err != nil {
+		t.Fatalf("parseSettings() error = %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf(`settings = %v, wanted %v`, got, want)
+	}
+	if _, err := parseSettings(make([]byte, 5)); err == nil {
+		t.Error("expected error for settings of the wrong length")
+	}
+}
@@ -1,6 +1,11 @@
 package socket
 
-import ctls "crypto/tls"
+import (
+	ctls "crypto/tls"
+	"errors"
+	"net"
+	"os"
+)
 
 // A [combined] socket is one that holds two TCP connections. The first serves
 // and receives messages directly over TCP, while the second uses TLS over TCP
@@ -12,14 +17,14 @@ type combined struct {
 	tls Socket
 }
 
-// Use [NewCombinedSocket] to listen over two separate ports, listening over
-// TCP on the first and TLS over TCP on the second. A valid, non-nil
-// [ctls.Config] must be passed and the ports must be different, otherwise
-// calling [Socket.Bind] will fail.
-func NewCombinedSocket(tcpPort, tlsPort uint16, conf *ctls.Config) Socket {
+// Use [NewCombinedSocket] to listen on two separate endpoints, accepting plain
+// connections on the first and TLS connections on the second. A valid,
+// non-nil [ctls.Config] must be passed and the endpoints must be different,
+// otherwise calling [Socket.Bind] will fail.
+func NewCombinedSocket(tcpEp, tlsEp Endpoint, conf *ctls.Config) Socket {
 	return &combined{
-		tcp: NewTcpSocket(tcpPort),
-		tls: NewTlsSocket(tlsPort, conf),
+		tcp: NewTcpSocket(tcpEp),
+		tls: NewTlsSocket(tlsEp, conf),
 	}
 }
 
@@ -56,12 +61,28 @@ func (c *combined) Serve(onConn onConnection, onErr onError) {
 	<-ch
 }
 
-func (c *combined) Close() error {
-	if err := c.tcp.Close(); err != nil {
-		return err
+// The addresses of the TCP socket come before those of the TLS socket.
+func (c *combined) Addrs() []net.Addr {
+	return append(c.tcp.Addrs(), c.tls.Addrs()...)
+}
+
+func (c *combined) Files() ([]*os.File, error) {
+	tcpFiles, err := c.tcp.Files()
+	if err != nil {
+		return nil, err
 	}
-	if err := c.tls.Close(); err != nil {
-		return err
+	tlsFiles, err := c.tls.Files()
+	if err != nil {
+		for _, f := range tcpFiles {
+			f.Close()
+		}
+		return nil, err
 	}
-	return nil
+	return append(tcpFiles, tlsFiles...), nil
+}
+
+// Closes both underlying sockets. The second socket is closed even if closing
+// the first fails, so that neither is left accepting connections.
+func (c *combined) Close() error {
+	return errors.Join(c.tcp.Close(), c.tls.Close())
 }
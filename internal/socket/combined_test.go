@@ -10,7 +10,7 @@ import (
 
 func TestCombinedSocketListenAndServe(t *testing.T) {
 	conf := newTestTLSConfig()
-	c := NewCombinedSocket(0, 0, conf).(*combined)
+	c := NewCombinedSocket(PortEndpoint(0), PortEndpoint(0), conf).(*combined)
 
 	if err := c.Bind(); err != nil {
 		t.Fatalf("Bind failed: %v", err)
@@ -40,7 +40,14 @@ func TestCombinedSocketListenAndServe(t *testing.T) {
 		},
 	)
 
+	addrs := c.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf(`len(Addrs()) = %d, wanted 2`, len(addrs))
+	}
 	tcpAddr := c.tcp.(*tcp).ln.Addr().String()
+	if addrs[0].String() != tcpAddr {
+		t.Errorf(`first address = %s, wanted TCP address %s`, addrs[0], tcpAddr)
+	}
 	tcpConn, err := net.Dial("tcp", tcpAddr)
 	if err != nil {
 		t.Fatalf("failed to dial TCP server: %v", err)
@@ -90,7 +97,7 @@ func TestCombinedSocketClose(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewCombinedSocket(0, 0, newTestTLSConfig())
+			s := NewCombinedSocket(PortEndpoint(0), PortEndpoint(0), newTestTLSConfig())
 			tc.setup(s)
 
 			err := s.Close()
@@ -104,3 +111,28 @@ func TestCombinedSocketClose(t *testing.T) {
 		})
 	}
 }
+
+func TestCombinedSocketServeReturnsOnClose(t *testing.T) {
+	s := NewCombinedSocket(PortEndpoint(0), PortEndpoint(0), newTestTLSConfig())
+	if err := s.Bind(); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Serve(
+			func(conn net.Conn) { conn.Close() },
+			func(err error) { t.Errorf("unexpected error: %v", err) },
+		)
+		close(done)
+	}()
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the socket was closed")
+	}
+}
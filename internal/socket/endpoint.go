@@ -0,0 +1,61 @@
+package socket
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// An [Endpoint] describes where a socket accepts connections from. Either the
+// listener is used as-is, or a new listener is opened on the network and
+// address when the socket is bound.
+type Endpoint struct {
+	Network  string
+	Address  string
+	Listener net.Listener
+	// Set when connections are accepted through a load balancer that uses
+	// the PROXY protocol.
+	Proxy *ProxyProtocol
+}
+
+// Creates an endpoint that listens over TCP on the given port across all
+// interfaces. Port 0 lets the kernel choose a free port.
+func PortEndpoint(port uint16) Endpoint {
+	return Endpoint{Network: "tcp", Address: fmt.Sprintf(":%d", port)}
+}
+
+// Creates an endpoint for a listen address. Addresses prefixed with "unix:" or
+// containing a "/" are the path of a Unix domain socket, while anything else
+// is treated as a "host:port" TCP address.
+func AddressEndpoint(addr string) Endpoint {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return Endpoint{Network: "unix", Address: path}
+	}
+	if strings.Contains(addr, "/") {
+		return Endpoint{Network: "unix", Address: addr}
+	}
+	return Endpoint{Network: "tcp", Address: addr}
+}
+
+// Creates an endpoint that accepts connections from a listener that has
+// already been opened, such as one inherited from a supervising process.
+func ListenerEndpoint(ln net.Listener) Endpoint {
+	return Endpoint{Listener: ln}
+}
+
+func (e Endpoint) listen() (net.Listener, error) {
+	if e.Listener != nil {
+		return e.Listener, nil
+	}
+	return net.Listen(e.Network, e.Address)
+}
+
+// Prepares a connection that has been accepted from the endpoint to be
+// served. This is called in the connection's own goroutine, since it may need
+// to wait on the client.
+func (e Endpoint) accept(conn net.Conn) (net.Conn, error) {
+	if e.Proxy == nil {
+		return conn, nil
+	}
+	return e.Proxy.decode(conn)
+}
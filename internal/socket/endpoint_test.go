@@ -0,0 +1,116 @@
+package socket
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddressEndpoint(t *testing.T) {
+	tests := []struct {
+		addr        string
+		wantNetwork string
+		wantAddress string
+	}{
+		{addr: ":8080", wantNetwork: "tcp", wantAddress: ":8080"},
+		{addr: "127.0.0.1:0", wantNetwork: "tcp", wantAddress: "127.0.0.1:0"},
+		{addr: "[::1]:443", wantNetwork: "tcp", wantAddress: "[::1]:443"},
+		{addr: "localhost:3000", wantNetwork: "tcp", wantAddress: "localhost:3000"},
+		{addr: "/run/app.sock", wantNetwork: "unix", wantAddress: "/run/app.sock"},
+		{addr: "./app.sock", wantNetwork: "unix", wantAddress: "./app.sock"},
+		{addr: "unix:app.sock", wantNetwork: "unix", wantAddress: "app.sock"},
+		{addr: "unix:/run/app.sock", wantNetwork: "unix", wantAddress: "/run/app.sock"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.addr, func(t *testing.T) {
+			ep := AddressEndpoint(tc.addr)
+
+			if ep.Network != tc.wantNetwork {
+				t.Errorf(`network = %#q, wanted %#q`, ep.Network, tc.wantNetwork)
+			}
+			if ep.Address != tc.wantAddress {
+				t.Errorf(`address = %#q, wanted %#q`, ep.Address, tc.wantAddress)
+			}
+		})
+	}
+}
+
+func TestEndpointListen(t *testing.T) {
+	serve := func(t *testing.T, s Socket) net.Addr {
+		t.Helper()
+		if err := s.Bind(); err != nil {
+			t.Fatalf("Bind failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		go s.Serve(
+			func(conn net.Conn) {
+				conn.Write([]byte("OK"))
+				conn.Close()
+			},
+			func(err error) { t.Errorf("unexpected error: %v", err) },
+		)
+		addrs := s.Addrs()
+		if len(addrs) != 1 {
+			t.Fatalf(`len(Addrs()) = %d, wanted 1`, len(addrs))
+		}
+		return addrs[0]
+	}
+	expectServed := func(t *testing.T, addr net.Addr) {
+		t.Helper()
+		conn, err := net.DialTimeout(addr.Network(), addr.String(), time.Second)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		buf := make([]byte, 2)
+		if _, err := conn.Read(buf); err != nil || string(buf) != "OK" {
+			t.Errorf(`read = %q, %v, wanted "OK"`, buf, err)
+		}
+	}
+
+	t.Run("ephemeral port", func(t *testing.T) {
+		addr := serve(t, NewTcpSocket(AddressEndpoint("127.0.0.1:0")))
+
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			t.Fatalf(`addr = %T, wanted *net.TCPAddr`, addr)
+		}
+		if tcpAddr.Port == 0 {
+			t.Error("expected the kernel to have chosen a port")
+		}
+		expectServed(t, addr)
+	})
+
+	t.Run("unix domain socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.sock")
+
+		addr := serve(t, NewTcpSocket(AddressEndpoint(path)))
+
+		if addr.Network() != "unix" || addr.String() != path {
+			t.Errorf(`addr = %s %s, wanted unix %s`, addr.Network(), addr, path)
+		}
+		expectServed(t, addr)
+	})
+
+	t.Run("existing listener", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+
+		addr := serve(t, NewTcpSocket(ListenerEndpoint(ln)))
+
+		if addr.String() != ln.Addr().String() {
+			t.Errorf(`addr = %s, wanted %s`, addr, ln.Addr())
+		}
+		expectServed(t, addr)
+	})
+
+	t.Run("not bound", func(t *testing.T) {
+		if addrs := NewTcpSocket(PortEndpoint(0)).Addrs(); addrs != nil {
+			t.Errorf(`Addrs() = %v, wanted nil`, addrs)
+		}
+	})
+}
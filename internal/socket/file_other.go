@@ -0,0 +1,13 @@
+//go:build !unix
+
+package socket
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+func listenerFile(ln net.Listener) (*os.File, error) {
+	return nil, errors.New("handing over listeners is not supported on this platform")
+}
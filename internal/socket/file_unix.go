@@ -0,0 +1,51 @@
+//go:build unix
+
+package socket
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+// Returns a duplicate of the listener's file descriptor, so it can be passed
+// to a child process. Unlike the File method of the standard library's
+// listeners, the returned file does not put the descriptor into blocking mode
+// when it is passed to a child process. Blocking mode is shared between
+// duplicates, so this would leave the listener blocked in Accept once the
+// child has accepted the connection it was woken up for, and unable to close.
+func listenerFile(ln net.Listener) (*os.File, error) {
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("listener cannot be handed over")
+	}
+	if ul, ok := ln.(*net.UnixListener); ok {
+		// The socket file must outlive this listener, since the child process
+		// continues to accept connections from it.
+		ul.SetUnlinkOnClose(false)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var fd int
+	var dupErr error
+	err = rc.Control(func(sysfd uintptr) {
+		// Holding the fork lock stops the duplicate leaking to processes
+		// started before it is marked close-on-exec.
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		fd, dupErr = syscall.Dup(int(sysfd))
+		if dupErr == nil {
+			syscall.CloseOnExec(fd)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+	return os.NewFile(uintptr(fd), ln.Addr().String()), nil
+}
@@ -0,0 +1,118 @@
+package socket
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// The environment variables used to pass listening sockets to a process,
+	// following the socket activation convention used by systemd.
+	envListenFds     = "LISTEN_FDS"
+	envListenPid     = "LISTEN_PID"
+	envListenFdNames = "LISTEN_FDNAMES"
+	// The first file descriptor passed to the process, since 0, 1 and 2 are
+	// reserved for stdin, stdout and stderr.
+	listenFdsStart = 3
+)
+
+// An [InheritedListener] is a listening socket passed to the process by its
+// parent.
+type InheritedListener struct {
+	// The name given to the listener through LISTEN_FDNAMES, which is empty
+	// if the parent did not name its listeners.
+	Name     string
+	Listener net.Listener
+}
+
+var inherited struct {
+	once sync.Once
+	ls   []InheritedListener
+	err  error
+}
+
+// Returns the listeners passed to the process by its parent, in the order they
+// were passed. LISTEN_FDS holds how many listeners were passed, starting at
+// file descriptor 3, while LISTEN_PID holds the process they were meant for
+// and LISTEN_FDNAMES optionally holds a colon-separated name for each. The
+// listeners are meant for another process if LISTEN_PID does not match, in
+// which case none are returned. LISTEN_PID may be left unset, since a parent
+// that starts the process using [os/exec] cannot know its PID in advance.
+//
+// The environment is only read once, after which the variables are cleared so
+// they are not passed on to any processes this process starts.
+func Inherited() ([]InheritedListener, error) {
+	inherited.once.Do(func() {
+		inherited.ls, inherited.err = inherit(os.Getenv(envListenFds), os.Getenv(envListenPid), os.Getenv(envListenFdNames))
+		os.Unsetenv(envListenFds)
+		os.Unsetenv(envListenPid)
+		os.Unsetenv(envListenFdNames)
+	})
+	return inherited.ls, inherited.err
+}
+
+func inherit(fds, pid, names string) ([]InheritedListener, error) {
+	if fds == "" {
+		return nil, nil
+	}
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s %q", envListenFds, fds)
+	}
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+		if len(fdNames) != n {
+			return nil, fmt.Errorf("%s has %d names but %s is %d", envListenFdNames, len(fdNames), envListenFds, n)
+		}
+	}
+
+	ls := make([]InheritedListener, 0, n)
+	for i := range n {
+		fd := listenFdsStart + i
+		l := InheritedListener{}
+		if fdNames != nil {
+			l.Name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(fd), fmt.Sprintf("listener-%d", i))
+		// net.FileListener duplicates the descriptor without passing it on to
+		// any processes this process starts, so the original is closed
+		// whether or not it succeeds.
+		l.Listener, err = net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, l := range ls {
+				l.Listener.Close()
+			}
+			return nil, fmt.Errorf("file descriptor %d is not a listener: %w", fd, err)
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
+}
+
+// Returns a copy of the environment for a child process that is passed the
+// given listeners as extra files, replacing any socket activation variables
+// that the environment already holds. LISTEN_PID is left unset since the PID
+// of the child is not known until it has started.
+func ChildEnv(env []string, names []string) []string {
+	out := make([]string, 0, len(env)+2)
+	for _, kv := range env {
+		key, _, _ := strings.Cut(kv, "=")
+		if key == envListenFds || key == envListenPid || key == envListenFdNames {
+			continue
+		}
+		out = append(out, kv)
+	}
+	return append(out,
+		fmt.Sprintf("%s=%d", envListenFds, len(names)),
+		fmt.Sprintf("%s=%s", envListenFdNames, strings.Join(names, ":")),
+	)
+}
@@ -0,0 +1,168 @@
+package socket
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Set in the environment of the child process started by TestInherited.
+const envInheritChild = "ROUTEIT_TEST_INHERIT_CHILD"
+
+func TestInherited(t *testing.T) {
+	var files []*os.File
+	var want []string
+	for range 2 {
+		s := NewTcpSocket(AddressEndpoint("127.0.0.1:0"))
+		if err := s.Bind(); err != nil {
+			t.Fatalf("Bind failed: %v", err)
+		}
+		defer s.Close()
+		fs, err := s.Files()
+		if err != nil {
+			t.Fatalf("Files failed: %v", err)
+		}
+		defer fs[0].Close()
+		files = append(files, fs...)
+		want = append(want, s.Addrs()[0].String())
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestInheritedChild$")
+	cmd.ExtraFiles = files
+	cmd.Env = append(ChildEnv(os.Environ(), []string{"http", "https"}), envInheritChild+"=1")
+
+	out, err := cmd.CombinedOutput()
+
+	if err != nil {
+		t.Fatalf("child failed: %v\n%s", err, out)
+	}
+	for i, name := range []string{"http", "https"} {
+		line := fmt.Sprintf("%s %s", name, want[i])
+		if !strings.Contains(string(out), line) {
+			t.Errorf(`child output missing %q:\n%s`, line, out)
+		}
+	}
+}
+
+// Run as a child process by TestInherited, reporting the listeners it was
+// passed.
+func TestInheritedChild(t *testing.T) {
+	if os.Getenv(envInheritChild) == "" {
+		t.Skip("only run as a child process")
+	}
+
+	ls, err := Inherited()
+
+	if err != nil {
+		t.Fatalf("Inherited failed: %v", err)
+	}
+	for _, l := range ls {
+		fmt.Printf("%s %s\n", l.Name, l.Listener.Addr())
+	}
+	if _, ok := os.LookupEnv(envListenFds); ok {
+		t.Errorf("expected %s to be cleared", envListenFds)
+	}
+	again, _ := Inherited()
+	if len(again) != len(ls) {
+		t.Errorf(`len(Inherited()) = %d on second call, wanted %d`, len(again), len(ls))
+	}
+}
+
+func TestInherit(t *testing.T) {
+	tests := []struct {
+		name    string
+		fds     string
+		pid     string
+		names   string
+		wantErr bool
+	}{
+		{name: "nothing passed"},
+		{name: "meant for another process", fds: "2", pid: "1"},
+		{name: "no listeners", fds: "0"},
+		{name: "invalid count", fds: "two", wantErr: true},
+		{name: "negative count", fds: "-1", wantErr: true},
+		{name: "names do not match count", fds: "2", names: "http", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ls, err := inherit(tc.fds, tc.pid, tc.names)
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf(`err = %v, wanted error = %t`, err, tc.wantErr)
+			}
+			if len(ls) != 0 {
+				t.Errorf(`len(listeners) = %d, wanted 0`, len(ls))
+			}
+		})
+	}
+}
+
+func TestChildEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "LISTEN_FDS=5", "LISTEN_PID=10", "LISTEN_FDNAMES=a:b:c:d:e", "HOME=/root"}
+
+	got := ChildEnv(env, []string{"http", "https"})
+
+	want := []string{"PATH=/bin", "HOME=/root", "LISTEN_FDS=2", "LISTEN_FDNAMES=http:https"}
+	if !slices.Equal(got, want) {
+		t.Errorf(`ChildEnv() = %v, wanted %v`, got, want)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	t.Run("not bound", func(t *testing.T) {
+		if _, err := NewCombinedSocket(PortEndpoint(0), PortEndpoint(0), newTestTLSConfig()).Files(); err == nil {
+			t.Error("expected Files to fail before binding")
+		}
+	})
+
+	t.Run("hands over plain listeners", func(t *testing.T) {
+		s := NewCombinedSocket(AddressEndpoint("127.0.0.1:0"), AddressEndpoint("127.0.0.1:0"), newTestTLSConfig())
+		if err := s.Bind(); err != nil {
+			t.Fatalf("Bind failed: %v", err)
+		}
+		defer s.Close()
+
+		files, err := s.Files()
+
+		if err != nil {
+			t.Fatalf("Files failed: %v", err)
+		}
+		if len(files) != 2 {
+			t.Fatalf(`len(Files()) = %d, wanted 2`, len(files))
+		}
+		for i, f := range files {
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				t.Fatalf("file %d is not a listener: %v", i, err)
+			}
+			if got, want := ln.Addr().String(), s.Addrs()[i].String(); got != want {
+				t.Errorf(`file %d address = %s, wanted %s`, i, got, want)
+			}
+			ln.Close()
+		}
+	})
+
+	t.Run("unix domain socket outlives the listener", func(t *testing.T) {
+		path := t.TempDir() + "/app.sock"
+		s := NewTcpSocket(AddressEndpoint(path))
+		if err := s.Bind(); err != nil {
+			t.Fatalf("Bind failed: %v", err)
+		}
+		files, err := s.Files()
+		if err != nil {
+			t.Fatalf("Files failed: %v", err)
+		}
+		defer files[0].Close()
+
+		s.Close()
+
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected socket file to remain: %v", err)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+package socket
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The maximum length of a version 1 header, including the trailing CRLF.
+const proxyV1MaxLength = 107
+
+var (
+	ErrProxyHeader = errors.New("invalid PROXY protocol header")
+
+	// Every version 2 header starts with this signature, which cannot appear
+	// at the start of a request in any other protocol.
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// The [ProxyProtocol] decodes the header that load balancers send ahead of
+// each connection when using the PROXY protocol, which holds the address of
+// the client that opened the connection to the load balancer. Both the text
+// format of version 1 and the binary format of version 2 are supported.
+type ProxyProtocol struct {
+	// Only connections from these ranges are expected to send a header.
+	// Connections from anywhere else are passed through untouched, so a
+	// client cannot claim to be somebody else by sending its own header.
+	Trusted []netip.Prefix
+	// How long the load balancer has to send the header.
+	Timeout time.Duration
+}
+
+// A proxyConn reports the addresses given in the PROXY protocol header in
+// place of those of the load balancer.
+type proxyConn struct {
+	net.Conn
+	br     *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.br.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// Reads the header from the connection if it comes from a trusted address,
+// returning a connection that reports the addresses in the header. Connections
+// from a trusted address that do not start with a valid header are rejected.
+func (p *ProxyProtocol) decode(conn net.Conn) (net.Conn, error) {
+	if !p.trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	if p.Timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(p.Timeout)); err != nil {
+			return nil, err
+		}
+	}
+	pc := &proxyConn{
+		Conn:   conn,
+		br:     bufio.NewReader(conn),
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+	}
+	if err := pc.readHeader(); err != nil {
+		return nil, fmt.Errorf("%w from %s: %w", ErrProxyHeader, conn.RemoteAddr(), err)
+	}
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, err
+	}
+	return pc, nil
+}
+
+func (p *ProxyProtocol) trusts(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range p.Trusted {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *proxyConn) readHeader() error {
+	// Both versions of the header are at least this long, so we can safely
+	// wait for this much before deciding which version is being used.
+	start, err := c.br.Peek(len(proxyV2Signature))
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(start, proxyV2Signature) {
+		return c.readV2()
+	}
+	if bytes.HasPrefix(start, []byte("PROXY ")) {
+		return c.readV1()
+	}
+	return errors.New("missing header")
+}
+
+// Reads a version 1 header, such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func (c *proxyConn) readV1() error {
+	var line []byte
+	for {
+		b, err := c.br.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == proxyV1MaxLength {
+			return errors.New("header too long")
+		}
+	}
+	header, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return errors.New("header does not end in CRLF")
+	}
+
+	fields := strings.Split(header, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		// The load balancer could not tell where the connection came from,
+		// so the rest of the line is ignored and the real addresses are used.
+		return nil
+	}
+	if len(fields) != 6 {
+		return fmt.Errorf("expected 6 fields, got %d", len(fields))
+	}
+	src, err := parseV1Addr(fields[1], fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseV1Addr(fields[1], fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.remote, c.local = src, dst
+	return nil
+}
+
+func parseV1Addr(proto, ip, port string) (*net.TCPAddr, error) {
+	if proto != "TCP4" && proto != "TCP6" {
+		return nil, fmt.Errorf("unknown protocol %q", proto)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return nil, err
+	}
+	if (proto == "TCP4" && !addr.Is4()) || (proto == "TCP6" && !addr.Is6()) {
+		return nil, fmt.Errorf("address %s does not match protocol %s", ip, proto)
+	}
+	// Ports are written in decimal without leading zeroes.
+	if port != "0" && strings.HasPrefix(port, "0") {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))), nil
+}
+
+// Reads a version 2 header, which is the signature followed by the version
+// and command, the address family and protocol, the length of the rest of the
+// header, then the addresses and any extensions.
+func (c *proxyConn) readV2() error {
+	var fixed [16]byte
+	if _, err := io.ReadFull(c.br, fixed[:]); err != nil {
+		return err
+	}
+	if version := fixed[12] >> 4; version != 2 {
+		return fmt.Errorf("unsupported version %d", version)
+	}
+	command, family, proto := fixed[12]&0x0f, fixed[13]>>4, fixed[13]&0x0f
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(c.br, body); err != nil {
+		return err
+	}
+
+	switch command {
+	case 0x0:
+		// The load balancer opened the connection itself, such as for a
+		// health check, so the real addresses are used.
+		return nil
+	case 0x1:
+	default:
+		return fmt.Errorf("unknown command %#x", command)
+	}
+	if proto != 0x1 {
+		// Only connections over TCP can carry HTTP. An unspecified protocol
+		// means the addresses are not known, so the real addresses are used.
+		if proto == 0x0 && family == 0x0 {
+			return nil
+		}
+		return fmt.Errorf("unsupported protocol %#x", proto)
+	}
+
+	var size int
+	switch family {
+	case 0x1:
+		size = net.IPv4len
+	case 0x2:
+		size = net.IPv6len
+	default:
+		// Connections over a Unix domain socket, or from an unspecified
+		// family, have no address that can be reported.
+		return nil
+	}
+	if len(body) < 2*size+4 {
+		return errors.New("header too short for addresses")
+	}
+	src, _ := netip.AddrFromSlice(body[:size])
+	dst, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+	c.remote = net.TCPAddrFromAddrPort(netip.AddrPortFrom(src, srcPort))
+	c.local = net.TCPAddrFromAddrPort(netip.AddrPortFrom(dst, dstPort))
+	return nil
+}
@@ -0,0 +1,266 @@
+package socket
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A fakeConn is one end of a pipe that appears to have been accepted from the
+// load balancer.
+type fakeConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c fakeConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func TestProxyProtocolDecode(t *testing.T) {
+	balancer := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 40000}
+	trusted := &ProxyProtocol{
+		Trusted: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		Timeout: time.Second,
+	}
+	v2 := func(cmd, fam byte, addrs []byte, tlvs ...byte) string {
+		body := append(append([]byte{}, addrs...), tlvs...)
+		header := append([]byte{}, proxyV2Signature...)
+		header = append(header, 0x20|cmd, fam)
+		header = binary.BigEndian.AppendUint16(header, uint16(len(body)))
+		return string(append(header, body...))
+	}
+	inet := []byte{192, 0, 2, 1, 198, 51, 100, 1, 0xdc, 0x04, 0x01, 0xbb}
+	inet6 := append(append(
+		netip.MustParseAddr("2001:db8::1").AsSlice(),
+		netip.MustParseAddr("2001:db8::2").AsSlice()...),
+		0xdc, 0x04, 0x01, 0xbb,
+	)
+	decode := func(t *testing.T, p *ProxyProtocol, remote net.Addr, sent string) (net.Conn, error) {
+		t.Helper()
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go client.Write([]byte(sent + "GET / HTTP/1.1\r\n"))
+		return p.decode(fakeConn{server, remote})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			header     string
+			wantRemote string
+			wantLocal  string
+		}{
+			{
+				name:       "v1 tcp4",
+				header:     "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+				wantRemote: "192.0.2.1:56324",
+				wantLocal:  "198.51.100.1:443",
+			},
+			{
+				name:       "v1 tcp6",
+				header:     "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n",
+				wantRemote: "[2001:db8::1]:56324",
+				wantLocal:  "[2001:db8::2]:443",
+			},
+			{
+				name:       "v1 unknown",
+				header:     "PROXY UNKNOWN ignored fields\r\n",
+				wantRemote: balancer.String(),
+			},
+			{
+				name:       "v2 inet",
+				header:     v2(0x1, 0x11, inet),
+				wantRemote: "192.0.2.1:56324",
+				wantLocal:  "198.51.100.1:443",
+			},
+			{
+				name:       "v2 inet6",
+				header:     v2(0x1, 0x21, inet6),
+				wantRemote: "[2001:db8::1]:56324",
+				wantLocal:  "[2001:db8::2]:443",
+			},
+			{
+				name:       "v2 with extensions",
+				header:     v2(0x1, 0x11, inet, 0x04, 0x00, 0x02, 'h', '2'),
+				wantRemote: "192.0.2.1:56324",
+				wantLocal:  "198.51.100.1:443",
+			},
+			{
+				name:       "v2 local",
+				header:     v2(0x0, 0x00, nil),
+				wantRemote: balancer.String(),
+			},
+			{
+				name:       "v2 unspecified",
+				header:     v2(0x1, 0x00, nil),
+				wantRemote: balancer.String(),
+			},
+			{
+				name:       "v2 unix",
+				header:     v2(0x1, 0x31, make([]byte, 216)),
+				wantRemote: balancer.String(),
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				conn, err := decode(t, trusted, balancer, tc.header)
+
+				if err != nil {
+					t.Fatalf("decode failed: %v", err)
+				}
+				if got := conn.RemoteAddr().String(); got != tc.wantRemote {
+					t.Errorf(`RemoteAddr() = %s, wanted %s`, got, tc.wantRemote)
+				}
+				if tc.wantLocal != "" && conn.LocalAddr().String() != tc.wantLocal {
+					t.Errorf(`LocalAddr() = %s, wanted %s`, conn.LocalAddr(), tc.wantLocal)
+				}
+				line := make([]byte, len("GET / HTTP/1.1\r\n"))
+				if _, err := io.ReadFull(conn, line); err != nil || string(line) != "GET / HTTP/1.1\r\n" {
+					t.Errorf(`read after header = %q, %v, wanted the request line`, line, err)
+				}
+			})
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			header string
+		}{
+			{name: "missing", header: ""},
+			{name: "v1 unknown protocol", header: "PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n"},
+			{name: "v1 mismatched address", header: "PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n"},
+			{name: "v1 invalid address", header: "PROXY TCP4 192.0.2 198.51.100.1 56324 443\r\n"},
+			{name: "v1 missing fields", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n"},
+			{name: "v1 invalid port", header: "PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n"},
+			{name: "v1 leading zero port", header: "PROXY TCP4 192.0.2.1 198.51.100.1 0443 443\r\n"},
+			{name: "v1 no CR", header: "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n"},
+			{name: "v1 too long", header: "PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n"},
+			{name: "v2 wrong version", header: strings.Replace(v2(0x1, 0x11, inet), "\n\x21", "\n\x11", 1)},
+			{name: "v2 unknown command", header: v2(0x2, 0x11, inet)},
+			{name: "v2 datagram", header: v2(0x1, 0x12, inet)},
+			{name: "v2 addresses too short", header: v2(0x1, 0x21, inet)},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := decode(t, trusted, balancer, tc.header)
+
+				if !errors.Is(err, ErrProxyHeader) {
+					t.Errorf(`err = %v, wanted %v`, err, ErrProxyHeader)
+				}
+			})
+		}
+	})
+
+	t.Run("untrusted passes through", func(t *testing.T) {
+		header := "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"
+		client := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 40000}
+
+		conn, err := decode(t, trusted, client, header)
+
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if conn.RemoteAddr() != client {
+			t.Errorf(`RemoteAddr() = %s, wanted %s`, conn.RemoteAddr(), client)
+		}
+		line := make([]byte, len(header))
+		if _, err := io.ReadFull(conn, line); err != nil || string(line) != header {
+			t.Errorf(`read = %q, %v, wanted the header untouched`, line, err)
+		}
+	})
+
+	t.Run("ipv4 mapped balancer", func(t *testing.T) {
+		mapped := &net.TCPAddr{IP: net.ParseIP("::ffff:10.0.0.5"), Port: 40000}
+
+		conn, err := decode(t, trusted, mapped, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+
+		if err != nil {
+			t.Fatalf("decode failed: %v", err)
+		}
+		if got := conn.RemoteAddr().String(); got != "192.0.2.1:56324" {
+			t.Errorf(`RemoteAddr() = %s, wanted 192.0.2.1:56324`, got)
+		}
+	})
+
+	t.Run("times out", func(t *testing.T) {
+		p := &ProxyProtocol{Trusted: trusted.Trusted, Timeout: 10 * time.Millisecond}
+		client, server := net.Pipe()
+		defer client.Close()
+
+		_, err := p.decode(fakeConn{server, balancer})
+
+		if !errors.Is(err, ErrProxyHeader) || !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Errorf(`err = %v, wanted deadline exceeded`, err)
+		}
+	})
+}
+
+func TestProxyProtocolSocket(t *testing.T) {
+	ep := AddressEndpoint("127.0.0.1:0")
+	ep.Proxy = &ProxyProtocol{Trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}, Timeout: time.Second}
+	s := NewTcpSocket(ep)
+	if err := s.Bind(); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	defer s.Close()
+	remotes := make(chan net.Addr, 1)
+	errs := make(chan error, 1)
+	go s.Serve(
+		func(conn net.Conn) {
+			defer conn.Close()
+			remotes <- conn.RemoteAddr()
+		},
+		func(err error) { errs <- err },
+	)
+
+	t.Run("reports client address", func(t *testing.T) {
+		conn, err := net.Dial("tcp", s.Addrs()[0].String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 443\r\n"))
+
+		select {
+		case addr := <-remotes:
+			if addr.String() != "192.0.2.1:56324" {
+				t.Errorf(`RemoteAddr() = %s, wanted 192.0.2.1:56324`, addr)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("consumer was not called in time")
+		}
+	})
+
+	t.Run("rejects malformed preamble", func(t *testing.T) {
+		conn, err := net.Dial("tcp", s.Addrs()[0].String())
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+
+		select {
+		case err := <-errs:
+			if !errors.Is(err, ErrProxyHeader) {
+				t.Errorf(`err = %v, wanted %v`, err, ErrProxyHeader)
+			}
+		case <-remotes:
+			t.Fatal("expected connection to be rejected")
+		case <-time.After(time.Second):
+			t.Fatal("error handler was not called in time")
+		}
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf(`read = %v, wanted the connection to be closed`, err)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package socket
 import (
 	"errors"
 	"net"
+	"os"
 )
 
 var ErrSocketNotInUse = errors.New("socket is not in use")
@@ -16,9 +17,21 @@ type Socket interface {
 
 	// The socket will start accepting traffic, and will pipe the connections
 	// to the provided connection consumer. Each new connection is handled
-	// inside its own go-routine.
+	// inside its own go-routine. This blocks until the socket is closed using
+	// [Socket.Close].
 	Serve(onConnection, onError)
 
+	// The addresses the socket is listening on, which is nil until the socket
+	// has been bound. This is useful when listening on port 0, as it holds
+	// the port chosen by the kernel.
+	Addrs() []net.Addr
+
+	// Duplicates of the file descriptors of the socket's listeners, in the
+	// same order as [Socket.Addrs], so they can be handed over to another
+	// process. The socket continues to accept connections until it is
+	// closed.
+	Files() ([]*os.File, error)
+
 	// [Close] should be called to close the underlying networking socket(s)
 	// opened by the [Socket]. This may return an error, for example if the
 	// closing failed, or if the socket has not been bound correctly.
@@ -1,24 +1,25 @@
 package socket
 
 import (
-	"fmt"
 	"net"
+	"os"
 	"sync/atomic"
 )
 
 type tcp struct {
-	port   uint16
+	ep     Endpoint
 	ln     net.Listener
 	closed atomic.Bool
 }
 
-// Creates a new socket that operates over TCP on the specified port.
-func NewTcpSocket(port uint16) Socket {
-	return &tcp{port: port}
+// Creates a new socket that accepts plain connections from the endpoint. This
+// is typically over TCP, though the endpoint may also be a Unix domain socket.
+func NewTcpSocket(ep Endpoint) Socket {
+	return &tcp{ep: ep}
 }
 
 func (t *tcp) Bind() error {
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", t.port))
+	ln, err := t.ep.listen()
 	if err != nil {
 		return err
 	}
@@ -37,13 +38,44 @@ func (t *tcp) Serve(onConn onConnection, onErr onError) {
 
 		conn, err := t.ln.Accept()
 		if err != nil {
+			if t.closed.Load() {
+				// The listener has been closed while we were waiting for a
+				// connection, which is how serving is stopped.
+				return
+			}
 			go onErr(err)
 			continue
 		}
-		go onConn(conn)
+		go func() {
+			accepted, err := t.ep.accept(conn)
+			if err != nil {
+				conn.Close()
+				onErr(err)
+				return
+			}
+			onConn(accepted)
+		}()
 	}
 }
 
+func (t *tcp) Addrs() []net.Addr {
+	if t.ln == nil {
+		return nil
+	}
+	return []net.Addr{t.ln.Addr()}
+}
+
+func (t *tcp) Files() ([]*os.File, error) {
+	if t.ln == nil {
+		return nil, ErrSocketNotInUse
+	}
+	f, err := listenerFile(t.ln)
+	if err != nil {
+		return nil, err
+	}
+	return []*os.File{f}, nil
+}
+
 func (t *tcp) Close() error {
 	if t.ln == nil {
 		return ErrSocketNotInUse
@@ -10,7 +10,7 @@ import (
 func TestTcpSocketListenAndServe(t *testing.T) {
 	// Using port 0 here means we let the kernel choose the port, reducing
 	// flakiness as it is incredibly unlikely that there are no ports available.
-	s := NewTcpSocket(0).(*tcp)
+	s := NewTcpSocket(PortEndpoint(0)).(*tcp)
 	if err := s.Bind(); err != nil {
 		t.Fatalf("Bind failed: %v", err)
 	}
@@ -72,7 +72,7 @@ func TestTcpSocketClose(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewTcpSocket(0)
+			s := NewTcpSocket(PortEndpoint(0))
 			tc.setup(s)
 
 			err := s.Close()
@@ -86,3 +86,26 @@ func TestTcpSocketClose(t *testing.T) {
 		})
 	}
 }
+
+func TestTcpSocketServeReturnsOnClose(t *testing.T) {
+	s := NewTcpSocket(PortEndpoint(0))
+	if err := s.Bind(); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.Serve(
+			func(conn net.Conn) { conn.Close() },
+			func(err error) { t.Errorf("unexpected error: %v", err) },
+		)
+		close(done)
+	}()
+	s.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Serve did not return after the socket was closed")
+	}
+}
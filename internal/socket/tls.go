@@ -2,25 +2,27 @@ package socket
 
 import (
 	ctls "crypto/tls"
-	"fmt"
 	"net"
+	"os"
 	"sync/atomic"
 )
 
 type tls struct {
-	port   uint16
+	ep     Endpoint
 	conf   *ctls.Config
 	ln     net.Listener
 	closed atomic.Bool
 }
 
-// Creates a new socket that uses TLS over TCP with the given config and port.
-func NewTlsSocket(port uint16, conf *ctls.Config) Socket {
-	return &tls{port: port, conf: conf.Clone()}
+// Creates a new socket that uses TLS with the given config over connections
+// accepted from the endpoint. The TLS handshake is performed by the socket, so
+// a listener given in the endpoint should accept plain connections.
+func NewTlsSocket(ep Endpoint, conf *ctls.Config) Socket {
+	return &tls{ep: ep, conf: conf.Clone()}
 }
 
 func (t *tls) Bind() error {
-	ln, err := ctls.Listen("tcp", fmt.Sprintf(":%d", t.port), t.conf)
+	ln, err := t.ep.listen()
 	if err != nil {
 		return err
 	}
@@ -39,13 +41,48 @@ func (t *tls) Serve(onConn onConnection, onErr onError) {
 
 		conn, err := t.ln.Accept()
 		if err != nil {
+			if t.closed.Load() {
+				// The listener has been closed while we were waiting for a
+				// connection, which is how serving is stopped.
+				return
+			}
 			go onErr(err)
 			continue
 		}
-		go onConn(conn)
+		go func() {
+			// The TLS handshake happens after the connection has been
+			// prepared, since a PROXY protocol header is sent ahead of it.
+			accepted, err := t.ep.accept(conn)
+			if err != nil {
+				conn.Close()
+				onErr(err)
+				return
+			}
+			onConn(ctls.Server(accepted, t.conf))
+		}()
 	}
 }
 
+func (t *tls) Addrs() []net.Addr {
+	if t.ln == nil {
+		return nil
+	}
+	return []net.Addr{t.ln.Addr()}
+}
+
+// The TLS handshake is performed by whichever process accepts the connection,
+// so the listener that is handed over accepts plain connections.
+func (t *tls) Files() ([]*os.File, error) {
+	if t.ln == nil {
+		return nil, ErrSocketNotInUse
+	}
+	f, err := listenerFile(t.ln)
+	if err != nil {
+		return nil, err
+	}
+	return []*os.File{f}, nil
+}
+
 func (t *tls) Close() error {
 	if t.ln == nil {
 		return ErrSocketNotInUse
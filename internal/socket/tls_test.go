@@ -15,7 +15,7 @@ import (
 
 func TestTlsSocketListenAndServe(t *testing.T) {
 	conf := newTestTLSConfig()
-	s := NewTlsSocket(0, conf).(*tls)
+	s := NewTlsSocket(PortEndpoint(0), conf).(*tls)
 
 	if err := s.Bind(); err != nil {
 		t.Fatalf("Bind failed: %v", err)
@@ -76,7 +76,7 @@ func TestTlsSocketClose(t *testing.T) {
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			s := NewTlsSocket(0, newTestTLSConfig())
+			s := NewTlsSocket(PortEndpoint(0), newTestTLSConfig())
 			tc.setup(s)
 
 			err := s.Close()
@@ -0,0 +1,173 @@
+// Package websocket implements the framing layer of the WebSocket protocol
+// described in RFC-6455 Sec 5. It is only concerned with individual frames -
+// assembling messages out of frames and the semantics of control frames are
+// left to the caller.
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+type Opcode byte
+
+const (
+	OpContinuation Opcode = 0x0
+	OpText         Opcode = 0x1
+	OpBinary       Opcode = 0x2
+	OpClose        Opcode = 0x8
+	OpPing         Opcode = 0x9
+	OpPong         Opcode = 0xA
+)
+
+// Control frames may not carry more than this many bytes of payload
+// (RFC-6455 Sec 5.5).
+const MaxControlPayload = 125
+
+// Returned when a frame violates the framing rules of the protocol, after
+// which the connection must be failed (RFC-6455 Sec 7.1.7).
+var ErrProtocol = errors.New("websocket: protocol error")
+
+// The Header holds everything in a frame that precedes its payload.
+type Header struct {
+	Fin    bool
+	Opcode Opcode
+	Masked bool
+	Mask   [4]byte
+	Length uint64
+}
+
+// Reports whether the opcode belongs to a control frame. Control frames may
+// be sent in the middle of a fragmented message.
+func (o Opcode) IsControl() bool {
+	return o&0x8 != 0
+}
+
+func (o Opcode) valid() bool {
+	switch o {
+	case OpContinuation, OpText, OpBinary, OpClose, OpPing, OpPong:
+		return true
+	default:
+		return false
+	}
+}
+
+// Reads the header of the next frame. [ErrProtocol] is returned for headers
+// that use reserved bits or opcodes, since no extensions are supported, and
+// for control frames that are fragmented or too large. Whether the frame is
+// masked is reported but not enforced, since that depends on which end of the
+// connection is reading.
+func ReadHeader(br *bufio.Reader) (Header, error) {
+	var h Header
+	var b [8]byte
+	if _, err := io.ReadFull(br, b[:2]); err != nil {
+		return h, err
+	}
+
+	h.Fin = b[0]&0x80 != 0
+	h.Opcode = Opcode(b[0] & 0x0F)
+	h.Masked = b[1]&0x80 != 0
+	if b[0]&0x70 != 0 || !h.Opcode.valid() {
+		return h, ErrProtocol
+	}
+
+	switch n := b[1] & 0x7F; n {
+	case 126:
+		if _, err := io.ReadFull(br, b[:2]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.Length = uint64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(br, b[:8]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+		h.Length = binary.BigEndian.Uint64(b[:8])
+		// The most significant bit must be 0 (RFC-6455 Sec 5.2).
+		if h.Length>>63 != 0 {
+			return h, ErrProtocol
+		}
+	default:
+		h.Length = uint64(n)
+	}
+
+	if h.Opcode.IsControl() && (!h.Fin || h.Length > MaxControlPayload) {
+		return h, ErrProtocol
+	}
+	if h.Masked {
+		if _, err := io.ReadFull(br, h.Mask[:]); err != nil {
+			return h, unexpectedEOF(err)
+		}
+	}
+	return h, nil
+}
+
+// Reads the payload of the frame with the given header, unmasking it if
+// needed. The caller is responsible for making sure the payload is of an
+// acceptable size before reading it.
+func ReadPayload(br *bufio.Reader, h Header) ([]byte, error) {
+	payload := make([]byte, h.Length)
+	if _, err := io.ReadFull(br, payload); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if h.Masked {
+		mask(payload, h.Mask)
+	}
+	return payload, nil
+}
+
+// Writes a single frame. The payload is masked if the header says so, which
+// clients must do and servers must not (RFC-6455 Sec 5.1). The length of the
+// header is ignored in favour of the length of the payload. The payload is
+// left untouched.
+func WriteFrame(w io.Writer, h Header, payload []byte) error {
+	var b [14]byte
+	b[0] = byte(h.Opcode)
+	if h.Fin {
+		b[0] |= 0x80
+	}
+	n := 2
+	switch l := len(payload); {
+	case l < 126:
+		b[1] = byte(l)
+	case l <= 0xFFFF:
+		b[1] = 126
+		binary.BigEndian.PutUint16(b[2:], uint16(l))
+		n += 2
+	default:
+		b[1] = 127
+		binary.BigEndian.PutUint64(b[2:], uint64(l))
+		n += 8
+	}
+	if h.Masked {
+		b[1] |= 0x80
+		n += copy(b[n:], h.Mask[:])
+		masked := make([]byte, len(payload))
+		copy(masked, payload)
+		mask(masked, h.Mask)
+		payload = masked
+	}
+
+	if _, err := w.Write(b[:n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Masking and unmasking are the same operation (RFC-6455 Sec 5.3).
+func mask(b []byte, key [4]byte) {
+	for i := range b {
+		b[i] ^= key[i%4]
+	}
+}
+
+// A frame that has started must be finished, so running out of input part way
+// through a frame is never a clean end to the connection.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
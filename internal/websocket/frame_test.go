@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []byte
+		want    Header
+		wantErr error
+	}{
+		{
+			name: "unmasked text",
+			in:   []byte{0x81, 0x05},
+			want: Header{Fin: true, Opcode: OpText, Length: 5},
+		},
+		{
+			name: "masked binary",
+			in:   []byte{0x82, 0x83, 1, 2, 3, 4},
+			want: Header{Fin: true, Opcode: OpBinary, Masked: true, Mask: [4]byte{1, 2, 3, 4}, Length: 3},
+		},
+		{
+			name: "fragment",
+			in:   []byte{0x01, 0x05},
+			want: Header{Opcode: OpText, Length: 5},
+		},
+		{
+			name: "16 bit length",
+			in:   []byte{0x82, 126, 0x01, 0x00},
+			want: Header{Fin: true, Opcode: OpBinary, Length: 256},
+		},
+		{
+			name: "64 bit length",
+			in:   []byte{0x82, 127, 0, 0, 0, 0, 0, 1, 0, 0},
+			want: Header{Fin: true, Opcode: OpBinary, Length: 65536},
+		},
+		{
+			name:    "64 bit length with most significant bit set",
+			in:      []byte{0x82, 127, 0x80, 0, 0, 0, 0, 0, 0, 0},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "reserved bit set",
+			in:      []byte{0xC1, 0x00},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "reserved opcode",
+			in:      []byte{0x83, 0x00},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "fragmented control frame",
+			in:      []byte{0x09, 0x00},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "control frame too large",
+			in:      []byte{0x89, 126, 0x00, 0x7E},
+			wantErr: ErrProtocol,
+		},
+		{
+			name:    "empty input",
+			wantErr: io.EOF,
+		},
+		{
+			name:    "truncated length",
+			in:      []byte{0x82, 126, 0x01},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "truncated mask",
+			in:      []byte{0x82, 0x83, 1, 2},
+			wantErr: io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := ReadHeader(bufio.NewReader(bytes.NewReader(tc.in)))
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf(`ReadHeader() error = %v, wanted %v`, err, tc.wantErr)
+			}
+			if tc.wantErr == nil && h != tc.want {
+				t.Errorf(`ReadHeader() = %+v, wanted %+v`, h, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteFrame(t *testing.T) {
+	tests := []struct {
+		name    string
+		h       Header
+		payload string
+		want    []byte
+	}{
+		{
+			name:    "short payload",
+			h:       Header{Fin: true, Opcode: OpText},
+			payload: "hello",
+			want:    append([]byte{0x81, 0x05}, "hello"...),
+		},
+		{
+			name: "empty payload",
+			h:    Header{Fin: true, Opcode: OpPing},
+			want: []byte{0x89, 0x00},
+		},
+		{
+			name:    "not final",
+			h:       Header{Opcode: OpBinary},
+			payload: "a",
+			want:    []byte{0x02, 0x01, 'a'},
+		},
+		{
+			name:    "masked",
+			h:       Header{Fin: true, Opcode: OpText, Masked: true, Mask: [4]byte{1, 2, 3, 4}},
+			payload: "abcde",
+			want:    []byte{0x81, 0x85, 1, 2, 3, 4, 'a' ^ 1, 'b' ^ 2, 'c' ^ 3, 'd' ^ 4, 'e' ^ 1},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			payload := []byte(tc.payload)
+
+			if err := WriteFrame(&buf, tc.h, payload); err != nil {
+				t.Fatalf(`WriteFrame() error = %v`, err)
+			}
+
+			if !bytes.Equal(buf.Bytes(), tc.want) {
+				t.Errorf(`WriteFrame() = %v, wanted %v`, buf.Bytes(), tc.want)
+			}
+			if string(payload) != tc.payload {
+				t.Errorf(`payload modified to %q`, payload)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 125, 126, 65535, 65536} {
+		for _, masked := range []bool{false, true} {
+			payload := []byte(strings.Repeat("x", size))
+			var buf bytes.Buffer
+			in := Header{Fin: true, Opcode: OpBinary, Masked: masked, Mask: [4]byte{9, 8, 7, 6}}
+			if err := WriteFrame(&buf, in, payload); err != nil {
+				t.Fatalf(`WriteFrame() error = %v`, err)
+			}
+
+			br := bufio.NewReader(&buf)
+			h, err := ReadHeader(br)
+			if err != nil {
+				t.Fatalf(`ReadHeader() error = %v`, err)
+			}
+			got, err := ReadPayload(br, h)
+			if err != nil {
+				t.Fatalf(`ReadPayload() error = %v`, err)
+			}
+
+			if h.Length != uint64(size) || h.Masked != masked {
+				t.Errorf(`header = %+v, wanted length %d and masked %t`, h, size, masked)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf(`payload of size %d (masked %t) did not survive the round trip`, size, masked)
+			}
+		}
+	}
+}
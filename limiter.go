@@ -0,0 +1,114 @@
+package routeit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// A limiter bounds how much of something the server does at once, such as how
+// many connections it serves. Work that arrives once the limit has been
+// reached may wait a while for capacity to free up before it is turned away.
+type limiter struct {
+	// Holds a value for each unit of capacity in use. This is nil when there
+	// is no limit, in which case only the counts are kept.
+	slots    chan struct{}
+	active   atomic.Int64
+	queued   atomic.Int64
+	rejected atomic.Uint64
+}
+
+func newLimiter(max uint) *limiter {
+	l := &limiter{}
+	if max != 0 {
+		l.slots = make(chan struct{}, max)
+	}
+	return l
+}
+
+// Takes a unit of capacity, waiting up to timeout for one to free up if the
+// limit has been reached. Reports false if no capacity became available in
+// time or the context is done first, in which case the work should be turned
+// away. Each successful call must be paired with a call to release.
+func (l *limiter) acquire(ctx context.Context, timeout time.Duration) bool {
+	if l.slots == nil {
+		l.active.Add(1)
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		l.active.Add(1)
+		return true
+	default:
+	}
+	if timeout > 0 {
+		l.queued.Add(1)
+		defer l.queued.Add(-1)
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case l.slots <- struct{}{}:
+			l.active.Add(1)
+			return true
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+	}
+	l.rejected.Add(1)
+	return false
+}
+
+func (l *limiter) release() {
+	l.active.Add(-1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// The [ServerStats] are a snapshot of how busy the server is, which can be
+// used to alert when the server is close to the limits set by
+// [ServerConfig.MaxConnections] and [ServerConfig.MaxInFlightRequests].
+type ServerStats struct {
+	// The number of connections currently being served.
+	Connections int64
+	// The number of connections waiting for another connection to close
+	// before they are served.
+	QueuedConnections int64
+	// The total number of connections turned away because the server was
+	// serving too many connections.
+	RejectedConnections uint64
+	// The number of requests currently being handled.
+	InFlightRequests int64
+	// The number of requests waiting for another request to finish before
+	// they are handled.
+	QueuedRequests int64
+	// The total number of requests turned away because the server was
+	// handling too many requests.
+	RejectedRequests uint64
+}
+
+// Returns how busy the server currently is. This is safe to call at any time,
+// including while the server is serving requests.
+func (s *Server) Stats() ServerStats {
+	return ServerStats{
+		Connections:         s.connLimit.active.Load(),
+		QueuedConnections:   s.connLimit.queued.Load(),
+		RejectedConnections: s.connLimit.rejected.Load(),
+		InFlightRequests:    s.reqLimit.active.Load(),
+		QueuedRequests:      s.reqLimit.queued.Load(),
+		RejectedRequests:    s.reqLimit.rejected.Load(),
+	}
+}
+
+// The error returned when the server is too busy to handle a request. Clients
+// are told when to try again using the Retry-After header, which only has
+// second precision (RFC-9110 Sec 10.2.3).
+func (s *Server) errOverloaded() *HttpError {
+	err := ErrServiceUnavailable().WithMessage("Server is at capacity")
+	secs := int64(math.Ceil(s.conf.OverloadRetryAfter.Seconds()))
+	err.headers.Set("Retry-After", fmt.Sprintf("%d", secs))
+	return err
+}
@@ -0,0 +1,254 @@
+package routeit
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		l := newLimiter(0)
+
+		for range 100 {
+			if !l.acquire(t.Context(), 0) {
+				t.Fatal("expected acquire to succeed")
+			}
+		}
+
+		if got := l.active.Load(); got != 100 {
+			t.Errorf(`active = %d, wanted 100`, got)
+		}
+		l.release()
+		if got := l.active.Load(); got != 99 {
+			t.Errorf(`active = %d, wanted 99`, got)
+		}
+	})
+
+	t.Run("rejects once full", func(t *testing.T) {
+		l := newLimiter(2)
+
+		first, second, third := l.acquire(t.Context(), 0), l.acquire(t.Context(), 0), l.acquire(t.Context(), 0)
+
+		if !first || !second {
+			t.Error("expected acquire to succeed while below the limit")
+		}
+		if third {
+			t.Error("expected acquire to fail once the limit is reached")
+		}
+		if got := l.rejected.Load(); got != 1 {
+			t.Errorf(`rejected = %d, wanted 1`, got)
+		}
+		l.release()
+		if !l.acquire(t.Context(), 0) {
+			t.Error("expected acquire to succeed once capacity is released")
+		}
+	})
+
+	t.Run("waits for capacity", func(t *testing.T) {
+		l := newLimiter(1)
+		l.acquire(t.Context(), 0)
+		go func() {
+			for l.queued.Load() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			l.release()
+		}()
+
+		if !l.acquire(t.Context(), time.Second) {
+			t.Error("expected acquire to succeed once capacity is released")
+		}
+		if got := l.queued.Load(); got != 0 {
+			t.Errorf(`queued = %d, wanted 0`, got)
+		}
+	})
+
+	t.Run("gives up waiting", func(t *testing.T) {
+		l := newLimiter(1)
+		l.acquire(t.Context(), 0)
+
+		if l.acquire(t.Context(), 10*time.Millisecond) {
+			t.Error("expected acquire to time out")
+		}
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		if l.acquire(ctx, time.Minute) {
+			t.Error("expected acquire to stop once the context is done")
+		}
+		if got := l.rejected.Load(); got != 2 {
+			t.Errorf(`rejected = %d, wanted 2`, got)
+		}
+	})
+}
+
+func TestLoadShedding(t *testing.T) {
+	newServer := func(conf ServerConfig, block chan struct{}) *Server {
+		conf.Debug = true
+		conf.LoggingHandler = slog.DiscardHandler
+		srv := NewServer(conf)
+		srv.RegisterRoutes(RouteRegistry{
+			"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+				rw.Text("Hello!")
+				return nil
+			}),
+			"/block": Get(func(rw *ResponseWriter, req *Request) error {
+				<-block
+				rw.Text("unblocked")
+				return nil
+			}),
+		})
+		return srv
+	}
+	connect := func(t *testing.T, srv *Server) (net.Conn, *bufio.Reader) {
+		t.Helper()
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go srv.handleNewConnection(server)
+		return client, bufio.NewReader(client)
+	}
+	get := func(t *testing.T, conn net.Conn, br *bufio.Reader, path string) *http.Response {
+		t.Helper()
+		go conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		io.ReadAll(res.Body)
+		return res
+	}
+	waitFor := func(t *testing.T, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for condition")
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("rejects connections over the limit", func(t *testing.T) {
+		srv := newServer(ServerConfig{MaxConnections: 1, OverloadRetryAfter: 1500 * time.Millisecond}, nil)
+		first, firstBr := connect(t, srv)
+		get(t, first, firstBr, "/hello")
+
+		second, secondBr := connect(t, srv)
+		res := get(t, second, secondBr, "/hello")
+
+		if res.StatusCode != 503 {
+			t.Errorf(`status = %d, wanted 503`, res.StatusCode)
+		}
+		if got := res.Header.Get("Retry-After"); got != "2" {
+			t.Errorf(`Retry-After = %#q, wanted "2"`, got)
+		}
+		if !res.Close {
+			t.Error("expected rejected connection to be closed")
+		}
+		stats := srv.Stats()
+		if stats.Connections != 1 || stats.RejectedConnections != 1 {
+			t.Errorf(`stats = %+v, wanted 1 connection and 1 rejected connection`, stats)
+		}
+		if res := get(t, first, firstBr, "/hello"); res.StatusCode != 200 {
+			t.Errorf(`status on first connection = %d, wanted 200`, res.StatusCode)
+		}
+	})
+
+	t.Run("rejects connections without reading the body", func(t *testing.T) {
+		srv := newServer(ServerConfig{MaxConnections: 1}, nil)
+		first, firstBr := connect(t, srv)
+		get(t, first, firstBr, "/hello")
+		second, secondBr := connect(t, srv)
+		body := strings.Repeat("a", 64*int(KiB))
+		written := make(chan int)
+		go func() {
+			n, _ := second.Write([]byte("POST /hello HTTP/1.1\r\nHost: localhost\r\nContent-Length: 65536\r\n\r\n" + body))
+			written <- n
+		}()
+
+		res, err := http.ReadResponse(secondBr, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+
+		if res.StatusCode != 503 {
+			t.Errorf(`status = %d, wanted 503`, res.StatusCode)
+		}
+		if n := <-written; n > int(rejectReadLimit) {
+			t.Errorf(`server read %d bytes, wanted at most %d`, n, rejectReadLimit)
+		}
+	})
+
+	t.Run("rejects TLS connections before the handshake", func(t *testing.T) {
+		srv := newServer(ServerConfig{MaxConnections: 1}, nil)
+		first, firstBr := connect(t, srv)
+		get(t, first, firstBr, "/hello")
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go srv.handleNewConnection(tls.Server(server, &tls.Config{}))
+
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := client.Read(make([]byte, 1))
+
+		if err != io.EOF {
+			t.Errorf(`err = %v, wanted the connection to be closed`, err)
+		}
+	})
+
+	t.Run("queues connections until one closes", func(t *testing.T) {
+		srv := newServer(ServerConfig{MaxConnections: 1, OverloadQueueTimeout: time.Second}, nil)
+		first, firstBr := connect(t, srv)
+		get(t, first, firstBr, "/hello")
+
+		second, secondBr := connect(t, srv)
+		waitFor(t, func() bool { return srv.Stats().QueuedConnections == 1 })
+		first.Close()
+		res := get(t, second, secondBr, "/hello")
+
+		if res.StatusCode != 200 {
+			t.Errorf(`status = %d, wanted 200`, res.StatusCode)
+		}
+	})
+
+	t.Run("rejects requests over the limit", func(t *testing.T) {
+		block := make(chan struct{})
+		srv := newServer(ServerConfig{MaxInFlightRequests: 1}, block)
+		client := NewTestClient(srv)
+		blocked := make(chan *TestResponse)
+		go func() { blocked <- client.Get("/block") }()
+		waitFor(t, func() bool { return srv.Stats().InFlightRequests == 1 })
+
+		res := client.Get("/hello")
+		close(block)
+
+		res.AssertStatusCode(t, StatusServiceUnavailable)
+		res.AssertHeaderMatchesString(t, "Retry-After", "1")
+		(<-blocked).AssertStatusCode(t, StatusOK)
+		if stats := srv.Stats(); stats.InFlightRequests != 0 || stats.RejectedRequests != 1 {
+			t.Errorf(`stats = %+v, wanted no requests in flight and 1 rejected request`, stats)
+		}
+	})
+
+	t.Run("queues requests until one finishes", func(t *testing.T) {
+		block := make(chan struct{})
+		srv := newServer(ServerConfig{MaxInFlightRequests: 1, OverloadQueueTimeout: time.Second}, block)
+		client := NewTestClient(srv)
+		blocked := make(chan *TestResponse)
+		go func() { blocked <- client.Get("/block") }()
+		waitFor(t, func() bool { return srv.Stats().InFlightRequests == 1 })
+
+		queued := make(chan *TestResponse)
+		go func() { queued <- client.Get("/hello") }()
+		waitFor(t, func() bool { return srv.Stats().QueuedRequests == 1 })
+		close(block)
+
+		(<-blocked).AssertStatusCode(t, StatusOK)
+		(<-queued).AssertStatusCode(t, StatusOK)
+	})
+}
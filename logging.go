@@ -19,8 +19,11 @@ type logger struct {
 }
 
 func newLogger(handler slog.Handler, debug bool, fn LogAttrExtractor) *logger {
+	if fn == nil {
+		fn = func(r *Request, hs HttpStatus) []slog.Attr { return []slog.Attr{} }
+	}
 	if handler != nil {
-		return &logger{log: slog.New(handler)}
+		return &logger{log: slog.New(handler), extraAttrs: fn}
 	}
 	logOpts := slog.HandlerOptions{}
 	if debug {
@@ -29,9 +32,6 @@ func newLogger(handler slog.Handler, debug bool, fn LogAttrExtractor) *logger {
 		logOpts.Level = slog.LevelInfo
 	}
 	jsonHandler := slog.NewJSONHandler(os.Stdout, &logOpts)
-	if fn == nil {
-		fn = func(r *Request, hs HttpStatus) []slog.Attr { return []slog.Attr{} }
-	}
 	return &logger{log: slog.New(jsonHandler), extraAttrs: fn}
 }
 
@@ -48,7 +48,7 @@ func (l *logger) LogRequestAndResponse(rw *ResponseWriter, req *Request) {
 
 	l.log.LogAttrs(req.Context(), level, "Received request", l.attrs(rw, req)...)
 
-	if rw.s.isError() && len(req.body) != 0 {
+	if rw.s.isError() && req.bodyConsumed() && len(req.body) != 0 {
 		l.log.Debug("Request failed", slog.String("body", string(req.body)))
 	}
 }
@@ -0,0 +1,56 @@
+package routeit
+
+import (
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogAttrExtractor(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor LogAttrExtractor
+		want      string
+	}{
+		{
+			name: "custom handler with extractor",
+			extractor: func(req *Request, s HttpStatus) []slog.Attr {
+				return []slog.Attr{slog.String("tenant", "acme")}
+			},
+			want: "tenant=acme",
+		},
+		{
+			name: "custom handler without extractor",
+			want: "status=200",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var logs lockedBuffer
+			srv := NewServer(ServerConfig{
+				Debug:            true,
+				LoggingHandler:   slog.NewTextHandler(&logs, nil),
+				LogAttrExtractor: tc.extractor,
+			})
+			srv.RegisterRoutes(RouteRegistry{
+				"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+					rw.Text("Hello!")
+					return nil
+				}),
+			})
+
+			res := NewTestClient(srv).Get("/hello")
+
+			res.AssertStatusCode(t, StatusOK)
+			// Requests are logged once the response has been sent.
+			deadline := time.Now().Add(time.Second)
+			for !strings.Contains(logs.String(), tc.want) {
+				if time.Now().After(deadline) {
+					t.Fatalf(`logs = %q, wanted them to contain %q`, logs.String(), tc.want)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+		})
+	}
+}
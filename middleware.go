@@ -18,6 +18,12 @@ import (
 // propagated to the response - even if the handler or intermediary middleware
 // returns an error or panics. The error's headers take precedence and will
 // overwrite any headers of the same name that are already set.
+//
+// Clients that send the "Expect: 100-continue" header wait to be told to send
+// the request body. The server only does this once the request has made it
+// through all middleware to the handler, or when the body is first accessed,
+// so middleware that rejects a request before reading its body spares the
+// client from sending it.
 type Middleware func(c Chain, rw *ResponseWriter, req *Request) error
 
 // The [Chain] manages the arrangement of middleware and can be used to invoke
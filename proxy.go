@@ -0,0 +1,360 @@
+package routeit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultProxyTimeout     = 5 * time.Second
+	defaultProxyFailTimeout = 10 * time.Second
+	// Responses from upstream that are no larger than this are sent in one
+	// go, keeping their Content-Length. Anything larger, or of unknown length,
+	// is streamed to the client.
+	proxyBufferSize = 32 * KiB
+)
+
+var errUpstreamTimeout = errors.New("upstream did not respond in time")
+
+// Headers that only apply to a single connection, so must not be passed on by
+// a proxy (RFC-9110 Sec 7.6.1).
+var hopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type ProxyConfig struct {
+	// The base URLs of the upstream servers requests are forwarded to, such as
+	// "http://10.0.0.5:8080". Requests are shared between the upstreams in
+	// turn. An upstream's path, if it has one, is prepended to the path of
+	// each request. Setup will panic if no upstreams are given, or if any are
+	// not absolute http or https URLs.
+	Upstreams []string
+	// Removed from the start of the request's path before it is forwarded,
+	// such as the namespace the proxy is registered under. The path is the one
+	// the request was routed with, so has already been through any URL
+	// rewrites.
+	StripPrefix string
+	// How long to wait for the upstream to start responding, after which the
+	// request fails with 504: Gateway Timeout. This should be shorter than the
+	// server's write deadline, otherwise the server will respond with 503:
+	// Service Unavailable first. Once the upstream has started responding, its
+	// response is streamed for as long as the server allows. Defaults to 5
+	// seconds.
+	Timeout time.Duration
+	// The number of times in a row an upstream can fail to respond before it
+	// is considered to be unhealthy. Unhealthy upstreams are skipped for
+	// FailTimeout, after which they are tried again, and are considered
+	// healthy once they next respond. The upstreams are never sent requests
+	// just to check their health, so this only applies when there are
+	// requests to forward. Health checks are disabled by default.
+	MaxFails uint
+	// How long an unhealthy upstream is skipped for. Defaults to 10 seconds.
+	FailTimeout time.Duration
+	// Makes the requests to the upstreams. Defaults to a copy of
+	// [http.DefaultTransport].
+	Transport http.RoundTripper
+}
+
+// A reverseProxy forwards requests to its upstreams, choosing each upstream in
+// turn.
+type reverseProxy struct {
+	upstreams   []*upstream
+	stripPrefix string
+	timeout     time.Duration
+	maxFails    uint
+	failTimeout time.Duration
+	transport   http.RoundTripper
+
+	mu   sync.Mutex
+	next int
+}
+
+type upstream struct {
+	url *url.URL
+	// Guarded by the proxy's mutex.
+	fails     uint
+	downUntil time.Time
+}
+
+// Creates a handler that forwards requests to upstream servers, for servers
+// acting as a gateway in front of other services. The handler responds to
+// every method, and all of the server's middleware runs before the request is
+// forwarded, so the proxy can be protected by authentication and similar
+// middleware.
+//
+// Headers that only apply to the connection with the client are removed
+// before the request is forwarded, and the Forwarded (RFC-7239) and
+// X-Forwarded-For headers have the address of whoever connected to the server
+// appended, so the upstream can find the client. X-Forwarded-Host and
+// X-Forwarded-Proto are set to the host and scheme the server was sent the
+// request with. The upstream's response, including its status and headers,
+// is streamed back to the client. Headers set by middleware are kept unless
+// the upstream sends a header of the same name.
+//
+// Upstreams that cannot be reached respond with 502: Bad Gateway, and those
+// that take longer than [ProxyConfig.Timeout] to respond with 504: Gateway
+// Timeout, both of which are passed to the error handlers. Requests are not
+// retried against other upstreams, since it is not always safe to send a
+// request twice. Connection upgrades, such as WebSockets, are not forwarded.
+func ReverseProxy(conf ProxyConfig) Handler {
+	if len(conf.Upstreams) == 0 {
+		panic("reverse proxy must have at least one upstream")
+	}
+	p := &reverseProxy{
+		stripPrefix: strings.TrimSuffix(conf.StripPrefix, "/"),
+		timeout:     conf.Timeout,
+		maxFails:    conf.MaxFails,
+		failTimeout: conf.FailTimeout,
+		transport:   conf.Transport,
+	}
+	for _, raw := range conf.Upstreams {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			panic(fmt.Sprintf("invalid upstream [%s] - must be an absolute http or https URL", raw))
+		}
+		p.upstreams = append(p.upstreams, &upstream{url: u})
+	}
+	if p.stripPrefix != "" && !strings.HasPrefix(p.stripPrefix, "/") {
+		p.stripPrefix = "/" + p.stripPrefix
+	}
+	if p.timeout == 0 {
+		p.timeout = defaultProxyTimeout
+	}
+	if p.failTimeout == 0 {
+		p.failTimeout = defaultProxyFailTimeout
+	}
+	if p.transport == nil {
+		p.transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+	return anyMethod(p.forward)
+}
+
+func (p *reverseProxy) forward(rw *ResponseWriter, req *Request) error {
+	up := p.pick()
+	if up == nil {
+		return ErrBadGateway().WithMessage("No healthy upstream servers")
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+	defer cancel(nil)
+	timer := time.AfterFunc(p.timeout, func() { cancel(errUpstreamTimeout) })
+	res, err := p.transport.RoundTrip(p.outgoing(req, up).WithContext(ctx))
+	if !timer.Stop() && err == nil {
+		// The upstream responded just as it ran out of time, but its body can
+		// no longer be read.
+		res.Body.Close()
+		err = errUpstreamTimeout
+	}
+	if err != nil {
+		if req.Context().Err() != nil {
+			// The upstream is not at fault if the client has gone away or the
+			// server has given up on the request.
+			return context.Cause(req.Context())
+		}
+		p.report(up, false)
+		var netErr net.Error
+		if errors.Is(context.Cause(ctx), errUpstreamTimeout) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			return ErrGatewayTimeout().WithCause(err)
+		}
+		return ErrBadGateway().WithCause(err)
+	}
+	p.report(up, true)
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 599 {
+		return ErrBadGateway().WithMessagef("Upstream responded with invalid status %d", res.StatusCode)
+	}
+	removeHopByHopHeaders(res.Header)
+	rw.Status(httpStatusFromCode(res.StatusCode))
+	setHttpHeaders(rw, res.Header)
+	if req.mthd == HEAD || !rw.s.allowsBody() {
+		// The upstream's Content-Length, if any, describes the body it would
+		// have sent, so is passed on as is.
+		return nil
+	}
+	return copyUpstreamBody(rw, res)
+}
+
+// Chooses the next healthy upstream, or nil if all of them are unhealthy.
+func (p *reverseProxy) pick() *upstream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for range p.upstreams {
+		up := p.upstreams[p.next]
+		p.next = (p.next + 1) % len(p.upstreams)
+		if p.maxFails == 0 || !now.Before(up.downUntil) {
+			return up
+		}
+	}
+	return nil
+}
+
+// Records whether the upstream responded. An upstream that fails again once
+// it has been given another chance is skipped straight away, since it has not
+// responded since it was last considered unhealthy.
+func (p *reverseProxy) report(up *upstream, ok bool) {
+	if p.maxFails == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ok {
+		up.fails = 0
+		return
+	}
+	up.fails++
+	if up.fails >= p.maxFails {
+		up.downUntil = time.Now().Add(p.failTimeout)
+	}
+}
+
+// Builds the request sent to the upstream.
+func (p *reverseProxy) outgoing(req *Request, up *upstream) *http.Request {
+	out := newHttpRequest(req)
+	out.RequestURI = ""
+	out.Host = ""
+	out.Trailer = nil
+
+	// The path is unchanged if it does not start with the prefix, so the
+	// upstream can decide how to respond.
+	path, rawPath := out.URL.Path, out.URL.RawPath
+	if p.stripPrefix != "" {
+		path = stripPathPrefix(path, p.stripPrefix)
+		// Escaping within the prefix may not match the decoded prefix, in
+		// which case the path is escaped afresh.
+		rawPath = stripPathPrefix(rawPath, p.stripPrefix)
+		if u := (url.URL{Path: path, RawPath: rawPath}); u.EscapedPath() != rawPath {
+			rawPath = ""
+		}
+	}
+	target := *up.url
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	if rawPath != "" {
+		target.RawPath = strings.TrimSuffix(target.EscapedPath(), "/") + rawPath
+	} else {
+		target.RawPath = ""
+	}
+	target.RawQuery = out.URL.RawQuery
+	out.URL = &target
+
+	// The body has already been read from the client.
+	out.Header.Del("Expect")
+	removeHopByHopHeaders(out.Header)
+	appendForwarded(out.Header, req)
+	return out
+}
+
+func stripPathPrefix(path, prefix string) string {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return path
+	}
+	if rest == "" {
+		return "/"
+	}
+	return rest
+}
+
+// Removes headers that only apply to the connection they were received over,
+// along with any headers the Connection header names as doing so.
+func removeHopByHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for name := range strings.SplitSeq(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
+
+// Describes the hop between whoever connected to the server and the server in
+// the forwarding headers, adding to the description of any earlier hops.
+func appendForwarded(h http.Header, req *Request) {
+	proto := "http"
+	if req.tlsState != nil {
+		proto = "https"
+	}
+	host, _ := req.Headers().First("Host")
+
+	// Peers that did not connect over TCP, such as over a Unix domain socket,
+	// have no address to give.
+	node := "unknown"
+	if addr, err := netip.ParseAddr(req.peer); err == nil {
+		addr = addr.Unmap()
+		node = addr.String()
+		if prior := h.Values("X-Forwarded-For"); len(prior) != 0 {
+			h.Set("X-Forwarded-For", strings.Join(prior, ", ")+", "+node)
+		} else {
+			h.Set("X-Forwarded-For", node)
+		}
+		if addr.Is6() {
+			// IPv6 addresses must be bracketed and quoted (RFC-7239 Sec 6).
+			node = `"[` + node + `]"`
+		}
+	}
+	h.Add("Forwarded", fmt.Sprintf("for=%s;host=%q;proto=%s", node, host, proto))
+	h.Set("X-Forwarded-Host", host)
+	h.Set("X-Forwarded-Proto", proto)
+}
+
+// Sends the upstream's response body to the client. Small bodies of a known
+// length are sent in one go, while anything else is streamed, flushing
+// whatever the upstream has sent so far so that streamed responses, such as
+// event streams, are not held up. Streamed bodies keep the length the upstream
+// gave them, and only use the chunked transfer coding when it is not known.
+func copyUpstreamBody(rw *ResponseWriter, res *http.Response) error {
+	if res.ContentLength >= 0 && res.ContentLength <= int64(proxyBufferSize) {
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			return ErrBadGateway().WithCause(err)
+		}
+		setHttpBody(rw, body, res.Header.Get("Content-Type"))
+		return nil
+	}
+	if res.ContentLength > 0 {
+		rw.stream.length = res.ContentLength
+	}
+
+	buf := make([]byte, proxyBufferSize)
+	for {
+		n, err := res.Body.Read(buf)
+		if n > 0 {
+			if _, err := rw.Write(buf[:n]); err != nil {
+				return err
+			}
+			if err := rw.Flush(); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if !rw.streaming() {
+				return ErrBadGateway().WithCause(err)
+			}
+			return err
+		}
+	}
+}
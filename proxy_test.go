@@ -0,0 +1,205 @@
+package routeit
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReverseProxy(t *testing.T) {
+	upstream := func(t *testing.T, name string) *httptest.Server {
+		t.Helper()
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			switch r.URL.Path {
+			case "/base/large":
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Length", "100000")
+				w.Write([]byte(strings.Repeat("a", 100_000)))
+				return
+			case "/base/unsized":
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(strings.Repeat("a", 100_000)))
+				return
+			case "/base/slow":
+				time.Sleep(200 * time.Millisecond)
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Header().Set("Connection", "X-Hop")
+			w.Header().Set("X-Hop", "secret")
+			w.Header().Set("X-Upstream", name)
+			w.Header().Set("X-Middleware", "upstream")
+			w.WriteHeader(http.StatusAccepted)
+			fmt.Fprintf(w, "%s %s?%s host=%s body=%s hop=%q forwarded=%q xff=%q xfh=%q xfp=%q",
+				r.Method, r.URL.Path, r.URL.RawQuery, r.Host, body, r.Header.Get("X-Hop"),
+				r.Header.Get("Forwarded"), r.Header.Get("X-Forwarded-For"),
+				r.Header.Get("X-Forwarded-Host"), r.Header.Get("X-Forwarded-Proto"))
+		}))
+		t.Cleanup(ts.Close)
+		return ts
+	}
+	closed := func(t *testing.T) string {
+		t.Helper()
+		ts := httptest.NewServer(http.NotFoundHandler())
+		ts.Close()
+		return ts.URL
+	}
+	serve := func(conf ProxyConfig) TestClient {
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterMiddleware(func(c Chain, rw *ResponseWriter, req *Request) error {
+			rw.Headers().Set("X-Middleware", "routeit")
+			rw.Headers().Set("X-Before", "routeit")
+			return c.Proceed(rw, req)
+		})
+		srv.RegisterRoutesUnderNamespace("/api", RouteRegistry{
+			"/:resource": ReverseProxy(conf),
+		})
+		return NewTestClient(srv)
+	}
+
+	t.Run("forwards request", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{up.URL + "/base/"}, StripPrefix: "/api"})
+
+		res := client.PostText("/api/items?q=1", "ping", "Connection", "X-Hop", "X-Hop", "secret", "X-Forwarded-For", "10.0.0.1")
+
+		res.AssertStatusCode(t, StatusAccepted)
+		res.AssertBodyMatchesStringf(t,
+			`POST /base/items?q=1 host=%s body=ping hop="" forwarded="for=127.0.0.1;host=\"localhost:1234\";proto=http" xff="10.0.0.1, 127.0.0.1" xfh="localhost:1234" xfp="http"`,
+			up.Listener.Addr())
+		res.AssertHeaderMatchesString(t, "X-Upstream", "one")
+		res.AssertHeaderMatchesString(t, "X-Middleware", "upstream")
+		res.AssertHeaderMatchesString(t, "X-Before", "routeit")
+		res.AssertHeaderMatchesString(t, "Content-Type", "text/plain; charset=utf-8")
+		res.RefuteHeaderPresent(t, "X-Hop")
+		res.RefuteHeaderPresent(t, "Transfer-Encoding")
+	})
+
+	t.Run("forwards query unchanged", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{up.URL + "/base"}, StripPrefix: "/api"})
+
+		res := client.Get("/api/items?b=1&a=2&flag&x=a+b%20c")
+
+		res.AssertStatusCode(t, StatusAccepted)
+		res.AssertBodyMatchesStringf(t,
+			`GET /base/items?b=1&a=2&flag&x=a+b%%20c host=%s body= hop="" forwarded="for=127.0.0.1;host=\"localhost:1234\";proto=http" xff="127.0.0.1" xfh="localhost:1234" xfp="http"`,
+			up.Listener.Addr())
+	})
+
+	t.Run("streams large responses", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{up.URL + "/base"}, StripPrefix: "api"})
+
+		res := client.Get("/api/large")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, strings.Repeat("a", 100_000))
+		res.AssertHeaderMatchesString(t, "Content-Length", "100000")
+		res.RefuteHeaderPresent(t, "Transfer-Encoding")
+	})
+
+	t.Run("streams responses of unknown length", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{up.URL + "/base"}, StripPrefix: "api"})
+
+		res := client.Get("/api/unsized")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, strings.Repeat("a", 100_000))
+		res.AssertHeaderMatchesString(t, "Transfer-Encoding", "chunked")
+		res.RefuteHeaderPresent(t, "Content-Length")
+	})
+
+	t.Run("head", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{up.URL + "/base"}, StripPrefix: "/api"})
+
+		res := client.Head("/api/large")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyEmpty(t)
+		res.AssertHeaderMatchesString(t, "Content-Length", "100000")
+	})
+
+	t.Run("unreachable upstream", func(t *testing.T) {
+		client := serve(ProxyConfig{Upstreams: []string{closed(t)}})
+
+		res := client.Get("/api/items")
+
+		res.AssertStatusCode(t, StatusBadGateway)
+	})
+
+	t.Run("slow upstream", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{up.URL + "/base"}, StripPrefix: "/api", Timeout: 50 * time.Millisecond})
+
+		res := client.Get("/api/slow")
+
+		res.AssertStatusCode(t, StatusGatewayTimeout)
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		one, two := upstream(t, "one"), upstream(t, "two")
+		client := serve(ProxyConfig{Upstreams: []string{one.URL, two.URL}})
+
+		var got []string
+		for range 4 {
+			vals, _ := client.Get("/api/items").rw.headers.headers.All("X-Upstream")
+			got = append(got, vals...)
+		}
+
+		if want := "one,two,one,two"; strings.Join(got, ",") != want {
+			t.Errorf(`upstreams = %v, wanted %s`, got, want)
+		}
+	})
+
+	t.Run("passive health checks", func(t *testing.T) {
+		up := upstream(t, "one")
+		client := serve(ProxyConfig{Upstreams: []string{closed(t), up.URL}, MaxFails: 1, FailTimeout: time.Minute})
+
+		client.Get("/api/items").AssertStatusCode(t, StatusBadGateway)
+		for range 3 {
+			res := client.Get("/api/items")
+			res.AssertStatusCode(t, StatusAccepted)
+			res.AssertHeaderMatchesString(t, "X-Upstream", "one")
+		}
+	})
+
+	t.Run("no healthy upstreams", func(t *testing.T) {
+		client := serve(ProxyConfig{Upstreams: []string{closed(t)}, MaxFails: 1, FailTimeout: time.Minute})
+
+		client.Get("/api/items").AssertStatusCode(t, StatusBadGateway)
+		res := client.Get("/api/items")
+
+		res.AssertStatusCode(t, StatusBadGateway)
+		res.AssertBodyContainsString(t, "No healthy upstream servers")
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		tests := []struct {
+			name string
+			conf ProxyConfig
+		}{
+			{"no upstreams", ProxyConfig{}},
+			{"relative upstream", ProxyConfig{Upstreams: []string{"/path"}}},
+			{"unsupported scheme", ProxyConfig{Upstreams: []string{"ftp://example.com"}}},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				defer func() {
+					if recover() == nil {
+						t.Error("expected panic")
+					}
+				}()
+
+				ReverseProxy(tc.conf)
+			})
+		}
+	})
+}
@@ -0,0 +1,310 @@
+package routeit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// Content no larger than this is read into memory and sent in one go, keeping
+// its Content-Length, while anything larger is streamed to the client.
+const maxBufferedContent = int64(32 * KiB)
+
+// A byteRange is a part of some content, starting at the given offset.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// Responds with content that clients can request part of, such as a video or
+// a large download, using the Range header (RFC-9110 Sec 14). The response
+// tells clients that ranges are supported through the Accept-Ranges header.
+// GET requests for a single range receive 206: Partial Content with just that
+// range, while requests for several ranges receive each of them as part of a
+// multipart/byteranges body. Requests for ranges that lie entirely outside
+// the content are rejected with 416: Range Not Satisfiable. The whole content
+// is sent for all other requests, or when the Range header cannot be
+// understood.
+//
+// If the response has an ETag or Last-Modified header set, the If-Range
+// header is honoured, so clients resuming a download receive the whole
+// content once it has changed instead of a range of the new content that does
+// not line up with what they already have.
+//
+// The request's conditional headers are evaluated against the validators
+// already set on the response before the Range header is, so this should be
+// called once they have been set. Only the requested ranges are read from the
+// content. Up to 32 KiB is held in memory, while anything larger is streamed
+// to the client as it is read, keeping its Content-Length unless several
+// ranges were asked for. The content is read from its current offset, and its
+// size is found by seeking to its end. When ct is the zero value, the content
+// type is inferred from the start of the content, as with
+// [ResponseWriter.Raw].
+func (rw *ResponseWriter) ServeContent(req *Request, content io.ReadSeeker, ct ContentType) error {
+	// Preconditions are evaluated before the Range header (RFC-9110 Sec
+	// 13.2.2), so a client that already has the content is told so, even if
+	// the range it asked for cannot be satisfied.
+	if req.mthd == GET || req.mthd == HEAD {
+		if done, err := rw.CheckPreconditions(req); done || err != nil {
+			return err
+		}
+	}
+	start, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	end, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	size := end - start
+	if ct == (ContentType{}) {
+		if ct, err = sniffContentType(content, start, size); err != nil {
+			return err
+		}
+	}
+	rw.headers.Set("Accept-Ranges", "bytes")
+
+	ranges, err := rw.requestedRanges(req, size)
+	if err != nil {
+		return err
+	}
+	if len(ranges) > 1 {
+		return rw.serveMultipartRanges(req, content, start, size, ranges, ct)
+	}
+	r := byteRange{0, size}
+	if len(ranges) == 1 {
+		r = ranges[0]
+		rw.Status(StatusPartialContent)
+		rw.headers.Set("Content-Range", r.contentRange(size))
+	}
+	if r.length <= maxBufferedContent {
+		body, err := readRange(content, start, r)
+		if err != nil {
+			return err
+		}
+		rw.RawWithContentType(body, ct)
+		return nil
+	}
+	rw.headers.Set("Content-Type", ct.string())
+	rw.stream.length = r.length
+	return rw.streamContent(req, func(w io.Writer) error {
+		return copyRange(w, content, start, r)
+	})
+}
+
+// Sends several ranges of the content as a multipart/byteranges body
+// (RFC-9110 Sec 14.6), with each range in its own part.
+func (rw *ResponseWriter) serveMultipartRanges(req *Request, content io.ReadSeeker, offset, size int64, ranges []byteRange, ct ContentType) error {
+	rw.Status(StatusPartialContent)
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total <= maxBufferedContent {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		if err := writeMultipartRanges(mw, content, offset, size, ranges, ct); err != nil {
+			return err
+		}
+		rw.RawWithContentType(buf.Bytes(), CTMultipartByteranges)
+		rw.headers.Set("Content-Type", CTMultipartByteranges.string()+"; boundary="+mw.Boundary())
+		return nil
+	}
+	mw := multipart.NewWriter(rw)
+	rw.headers.Set("Content-Type", CTMultipartByteranges.string()+"; boundary="+mw.Boundary())
+	return rw.streamContent(req, func(io.Writer) error {
+		return writeMultipartRanges(mw, content, offset, size, ranges, ct)
+	})
+}
+
+// Streams the body produced by write to the client. Responses to HEAD
+// requests never have a body, so the content is not read for them.
+func (rw *ResponseWriter) streamContent(req *Request, write func(w io.Writer) error) error {
+	if req.mthd == HEAD {
+		return rw.Flush()
+	}
+	return write(rw)
+}
+
+// Finds the ranges of the content the client asked for, if it asked for any
+// that we are willing to send. Requests whose ranges all lie outside the
+// content cannot be satisfied.
+func (rw *ResponseWriter) requestedRanges(req *Request, size int64) ([]byteRange, error) {
+	if req.mthd != GET && req.mthd != HEAD {
+		return nil, nil
+	}
+	raw, found := req.Headers().Last("Range")
+	if !found || size == 0 || !rw.ifRange(req) {
+		return nil, nil
+	}
+	ranges, err := parseRange(raw, size)
+	if errors.Is(err, errNoOverlap) {
+		httpErr := ErrRangeNotSatisfiable()
+		httpErr.headers.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return nil, httpErr
+	}
+	if err != nil {
+		// Servers may ignore a Range header they do not understand (RFC-9110
+		// Sec 14.2), which is friendlier than rejecting the request.
+		return nil, nil
+	}
+	var total int64
+	for _, r := range ranges {
+		total += r.length
+	}
+	if total > size {
+		// Sending more than the whole content, such as when the ranges
+		// overlap, is more work than just sending the content, and is a
+		// common way to try to make servers do more work than they need to.
+		return nil, nil
+	}
+	return ranges, nil
+}
+
+// Evaluates the If-Range header (RFC-9110 Sec 13.1.5), which only lets the
+// ranges be sent if the response still has the validator the client was
+// given. Validators are compared using the strong comparison, since ranges of
+// content that is only semantically equivalent may not line up.
+func (rw *ResponseWriter) ifRange(req *Request) bool {
+	raw, found := req.Headers().Last("If-Range")
+	if !found {
+		return true
+	}
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, `"`) || strings.HasPrefix(raw, "W/") {
+		etag, _ := rw.headers.headers.All("ETag")
+		return len(etag) != 0 && !strings.HasPrefix(raw, "W/") && etag[0] == raw
+	}
+	lastModified, _ := rw.headers.headers.All("Last-Modified")
+	if len(lastModified) == 0 {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified[0])
+	if err != nil {
+		return false
+	}
+	since, err := http.ParseTime(raw)
+	return err == nil && modified.Equal(since)
+}
+
+var (
+	errInvalidRange = errors.New("invalid range")
+	errNoOverlap    = errors.New("no requested range overlaps the content")
+)
+
+// Parses the Range header (RFC-9110 Sec 14.1.2), dropping any ranges that lie
+// entirely outside the content and clamping those that run past its end.
+func parseRange(raw string, size int64) ([]byteRange, error) {
+	specs, ok := strings.CutPrefix(strings.TrimSpace(raw), "bytes=")
+	if !ok {
+		return nil, errInvalidRange
+	}
+	var ranges []byteRange
+	empty := true
+	for spec := range strings.SplitSeq(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			// Empty elements are allowed in lists (RFC-9110 Sec 5.6.1).
+			continue
+		}
+		empty = false
+		first, last, ok := strings.Cut(spec, "-")
+		if !ok {
+			return nil, errInvalidRange
+		}
+		var r byteRange
+		if first == "" {
+			// A suffix range asks for the last bytes of the content.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, errInvalidRange
+			}
+			if n == 0 {
+				continue
+			}
+			r = byteRange{start: max(size-n, 0), length: min(n, size)}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, errInvalidRange
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, errInvalidRange
+				}
+			}
+			if start >= size {
+				continue
+			}
+			r = byteRange{start: start, length: min(end, size-1) - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+	if empty {
+		return nil, errInvalidRange
+	}
+	if len(ranges) == 0 {
+		return nil, errNoOverlap
+	}
+	return ranges, nil
+}
+
+func readRange(content io.ReadSeeker, offset int64, r byteRange) ([]byte, error) {
+	if _, err := content.Seek(offset+r.start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	body := make([]byte, r.length)
+	if _, err := io.ReadFull(content, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// Copies a range of the content to w.
+func copyRange(w io.Writer, content io.ReadSeeker, offset int64, r byteRange) error {
+	if _, err := content.Seek(offset+r.start, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, content, r.length)
+	return err
+}
+
+// Writes a multipart/byteranges body using mw, with each range in its own
+// part.
+func writeMultipartRanges(mw *multipart.Writer, content io.ReadSeeker, offset, size int64, ranges []byteRange, ct ContentType) error {
+	for _, r := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {ct.string()},
+			"Content-Range": {r.contentRange(size)},
+		})
+		if err != nil {
+			return err
+		}
+		if err := copyRange(part, content, offset, r); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// Infers the type of the content from its first few bytes.
+func sniffContentType(content io.ReadSeeker, offset, size int64) (ContentType, error) {
+	head, err := readRange(content, offset, byteRange{0, min(size, 512)})
+	if err != nil {
+		return ContentType{}, err
+	}
+	return parseContentType(http.DetectContentType(head)), nil
+}
@@ -0,0 +1,316 @@
+package routeit
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []byteRange
+		wantErr error
+	}{
+		{in: "bytes=0-4", want: []byteRange{{0, 5}}},
+		{in: "bytes=5-", want: []byteRange{{5, 5}}},
+		{in: "bytes=-3", want: []byteRange{{7, 3}}},
+		{in: "bytes=-20", want: []byteRange{{0, 10}}},
+		{in: "bytes=8-20", want: []byteRange{{8, 2}}},
+		{in: "bytes=0-0, 2-3,, -1", want: []byteRange{{0, 1}, {2, 2}, {9, 1}}},
+		{in: "bytes=0-1, 20-30", want: []byteRange{{0, 2}}},
+		{in: "bytes=10-", wantErr: errNoOverlap},
+		{in: "bytes=-0", wantErr: errNoOverlap},
+		{in: "bytes=", wantErr: errInvalidRange},
+		{in: "items=0-1", wantErr: errInvalidRange},
+		{in: "bytes=4-2", wantErr: errInvalidRange},
+		{in: "bytes=a-2", wantErr: errInvalidRange},
+		{in: "bytes=0-1, 3", wantErr: errInvalidRange},
+	}
+	for _, tc := range tests {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := parseRange(tc.in, 10)
+
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf(`parseRange() error = %v, wanted %v`, err, tc.wantErr)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf(`parseRange() = %v, wanted %v`, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestServeContent(t *testing.T) {
+	const content = "0123456789"
+	serve := func(t *testing.T, m HttpMethod, validators []string, h ...string) (*TestResponse, error) {
+		t.Helper()
+		handler := MultiMethod(MultiMethodHandler{
+			Get: func(rw *ResponseWriter, req *Request) error {
+				for i := 0; i < len(validators); i += 2 {
+					rw.Headers().Set(validators[i], validators[i+1])
+				}
+				return rw.ServeContent(req, strings.NewReader(content), CTTextPlain)
+			},
+			Post: func(rw *ResponseWriter, req *Request) error {
+				return rw.ServeContent(req, strings.NewReader(content), CTTextPlain)
+			},
+		})
+		return TestHandler(handler, NewTestRequest(t, "/blob", m, TestRequestOptions{Headers: h}))
+	}
+
+	t.Run("whole content", func(t *testing.T) {
+		res, err := serve(t, GET, nil)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, content)
+		res.AssertHeaderMatchesString(t, "Accept-Ranges", "bytes")
+		res.AssertHeaderMatchesString(t, "Content-Length", "10")
+		res.RefuteHeaderPresent(t, "Content-Range")
+	})
+
+	t.Run("single range", func(t *testing.T) {
+		res, err := serve(t, GET, nil, "Range", "bytes=2-5")
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.AssertStatusCode(t, StatusPartialContent)
+		res.AssertBodyMatchesString(t, "2345")
+		res.AssertHeaderMatchesString(t, "Content-Range", "bytes 2-5/10")
+		res.AssertHeaderMatchesString(t, "Content-Length", "4")
+		res.AssertHeaderMatchesString(t, "Content-Type", "text/plain")
+	})
+
+	t.Run("multiple ranges", func(t *testing.T) {
+		res, err := serve(t, GET, nil, "Range", "bytes=0-1,-2")
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.AssertStatusCode(t, StatusPartialContent)
+		ct, _ := res.rw.headers.headers.All("Content-Type")
+		mediaType, params, err := mime.ParseMediaType(ct[0])
+		if err != nil || mediaType != "multipart/byteranges" {
+			t.Fatalf(`Content-Type = %q, wanted multipart/byteranges`, ct[0])
+		}
+		mr := multipart.NewReader(strings.NewReader(string(res.rw.bdy)), params["boundary"])
+		for _, want := range []struct{ body, contentRange string }{{"01", "bytes 0-1/10"}, {"89", "bytes 8-9/10"}} {
+			part, err := mr.NextPart()
+			if err != nil {
+				t.Fatalf("failed to read part: %v", err)
+			}
+			body, _ := io.ReadAll(part)
+			if string(body) != want.body {
+				t.Errorf(`part = %q, wanted %q`, body, want.body)
+			}
+			if got := part.Header.Get("Content-Range"); got != want.contentRange {
+				t.Errorf(`Content-Range = %q, wanted %q`, got, want.contentRange)
+			}
+			if got := part.Header.Get("Content-Type"); got != "text/plain" {
+				t.Errorf(`Content-Type = %q, wanted "text/plain"`, got)
+			}
+		}
+		if _, err := mr.NextPart(); err != io.EOF {
+			t.Errorf("expected no more parts, got %v", err)
+		}
+	})
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		_, err := serve(t, GET, nil, "Range", "bytes=20-")
+
+		var httpErr *HttpError
+		if !errors.As(err, &httpErr) || httpErr.Status() != StatusRangeNotSatisfiable {
+			t.Fatalf(`error = %v, wanted 416`, err)
+		}
+		if got, _ := httpErr.headers.All("Content-Range"); !slices.Equal(got, []string{"bytes */10"}) {
+			t.Errorf(`Content-Range = %v, wanted "bytes */10"`, got)
+		}
+	})
+
+	t.Run("preconditions before ranges", func(t *testing.T) {
+		res, err := serve(t, GET, []string{"ETag", `"v1"`}, "Range", "bytes=20-", "If-None-Match", `"v1"`)
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		res.AssertStatusCode(t, StatusNotModified)
+		res.AssertBodyEmpty(t)
+	})
+
+	t.Run("ignored ranges", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			method     HttpMethod
+			validators []string
+			headers    []string
+		}{
+			{name: "invalid range", headers: []string{"Range", "bytes=5-2"}},
+			{name: "unknown unit", headers: []string{"Range", "items=0-1"}},
+			{name: "more than the content", headers: []string{"Range", "bytes=0-8,1-9"}},
+			{name: "not a GET", method: POST, headers: []string{"Range", "bytes=0-1"}},
+			{
+				name:       "If-Range etag mismatch",
+				validators: []string{"ETag", `"v2"`},
+				headers:    []string{"Range", "bytes=0-1", "If-Range", `"v1"`},
+			},
+			{
+				name:       "If-Range weak etag",
+				validators: []string{"ETag", `W/"v1"`},
+				headers:    []string{"Range", "bytes=0-1", "If-Range", `W/"v1"`},
+			},
+			{
+				name:       "If-Range date mismatch",
+				validators: []string{"Last-Modified", "Tue, 01 Jul 2025 10:00:00 GMT"},
+				headers:    []string{"Range", "bytes=0-1", "If-Range", "Mon, 30 Jun 2025 10:00:00 GMT"},
+			},
+			{
+				name:    "If-Range without validator",
+				headers: []string{"Range", "bytes=0-1", "If-Range", `"v1"`},
+			},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				method := tc.method
+				if method == (HttpMethod{}) {
+					method = GET
+				}
+
+				res, err := serve(t, method, tc.validators, tc.headers...)
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				res.AssertBodyMatchesString(t, content)
+				res.RefuteHeaderPresent(t, "Content-Range")
+			})
+		}
+	})
+
+	t.Run("If-Range matches", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			validators []string
+			ifRange    string
+		}{
+			{"etag", []string{"ETag", `"v1"`}, `"v1"`},
+			{"date", []string{"Last-Modified", "Tue, 01 Jul 2025 10:00:00 GMT"}, "Tue, 01 Jul 2025 10:00:00 GMT"},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res, err := serve(t, GET, tc.validators, "Range", "bytes=0-1", "If-Range", tc.ifRange)
+
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				res.AssertStatusCode(t, StatusPartialContent)
+				res.AssertBodyMatchesString(t, "01")
+			})
+		}
+	})
+	t.Run("large content", func(t *testing.T) {
+		large := strings.Repeat("0123456789", 10_000)
+		var largestRead int
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{
+			"/large": Get(func(rw *ResponseWriter, req *Request) error {
+				rw.ETag("v1")
+				return rw.ServeContent(req, &trackingReader{ReadSeeker: strings.NewReader(large), largest: &largestRead}, CTTextPlain)
+			}),
+		})
+		client := NewTestClient(srv)
+
+		tests := []struct {
+			name       string
+			method     HttpMethod
+			headers    []string
+			want       HttpStatus
+			wantBody   string
+			wantLength string
+		}{
+			{name: "whole content", method: GET, want: StatusOK, wantBody: large, wantLength: "100000"},
+			{
+				name:       "single range",
+				method:     GET,
+				headers:    []string{"Range", "bytes=10-49999"},
+				want:       StatusPartialContent,
+				wantBody:   large[10:50_000],
+				wantLength: "49990",
+			},
+			{name: "head", method: HEAD, want: StatusOK, wantLength: "100000"},
+			{name: "not modified", method: GET, headers: []string{"If-None-Match", `"v1"`}, want: StatusNotModified},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				largestRead = 0
+
+				var res *TestResponse
+				if tc.method == HEAD {
+					res = client.Head("/large", tc.headers...)
+				} else {
+					res = client.Get("/large", tc.headers...)
+				}
+
+				res.AssertStatusCode(t, tc.want)
+				if tc.wantBody == "" {
+					res.AssertBodyEmpty(t)
+				} else {
+					res.AssertBodyMatchesString(t, tc.wantBody)
+				}
+				if tc.wantLength != "" {
+					res.AssertHeaderMatchesString(t, "Content-Length", tc.wantLength)
+					res.AssertHeaderMatchesString(t, "Content-Type", "text/plain")
+				} else {
+					res.RefuteHeaderPresent(t, "Content-Length")
+				}
+				res.RefuteHeaderPresent(t, "Transfer-Encoding")
+				if largestRead > int(maxBufferedContent) {
+					t.Errorf(`largest read = %d bytes, wanted at most %d`, largestRead, maxBufferedContent)
+				}
+			})
+		}
+
+		t.Run("multiple ranges", func(t *testing.T) {
+			res := client.Get("/large", "Range", "bytes=0-39999,-40000")
+
+			res.AssertStatusCode(t, StatusPartialContent)
+			res.AssertHeaderMatchesString(t, "Transfer-Encoding", "chunked")
+			ct, _ := res.rw.headers.headers.All("Content-Type")
+			_, params, _ := mime.ParseMediaType(ct[0])
+			mr := multipart.NewReader(strings.NewReader(string(res.rw.bdy)), params["boundary"])
+			for _, want := range []string{large[:40_000], large[60_000:]} {
+				part, err := mr.NextPart()
+				if err != nil {
+					t.Fatalf("failed to read part: %v", err)
+				}
+				if body, _ := io.ReadAll(part); string(body) != want {
+					t.Errorf(`part length = %d, wanted %d`, len(body), len(want))
+				}
+			}
+			if _, err := mr.NextPart(); err != io.EOF {
+				t.Errorf("expected no more parts, got %v", err)
+			}
+		})
+	})
+}
+
+// A trackingReader records the largest read made from it, so tests can tell
+// whether content was read in full or a piece at a time.
+type trackingReader struct {
+	io.ReadSeeker
+	largest *int
+}
+
+func (tr *trackingReader) Read(p []byte) (int, error) {
+	n, err := tr.ReadSeeker.Read(p)
+	*tr.largest = max(*tr.largest, n)
+	return n, err
+}
@@ -0,0 +1,75 @@
+package routeit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sktylr/routeit/internal/headers"
+	"github.com/sktylr/routeit/internal/http2"
+)
+
+// A rawRequest rewrites a request that did not arrive as a HTTP/1.1 message,
+// such as one made over HTTP/2 or one handed to us by net/http, as one.
+// Parsing the rewritten request subjects it to exactly the same validation and
+// limits as requests that arrive over HTTP/1.1.
+type rawRequest struct {
+	buf     bytes.Buffer
+	cookies []string
+}
+
+func newRawRequest(method, target, host string) *rawRequest {
+	r := &rawRequest{}
+	fmt.Fprintf(&r.buf, "%s %s HTTP/1.1\r\n", method, target)
+	if host != "" {
+		fmt.Fprintf(&r.buf, "Host: %s\r\n", host)
+	}
+	return r
+}
+
+// Adds a header to the request. The framing of the body is decided once the
+// body is known, so any headers describing it are dropped along with those
+// that only apply to the connection the request arrived on. The client has
+// already sent the body, so there is no need to honour Expect either.
+func (r *rawRequest) header(name, value string) {
+	switch strings.ToLower(name) {
+	case "host", "content-length", "expect":
+		return
+	case "cookie":
+		// Cookies may be split over several headers to compress them better,
+		// and must be joined back together (RFC-9113 Sec 8.2.3).
+		r.cookies = append(r.cookies, value)
+		return
+	}
+	if http2.IsConnectionSpecific(name) {
+		return
+	}
+	fmt.Fprintf(&r.buf, "%s: %s\r\n", name, value)
+}
+
+// Adds the body and trailers, then parses the request. Requests with trailers
+// use the chunked transfer coding, which is the only way for trailers to
+// follow the body.
+func (r *rawRequest) parse(body []byte, trailers headers.Headers, limits requestLimits, ctx context.Context) (*Request, *HttpError) {
+	if len(r.cookies) != 0 {
+		fmt.Fprintf(&r.buf, "Cookie: %s\r\n", strings.Join(r.cookies, "; "))
+	}
+	if len(trailers) == 0 {
+		if len(body) != 0 {
+			fmt.Fprintf(&r.buf, "Content-Length: %d\r\n", len(body))
+		}
+		r.buf.WriteString("\r\n")
+		r.buf.Write(body)
+	} else {
+		r.buf.WriteString("Transfer-Encoding: chunked\r\n\r\n")
+		if len(body) != 0 {
+			fmt.Fprintf(&r.buf, "%x\r\n%s\r\n", len(body), body)
+		}
+		r.buf.WriteString("0\r\n")
+		trailers.WriteTo(&r.buf)
+		r.buf.WriteString("\r\n")
+	}
+	return readRequest(bufio.NewReader(&r.buf), limits, ctx)
+}
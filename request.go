@@ -1,6 +1,7 @@
 package routeit
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
@@ -8,8 +9,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"strconv"
 	"strings"
+
+	"github.com/sktylr/routeit/internal/chunked"
+	"github.com/sktylr/routeit/internal/headers"
 )
 
 var (
@@ -29,6 +35,7 @@ type Request struct {
 	uri       uri
 	headers   *RequestHeaders
 	body      []byte
+	trailers  *RequestHeaders
 	ct        ContentType
 	host      string
 	userAgent string
@@ -36,6 +43,18 @@ type Request struct {
 	accept    []ContentType
 	id        string
 	tlsState  *tls.ConnectionState
+	scheme    string
+	// Set when the request was received from a trusted proxy that described
+	// the request it received, along with the host given by the proxy, if
+	// any.
+	forwarded     bool
+	forwardedHost string
+	// The address of whoever connected to the server, which is the proxy
+	// rather than the client for forwarded requests.
+	peer string
+	// Set for requests whose body is only read once the client has been told
+	// to send it.
+	pending *pendingBody
 }
 
 type HttpMethod struct {
@@ -48,39 +67,69 @@ type requestLine struct {
 	uri   uri
 }
 
-// Parses the raw byte slice of the request into a more usable request structure
+// The [requestLimits] bound how much of the request the server is willing to
+// read from the client. The header limit covers the request line and all
+// headers, while the body limit only covers the request body.
+type requestLimits struct {
+	maxHeaderSize RequestSize
+	maxBodySize   RequestSize
+}
+
+var errHeaderTooLarge = errors.New("request line and headers exceed the maximum header size")
+
+// Reads and parses a single request from the reader into a more usable request
+// structure. The reader is only advanced as far as the end of the request, so
+// any subsequent (e.g. pipelined) requests can be read from the same reader.
 //
 // The request is made up of three components: the request line, headers and the
 // body. For HTTP/1.1, at a bare minimum the Host header must be included, though
 // the body is optional (and ignored for certain request methods such as GET).
 //
-// Each section is split using carriage returns (CRLF or \r\n). After the request
-// line, and each header line is also split using a carriage return. The request
-// line is always only a single line made up of three components - the request
-// method, the path (or URI) and the HTTP protocol, and a blank line (using a
-// carriage return) also follows the headers before the optional body.
+// The request line and each header line are terminated by a carriage return
+// (CRLF or \r\n). The request line is always only a single line made up of
+// three components - the request method, the path (or URI) and the HTTP
+// protocol, and a blank line (using a carriage return) also follows the
+// headers before the optional body. The request line and headers are read
+// line by line until the blank line is reached, after which exactly
+// Content-Length bytes of the body are read. Clients that expect 100-continue
+// have not sent the body yet, so it is left to be read once it is needed.
 //
 // https://developer.mozilla.org/en-US/docs/Web/HTTP/Guides/Messages
-func requestFromRaw(raw []byte, maxSize RequestSize, ctx context.Context) (*Request, *HttpError) {
-	sections := bytes.Split(raw, []byte("\r\n"))
-
-	// We are expecting 1 carriage return after the request line and 1
-	// carriage return after all the headers. This means there will be at least
-	// 3 sections.
-	if len(sections) < 3 {
-		return nil, ErrBadRequest()
-	}
+func readRequest(br *bufio.Reader, limits requestLimits, ctx context.Context) (*Request, *HttpError) {
+	// We keep hold of the raw bytes of the request line and headers since
+	// TRACE requests need to reflect the received request back to the client.
+	var raw bytes.Buffer
+	remaining := int(limits.maxHeaderSize)
 
-	prtclRaw := sections[0]
-	reqLine, err := parseRequestLine(prtclRaw)
+	prtclRaw, err := readLine(br, &remaining, &raw)
 	if err != nil {
-		return nil, err
+		if errors.Is(err, errHeaderTooLarge) {
+			return nil, ErrURITooLong()
+		}
+		return nil, httpErrorForRead(err)
+	}
+	reqLine, httpErr := parseRequestLine(prtclRaw)
+	if httpErr != nil {
+		return nil, httpErr
 	}
 
-	hdrsRaw := sections[1:]
-	reqHdrs, lastHeader, err := headersFromRaw(hdrsRaw)
-	if err != nil {
-		return nil, err
+	var hdrsRaw [][]byte
+	for {
+		line, err := readLine(br, &remaining, &raw)
+		if err != nil {
+			if errors.Is(err, errHeaderTooLarge) {
+				return nil, ErrRequestHeaderFieldsTooLarge()
+			}
+			return nil, httpErrorForRead(err)
+		}
+		hdrsRaw = append(hdrsRaw, line)
+		if len(line) == 0 {
+			break
+		}
+	}
+	reqHdrs, _, httpErr := headersFromRaw(hdrsRaw)
+	if httpErr != nil {
+		return nil, httpErr
 	}
 
 	ct := ContentType{}
@@ -88,62 +137,261 @@ func requestFromRaw(raw []byte, maxSize RequestSize, ctx context.Context) (*Requ
 	if hasCType && reqLine.mthd.canHaveBody() {
 		ct = parseContentType(ctRaw)
 	}
-	cLen := reqHdrs.headers.ContentLength()
 
-	if cLen > uint(maxSize) {
-		return nil, ErrContentTooLarge()
+	accept := parseAcceptHeader(reqHdrs)
+	userAgent, _ := reqHdrs.First("User-Agent")
+	req := &Request{
+		mthd:      reqLine.mthd,
+		uri:       reqLine.uri,
+		headers:   reqHdrs,
+		trailers:  &RequestHeaders{headers: headers.NewHeaders()},
+		ct:        ct,
+		userAgent: userAgent,
+		accept:    accept,
+		ctx:       ctx,
+	}
+
+	framing, httpErr := parseBodyFraming(reqHdrs, limits.maxBodySize)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	expectsContinue, httpErr := parseExpect(reqHdrs)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if expectsContinue && framing.hasBody() {
+		// The client will not send the body until we tell it to, which we
+		// only do once the request has passed through middleware to a
+		// handler. Everything we can check about the body without reading it
+		// is checked up front, so the client is not asked to send a body we
+		// would reject anyway.
+		if !ct.isValid() && reqLine.mthd.canHaveBody() {
+			return nil, ErrBadRequest().WithMessage("Cannot specify a request body without Content-Type")
+		}
+		req.pending = &pendingBody{br: br, framing: framing, maxTrailerSize: remaining, raw: raw.Bytes()}
+		return req, nil
+	}
+
+	bdyRaw, trailers, httpErr := readBody(br, framing, remaining)
+	if httpErr != nil {
+		return nil, httpErr
+	}
+	if httpErr := req.setBody(bdyRaw, trailers, raw.Bytes()); httpErr != nil {
+		return nil, httpErr
 	}
+	return req, nil
+}
 
-	if !ct.isValid() && cLen != 0 && reqLine.mthd.canHaveBody() {
-		return nil, ErrBadRequest().WithMessage("Cannot specify a Content-Length without Content-Type")
+// Sets the body and trailers of the request once they have been read from the
+// connection. The raw request line and headers are needed for TRACE requests,
+// which reflect the received request back to the client.
+func (req *Request) setBody(bdyRaw []byte, trailers *RequestHeaders, raw []byte) *HttpError {
+	if !req.ct.isValid() && len(bdyRaw) != 0 && req.mthd.canHaveBody() {
+		return ErrBadRequest().WithMessage("Cannot specify a request body without Content-Type")
 	}
 
-	bdyRaw := bytes.Join(hdrsRaw[lastHeader+1:], []byte("\r\n"))
-	var body []byte
-	if cLen == 0 || !reqLine.mthd.canHaveBody() {
+	if len(bdyRaw) == 0 || !req.mthd.canHaveBody() {
 		// For GET, HEAD or OPTIONS requests, the request body should be
 		// ignored even if provided. Servers can technically accept request
 		// bodies for OPTIONS requests, however it is up to the server
-		// implementation, and routeit chooses not to. Where we are consuming
-		// the body, we should only look for Content-Length bytes and no more.
-		body = []byte{}
-		if reqLine.mthd == TRACE {
+		// implementation, and routeit chooses not to.
+		req.body = []byte{}
+		if req.mthd == TRACE {
 			// TRACE requests should not have a body. However, they should
 			// return the entire received request in their own response body.
 			// To simplify data storage, we will store the raw request on the
 			// body property. In reality, the integrator cannot design their
 			// own custom handler for TRACE requests, so this difference is not
 			// noticeable and easily managed within the framework.
-			body = raw
+			req.body = append(raw, bdyRaw...)
 		}
 	} else {
-		reader := bytes.NewReader(bdyRaw)
-		body = make([]byte, cLen)
-		_, err := io.ReadFull(reader, body)
+		req.body = bdyRaw
+	}
+	req.trailers = trailers
+	return nil
+}
+
+// Reads a single CRLF terminated line from the reader, returning the line
+// without the trailing CRLF. The raw line (including the CRLF) is written to
+// raw. An error is returned if the line would take the total number of bytes
+// read beyond the remaining allowance, or if the line is not terminated by
+// CRLF, since bare line feeds are not accepted as line terminators.
+func readLine(br *bufio.Reader, remaining *int, raw *bytes.Buffer) ([]byte, error) {
+	var line []byte
+	for {
+		frag, err := br.ReadSlice('\n')
+		if len(line)+len(frag) > *remaining {
+			return nil, errHeaderTooLarge
+		}
+		line = append(line, frag...)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+	}
+	*remaining -= len(line)
+	raw.Write(line)
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrBadRequest()
+	}
+	return line[:len(line)-2], nil
+}
+
+// The bodyFraming describes how the end of the request body is found.
+type bodyFraming struct {
+	chunked bool
+	length  uint64
+	maxSize RequestSize
+}
+
+func (f bodyFraming) hasBody() bool {
+	return f.chunked || f.length != 0
+}
+
+// Determines how the request body is framed from the request's headers. The
+// body is framed either by the Content-Length header, or using the chunked
+// transfer coding, in which case there may also be a trailer section
+// following the body. Where neither is present, the request has no body
+// (RFC-9112 Sec 6.3). Requests whose Content-Length exceeds the maximum body
+// size are rejected before any of the body is read.
+func parseBodyFraming(h *RequestHeaders, maxSize RequestSize) (bodyFraming, *HttpError) {
+	tes, hasTE := h.All("Transfer-Encoding")
+	if hasTE {
+		// A request containing both headers is a common request smuggling
+		// vector, as intermediaries may disagree on which header frames the
+		// body. RFC-9112 Sec 6.1 allows the server to reject such requests,
+		// which we always do.
+		if _, hasCLen := h.All("Content-Length"); hasCLen {
+			return bodyFraming{}, ErrBadRequest().WithMessage("Cannot specify both Content-Length and Transfer-Encoding")
+		}
+
+		var codings []string
+		for _, te := range tes {
+			for coding := range strings.SplitSeq(te, ",") {
+				codings = append(codings, strings.ToLower(strings.TrimSpace(coding)))
+			}
+		}
+		if codings[len(codings)-1] != "chunked" {
+			// Per RFC-9112 Sec 6.3, if chunked is not the final coding we
+			// cannot determine the length of the body, so must reject it.
+			return bodyFraming{}, ErrBadRequest().WithMessage("Final Transfer-Encoding must be chunked")
+		}
+		if len(codings) != 1 {
+			// We don't support any transfer codings other than chunked, such
+			// as gzip or deflate.
+			return bodyFraming{}, ErrNotImplemented().WithMessagef("Unsupported Transfer-Encoding: %s", strings.Join(codings, ", "))
+		}
+		return bodyFraming{chunked: true, maxSize: maxSize}, nil
+	}
+
+	cLen, httpErr := parseContentLength(h)
+	if httpErr != nil {
+		return bodyFraming{}, httpErr
+	}
+	if cLen > uint64(maxSize) {
+		return bodyFraming{}, ErrContentTooLarge()
+	}
+	return bodyFraming{length: cLen, maxSize: maxSize}, nil
+}
+
+// Reads the request body from the reader, using the given framing. Since both
+// the body and trailers must be read even when the body is later ignored,
+// otherwise the unread bytes would be interpreted as the start of the next
+// request on the connection, this is done regardless of the request method.
+func readBody(br *bufio.Reader, f bodyFraming, maxTrailerSize int) ([]byte, *RequestHeaders, *HttpError) {
+	trailers := &RequestHeaders{headers: headers.NewHeaders()}
+	if f.chunked {
+		body, trailerHdrs, err := chunked.Decode(br, int(f.maxSize), maxTrailerSize)
 		if err != nil {
-			// Http servers are expected to read **exactly** Content-Length bytes
-			// from the request body. This error is returned if the reader contains
-			// **less** than the requested number of bytes, so we cannot read it
-			// all. Either the client has not sent it all (e.g. due to a slow
-			// connection), or the request is malformed. Return 400 Bad Request
-			// since the failure is with the client.
-			return nil, ErrBadRequest()
+			switch {
+			case errors.Is(err, chunked.ErrTooLarge):
+				return nil, nil, ErrContentTooLarge().WithCause(err)
+			case errors.Is(err, chunked.ErrMalformed):
+				return nil, nil, ErrBadRequest().WithCause(err).WithMessage("Malformed chunked request body")
+			default:
+				return nil, nil, httpErrorForRead(err)
+			}
 		}
+		trailers.headers = trailerHdrs
+		return body, trailers, nil
 	}
 
-	accept := parseAcceptHeader(reqHdrs)
-	userAgent, _ := reqHdrs.First("User-Agent")
-	req := Request{
-		mthd:      reqLine.mthd,
-		uri:       reqLine.uri,
-		headers:   reqHdrs,
-		body:      body,
-		ct:        ct,
-		userAgent: userAgent,
-		accept:    accept,
-		ctx:       ctx,
+	// Http servers are expected to read **exactly** Content-Length bytes from
+	// the request body.
+	body := make([]byte, f.length)
+	if _, err := io.ReadFull(br, body); err != nil {
+		// The reader contains **less** than the requested number of bytes, so
+		// we cannot read it all. Either the client has not sent it all (e.g.
+		// due to a slow connection), or the request is malformed.
+		return nil, nil, httpErrorForRead(err)
 	}
-	return &req, nil
+	return body, trailers, nil
+}
+
+// Reports whether the client is waiting for a 100: Continue response before
+// sending the body of the request (RFC-9110 Sec 10.1.1). This is the only
+// expectation defined, so any other expectation is answered with 417:
+// Expectation Failed.
+func parseExpect(h *RequestHeaders) (bool, *HttpError) {
+	vals, found := h.All("Expect")
+	if !found {
+		return false, nil
+	}
+	for _, v := range vals {
+		if !strings.EqualFold(strings.TrimSpace(v), "100-continue") {
+			return false, ErrExpectationFailed().WithMessagef("Unsupported expectation %#q", v)
+		}
+	}
+	return true, nil
+}
+
+// Parses the Content-Length header, which defaults to 0 if not present. The
+// header must be a valid, non-negative integer. Since the Content-Length
+// determines where the request ends on a persistent connection, we are
+// strict with its validation. Repeated headers are allowed so long as every
+// value is identical (RFC-9110 Sec 8.6).
+func parseContentLength(h *RequestHeaders) (uint64, *HttpError) {
+	vals, found := h.All("Content-Length")
+	if !found {
+		return 0, nil
+	}
+
+	var cLen uint64
+	seen := false
+	for _, raw := range vals {
+		for v := range strings.SplitSeq(raw, ",") {
+			parsed, err := strconv.ParseUint(strings.TrimSpace(v), 10, 63)
+			if err != nil {
+				return 0, ErrBadRequest().WithMessage("Invalid Content-Length").WithCause(err)
+			}
+			if seen && parsed != cLen {
+				return 0, ErrBadRequest().WithMessage("Conflicting Content-Length values")
+			}
+			cLen = parsed
+			seen = true
+		}
+	}
+	return cLen, nil
+}
+
+// Maps an error encountered while reading the request from the connection to
+// the appropriate [HttpError]. If the client took too long to send the
+// request, we return 408: Request Timeout, otherwise the request is
+// incomplete, so we return 400: Bad Request.
+func httpErrorForRead(err error) *HttpError {
+	var httpErr *HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrRequestTimeout().WithCause(err)
+	}
+	return ErrBadRequest().WithCause(err)
 }
 
 // Access the request's HTTP method
@@ -187,7 +435,19 @@ func (req *Request) Headers() *RequestHeaders {
 	return req.headers
 }
 
-// The Host header of the request. This will always be present and non-empty
+// Access the trailer fields of the request. Trailers can only be sent by the
+// client after a chunked request body, so this will be empty for all other
+// requests. Trailers are kept separate from [Request.Headers] since they are
+// received after the rest of the request, so should not be trusted to the
+// same extent as regular headers.
+func (req *Request) Trailers() *RequestHeaders {
+	return req.trailers
+}
+
+// The Host header of the request, without the port. This will always be
+// present and non-empty. When the request was received from a trusted proxy
+// (see [ServerConfig.TrustedProxies]), this is the host the client requested
+// from the proxy.
 func (req *Request) Host() string {
 	return req.host
 }
@@ -198,7 +458,10 @@ func (req *Request) UserAgent() string {
 	return req.userAgent
 }
 
-// The client's IP address that established connection with the server
+// The client's IP address that established connection with the server. When
+// the request was received from a trusted proxy (see
+// [ServerConfig.TrustedProxies]), this is the address of the client that
+// connected to the proxy.
 func (req *Request) ClientIP() string {
 	return req.ip
 }
@@ -217,6 +480,15 @@ func (req *Request) Tls() *tls.ConnectionState {
 	return req.tlsState
 }
 
+// The scheme the client made the request with, either "http" or "https". This
+// normally depends on whether the request was received over TLS, though when
+// the request was received from a trusted proxy (see
+// [ServerConfig.TrustedProxies]), this is the scheme the client used to
+// connect to the proxy.
+func (req *Request) Scheme() string {
+	return req.scheme
+}
+
 // Access the query parameters of the request URI. This will always return a
 // non-nil pointer, even if the URI contains no query parameters. See the
 // [QueryParams] type for access methods to retrieve individual keys.
@@ -250,6 +522,9 @@ func (req *Request) UnsafeBodyFromJson(to any) error {
 		panic(fmt.Sprintf("BodyFromJson requires a non-nil pointer destination, got %T", to))
 	}
 	req.mustAllowBodyReading()
+	if err := req.readPendingBody(); err != nil {
+		return err
+	}
 	err := json.Unmarshal([]byte(req.body), to)
 	if err == nil {
 		return nil
@@ -271,13 +546,20 @@ func (req *Request) BodyFromText() (string, error) {
 	if !req.ContentType().Matches(CTTextPlain) {
 		return "", ErrUnsupportedMediaType(CTTextPlain)
 	}
+	if err := req.readPendingBody(); err != nil {
+		return "", err
+	}
 	return string(req.body), nil
 }
 
 // Returns the raw body content as a string. Will panic if this is called on a
-// method that cannot support a request body, such as GET, HEAD or OPTIONS.
+// method that cannot support a request body, such as GET, HEAD or OPTIONS, or
+// if the body cannot be read from the connection.
 func (req *Request) UnsafeBodyFromText() string {
 	req.mustAllowBodyReading()
+	if err := req.readPendingBody(); err != nil {
+		panic(err)
+	}
 	return string(req.body)
 }
 
@@ -290,15 +572,21 @@ func (req *Request) BodyFromRaw(ct ContentType) ([]byte, error) {
 	if !req.ContentType().Matches(ct) {
 		return nil, ErrUnsupportedMediaType(ct)
 	}
+	if err := req.readPendingBody(); err != nil {
+		return nil, err
+	}
 	return req.body, nil
 }
 
 // Returns the raw body content. Will panic if this is called on a method that
 // cannot support a request body, such as GET, HEAD or OPTIONS. This does not
 // assert that the body is present nor contains a corresponding Content-Type
-// header
+// header. Will also panic if the body cannot be read from the connection.
 func (req *Request) UnsafeBodyFromRaw() []byte {
 	req.mustAllowBodyReading()
+	if err := req.readPendingBody(); err != nil {
+		panic(err)
+	}
 	return req.body
 }
 
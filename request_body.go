@@ -0,0 +1,88 @@
+package routeit
+
+import (
+	"bufio"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	bodyUnread int32 = iota
+	bodyReading
+	bodyRead
+	// The body failed to be read, or the server finished with the request
+	// before the body was needed. Either way, the connection cannot be reused
+	// since we do not know where the request ends.
+	bodyAbandoned
+)
+
+// A pendingBody is the body of a request whose client expects 100-continue,
+// which is not read until something needs it (RFC-9110 Sec 10.1.1). This gives
+// middleware, such as authentication, the chance to reject the request before
+// the client sends what may be a very large body.
+type pendingBody struct {
+	br             *bufio.Reader
+	framing        bodyFraming
+	maxTrailerSize int
+	// The raw request line and headers, which TRACE requests reflect back to
+	// the client.
+	raw []byte
+	// Called before the body is read, to tell the client it can start sending
+	// the body. This is nil when the request is not attached to a connection,
+	// in which case the body is already available.
+	beforeRead func() error
+
+	once  sync.Once
+	state atomic.Int32
+	err   *HttpError
+}
+
+// Reads the body of the request from the connection, if it has not been read
+// yet. This is safe to call multiple times, and only ever reads the body
+// once.
+func (req *Request) readPendingBody() *HttpError {
+	p := req.pending
+	if p == nil {
+		return nil
+	}
+	p.once.Do(func() {
+		// The server may have finished with the request already, such as
+		// when the handler has run past the write deadline, in which case the
+		// connection no longer belongs to the request.
+		if !p.state.CompareAndSwap(bodyUnread, bodyReading) {
+			p.err = ErrServiceUnavailable().WithMessage("Request body is no longer available")
+			return
+		}
+		if p.beforeRead != nil {
+			if err := p.beforeRead(); err != nil {
+				p.state.Store(bodyAbandoned)
+				p.err = httpErrorForRead(err)
+				return
+			}
+		}
+		bdyRaw, trailers, httpErr := readBody(p.br, p.framing, p.maxTrailerSize)
+		if httpErr == nil {
+			httpErr = req.setBody(bdyRaw, trailers, p.raw)
+		}
+		if httpErr != nil {
+			p.state.Store(bodyAbandoned)
+			p.err = httpErr
+			return
+		}
+		p.state.Store(bodyRead)
+	})
+	return p.err
+}
+
+// Reports whether the connection the request was received on can be used for
+// further requests, once the server has finished with the request. This is
+// only the case once the body has been read in full, so a body that has not
+// been read by now never will be.
+func (req *Request) bodyConsumed() bool {
+	p := req.pending
+	if p == nil {
+		return true
+	}
+	p.state.CompareAndSwap(bodyUnread, bodyAbandoned)
+	return p.state.Load() == bodyRead
+}
@@ -1,14 +1,27 @@
 package routeit
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"reflect"
 	"slices"
+	"strings"
 	"testing"
+	"testing/iotest"
 )
 
 var defaultRequestSize = 10 * KiB
 
+// Reads a single request from the raw bytes using a generous header limit, so
+// tests can focus on the body limit where needed.
+func requestFromRaw(raw []byte, maxSize RequestSize, ctx context.Context) (*Request, *HttpError) {
+	limits := requestLimits{maxHeaderSize: 32 * KiB, maxBodySize: maxSize}
+	return readRequest(bufio.NewReader(bytes.NewReader(raw)), limits, ctx)
+}
+
 func TestRequestFromRaw(t *testing.T) {
 	expectBody := func(t *testing.T, got []byte, want string) {
 		t.Helper()
@@ -127,6 +140,72 @@ func TestRequestFromRaw(t *testing.T) {
 				input:      "POST / HTTP/1.1\r\nContent-Length: 6\r\nContent-Type: text/plain\r\nHello!",
 				wantStatus: StatusBadRequest,
 			},
+			{
+				name:       "body shorter than content-length",
+				input:      "POST / HTTP/1.1\r\nContent-Length: 10\r\nContent-Type: text/plain\r\n\r\nHello!",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "unparsable content-length",
+				input:      "POST / HTTP/1.1\r\nContent-Length: abc\r\nContent-Type: text/plain\r\n\r\nHello!",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "negative content-length",
+				input:      "POST / HTTP/1.1\r\nContent-Length: -6\r\nContent-Type: text/plain\r\n\r\nHello!",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "conflicting content-length",
+				input:      "POST / HTTP/1.1\r\nContent-Length: 6\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nHello!",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "bare line feed in headers",
+				input:      "GET / HTTP/1.1\r\nHost: localhost\n\r\n",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "content-length and transfer-encoding",
+				input:      "POST / HTTP/1.1\r\nContent-Length: 5\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "chunked is not the final transfer-encoding",
+				input:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked, gzip\r\nContent-Type: text/plain\r\n\r\n",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "unsupported transfer-encoding",
+				input:      "POST / HTTP/1.1\r\nTransfer-Encoding: gzip, chunked\r\nContent-Type: text/plain\r\n\r\n",
+				wantStatus: StatusNotImplemented,
+			},
+			{
+				name:       "malformed chunked body",
+				input:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\nzz\r\nhello\r\n0\r\n\r\n",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "incomplete chunked body",
+				input:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\n5\r\nhello\r\n",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "max size exceeded by chunked body",
+				input:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\n5\r\nhello\r\n5\r\nworld\r\n0\r\n\r\n",
+				maxSize:    8 * Byte,
+				wantStatus: StatusContentTooLarge,
+			},
+			{
+				name:       "chunked body but no content type",
+				input:      "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+				wantStatus: StatusBadRequest,
+			},
+			{
+				name:       "connection closed before headers end",
+				input:      "GET / HTTP/1.1\r\nHost: localhost\r\n",
+				wantStatus: StatusBadRequest,
+			},
 		}
 
 		for _, tc := range tests {
@@ -413,6 +492,267 @@ func TestRequestFromRaw(t *testing.T) {
 	})
 }
 
+func TestReadRequest(t *testing.T) {
+	t.Run("header limits", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			input      string
+			maxHeader  RequestSize
+			wantStatus HttpStatus
+		}{
+			{
+				name:       "request line exceeds limit",
+				input:      "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\nHost: localhost\r\n\r\n",
+				maxHeader:  64 * Byte,
+				wantStatus: StatusURITooLong,
+			},
+			{
+				name:       "headers exceed limit",
+				input:      "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 100) + "\r\n\r\n",
+				maxHeader:  64 * Byte,
+				wantStatus: StatusRequestHeaderFieldsTooLarge,
+			},
+			{
+				name:       "header line longer than the read buffer",
+				input:      "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("a", 8*1024) + "\r\n\r\n",
+				maxHeader:  4 * KiB,
+				wantStatus: StatusRequestHeaderFieldsTooLarge,
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				br := bufio.NewReader(strings.NewReader(tc.input))
+				limits := requestLimits{maxHeaderSize: tc.maxHeader, maxBodySize: KiB}
+
+				_, err := readRequest(br, limits, t.Context())
+
+				if err == nil {
+					t.Fatal("expected error to be present")
+				}
+				if err.status != tc.wantStatus {
+					t.Errorf(`status = %d, wanted %d`, err.status.code, tc.wantStatus.code)
+				}
+			})
+		}
+	})
+
+	t.Run("reads requests delivered one byte at a time", func(t *testing.T) {
+		in := "POST /hello HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\nContent-Type: text/plain\r\n\r\nhello world"
+		br := bufio.NewReader(iotest.OneByteReader(strings.NewReader(in)))
+		limits := requestLimits{maxHeaderSize: KiB, maxBodySize: KiB}
+
+		req, err := readRequest(br, limits, t.Context())
+
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if string(req.body) != "hello world" {
+			t.Errorf(`body = %#q, wanted "hello world"`, req.body)
+		}
+	})
+
+	t.Run("reads body larger than the read buffer", func(t *testing.T) {
+		body := strings.Repeat("a", 64*1024)
+		in := fmt.Sprintf("POST /hello HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\nContent-Type: text/plain\r\n\r\n%s", len(body), body)
+		br := bufio.NewReader(strings.NewReader(in))
+		limits := requestLimits{maxHeaderSize: KiB, maxBodySize: MiB}
+
+		req, err := readRequest(br, limits, t.Context())
+
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if string(req.body) != body {
+			t.Errorf(`len(body) = %d, wanted %d`, len(req.body), len(body))
+		}
+	})
+
+	t.Run("chunked body", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			input        string
+			wantBody     string
+			wantTrailers map[string]string
+		}{
+			{
+				name:     "single chunk",
+				input:    "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+				wantBody: "hello",
+			},
+			{
+				name:     "multiple chunks with extensions",
+				input:    "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\n5;foo=bar\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+				wantBody: "hello world",
+			},
+			{
+				name:     "case insensitive coding",
+				input:    "POST / HTTP/1.1\r\nTransfer-Encoding: Chunked\r\nContent-Type: text/plain\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+				wantBody: "hello",
+			},
+			{
+				name:         "with trailers",
+				input:        "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: text/plain\r\n\r\n5\r\nhello\r\n0\r\nX-Checksum: abc\r\n\r\n",
+				wantBody:     "hello",
+				wantTrailers: map[string]string{"X-Checksum": "abc"},
+			},
+			{
+				name:     "empty body without content type",
+				input:    "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n",
+				wantBody: "",
+			},
+			{
+				name:     "ignored for GET requests",
+				input:    "GET / HTTP/1.1\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+				wantBody: "",
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				br := bufio.NewReader(strings.NewReader(tc.input + "GET /next HTTP/1.1\r\n\r\n"))
+				limits := requestLimits{maxHeaderSize: KiB, maxBodySize: KiB}
+
+				req, err := readRequest(br, limits, t.Context())
+
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				if string(req.body) != tc.wantBody {
+					t.Errorf(`body = %#q, wanted %#q`, req.body, tc.wantBody)
+				}
+				for k, want := range tc.wantTrailers {
+					if got, _ := req.Trailers().First(k); got != want {
+						t.Errorf(`Trailers()[%#q] = %#q, wanted %#q`, k, got, want)
+					}
+				}
+				if rest, _ := io.ReadAll(br); string(rest) != "GET /next HTTP/1.1\r\n\r\n" {
+					t.Errorf(`remaining = %q, wanted next request`, rest)
+				}
+			})
+		}
+	})
+
+	t.Run("chunked body is readable through body accessors", func(t *testing.T) {
+		in := "POST / HTTP/1.1\r\nTransfer-Encoding: chunked\r\nContent-Type: application/json\r\n\r\n7\r\n{\"foo\":\r\n6\r\n\"bar\"}\r\n0\r\n\r\n"
+		req, err := requestFromRaw([]byte(in), KiB, t.Context())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		var got struct{ Foo string }
+		if err := req.BodyFromJson(&got); err != nil {
+			t.Fatalf("BodyFromJson() error = %v", err)
+		}
+		if got.Foo != "bar" {
+			t.Errorf(`Foo = %#q, wanted "bar"`, got.Foo)
+		}
+		raw, rErr := req.BodyFromRaw(CTApplicationJson)
+		if rErr != nil {
+			t.Fatalf("BodyFromRaw() error = %v", rErr)
+		}
+		if string(raw) != `{"foo":"bar"}` {
+			t.Errorf(`BodyFromRaw() = %#q, wanted {"foo":"bar"}`, raw)
+		}
+	})
+
+	t.Run("leaves subsequent requests unread", func(t *testing.T) {
+		in := "POST /first HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello" +
+			"GET /second HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\n\r\nabc" +
+			"GET /third HTTP/1.1\r\nHost: localhost\r\n\r\n"
+		br := bufio.NewReader(strings.NewReader(in))
+		limits := requestLimits{maxHeaderSize: KiB, maxBodySize: KiB}
+
+		for _, want := range []string{"/first", "/second", "/third"} {
+			req, err := readRequest(br, limits, t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error reading %#q: %v", want, err)
+			}
+			if req.Path() != want {
+				t.Errorf(`Path() = %#q, wanted %#q`, req.Path(), want)
+			}
+		}
+		if _, err := br.ReadByte(); err != io.EOF {
+			t.Errorf(`expected reader to be exhausted, got %v`, err)
+		}
+	})
+
+	t.Run("expect 100-continue", func(t *testing.T) {
+		t.Run("leaves body unread until needed", func(t *testing.T) {
+			in := "POST /hello HTTP/1.1\r\nHost: localhost\r\nExpect: 100-Continue\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello"
+			br := bufio.NewReader(strings.NewReader(in))
+			limits := requestLimits{maxHeaderSize: KiB, maxBodySize: KiB}
+
+			req, err := readRequest(br, limits, t.Context())
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if req.pending == nil {
+				t.Fatal("expected body to be pending")
+			}
+			if req.body != nil {
+				t.Errorf(`body = %#q, wanted it to be unread`, req.body)
+			}
+
+			body, bErr := req.BodyFromText()
+			if bErr != nil {
+				t.Fatalf("BodyFromText() error = %v", bErr)
+			}
+			if body != "hello" {
+				t.Errorf(`BodyFromText() = %#q, wanted "hello"`, body)
+			}
+			if !req.bodyConsumed() {
+				t.Error("expected body to be consumed")
+			}
+		})
+
+		t.Run("without body", func(t *testing.T) {
+			req, err := requestFromRaw([]byte("POST /hello HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n\r\n"), KiB, t.Context())
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if req.pending != nil {
+				t.Error("did not expect a pending body")
+			}
+		})
+
+		tests := []struct {
+			name       string
+			input      string
+			wantStatus HttpStatus
+		}{
+			{
+				name:       "unsupported expectation",
+				input:      "POST /hello HTTP/1.1\r\nHost: localhost\r\nExpect: 200-ok\r\nContent-Length: 5\r\nContent-Type: text/plain\r\n\r\nhello",
+				wantStatus: StatusExpectationFailed,
+			},
+			{
+				name:       "content length too large",
+				input:      "POST /hello HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 2048\r\nContent-Type: text/plain\r\n\r\n",
+				wantStatus: StatusContentTooLarge,
+			},
+			{
+				name:       "missing content type",
+				input:      "POST /hello HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\nContent-Length: 5\r\n\r\n",
+				wantStatus: StatusBadRequest,
+			},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				_, err := requestFromRaw([]byte(tc.input), KiB, t.Context())
+
+				if err == nil {
+					t.Fatal("expected error to be present")
+				}
+				if err.status != tc.wantStatus {
+					t.Errorf(`status = %d, wanted %d`, err.status.code, tc.wantStatus.code)
+				}
+			})
+		}
+	})
+}
+
 func TestAcceptsContentType(t *testing.T) {
 	tests := []struct {
 		name   string
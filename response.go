@@ -3,6 +3,7 @@ package routeit
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -13,6 +14,7 @@ type ResponseWriter struct {
 	s       HttpStatus
 	headers *ResponseHeaders
 	ct      ContentType
+	stream  responseStream
 }
 
 // Sets a sensible default for the status code of the response depending on the
@@ -76,6 +78,9 @@ func (rw *ResponseWriter) Raw(raw []byte) {
 
 // Destructively sets the body of the response and updates headers accordingly
 func (rw *ResponseWriter) RawWithContentType(raw []byte, ct ContentType) {
+	if rw.streaming() {
+		panic(errors.New("cannot set the body of a response that has started streaming"))
+	}
 	rw.bdy = raw
 	rw.headers.Set("Content-Length", fmt.Sprintf("%d", len(raw)))
 	rw.headers.Set("Content-Type", ct.string())
@@ -85,11 +90,15 @@ func (rw *ResponseWriter) RawWithContentType(raw []byte, ct ContentType) {
 // Sets the status of the response. The server sets an opinionated default
 // depending on the incoming request. POST requests default to 201: Created and
 // DELETE requests default to 204: No Content. All other request methods
-// default to 200: OK.
+// default to 200: OK. This has no effect once the response has started
+// streaming, since the status has already been sent to the client.
 func (rw *ResponseWriter) Status(s HttpStatus) {
 	if !s.isValid() {
 		panic(fmt.Errorf("invalid HTTP status code: %d, %s", s.code, s.msg))
 	}
+	if rw.streaming() {
+		return
+	}
 	rw.s = s
 }
 
@@ -109,13 +118,28 @@ func (rw *ResponseWriter) clear() {
 	delete(rw.headers.headers, "content-length")
 }
 
+// Reports whether the connection the response is sent over should be closed
+// once the response has been written.
+func (rw *ResponseWriter) closesConnection() bool {
+	return rw.stream.err != nil || rw.headers.headers.ContainsToken("Connection", "close")
+}
+
 func (rw *ResponseWriter) write() []byte {
+	return append(rw.head(), rw.bdy...)
+}
+
+// Serialises the status line and headers of the response.
+func (rw *ResponseWriter) head() []byte {
 	var buf bytes.Buffer
 	buf.WriteString(fmt.Sprintf("HTTP/1.1 %d %s\r\n", rw.s.code, rw.s.msg))
-	now := time.Now().UTC()
-	rw.headers.Set("Date", now.Format("Mon, 02 Jan 2006 15:04:05 GMT"))
+	rw.setDate()
 	rw.headers.headers.WriteTo(&buf)
 	buf.WriteString("\r\n")
-	buf.Write(rw.bdy)
 	return buf.Bytes()
 }
+
+// Sets the Date header to the time the response is sent.
+func (rw *ResponseWriter) setDate() {
+	now := time.Now().UTC()
+	rw.headers.Set("Date", now.Format("Mon, 02 Jan 2006 15:04:05 GMT"))
+}
@@ -0,0 +1,251 @@
+package routeit
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/sktylr/routeit/internal/chunked"
+)
+
+var errBodyNotAllowed = errors.New("response status does not allow a body")
+
+// A responseStream holds the state of a response whose body is sent to the
+// client as the handler writes it, rather than being buffered and sent once
+// the handler has returned.
+type responseStream struct {
+	// The buffered writer of the connection the response is sent over. This is
+	// nil when the response is not attached to a connection, such as when
+	// using the [TestClient], in which case the streamed body is collected in
+	// the response body instead.
+	bw *bufio.Writer
+	// Sends the status and headers once the response starts streaming,
+	// returning the writer the body is streamed through, which is closed once
+	// the body is complete. When nil, the head is sent as HTTP/1.1 and the body
+	// uses the chunked transfer coding.
+	begin func(rw *ResponseWriter) (io.WriteCloser, error)
+	body  io.WriteCloser
+	// Called before the response starts streaming, with the content type of
+	// the body that is about to be streamed. The response is not streamed if
+	// this returns an error.
+	start func(ContentType) error
+	// The length of the body, when it is known before the response starts
+	// streaming, in which case the body is sent with a Content-Length instead
+	// of using the chunked transfer coding. This is 0 when the length is not
+	// known.
+	length int64
+	// Set for responses that send their headers but never a body, such as
+	// responses to HEAD requests.
+	discard bool
+	started bool
+	// Once set, the response can no longer be streamed and the connection
+	// must be closed once the handler has returned.
+	err error
+	// Takes the connection away from the server, for protocols that replace
+	// HTTP once the handshake is complete. This is nil when the response is
+	// not attached to a connection.
+	hijack   func() (net.Conn, *bufio.Reader, error)
+	hijacked bool
+}
+
+// Write implements [io.Writer], allowing the response body to be streamed to
+// the client as it is produced instead of being buffered in full. The first
+// call to Write (or [ResponseWriter.Flush]) commits the response - the status
+// and headers are sent as they are at that point and later changes to them
+// have no effect. Any body set previously using [ResponseWriter.Json],
+// [ResponseWriter.Text] or similar is discarded, and it is not possible to use
+// those methods once the response has started streaming.
+//
+// Streamed bodies use the chunked transfer coding, so the Content-Length
+// header is never sent. The Content-Type is not inferred and should be set
+// through [ResponseWriter.Headers] before the first call to Write. Written data
+// is buffered and sent whenever the buffer fills up or the response is
+// flushed.
+//
+// Once a response has started streaming, it is no longer subject to the
+// server's overall write deadline, which instead applies to each individual
+// write to the connection. If the handler returns an error or panics after the
+// response has started streaming, error handlers are not run since the status
+// has already been sent. The connection is closed instead, leaving the body
+// incomplete so that the client can tell the response was cut short.
+func (rw *ResponseWriter) Write(p []byte) (int, error) {
+	if err := rw.startStreaming(); err != nil {
+		return 0, err
+	}
+	st := &rw.stream
+	if st.discard || len(p) == 0 {
+		return len(p), nil
+	}
+	if st.bw == nil {
+		rw.bdy = append(rw.bdy, p...)
+		return len(p), nil
+	}
+	n, err := st.body.Write(p)
+	if err != nil {
+		st.err = err
+	}
+	return n, err
+}
+
+// Sends everything written to the response so far to the client. If nothing
+// has been written yet, this commits the response and sends its status and
+// headers straight away. See [ResponseWriter.Write] for details on streaming
+// responses.
+func (rw *ResponseWriter) Flush() error {
+	if err := rw.startStreaming(); err != nil {
+		return err
+	}
+	st := &rw.stream
+	if st.bw == nil {
+		return nil
+	}
+	if err := st.bw.Flush(); err != nil {
+		st.err = err
+		return err
+	}
+	return nil
+}
+
+func (rw *ResponseWriter) startStreaming() error {
+	st := &rw.stream
+	if st.err != nil {
+		return st.err
+	}
+	if st.hijacked {
+		return errHijacked
+	}
+	if st.started {
+		return nil
+	}
+	if !rw.s.allowsBody() {
+		return errBodyNotAllowed
+	}
+
+	var ct ContentType
+	if raw, found := rw.headers.headers.All("Content-Type"); found {
+		ct = parseContentType(raw[0])
+	}
+	// Nothing on the response may be modified until the start hook has
+	// approved streaming, since it may have timed out and already be in the
+	// hands of the server.
+	if st.start != nil {
+		if err := st.start(ct); err != nil {
+			return err
+		}
+	}
+
+	st.started = true
+	rw.ct = ct
+	rw.bdy = []byte{}
+	if st.length > 0 {
+		rw.headers.Set("Content-Length", fmt.Sprintf("%d", st.length))
+	} else {
+		delete(rw.headers.headers, "content-length")
+		rw.headers.Set("Transfer-Encoding", "chunked")
+	}
+	if st.bw == nil {
+		return nil
+	}
+	begin := st.begin
+	if begin == nil {
+		begin = (*ResponseWriter).beginHttp1
+	}
+	body, err := begin(rw)
+	if err != nil {
+		st.err = err
+		return err
+	}
+	if st.length > 0 {
+		body = &sizedBody{body: body, remaining: st.length}
+	}
+	st.body = body
+	return nil
+}
+
+func (rw *ResponseWriter) beginHttp1() (io.WriteCloser, error) {
+	bw := rw.stream.bw
+	if _, err := bw.Write(rw.head()); err != nil {
+		return nil, err
+	}
+	if rw.stream.length > 0 {
+		return nopCloser{bw}, nil
+	}
+	return chunked.NewWriter(bw), nil
+}
+
+var errBodyLength = errors.New("streamed body does not match its Content-Length")
+
+// The sizedBody ensures a streamed body is exactly as long as the
+// Content-Length sent for it, since the client cannot otherwise tell where
+// the body ends.
+type sizedBody struct {
+	body      io.WriteCloser
+	remaining int64
+}
+
+func (b *sizedBody) Write(p []byte) (int, error) {
+	if int64(len(p)) > b.remaining {
+		return 0, errBodyLength
+	}
+	n, err := b.body.Write(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+func (b *sizedBody) Close() error {
+	if b.remaining != 0 {
+		return errBodyLength
+	}
+	return b.body.Close()
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// Reports whether the response has been committed and its body is being
+// streamed to the client.
+func (rw *ResponseWriter) streaming() bool {
+	return rw.stream.started
+}
+
+// Aborts a response that has started streaming, after the handler has failed
+// in some way. The connection will be closed without completing the body.
+func (rw *ResponseWriter) abort(cause any) {
+	if rw.stream.err == nil {
+		rw.stream.err = fmt.Errorf("response aborted: %v", cause)
+	}
+}
+
+// Sends the response to the client, or completes it if it has been streamed.
+// An error is returned if the response could not be sent in full, in which
+// case the connection cannot be used any further.
+func (rw *ResponseWriter) writeTo(bw *bufio.Writer) error {
+	st := &rw.stream
+	if !st.started {
+		if _, err := bw.Write(rw.write()); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	if st.err != nil {
+		// Whatever was written before the response was aborted is still sent,
+		// but the last chunk is not, so the client knows the response is
+		// incomplete.
+		bw.Flush()
+		return st.err
+	}
+	if !st.discard {
+		if err := st.body.Close(); err != nil {
+			// As with aborted responses, the client is sent what it has been
+			// given so far so it can tell the body is incomplete.
+			bw.Flush()
+			return err
+		}
+	}
+	return bw.Flush()
+}
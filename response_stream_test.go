@@ -0,0 +1,326 @@
+package routeit
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStreamingResponse(t *testing.T) {
+	t.Run("test client", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			method     HttpMethod
+			fn         HandlerFunc
+			strict     bool
+			wantStatus HttpStatus
+			wantBody   string
+			wantTE     bool
+		}{
+			{
+				name: "collects streamed body",
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Headers().Set("Content-Type", "text/plain")
+					rw.Write([]byte("hello "))
+					rw.Flush()
+					rw.Write([]byte("world"))
+					return nil
+				},
+				wantStatus: StatusOK,
+				wantBody:   "hello world",
+				wantTE:     true,
+			},
+			{
+				name: "discards buffered body",
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Text("buffered")
+					rw.Write([]byte("streamed"))
+					return nil
+				},
+				wantStatus: StatusOK,
+				wantBody:   "streamed",
+				wantTE:     true,
+			},
+			{
+				name: "status cannot change after streaming starts",
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Status(StatusAccepted)
+					rw.Flush()
+					rw.Status(StatusPartialContent)
+					return nil
+				},
+				wantStatus: StatusAccepted,
+				wantTE:     true,
+			},
+			{
+				name:   "HEAD request",
+				method: HEAD,
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Write([]byte("hello"))
+					return nil
+				},
+				wantStatus: StatusOK,
+				wantTE:     true,
+			},
+			{
+				name: "status without body",
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Status(StatusNoContent)
+					if _, err := rw.Write([]byte("hello")); !errors.Is(err, errBodyNotAllowed) {
+						t.Errorf(`Write() error = %v, wanted %v`, err, errBodyNotAllowed)
+					}
+					return nil
+				},
+				wantStatus: StatusNoContent,
+			},
+			{
+				name:   "content type not accepted",
+				strict: true,
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Headers().Set("Content-Type", "text/csv")
+					_, err := rw.Write([]byte("a,b,c"))
+					return err
+				},
+				wantStatus: StatusNotAcceptable,
+			},
+			{
+				name: "error after streaming starts",
+				fn: func(rw *ResponseWriter, req *Request) error {
+					rw.Write([]byte("partial"))
+					return ErrInternalServerError()
+				},
+				wantStatus: StatusOK,
+				wantBody:   "partial",
+				wantTE:     true,
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler, StrictClientAcceptance: tc.strict})
+				srv.RegisterRoutes(RouteRegistry{"/stream": Get(tc.fn)})
+				client := NewTestClient(srv)
+
+				var res *TestResponse
+				if tc.method == HEAD {
+					res = client.Head("/stream")
+				} else {
+					res = client.Get("/stream", "Accept", "application/json")
+				}
+
+				res.AssertStatusCode(t, tc.wantStatus)
+				res.AssertBodyMatchesString(t, tc.wantBody)
+				if tc.wantTE {
+					res.AssertHeaderMatchesString(t, "Transfer-Encoding", "chunked")
+					res.RefuteHeaderPresent(t, "Content-Length")
+				} else {
+					res.RefuteHeaderPresent(t, "Transfer-Encoding")
+				}
+			})
+		}
+	})
+
+	t.Run("buffered helpers panic after streaming starts", func(t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected Text() to panic")
+			}
+		}()
+		rw := newResponse()
+		rw.Write([]byte("hello"))
+
+		rw.Text("world")
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		serve := func(t *testing.T, conf ServerConfig, h HandlerFunc) (net.Conn, *bufio.Reader, chan struct{}) {
+			t.Helper()
+			conf.Debug = true
+			conf.LoggingHandler = slog.DiscardHandler
+			srv := NewServer(conf)
+			srv.RegisterRoutes(RouteRegistry{
+				"/stream": Get(h),
+				"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+					rw.Text("Hello!")
+					return nil
+				}),
+			})
+			client, server := net.Pipe()
+			t.Cleanup(func() { client.Close() })
+			done := make(chan struct{})
+			go func() {
+				srv.handleNewConnection(server)
+				close(done)
+			}()
+			return client, bufio.NewReader(client), done
+		}
+		get := func(t *testing.T, conn net.Conn, br *bufio.Reader, method, path string) *http.Response {
+			t.Helper()
+			go conn.Write([]byte(method + " " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+			res, err := http.ReadResponse(br, &http.Request{Method: method})
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			return res
+		}
+
+		t.Run("sends headers and flushed data before handler returns", func(t *testing.T) {
+			proceed := make(chan struct{})
+			conn, br, _ := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+				rw.Headers().Set("Content-Type", "text/plain")
+				rw.Write([]byte("first,"))
+				rw.Flush()
+				<-proceed
+				rw.Write([]byte("second"))
+				return nil
+			})
+
+			res := get(t, conn, br, "GET", "/stream")
+			if len(res.TransferEncoding) != 1 || res.TransferEncoding[0] != "chunked" {
+				t.Errorf(`Transfer-Encoding = %v, wanted [chunked]`, res.TransferEncoding)
+			}
+			first := make([]byte, len("first,"))
+			if _, err := io.ReadFull(res.Body, first); err != nil {
+				t.Fatalf("failed to read first chunk: %v", err)
+			}
+			close(proceed)
+			rest, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("failed to read rest of body: %v", err)
+			}
+
+			if got := string(first) + string(rest); got != "first,second" {
+				t.Errorf(`body = %#q, wanted "first,second"`, got)
+			}
+			if res.Close {
+				t.Error("did not expect server to close the connection")
+			}
+			if next := get(t, conn, br, "GET", "/hello"); next.StatusCode != 200 {
+				t.Errorf(`next status = %d, wanted 200`, next.StatusCode)
+			}
+		})
+
+		t.Run("sends body of a known length", func(t *testing.T) {
+			conn, br, _ := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+				rw.stream.length = 11
+				rw.Write([]byte("hello "))
+				rw.Flush()
+				rw.Write([]byte("world"))
+				return nil
+			})
+
+			res := get(t, conn, br, "GET", "/stream")
+			body, err := io.ReadAll(res.Body)
+
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if string(body) != "hello world" {
+				t.Errorf(`body = %#q, wanted "hello world"`, body)
+			}
+			if res.ContentLength != 11 || len(res.TransferEncoding) != 0 {
+				t.Errorf(`Content-Length = %d, Transfer-Encoding = %v, wanted 11 and none`, res.ContentLength, res.TransferEncoding)
+			}
+			if next := get(t, conn, br, "GET", "/hello"); next.StatusCode != 200 {
+				t.Errorf(`next status = %d, wanted 200`, next.StatusCode)
+			}
+		})
+
+		t.Run("closes connection when body is shorter than its length", func(t *testing.T) {
+			conn, br, done := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+				rw.stream.length = 11
+				rw.Write([]byte("hello"))
+				return nil
+			})
+
+			res := get(t, conn, br, "GET", "/stream")
+			body, err := io.ReadAll(res.Body)
+
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf(`ReadAll() error = %v, wanted %v`, err, io.ErrUnexpectedEOF)
+			}
+			if string(body) != "hello" {
+				t.Errorf(`body = %#q, wanted "hello"`, body)
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected connection to be closed by the server")
+			}
+		})
+
+		t.Run("outlives write deadline", func(t *testing.T) {
+			conn, br, _ := serve(t, ServerConfig{WriteDeadline: 20 * time.Millisecond}, func(rw *ResponseWriter, req *Request) error {
+				rw.Write([]byte("slow "))
+				rw.Flush()
+				time.Sleep(50 * time.Millisecond)
+				_, err := rw.Write([]byte("response"))
+				return err
+			})
+
+			res := get(t, conn, br, "GET", "/stream")
+			body, err := io.ReadAll(res.Body)
+
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if string(body) != "slow response" {
+				t.Errorf(`body = %#q, wanted "slow response"`, body)
+			}
+		})
+
+		t.Run("HEAD request only sends headers", func(t *testing.T) {
+			conn, br, _ := serve(t, ServerConfig{}, func(rw *ResponseWriter, req *Request) error {
+				rw.Write([]byte("hello"))
+				return nil
+			})
+
+			res := get(t, conn, br, "HEAD", "/stream")
+			res.Body.Close()
+
+			if res.StatusCode != 200 {
+				t.Errorf(`status = %d, wanted 200`, res.StatusCode)
+			}
+			if next := get(t, conn, br, "GET", "/hello"); next.StatusCode != 200 {
+				t.Errorf(`next status = %d, wanted 200`, next.StatusCode)
+			}
+		})
+
+		for name, fn := range map[string]HandlerFunc{
+			"error": func(rw *ResponseWriter, req *Request) error {
+				rw.Write([]byte("partial"))
+				rw.Flush()
+				return errors.New("export failed")
+			},
+			"panic": func(rw *ResponseWriter, req *Request) error {
+				rw.Write([]byte("partial"))
+				rw.Flush()
+				panic("export failed")
+			},
+		} {
+			t.Run("aborts response on "+name, func(t *testing.T) {
+				conn, br, done := serve(t, ServerConfig{}, fn)
+
+				res := get(t, conn, br, "GET", "/stream")
+				body, err := io.ReadAll(res.Body)
+
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					t.Errorf(`ReadAll() error = %v, wanted %v`, err, io.ErrUnexpectedEOF)
+				}
+				if string(body) != "partial" {
+					t.Errorf(`body = %#q, wanted "partial"`, body)
+				}
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("expected connection to be closed by the server")
+				}
+			})
+		}
+	})
+}
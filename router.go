@@ -2,6 +2,7 @@ package routeit
 
 import (
 	"fmt"
+	"os"
 	"path"
 	"regexp"
 	"slices"
@@ -70,11 +71,9 @@ type router struct {
 	routes *trie.StringTrie[Handler, matchedRoute]
 	// The global namespace that all registered routes are prefixed with.
 	namespace []string
-	// The static directory for serving responses from disk.
-	staticDir    []string
-	servesStatic bool
-	staticLoader *Handler
-	rewrites     *trie.StringTrie[[]string, []string]
+	// The static files served by the router, with the longest prefixes first.
+	static   []*staticMount
+	rewrites *trie.StringTrie[[]string, []string]
 }
 
 func newRouter() *router {
@@ -124,7 +123,8 @@ func (r *router) GlobalNamespace(ns string) {
 
 // Sets the static directory that files are loaded from. Panics whenever the
 // directory is not a subdirectory of the project root but does not require the
-// directory to exist when setting it.
+// directory to exist when setting it. The files are served under a prefix
+// matching the directory's path.
 func (r *router) NewStaticDir(s string) {
 	if s == "" {
 		return
@@ -137,9 +137,42 @@ func (r *router) NewStaticDir(s string) {
 		panic(fmt.Sprintf("invalid static assets directory [%s] - must not be outside project root", s))
 	}
 	cleaned = strings.TrimPrefix(cleaned, "/")
-	r.staticLoader = staticLoader(r.namespace)
-	r.staticDir = strings.Split(cleaned, "/")
-	r.servesStatic = true
+	// Files in the static directory have always been served ahead of the
+	// server's routes, which existing servers may rely on.
+	r.NewStaticMount(cleaned, StaticConfig{Files: os.DirFS(cleaned)}).beforeRoutes = true
+}
+
+// Serves static files under the given prefix, which obeys the global
+// namespace. Panics if the prefix is already used by another mount or
+// contains back-tracking.
+func (r *router) NewStaticMount(prefix string, conf StaticConfig) *staticMount {
+	var segs []string
+	if trimmed := r.trimRouteForInsert(prefix); trimmed != "" {
+		segs = strings.Split(trimmed, "/")
+	}
+	if slices.Contains(segs, "..") || slices.Contains(segs, ".") {
+		panic(fmt.Sprintf("invalid static prefix [%s] - must not contain back-tracking", prefix))
+	}
+	for _, m := range r.static {
+		if slices.Equal(m.prefix, segs) {
+			panic(fmt.Sprintf("static files are already mounted at [%s]", prefix))
+		}
+	}
+
+	m := newStaticMount(segs, conf)
+	h := Get(func(rw *ResponseWriter, req *Request) error {
+		// The namespace is found when the request is served, since it may be
+		// set after the files are mounted.
+		path, _ := req.uri.RemoveNamespace(r.namespace)
+		return m.serve(rw, req, path[len(m.prefix):])
+	})
+	m.handler = &h
+	r.static = append(r.static, m)
+	// The most specific mount is chosen when mounts are nested.
+	slices.SortStableFunc(r.static, func(a, b *staticMount) int {
+		return len(b.prefix) - len(a.prefix)
+	})
+	return m
 }
 
 // Adds a new URL rewrite rule to the router. Ignores comments and empty lines
@@ -203,24 +236,12 @@ func (r *router) Route(req *Request) (*Handler, bool) {
 		return nil, false
 	}
 
-	if r.servesStatic {
-		isStaticRoute := true
-		for i, seg := range r.staticDir {
-			if seg != trimmed[i] {
-				isStaticRoute = false
-				break
-			}
-		}
-		if isStaticRoute {
-			if slices.Contains(trimmed, "..") {
-				// We want to prohibit back-tracking, even if it is technically
-				// safe (e.g. /foo/bar/../bar/image.png is safe since it can be
-				// simplified to /foo/bar/image.png but we don't want to allow
-				// back-tracking of any sort)
-				return nil, false
-			}
-			return r.staticLoader, true
-		}
+	// Files mounted using RegisterStatic are only served once no route
+	// matches, so files can be mounted at the root alongside the server's
+	// routes, while the static directory is served ahead of the routes.
+	static := r.staticMount(trimmed)
+	if static != nil && static.beforeRoutes {
+		return staticRoute(static, trimmed)
 	}
 
 	route, found := r.routes.Find(trimmed)
@@ -229,9 +250,33 @@ func (r *router) Route(req *Request) (*Handler, bool) {
 		return route.handler, true
 	}
 
+	if static != nil {
+		return staticRoute(static, trimmed)
+	}
 	return nil, false
 }
 
+// Finds the most specific static mount the path falls under, if any.
+func (r *router) staticMount(path []string) *staticMount {
+	for _, m := range r.static {
+		if len(path) >= len(m.prefix) && slices.Equal(path[:len(m.prefix)], m.prefix) {
+			return m
+		}
+	}
+	return nil
+}
+
+func staticRoute(m *staticMount, path []string) (*Handler, bool) {
+	if slices.Contains(path, "..") {
+		// We want to prohibit back-tracking, even if it is technically
+		// safe (e.g. /foo/bar/../bar/image.png is safe since it can be
+		// simplified to /foo/bar/image.png but we don't want to allow
+		// back-tracking of any sort)
+		return nil, false
+	}
+	return m.handler, true
+}
+
 // Passes the incoming URL through the router's rewrites.
 func (r *router) RewriteUri(uri *uri) *HttpError {
 	rewritten, found := r.rewrites.Find(uri.edgePath)
@@ -260,6 +305,11 @@ func (r *router) RewriteUri(uri *uri) *HttpError {
 	if rewrittenQuery == "" {
 		return nil
 	}
+	if uri.rawQuery == "" {
+		uri.rawQuery = rewrittenQuery
+	} else {
+		uri.rawQuery += "&" + rewrittenQuery
+	}
 	return parseQueryParams(rewrittenQuery, uri.queryParams)
 }
 
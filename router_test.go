@@ -4,8 +4,10 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 type RouteTest struct {
@@ -395,6 +397,43 @@ func TestRoute(t *testing.T) {
 			})
 		}
 	})
+
+	t.Run("static precedence", func(t *testing.T) {
+		router := newRouter()
+		router.RegisterRoutes(RouteRegistry{
+			"/static/:file": Get(func(rw *ResponseWriter, req *Request) error { return nil }),
+			"/assets/:file": Get(func(rw *ResponseWriter, req *Request) error { return nil }),
+		})
+		router.NewStaticDir("static")
+		mounted := router.NewStaticMount("/assets", StaticConfig{Files: fstest.MapFS{}})
+		dir := router.static[slices.IndexFunc(router.static, func(m *staticMount) bool { return m.beforeRoutes })]
+		tests := []struct {
+			name       string
+			path       string
+			wantStatic *staticMount
+		}{
+			{name: "static directory before routes", path: "/static/app.js", wantStatic: dir},
+			{name: "mounted files after routes", path: "/assets/app.js"},
+			{name: "mounted files without a route", path: "/assets/css/app.css", wantStatic: mounted},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				req := requestWithUrlAndMethod(tc.path, GET)
+
+				handler, found := router.Route(req)
+
+				if !found {
+					t.Fatal("expected to find a route")
+				}
+				if tc.wantStatic != nil && handler != tc.wantStatic.handler {
+					t.Error("expected static files to be served")
+				}
+				if tc.wantStatic == nil && (handler == dir.handler || handler == mounted.handler) {
+					t.Error("expected the route to be chosen over static files")
+				}
+			})
+		}
+	})
 }
 
 func TestNewStaticDir(t *testing.T) {
@@ -442,15 +481,15 @@ func TestNewStaticDir(t *testing.T) {
 
 			defer func() {
 				if r := recover(); r == nil && wantPanic {
-					t.Errorf("router invalid static dir, expected panic but got none - static = %+v", router.staticDir)
+					t.Errorf("router invalid static dir, expected panic but got none - static = %+v", router.static)
 				}
 			}()
 
 			router.NewStaticDir(tc.in)
 
 			want := strings.Split(tc.want, "/")
-			if !wantPanic && !reflect.DeepEqual(router.staticDir, want) {
-				t.Errorf(`router.static = %+v, wanted %#q`, router.staticDir, tc.want)
+			if !wantPanic && !reflect.DeepEqual(router.static[0].prefix, want) {
+				t.Errorf(`router.static = %+v, wanted %#q`, router.static[0].prefix, tc.want)
 			}
 		})
 	}
@@ -463,6 +502,7 @@ func TestRewriteUri(t *testing.T) {
 		in              string
 		wantRewritten   []string
 		wantQueryParams queryParameters
+		wantRawQuery    string
 		rewrite         bool
 	}{
 		{
@@ -533,6 +573,16 @@ func TestRewriteUri(t *testing.T) {
 			in:              "/foo/123",
 			wantRewritten:   []string{"baz"},
 			wantQueryParams: queryParameters{"id": {"123"}},
+			wantRawQuery:    "id=123",
+			rewrite:         true,
+		},
+		{
+			name:            "dynamic path to query param keeps existing query",
+			base:            map[string]string{"/foo/${bar}": "/baz?id=${bar}"},
+			in:              "/foo/123?b=2&flag",
+			wantRewritten:   []string{"baz"},
+			wantQueryParams: queryParameters{"id": {"123"}, "b": {"2"}, "flag": {""}},
+			wantRawQuery:    "b=2&flag&id=123",
 			rewrite:         true,
 		},
 	}
@@ -566,6 +616,9 @@ func TestRewriteUri(t *testing.T) {
 			if tc.wantQueryParams != nil && !reflect.DeepEqual(uri.queryParams.q, tc.wantQueryParams) {
 				t.Errorf("RewritePath(%q) query params = %#v, wanted %#v", tc.in, uri.queryParams.q, tc.wantQueryParams)
 			}
+			if uri.rawQuery != tc.wantRawQuery {
+				t.Errorf("RewritePath(%q) raw query = %q, wanted %q", tc.in, uri.rawQuery, tc.wantRawQuery)
+			}
 		})
 	}
 }
@@ -0,0 +1,185 @@
+package routeit
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/sktylr/routeit/internal/headers"
+	"github.com/sktylr/routeit/internal/http2"
+)
+
+// ServeHTTP implements [http.Handler], allowing the server to be used within
+// net/http, such as being mounted on a [http.ServeMux], served by a
+// [httptest.Server] or run by a serverless platform that speaks net/http. Each
+// request runs through the same URL rewrites, middleware, routing and error
+// handlers as requests the server receives itself, and the response is written
+// to w. Streamed responses are flushed to the client as they are written, and
+// handlers can take over the connection if w supports it. Requests are routed
+// using r.URL, so the server can be wrapped by handlers such as
+// [http.StripPrefix].
+//
+// The server does not need to have been started to serve requests this way,
+// and none of its listeners or connection limits are used. Routes, middleware
+// and error handlers must all be registered before the first request is
+// served.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	stop := context.AfterFunc(r.Context(), func() { cancel(errClientDisconnected) })
+	defer stop()
+	timer := time.AfterFunc(s.conf.WriteDeadline, func() { cancel(context.DeadlineExceeded) })
+	defer timer.Stop()
+
+	var rw *ResponseWriter
+	req, httpErr := s.readNetHttpRequest(r, ctx)
+	if httpErr != nil {
+		rw = newResponse()
+		httpErr.toResponse(rw)
+	} else {
+		s.setConnDetails(req, remoteAddr(r), r.TLS)
+		rc := http.NewResponseController(w)
+		bw := bufio.NewWriter(flushWriter{w, rc})
+		rw = newResponseForMethod(req.mthd)
+		rw.stream = responseStream{
+			bw:      bw,
+			discard: req.mthd == HEAD,
+			start:   s.startStream(ctx, req, timer),
+			begin: func(rw *ResponseWriter) (io.WriteCloser, error) {
+				writeNetHttpHead(w, rw)
+				return netHttpBody{bw}, nil
+			},
+			hijack: func() (net.Conn, *bufio.Reader, error) {
+				if !timer.Stop() {
+					return nil, nil, context.Cause(ctx)
+				}
+				conn, brw, err := rc.Hijack()
+				if err != nil {
+					return nil, nil, err
+				}
+				return conn, brw.Reader, nil
+			},
+		}
+		rw = s.serveRequest(ctx, req, rw)
+	}
+
+	stream := &rw.stream
+	switch {
+	case stream.hijacked:
+	case !stream.started:
+		writeNetHttpHead(w, rw)
+		w.Write(rw.bdy)
+	case stream.err != nil:
+		// This is how handlers tell net/http that the response is incomplete,
+		// so the client is not led to believe it has the full body.
+		stream.bw.Flush()
+		panic(http.ErrAbortHandler)
+	default:
+		stream.bw.Flush()
+	}
+}
+
+// Reads the request from net/http, which has already parsed it. The request
+// is rewritten as a HTTP/1.1 message and parsed again, so it is validated in
+// the same way as requests the server receives itself.
+func (s *Server) readNetHttpRequest(r *http.Request, ctx context.Context) (*Request, *HttpError) {
+	var body []byte
+	if r.Body != nil {
+		var httpErr *HttpError
+		if body, httpErr = readLimitedBody(r.Body, s.conf.RequestSize); httpErr != nil {
+			return nil, httpErr
+		}
+	}
+
+	// The URL is preferred to the RequestURI, which is what the client sent,
+	// since handlers such as [http.StripPrefix] change the URL to route the
+	// request before passing it on.
+	target := r.RequestURI
+	if r.URL != nil {
+		target = r.URL.RequestURI()
+	}
+	raw := newRawRequest(r.Method, target, r.Host)
+	for name, vals := range r.Header {
+		for _, val := range vals {
+			raw.header(name, val)
+		}
+	}
+	// Trailers are only available once the body has been read.
+	trailers := headers.NewHeaders()
+	for name, vals := range r.Trailer {
+		for _, val := range vals {
+			trailers.Append(name, val)
+		}
+	}
+	limits := requestLimits{maxHeaderSize: s.conf.MaxHeaderSize, maxBodySize: s.conf.RequestSize}
+	return raw.parse(body, trailers, limits, ctx)
+}
+
+// Finds the address of the client, which net/http gives as a string. Requests
+// that did not come over TCP, such as those made using [httptest.NewRequest],
+// may not have a usable address.
+func remoteAddr(r *http.Request) net.Addr {
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		return net.TCPAddrFromAddrPort(ap)
+	}
+	return stringAddr(r.RemoteAddr)
+}
+
+// A stringAddr is an address we know nothing about beyond its description.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "unknown" }
+func (a stringAddr) String() string  { return string(a) }
+
+// Writes the status and headers of the response. net/http manages the
+// connection itself, so headers describing the connection are left to it.
+func writeNetHttpHead(w http.ResponseWriter, rw *ResponseWriter) {
+	rw.setDate()
+	h := w.Header()
+	rw.headers.headers.Each(func(key, val string) {
+		if http2.IsConnectionSpecific(key) {
+			return
+		}
+		h.Add(key, val)
+	})
+	w.WriteHeader(int(rw.s.code))
+}
+
+// The flushWriter sends everything written to it to the client straight away,
+// where the response writer supports it. Writes come from the response's
+// buffer, so only happen once it is full or the response is flushed.
+type flushWriter struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+func (fw flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if err := fw.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return n, err
+	}
+	return n, nil
+}
+
+// The netHttpBody streams the body of a response through the response's
+// buffer. net/http ends the body once the handler returns, so closing the body
+// only needs to flush what is left in the buffer.
+type netHttpBody struct {
+	bw *bufio.Writer
+}
+
+func (b netHttpBody) Write(p []byte) (int, error) {
+	return b.bw.Write(p)
+}
+
+func (b netHttpBody) Close() error {
+	return b.bw.Flush()
+}
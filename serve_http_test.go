@@ -0,0 +1,197 @@
+package routeit
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeHTTP(t *testing.T) {
+	srv := NewServer(ServerConfig{
+		LoggingHandler: slog.DiscardHandler,
+		RequestSize:    KiB,
+		AllowedHosts:   []string{"example.com", "127.0.0.1"},
+	})
+	srv.RegisterMiddleware(func(c Chain, rw *ResponseWriter, req *Request) error {
+		rw.Headers().Set("X-Middleware", "ran")
+		return c.Proceed(rw, req)
+	})
+	srv.RegisterErrorHandlers(map[HttpStatus]ErrorResponseHandler{
+		StatusImATeapot: func(erw *ErrorResponseWriter, req *Request) {
+			erw.Text("short and stout")
+		},
+	})
+	srv.RegisterRoutes(RouteRegistry{
+		"/hello/:name": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Headers().Set("X-Client", req.ClientIP())
+			greeting, _ := req.Queries().First("greeting")
+			rw.Textf("Hello %s, %s!", req.PathParam("name"), greeting)
+			return nil
+		}),
+		"/echo": Post(func(rw *ResponseWriter, req *Request) error {
+			body, err := req.BodyFromRaw(CTTextPlain)
+			if err != nil {
+				return err
+			}
+			cookie, _ := req.Headers().First("Cookie")
+			rw.Headers().Set("X-Cookie", cookie)
+			rw.RawWithContentType(body, CTTextPlain)
+			return nil
+		}),
+		"/stream": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Headers().Set("Content-Type", "text/plain")
+			for i := range 3 {
+				if _, err := rw.Write([]byte(strings.Repeat(string(rune('a'+i)), 10_000))); err != nil {
+					return err
+				}
+				if err := rw.Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+		"/teapot": Get(func(rw *ResponseWriter, req *Request) error {
+			return ErrImATeapot()
+		}),
+	})
+
+	tests := []struct {
+		name        string
+		req         *http.Request
+		wantStatus  int
+		wantBody    string
+		wantHeaders map[string]string
+	}{
+		{
+			name:       "routes request",
+			req:        httptest.NewRequest("GET", "/hello/bob?greeting=hi", nil),
+			wantStatus: 200,
+			wantBody:   "Hello bob, hi!",
+			wantHeaders: map[string]string{
+				"Content-Type":   "text/plain",
+				"Content-Length": "14",
+				"X-Middleware":   "ran",
+				"X-Client":       "192.0.2.1",
+			},
+		},
+		{
+			name: "reads body and cookies",
+			req: func() *http.Request {
+				req := httptest.NewRequest("POST", "/echo", strings.NewReader("ping"))
+				req.Header.Set("Content-Type", "text/plain")
+				req.Header.Add("Cookie", "a=1")
+				req.Header.Add("Cookie", "b=2")
+				return req
+			}(),
+			wantStatus:  201,
+			wantBody:    "ping",
+			wantHeaders: map[string]string{"X-Cookie": "a=1; b=2"},
+		},
+		{
+			name:        "head request",
+			req:         httptest.NewRequest("HEAD", "/hello/bob", nil),
+			wantStatus:  200,
+			wantHeaders: map[string]string{"Content-Length": "12"},
+		},
+		{
+			name:        "custom error handler",
+			req:         httptest.NewRequest("GET", "/teapot", nil),
+			wantStatus:  418,
+			wantBody:    "short and stout",
+			wantHeaders: map[string]string{"X-Middleware": "ran"},
+		},
+		{
+			name:       "not found",
+			req:        httptest.NewRequest("GET", "/missing", nil),
+			wantStatus: 404,
+			wantBody:   "404: Not Found. Invalid route: /missing",
+		},
+		{
+			name: "body too large",
+			req: func() *http.Request {
+				req := httptest.NewRequest("POST", "/echo", strings.NewReader(strings.Repeat("a", 2048)))
+				req.Header.Set("Content-Type", "text/plain")
+				return req
+			}(),
+			wantStatus: 413,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			srv.ServeHTTP(rec, tc.req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf(`status = %d, wanted %d`, rec.Code, tc.wantStatus)
+			}
+			if tc.wantBody != "" && rec.Body.String() != tc.wantBody {
+				t.Errorf(`body = %q, wanted %q`, rec.Body.String(), tc.wantBody)
+			}
+			for k, want := range tc.wantHeaders {
+				if got := rec.Header().Get(k); got != want {
+					t.Errorf(`header %q = %q, wanted %q`, k, got, want)
+				}
+			}
+			if got := rec.Header().Get("Date"); got == "" {
+				t.Error("expected Date header to be set")
+			}
+		})
+	}
+
+	t.Run("streams over a real server", func(t *testing.T) {
+		ts := httptest.NewServer(srv)
+		defer ts.Close()
+
+		res, err := http.Get(ts.URL + "/stream")
+		if err != nil {
+			t.Fatalf("failed to make request: %v", err)
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+
+		if err != nil {
+			t.Fatalf("failed to read body: %v", err)
+		}
+		if res.StatusCode != 200 {
+			t.Errorf(`status = %d, wanted 200`, res.StatusCode)
+		}
+		want := strings.Repeat("a", 10_000) + strings.Repeat("b", 10_000) + strings.Repeat("c", 10_000)
+		if string(body) != want {
+			t.Errorf(`body length = %d, wanted %d`, len(body), len(want))
+		}
+		if got := res.Header.Get("Transfer-Encoding"); got != "" {
+			t.Errorf(`Transfer-Encoding header = %q, wanted it left to net/http`, got)
+		}
+	})
+
+	t.Run("mounts on a serve mux", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.Handle("/hello/", srv)
+		rec := httptest.NewRecorder()
+
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/hello/alice?greeting=hey", nil))
+
+		if rec.Body.String() != "Hello alice, hey!" {
+			t.Errorf(`body = %q, wanted "Hello alice, hey!"`, rec.Body.String())
+		}
+	})
+
+	t.Run("routes on the URL after a prefix is stripped", func(t *testing.T) {
+		mux := http.NewServeMux()
+		mux.Handle("/app/", http.StripPrefix("/app", srv))
+		rec := httptest.NewRecorder()
+
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", "/app/hello/alice?greeting=hey", nil))
+
+		if rec.Code != 200 {
+			t.Errorf(`status = %d, wanted 200`, rec.Code)
+		}
+		if rec.Body.String() != "Hello alice, hey!" {
+			t.Errorf(`body = %q, wanted "Hello alice, hey!"`, rec.Body.String())
+		}
+	})
+}
@@ -6,17 +6,34 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
-	"log/slog"
+	"io"
 	"net"
 	"os"
+	"os/signal"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/sktylr/routeit/internal/http2"
 	"github.com/sktylr/routeit/internal/socket"
 )
 
+// How often the server checks whether all connections have closed while
+// shutting down.
+const shutdownPollInterval = 10 * time.Millisecond
+
+const (
+	// How long a connection that is turned away because the server is at
+	// capacity is kept open for, at most.
+	rejectTimeout = time.Second
+	// How much of a rejected connection's request is read and discarded
+	// before the connection is closed.
+	rejectReadLimit = 4 * KiB
+)
+
 type Server struct {
 	conf         serverConfig
 	router       *router
@@ -25,6 +42,19 @@ type Server struct {
 	started      atomic.Bool
 	errorHandler *errorHandler
 	sock         socket.Socket
+	// The name of each of the socket's listeners, which tells the process
+	// the listeners are handed over to which protocol each is for.
+	sockNames []string
+	// Tracks the open connections so they can be drained when shutting down.
+	// The idle state of each connection is guarded by mu.
+	mu           sync.Mutex
+	conns        map[*conn]struct{}
+	inShutdown   atomic.Bool
+	shutdownDone chan struct{}
+	shutdownOnce sync.Once
+	connLimit    *limiter
+	reqLimit     *limiter
+	certs        *CertManager
 }
 
 // Constructs a new server given the config. Defaults are provided for all
@@ -36,6 +66,18 @@ func NewServer(conf ServerConfig) *Server {
 	if len(conf.AllowedHosts) == 0 && conf.Debug {
 		conf.AllowedHosts = []string{".localhost", "127.0.0.1", "[::1]"}
 	}
+	if conf.CertManager != nil {
+		if conf.TlsConfig == nil {
+			conf.TlsConfig = &tls.Config{}
+		}
+		conf.TlsConfig = conf.CertManager.apply(conf.TlsConfig)
+	}
+	if conf.ClientCerts != nil {
+		if conf.TlsConfig == nil {
+			panic("cannot ask for client certificates without a tls config")
+		}
+		conf.TlsConfig = conf.ClientCerts.apply(conf.TlsConfig)
+	}
 	router := newRouter()
 	router.GlobalNamespace(conf.Namespace)
 	router.NewStaticDir(conf.StaticDir)
@@ -45,7 +87,12 @@ func NewServer(conf ServerConfig) *Server {
 		log:          newLogger(conf.LoggingHandler, conf.Debug, conf.LogAttrExtractor),
 		errorHandler: newErrorHandler(conf.ErrorMapper),
 		middleware:   newMiddleware(),
+		conns:        map[*conn]struct{}{},
+		shutdownDone: make(chan struct{}),
+		certs:        conf.CertManager,
 	}
+	s.connLimit = newLimiter(s.conf.MaxConnections)
+	s.reqLimit = newLimiter(s.conf.MaxInFlightRequests)
 	// All requests should have timeout middleware built in
 	s.RegisterMiddleware(s.timeoutMiddleware)
 	if conf.RequestIdProvider != nil {
@@ -105,6 +152,27 @@ func (s *Server) RegisterRoutesUnderNamespace(namespace string, rreg RouteRegist
 	s.router.RegisterRoutesUnderNamespace(namespace, rreg)
 }
 
+// Serves static files, such as a web app's assets, to GET and HEAD requests
+// whose path starts with the prefix. The prefix obeys the global namespace (if
+// configured), and any number of file systems can be mounted at different
+// prefixes, with the most specific prefix chosen when they are nested. A
+// prefix of "/" serves files from the root of the server. Routes registered
+// on the server take precedence over static files, so files mounted at the
+// root do not hide the server's other routes. This is unlike
+// [ServerConfig.StaticDir], which is served ahead of the server's routes.
+// Requests for paths that try to back-track using ".." are rejected. This will
+// panic if the prefix has already been mounted, or if no file system is given.
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	assets, _ := fs.Sub(dist, "dist")
+//	srv.RegisterStatic("/assets", routeit.StaticConfig{Files: assets})
+func (s *Server) RegisterStatic(prefix string, conf StaticConfig) {
+	s.panicIfStarted("register static files")
+	s.router.NewStaticMount(prefix, conf)
+}
+
 // Registers middleware to the server. The order of registration matters, where
 // the first middleware registered will be the first middleware called in the
 // chain, the second will be the second and so on.
@@ -134,94 +202,354 @@ func (s *Server) StartOrPanic() {
 	}
 }
 
-// Starts the server using the config and registered routes. This should be the
-// last line of a main function as any code after this call is not executable.
-// The server's config is thread-safe - meaning that if thread A initialised
-// the server, registered routes and started the server, and thread B attempted
-// to register additional routes to the same server, then thread B would panic.
-// The server may also not be started multiple times from different threads as
-// this will also cause a panic
+// Starts the server using the config and registered routes. This blocks until
+// the server is shut down using [Server.Shutdown], so is typically the last
+// line of a main function. The server's config is thread-safe - meaning that
+// if thread A initialised the server, registered routes and started the
+// server, and thread B attempted to register additional routes to the same
+// server, then thread B would panic. The server may also not be started
+// multiple times from different threads as this will also cause a panic. Once
+// the server has been shut down, Start returns nil.
 func (s *Server) Start() error {
 	if !s.started.CompareAndSwap(false, true) {
 		return errors.New("server has already been started")
 	}
 
-	var portsAttrs []slog.Attr
-	if s.conf.HttpPort != 0 {
-		portsAttrs = append(portsAttrs, slog.Int("http_port", int(s.conf.HttpPort)))
-	}
-	if s.conf.HttpsPort != 0 {
-		portsAttrs = append(portsAttrs, slog.Int("https_port", int(s.conf.HttpsPort)))
-	}
-	s.log.LogAttrs(slog.LevelInfo, "Starting server", portsAttrs...)
+	s.log.Info("Starting server")
 
+	// Binding is guarded so that a concurrent call to Shutdown either sees the
+	// socket fully bound or stops the server from binding at all.
+	s.mu.Lock()
+	if s.inShutdown.Load() {
+		s.mu.Unlock()
+		return nil
+	}
 	if err := s.sock.Bind(); err != nil {
+		s.mu.Unlock()
 		s.log.Error("Failed to establish connection", "err", err)
 		return err
 	}
+	addrs := s.sock.Addrs()
+	s.mu.Unlock()
+	for i, addr := range addrs {
+		s.log.Info("Listening", "listener", s.sockNames[i], "network", addr.Network(), "addr", addr.String())
+	}
 	s.log.Info("Server started, ready for requests")
+	if s.certs != nil {
+		go s.certs.watch(s.shutdownDone, s.log)
+	}
+	if err := notifyHandOverReady(); err != nil {
+		s.log.Warn("Failed to tell the previous process the server is ready", "err", err)
+	}
 	s.sock.Serve(s.handleNewConnection, func(err error) {
 		s.log.Warn("Failed to accept incoming connection", "err", err)
 	})
-	return s.sock.Close()
+
+	// The socket only stops serving once it has been closed by Shutdown, which
+	// we wait on to finish draining the open connections.
+	<-s.shutdownDone
+	s.log.Info("Server shut down")
+	return nil
+}
+
+// Returns the addresses the server is listening on, with the HTTP address
+// before the HTTPS address when listening for both. This is nil until the
+// server has started listening, and is most useful when the server is
+// configured to listen on port 0, since it holds the port the kernel chose.
+// Tests can wait for the server to be ready by polling this after calling
+// [Server.Start] in a separate goroutine.
+func (s *Server) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sock.Addrs()
 }
 
-// Handles an incoming connection. Extracts the raw request bytes and sends the
-// raw response back to the client. Read and write deadlines are handled using
-// the server config.
-func (s *Server) handleNewConnection(conn net.Conn) {
-	now := time.Now()
-	rddln := now.Add(s.conf.ReadDeadline)
-	if err := conn.SetReadDeadline(rddln); err != nil {
-		s.log.Warn("Failed to set read deadline for incoming connection", "deadline", s.conf.ReadDeadline, "err", err)
+// Shuts down the server gracefully. The server stops accepting new
+// connections, closes any idle connections and waits for in-flight requests to
+// be served and their connections to close, after which [Server.Start]
+// returns. Connections that are serving a request when Shutdown is called are
+// closed once the response has been sent. If ctx expires before all
+// connections have closed, the remaining connections are closed forcibly and
+// the context's error is returned. Shutdown may be called multiple times, and
+// each call waits for the server to finish shutting down.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.started.Load() {
+		return errors.New("server has not been started")
 	}
-	if err := conn.SetWriteDeadline(rddln.Add(s.conf.ReadDeadline)); err != nil {
-		s.log.Warn("Failed to set write deadline for incoming connection", "deadline", s.conf.WriteDeadline, "err", err)
+
+	var err error
+	s.mu.Lock()
+	if s.inShutdown.CompareAndSwap(false, true) {
+		s.log.Info("Shutting down server")
+		err = s.sock.Close()
 	}
-	defer conn.Close()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeIdleConns() {
+		select {
+		case <-ctx.Done():
+			s.log.Warn("Forcibly closing connections that did not finish in time", "err", ctx.Err())
+			s.closeAllConns()
+			s.shutdownOnce.Do(func() { close(s.shutdownDone) })
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	s.shutdownOnce.Do(func() { close(s.shutdownDone) })
+	return err
+}
 
-	buf := make([]byte, s.conf.RequestSize)
-	_, err := conn.Read(buf)
-	if err != nil {
-		s.log.Warn("Failed to read request from connection", "err", err)
+// Shuts the server down gracefully using [Server.Shutdown] once the process
+// receives a SIGINT or SIGTERM signal. In-flight requests are given up to the
+// timeout to complete. This should be called before [Server.Start], which
+// returns once the server has shut down. Receiving a second signal while
+// shutting down terminates the process immediately.
+func (s *Server) ShutdownOnSignal(timeout time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		// Restore the default behaviour so a second signal is not ignored.
+		stop()
+		s.log.Info("Received signal, shutting down", "timeout", timeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			s.log.Error("Failed to shut down gracefully", "err", err)
+		}
+	}()
+}
+
+// Handles an incoming connection. HTTP/1.1 connections are persistent by
+// default (RFC-9112 Sec 9.3), so we continue to read requests and respond to
+// them over the same connection until either side asks for it to be closed,
+// the connection has been idle for too long or the connection has served the
+// maximum number of requests allowed. Read and write deadlines are handled
+// using the server config.
+func (s *Server) handleNewConnection(rwc net.Conn) {
+	// Capacity is taken before anything else, including the TLS handshake,
+	// so that a server at capacity does as little work as possible for the
+	// connections it turns away.
+	if !s.connLimit.acquire(context.Background(), s.conf.OverloadQueueTimeout) {
+		s.rejectConnection(rwc)
 		return
 	}
-
-	var tlsState *tls.ConnectionState
-	if tlsConn, ok := conn.(*tls.Conn); ok {
-		state := tlsConn.ConnectionState()
-		tlsState = &state
+	defer s.connLimit.release()
+	if tlsConn, ok := rwc.(*tls.Conn); ok {
+		// The handshake is completed up front so the connection's TLS state,
+		// such as the protocol the client chose and its certificate, is known
+		// before anything is read from it.
+		tlsConn.SetDeadline(time.Now().Add(s.conf.ReadDeadline))
+		if err := tlsConn.Handshake(); err != nil {
+			s.log.Warn("TLS handshake failed", "err", err)
+			rwc.Close()
+			return
+		}
+	}
+	c := newConn(rwc, s.conf.WriteDeadline)
+	defer func() {
+		if !c.hijacked {
+			rwc.Close()
+		}
+	}()
+	if !s.trackConn(c) {
+		return
+	}
+	defer s.untrackConn(c)
+	if c.tlsState != nil && c.tlsState.NegotiatedProtocol == "h2" {
+		s.serveHttp2(c)
+		return
 	}
 
-	res := s.handleNewRequest(buf, conn.RemoteAddr(), tlsState)
-	_, err = conn.Write(res.write())
+	for ; ; c.served++ {
+		// The first request on the connection is expected to arrive promptly,
+		// while subsequent requests may be sent whenever the client needs
+		// them, so we are more lenient while the connection is idle.
+		timeout := s.conf.ReadDeadline
+		if c.served != 0 {
+			timeout = s.conf.IdleTimeout
+		}
+		if err := rwc.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			s.log.Warn("Failed to set read deadline for incoming connection", "deadline", timeout, "err", err)
+		}
 
-	if err != nil {
-		s.log.Error("Failed to respond to client", "err", err)
+		// Wait for the first byte of the next request to arrive before
+		// reading the rest of the request, so we can tell apart a connection
+		// that is closed while idle and one that is closed mid-request.
+		if !s.setIdle(c, true) {
+			return
+		}
+		if _, err := c.br.Peek(1); err != nil {
+			if s.inShutdown.Load() {
+				s.log.Debug("Closing idle connection for shutdown", "requests_served", c.served)
+			} else if c.served != 0 && isIdleClose(err) {
+				// The client has either closed the connection after
+				// finishing with it, or has left it idle for too long. Both
+				// are normal ways for a persistent connection to end.
+				s.log.Debug("Closing idle connection", "requests_served", c.served, "err", err)
+			} else {
+				s.log.Warn("Failed to read request from connection", "err", err)
+			}
+			return
+		}
+		if !s.setIdle(c, false) {
+			// The connection was closed for shutdown just as a request
+			// started to arrive on it.
+			return
+		}
+		if c.served == 0 && s.conf.EnableHttp2 && c.tlsState == nil && s.hasHttp2Preface(c) {
+			s.serveHttp2(c)
+			return
+		}
+		if c.served != 0 {
+			if err := rwc.SetReadDeadline(time.Now().Add(s.conf.ReadDeadline)); err != nil {
+				s.log.Warn("Failed to set read deadline for incoming connection", "deadline", s.conf.ReadDeadline, "err", err)
+			}
+		}
+
+		rw := s.handleNewRequest(c)
+		if c.hijacked {
+			// The handler is finished with the connection, but it is up to
+			// the handler to close it.
+			if rw.stream.err != nil {
+				s.log.Warn("Handler failed after taking over connection", "err", rw.stream.err)
+			}
+			return
+		}
+		if s.inShutdown.Load() && !rw.streaming() {
+			rw.headers.Set("Connection", "close")
+		}
+		if err := rw.writeTo(c.bw); err != nil {
+			s.log.Error("Failed to respond to client", "err", err)
+			return
+		}
+		if rw.closesConnection() || s.inShutdown.Load() {
+			return
+		}
 	}
 }
 
-// Parses the raw request received from a connection and transforms it into a
-// response. Handles the bulk of the server logic, such as routing, middleware
-// and error handling.
-func (s *Server) handleNewRequest(raw []byte, addr net.Addr, tls *tls.ConnectionState) (rw *ResponseWriter) {
-	ctx, cancel := context.WithTimeout(context.Background(), s.conf.WriteDeadline)
-	defer cancel()
+// Reports whether a plain text connection starts with the HTTP/2 preface, which
+// is how clients that already know the server supports HTTP/2 skip HTTP/1.1
+// altogether (RFC-9113 Sec 3.3). No HTTP/1.1 request starts with "PRI", so we
+// only wait for the rest of the preface when the connection does.
+func (s *Server) hasHttp2Preface(c *conn) bool {
+	if b, err := c.br.Peek(3); err != nil || string(b) != http2.Preface[:3] {
+		return false
+	}
+	b, err := c.br.Peek(len(http2.Preface))
+	return err == nil && string(b) == http2.Preface
+}
 
-	req, httpErr := requestFromRaw(raw, s.conf.RequestSize, ctx)
+// Reads the next request received on a connection and transforms it into a
+// response, setting the response up to be streamed or taken over through the
+// connection.
+func (s *Server) handleNewRequest(c *conn) (rw *ResponseWriter) {
+	// The write deadline is enforced through a timer rather than a context
+	// deadline, since the timer is lifted once a response starts streaming.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	deadline := time.Now().Add(s.conf.WriteDeadline)
+	timer := time.AfterFunc(s.conf.WriteDeadline, func() { cancel(context.DeadlineExceeded) })
+	defer timer.Stop()
+
+	limits := requestLimits{maxHeaderSize: s.conf.MaxHeaderSize, maxBodySize: s.conf.RequestSize}
+	req, httpErr := readRequest(c.br, limits, ctx)
 	if httpErr != nil {
 		rw := newResponse()
 		httpErr.toResponse(rw)
+		// We cannot reliably tell where a malformed request ends, so the
+		// connection cannot safely be reused for any subsequent requests.
+		rw.headers.Set("Connection", "close")
 		return rw
 	}
 
-	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
-		req.ip = tcpAddr.IP.String()
-	} else {
-		req.ip = addr.String()
+	s.setConnDetails(req, c.addr, c.tlsState)
+
+	// The request's context is cancelled if the client goes away before we
+	// have responded, so long-running handlers can stop early.
+	stopWatching := c.watchForClose(func() { cancel(errClientDisconnected) })
+	defer stopWatching()
+	if req.pending != nil && c.rwc != nil {
+		req.pending.beforeRead = func() error {
+			// The body is read from the connection from here on, so it can no
+			// longer be watched, and the client has as long to send the body
+			// as it had to send the rest of the request.
+			stopWatching()
+			if err := c.rwc.SetReadDeadline(time.Now().Add(s.conf.ReadDeadline)); err != nil {
+				return err
+			}
+			// The interim response bypasses the connection's buffered writer,
+			// which the server may be using to respond if the handler has
+			// run past the write deadline. The writer is always flushed after
+			// a response, so nothing can be waiting in it to be sent first.
+			_, err := deadlineWriter{c.rwc, s.conf.WriteDeadline}.Write([]byte("HTTP/1.1 100 Continue\r\n\r\n"))
+			return err
+		}
 	}
-	req.tlsState = tls
 
+	rw = newResponseForMethod(req.mthd)
+	rw.stream = responseStream{
+		bw:      c.bw,
+		discard: req.mthd == HEAD,
+		start: func(ct ContentType) error {
+			if s.conf.StrictClientAcceptance && !req.AcceptsContentType(ct) {
+				return ErrNotAcceptable()
+			}
+			// Streamed responses may legitimately last much longer than the
+			// write deadline, so they are instead bound by the write deadline
+			// of each individual write to the connection.
+			if !timer.Stop() {
+				return context.Cause(ctx)
+			}
+			if !req.bodyConsumed() {
+				// It is too late to ask the client for the body once the
+				// response has started.
+				rw.headers.Set("Connection", "close")
+			}
+			if c.rwc == nil {
+				// Responses that are not sent over a connection, such as
+				// those made by the TestClient, have nothing else to bound
+				// them, so the client hangs up once the deadline passes.
+				time.AfterFunc(time.Until(deadline), func() { cancel(errClientDisconnected) })
+			}
+			return nil
+		},
+	}
+	if c.rwc != nil {
+		rw.stream.hijack = func() (net.Conn, *bufio.Reader, error) {
+			if !timer.Stop() {
+				return nil, nil, context.Cause(ctx)
+			}
+			stopWatching()
+			if err := c.bw.Flush(); err != nil {
+				return nil, nil, err
+			}
+			// The connection is no longer ours to time out or to close when
+			// shutting down.
+			c.rwc.SetDeadline(time.Time{})
+			c.hijacked = true
+			s.untrackConn(c)
+			return c.rwc, c.br, nil
+		}
+	}
+	// Whether the connection is closed once the response has been sent must
+	// be decided up front, since a streamed response sends its headers
+	// before the handler has finished. The client may ask for the connection
+	// to be closed once this request has been served, which we must honour
+	// and communicate in the response (RFC-9112 Sec 9.6).
+	if c.served+1 >= s.conf.MaxRequestsPerConnection || req.headers.headers.ContainsToken("Connection", "close") {
+		rw.headers.Set("Connection", "close")
+	}
+	return s.serveRequest(ctx, req, rw)
+}
+
+// Transforms a request that has been read from the client into a response,
+// whichever version of HTTP the request arrived over. Handles the bulk of the
+// server logic, such as routing, middleware and error handling.
+func (s *Server) serveRequest(ctx context.Context, req *Request, rw *ResponseWriter) (out *ResponseWriter) {
 	var err error
 	// This comes after the parsing of the request, since the parsing cannot
 	// panic. By doing this, it means that we have access to the parsed request
@@ -235,7 +563,12 @@ func (s *Server) handleNewRequest(raw []byte, addr net.Addr, tls *tls.Connection
 		if r == nil {
 			r = err
 		}
-		rw = s.errorHandler.HandleErrors(r, rw, req)
+		out = s.errorHandler.HandleErrors(r, rw, req)
+		if !out.streaming() && !out.hijacked() && !req.bodyConsumed() {
+			// The body of the request was never asked for, so we cannot tell
+			// where the request ends and the connection cannot be reused.
+			out.headers.Set("Connection", "close")
+		}
 
 		// In some cases, the HEAD request will fail - e.g. a panic or error
 		// returned. In those cases, we still return the error response, but
@@ -243,14 +576,20 @@ func (s *Server) handleNewRequest(raw []byte, addr net.Addr, tls *tls.Connection
 		// would be had the GET request succeeded, so Content-Length and
 		// Content-Type are left untouched.
 		if req.mthd == HEAD {
-			rw.bdy = []byte{}
+			out.bdy = []byte{}
 		}
 
-		go s.log.LogRequestAndResponse(rw, req)
+		go s.log.LogRequestAndResponse(out, req)
 	}()
 
 	s.router.RewriteUri(&req.uri)
-	rw = newResponseForMethod(req.mthd)
+	// Requests are limited before any middleware runs, since the timeout
+	// middleware hands each request its own goroutine.
+	if !s.reqLimit.acquire(ctx, s.conf.OverloadQueueTimeout) {
+		err = s.errOverloaded()
+		return rw
+	}
+	defer s.reqLimit.release()
 	handler, _ := s.router.Route(req)
 	chain := s.middleware.NewChain(coreHandler(handler, s.conf.handlingConfig))
 	err = chain.Proceed(rw, req)
@@ -262,36 +601,182 @@ func (s *Server) handleNewRequest(raw []byte, addr net.Addr, tls *tls.Connection
 	return rw
 }
 
+// Approves a response to start streaming, for requests that do not arrive on
+// a HTTP/1.1 connection of our own. Once streaming, the response is no longer
+// bound by the write deadline, which is enforced by the timer.
+func (s *Server) startStream(ctx context.Context, req *Request, timer *time.Timer) func(ContentType) error {
+	return func(ct ContentType) error {
+		if s.conf.StrictClientAcceptance && !req.AcceptsContentType(ct) {
+			return ErrNotAcceptable()
+		}
+		if !timer.Stop() {
+			return context.Cause(ctx)
+		}
+		return nil
+	}
+}
+
+// Reads a request body that has been sent in full, rejecting bodies that are
+// larger than the limit without reading any further.
+func readLimitedBody(r io.Reader, limit RequestSize) ([]byte, *HttpError) {
+	body, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, httpErrorForRead(err)
+	}
+	if len(body) > int(limit) {
+		return nil, ErrContentTooLarge()
+	}
+	return body, nil
+}
+
+// Records where the request came from, which is the connection's peer unless
+// the request was forwarded by a trusted proxy.
+func (s *Server) setConnDetails(req *Request, addr net.Addr, tlsState *tls.ConnectionState) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		req.ip = tcpAddr.IP.String()
+	} else {
+		req.ip = addr.String()
+	}
+	req.peer = req.ip
+	req.tlsState = tlsState
+	req.scheme = "http"
+	if tlsState != nil {
+		req.scheme = "https"
+	}
+	if len(s.conf.TrustedProxies) != 0 {
+		req.applyForwarded(addr, s.conf.TrustedProxies)
+	}
+}
+
 // Configures the socket that the server will use to listen for and respond to
 // requests.
 func (s *Server) configureSocket(conf httpsConfig) {
+	hasHttp := s.conf.HttpPort != 0 || s.conf.HttpAddr != "" || s.conf.HttpListener != nil
+	var inherited []socket.InheritedListener
+	if s.conf.InheritListeners {
+		ls, err := socket.Inherited()
+		if err != nil {
+			panic(fmt.Errorf("failed to inherit listeners: %w", err))
+		}
+		inherited = ls
+	}
+
+	var proxy *socket.ProxyProtocol
+	if len(s.conf.ProxyProtocolTrusted) != 0 {
+		proxy = &socket.ProxyProtocol{Trusted: s.conf.ProxyProtocolTrusted, Timeout: s.conf.ReadDeadline}
+	}
+
+	httpEp := endpointFor(s.conf.HttpPort, s.conf.HttpAddr, s.conf.HttpListener)
+	if ln := pickInherited(inherited, "http", 0); ln != nil {
+		httpEp = socket.ListenerEndpoint(ln)
+	}
+	httpEp.Proxy = proxy
 	if conf.TlsConfig == nil {
-		s.sock = socket.NewTcpSocket(s.conf.HttpPort)
+		s.sock = socket.NewTcpSocket(httpEp)
+		s.sockNames = []string{"http"}
 		return
 	}
 
-	// We only support HTTP/1.1 so we override any settings the user has
-	// provided to ensure we can respond to the client properly.
+	// We override any protocols the user has provided to ensure we can
+	// respond to the client properly, preferring HTTP/2 when it is enabled.
 	tlsConf := conf.TlsConfig.Clone()
 	tlsConf.NextProtos = []string{"http/1.1"}
+	if s.conf.EnableHttp2 {
+		tlsConf.NextProtos = []string{"h2", "http/1.1"}
+	}
+	httpsEp := endpointFor(s.conf.HttpsPort, s.conf.HttpsAddr, s.conf.HttpsListener)
 
-	if s.conf.HttpPort == 0 {
-		// By construction, we know the HTTPS port is non-zero and the HTTP
-		// port is 0, so we only want to listen for HTTPS messages.
-		s.sock = socket.NewTlsSocket(s.conf.HttpsPort, tlsConf)
+	if !hasHttp {
+		// By construction, we know the HTTPS endpoint is set and the HTTP
+		// endpoint is not, so we only want to listen for HTTPS messages.
+		if ln := pickInherited(inherited, "https", 0); ln != nil {
+			httpsEp = socket.ListenerEndpoint(ln)
+		}
+		httpsEp.Proxy = proxy
+		s.sock = socket.NewTlsSocket(httpsEp, tlsConf)
+		s.sockNames = []string{"https"}
+		return
 	}
 
-	s.sock = socket.NewCombinedSocket(s.conf.HttpPort, s.conf.HttpsPort, tlsConf)
+	if ln := pickInherited(inherited, "https", 1); ln != nil {
+		httpsEp = socket.ListenerEndpoint(ln)
+	}
+	httpsEp.Proxy = proxy
+	s.sock = socket.NewCombinedSocket(httpEp, httpsEp, tlsConf)
+	s.sockNames = []string{"http", "https"}
 	if conf.UpgradeToHttps {
+		port, ok := redirectPort(httpsEp)
+		if !ok {
+			panic("cannot upgrade to https without a fixed https port")
+		}
 		s.RegisterMiddleware(
-			upgradeToHttpsMiddleware(s.conf.HttpsPort, conf.UpgradeInstructionMaxAge),
+			upgradeToHttpsMiddleware(port, conf.UpgradeInstructionMaxAge),
 		)
 	}
 }
 
+// Picks the endpoint to listen on out of the listener, address and port, only
+// one of which is set by the time the config has been internalised.
+func endpointFor(port uint16, addr string, ln net.Listener) socket.Endpoint {
+	switch {
+	case ln != nil:
+		return socket.ListenerEndpoint(ln)
+	case addr != "":
+		return socket.AddressEndpoint(addr)
+	default:
+		return socket.PortEndpoint(port)
+	}
+}
+
+// Picks the inherited listener to use for a protocol. Listeners are matched by
+// name if the parent process named any of them after a protocol, and by
+// position otherwise.
+func pickInherited(ls []socket.InheritedListener, name string, index int) net.Listener {
+	named := false
+	for _, l := range ls {
+		if l.Name == name {
+			return l.Listener
+		}
+		named = named || l.Name == "http" || l.Name == "https"
+	}
+	if named || index >= len(ls) {
+		return nil
+	}
+	return ls[index].Listener
+}
+
+// Finds the port that clients are redirected to when upgrading to HTTPS. This
+// must be known before the server starts, so is only available if the HTTPS
+// endpoint is a TCP endpoint that does not leave the kernel to choose the
+// port.
+func redirectPort(ep socket.Endpoint) (uint16, bool) {
+	if ep.Listener != nil {
+		addr, ok := ep.Listener.Addr().(*net.TCPAddr)
+		if !ok {
+			return 0, false
+		}
+		return uint16(addr.Port), addr.Port != 0
+	}
+	if ep.Network != "tcp" {
+		return 0, false
+	}
+	_, p, err := net.SplitHostPort(ep.Address)
+	if err != nil {
+		return 0, false
+	}
+	port, err := net.LookupPort("tcp", p)
+	return uint16(port), err == nil && port != 0
+}
+
 // This is the outermost piece of middleware and ensures that the request does
-// not exceed the write timeout described by the server's configuration.
+// not exceed the write timeout described by the server's configuration. The
+// timeout no longer applies once the response has started streaming or the
+// handler has taken over the connection.
 func (s *Server) timeoutMiddleware(c Chain, rw *ResponseWriter, req *Request) error {
+	// Handlers may derive a new context for the request, such as with
+	// [Request.NewContextValue], so we hold on to the one the request started
+	// with rather than reading the request's context while they run.
+	ctx := req.ctx
 	done := make(chan any, 1)
 	go func() {
 		defer func() {
@@ -302,21 +787,30 @@ func (s *Server) timeoutMiddleware(c Chain, rw *ResponseWriter, req *Request) er
 		done <- c.Proceed(rw, req)
 	}()
 
+	var result any
 	select {
-	case result := <-done:
-		switch x := result.(type) {
-		case error:
-			return x
-		case nil:
-		default:
-			// This will be caught by the server and passed through the error
-			// handling pipeline. We don't know what type of panic this is and
-			// we (likely) did not cause it, so we don't coerce it to any other
-			// type here.
-			panic(x)
+	case result = <-done:
+	case <-ctx.Done():
+		cause := context.Cause(ctx)
+		if !errors.Is(cause, errClientDisconnected) {
+			return cause
 		}
-	case <-req.ctx.Done():
-		return req.ctx.Err()
+		// There is nobody left to respond to once the client has gone away,
+		// but the handler may still be using the response, so we wait for it
+		// to notice the cancelled context and return.
+		result = <-done
+	}
+
+	switch x := result.(type) {
+	case error:
+		return x
+	case nil:
+	default:
+		// This will be caught by the server and passed through the error
+		// handling pipeline. We don't know what type of panic this is and
+		// we (likely) did not cause it, so we don't coerce it to any other
+		// type here.
+		panic(x)
 	}
 	return nil
 }
@@ -349,6 +843,121 @@ func (s *Server) configureRewrites(rewritePath string) {
 	}
 }
 
+// Turns away a connection because the server is already serving as many
+// connections as it is allowed to. Plain text clients are sent a 503 without
+// their request being parsed, after which at most rejectReadLimit bytes of it
+// are drained so the response is not lost when the connection is closed. The
+// connection is closed within rejectTimeout, so a flood of connections cannot
+// tie the server up.
+func (s *Server) rejectConnection(rwc net.Conn) {
+	defer rwc.Close()
+	s.log.Warn("Rejecting connection, server is at capacity", "max_connections", s.conf.MaxConnections)
+	// Telling a TLS client that the server is busy would mean completing the
+	// handshake, which is the most expensive part of the connection, so they
+	// are turned away without a response.
+	if _, ok := rwc.(*tls.Conn); ok {
+		return
+	}
+	if err := rwc.SetDeadline(time.Now().Add(min(s.conf.ReadDeadline, rejectTimeout))); err != nil {
+		return
+	}
+	rw := newResponse()
+	s.errOverloaded().toResponse(rw)
+	rw.headers.Set("Connection", "close")
+	if err := rw.writeTo(bufio.NewWriter(rwc)); err != nil {
+		return
+	}
+	// The request is never parsed, but closing the connection while some of
+	// it is still unread can reset the connection before the client sees the
+	// response. A small amount is read and discarded to give the client the
+	// chance to read the response, without ever reading a request body.
+	if cw, ok := rwc.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	io.CopyN(io.Discard, rwc, int64(rejectReadLimit))
+}
+
+// Registers a newly accepted connection with the server, reporting false if
+// the server is shutting down and the connection should not be served.
+func (s *Server) trackConn(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inShutdown.Load() {
+		return false
+	}
+	s.conns[c] = struct{}{}
+	return true
+}
+
+func (s *Server) untrackConn(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+// Marks a connection as idle (waiting for the next request) or active
+// (serving a request). Reports false if the connection has been closed for
+// shutdown and should not be used any further.
+func (s *Server) setIdle(c *conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.closing {
+		return false
+	}
+	if idle && s.inShutdown.Load() {
+		return false
+	}
+	c.idle = idle
+	return true
+}
+
+// Closes every connection that is waiting for its next request, reporting
+// whether there are no connections left open.
+func (s *Server) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		switch {
+		case c.closing:
+		case c.h2 != nil:
+			// HTTP/2 connections may have many requests in flight, so the
+			// client is told not to start any more and the connection closes
+			// itself once the rest have been served. This cannot be done
+			// while holding the mutex, which the connection uses to report
+			// whether it is idle.
+			c.closing = true
+			go c.h2.Shutdown()
+		case c.idle:
+			c.closing = true
+			c.rwc.Close()
+		}
+	}
+	return len(s.conns) == 0
+}
+
+func (s *Server) closeAllConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.closing = true
+		c.rwc.Close()
+	}
+}
+
+// The cause of a request's context being cancelled when the client closes the
+// connection before the response has been sent.
+var errClientDisconnected = fmt.Errorf("client disconnected: %w", context.Canceled)
+
+// Reports whether the error returned from reading an idle connection is due to
+// the client closing the connection or the idle timeout being reached.
+func isIdleClose(err error) bool {
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 func (s *Server) panicIfStarted(action string) {
 	if s.started.Load() {
 		panic(fmt.Errorf("cannot %s after starting the server", action))
@@ -1,9 +1,27 @@
 package routeit
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -14,10 +32,15 @@ func TestNewServer(t *testing.T) {
 		// the global namespace, so it should be empty by default. The trie
 		// structure will handle the routing beyond that.
 		defaultConf := serverConfig{
-			HttpPort:      8080,
-			RequestSize:   KiB,
-			ReadDeadline:  10 * time.Second,
-			WriteDeadline: 10 * time.Second,
+			HttpPort:                 8080,
+			RequestSize:              KiB,
+			MaxHeaderSize:            32 * KiB,
+			ReadDeadline:             10 * time.Second,
+			WriteDeadline:            10 * time.Second,
+			IdleTimeout:              10 * time.Second,
+			MaxRequestsPerConnection: 1000,
+			MaxConcurrentStreams:     100,
+			OverloadRetryAfter:       time.Second,
 		}
 		tests := []struct {
 			name string
@@ -88,6 +111,27 @@ func TestNewServer(t *testing.T) {
 					return s
 				},
 			},
+			{
+				name: "only http address",
+				in:   ServerConfig{HttpConfig: HttpConfig{HttpAddr: "127.0.0.1:3000"}},
+				want: func(s serverConfig) serverConfig {
+					s.HttpPort = 0
+					return s
+				},
+			},
+			{
+				name: "https address and TLS config",
+				in: ServerConfig{
+					HttpConfig: HttpConfig{
+						HttpsAddr: "/run/app.sock",
+						TlsConfig: &tls.Config{},
+					},
+				},
+				want: func(s serverConfig) serverConfig {
+					s.HttpPort = 0
+					return s
+				},
+			},
 			{
 				name: "only request buffer size",
 				in:   ServerConfig{RequestSize: 3 * MiB},
@@ -96,11 +140,53 @@ func TestNewServer(t *testing.T) {
 					return s
 				},
 			},
+			{
+				name: "only max header size",
+				in:   ServerConfig{MaxHeaderSize: 8 * KiB},
+				want: func(s serverConfig) serverConfig {
+					s.MaxHeaderSize = 8 * KiB
+					return s
+				},
+			},
 			{
 				name: "only read deadline",
 				in:   ServerConfig{ReadDeadline: 3 * time.Minute},
 				want: func(s serverConfig) serverConfig {
 					s.ReadDeadline = 3 * time.Minute
+					s.IdleTimeout = 3 * time.Minute
+					return s
+				},
+			},
+			{
+				name: "only idle timeout",
+				in:   ServerConfig{IdleTimeout: time.Minute},
+				want: func(s serverConfig) serverConfig {
+					s.IdleTimeout = time.Minute
+					return s
+				},
+			},
+			{
+				name: "only max requests per connection",
+				in:   ServerConfig{MaxRequestsPerConnection: 5},
+				want: func(s serverConfig) serverConfig {
+					s.MaxRequestsPerConnection = 5
+					return s
+				},
+			},
+			{
+				name: "http/2 with max concurrent streams",
+				in:   ServerConfig{MaxConcurrentStreams: 10, HttpConfig: HttpConfig{EnableHttp2: true}},
+				want: func(s serverConfig) serverConfig {
+					s.EnableHttp2 = true
+					s.MaxConcurrentStreams = 10
+					return s
+				},
+			},
+			{
+				name: "only overload retry after",
+				in:   ServerConfig{OverloadRetryAfter: time.Minute},
+				want: func(s serverConfig) serverConfig {
+					s.OverloadRetryAfter = time.Minute
 					return s
 				},
 			},
@@ -120,6 +206,31 @@ func TestNewServer(t *testing.T) {
 					return s
 				},
 			},
+			{
+				name: "proxy protocol trusted ranges",
+				in: ServerConfig{HttpConfig: HttpConfig{
+					ProxyProtocolTrustedRanges: []string{"10.1.2.3/8", "192.0.2.1", "2001:db8::/32"},
+				}},
+				want: func(s serverConfig) serverConfig {
+					s.ProxyProtocolTrusted = []netip.Prefix{
+						netip.MustParsePrefix("10.0.0.0/8"),
+						netip.MustParsePrefix("192.0.2.1/32"),
+						netip.MustParsePrefix("2001:db8::/32"),
+					}
+					return s
+				},
+			},
+			{
+				name: "trusted proxies",
+				in:   ServerConfig{TrustedProxies: []string{"10.0.0.0/8", "2001:db8::1"}},
+				want: func(s serverConfig) serverConfig {
+					s.TrustedProxies = []netip.Prefix{
+						netip.MustParsePrefix("10.0.0.0/8"),
+						netip.MustParsePrefix("2001:db8::1/128"),
+					}
+					return s
+				},
+			},
 			{
 				name: "only debug",
 				in:   ServerConfig{Debug: true},
@@ -144,15 +255,39 @@ func TestNewServer(t *testing.T) {
 				if s.conf.RequestSize != want.RequestSize {
 					t.Errorf(`default request buffer size = %d, want %d`, s.conf.RequestSize, want.RequestSize)
 				}
+				if s.conf.MaxHeaderSize != want.MaxHeaderSize {
+					t.Errorf(`default max header size = %d, want %d`, s.conf.MaxHeaderSize, want.MaxHeaderSize)
+				}
 				if s.conf.ReadDeadline != want.ReadDeadline {
 					t.Errorf(`default read timeout = %d, want %d`, s.conf.ReadDeadline, want.ReadDeadline)
 				}
 				if s.conf.WriteDeadline != want.WriteDeadline {
 					t.Errorf(`default write timeout = %d, want %d`, s.conf.WriteDeadline, want.WriteDeadline)
 				}
+				if s.conf.IdleTimeout != want.IdleTimeout {
+					t.Errorf(`default idle timeout = %d, want %d`, s.conf.IdleTimeout, want.IdleTimeout)
+				}
+				if s.conf.MaxRequestsPerConnection != want.MaxRequestsPerConnection {
+					t.Errorf(`default max requests per connection = %d, want %d`, s.conf.MaxRequestsPerConnection, want.MaxRequestsPerConnection)
+				}
+				if s.conf.EnableHttp2 != want.EnableHttp2 {
+					t.Errorf(`EnableHttp2 = %t, want %t`, s.conf.EnableHttp2, want.EnableHttp2)
+				}
+				if s.conf.MaxConcurrentStreams != want.MaxConcurrentStreams {
+					t.Errorf(`default max concurrent streams = %d, want %d`, s.conf.MaxConcurrentStreams, want.MaxConcurrentStreams)
+				}
+				if s.conf.OverloadRetryAfter != want.OverloadRetryAfter {
+					t.Errorf(`default overload retry after = %d, want %d`, s.conf.OverloadRetryAfter, want.OverloadRetryAfter)
+				}
 				if s.conf.Namespace != want.Namespace {
 					t.Errorf(`default namespace = %#q, want %#q`, s.conf.Namespace, want.Namespace)
 				}
+				if !slices.Equal(s.conf.ProxyProtocolTrusted, want.ProxyProtocolTrusted) {
+					t.Errorf(`proxy protocol trusted = %v, want %v`, s.conf.ProxyProtocolTrusted, want.ProxyProtocolTrusted)
+				}
+				if !slices.Equal(s.conf.TrustedProxies, want.TrustedProxies) {
+					t.Errorf(`trusted proxies = %v, want %v`, s.conf.TrustedProxies, want.TrustedProxies)
+				}
 				if s.conf.Debug != want.Debug {
 					t.Errorf("Debug = %t, wanted %t", s.conf.Debug, want.Debug)
 				}
@@ -176,10 +311,57 @@ func TestNewServer(t *testing.T) {
 				name: "https port provided but no TLS config",
 				conf: ServerConfig{HttpConfig: HttpConfig{HttpsPort: 443}},
 			},
+			{
+				name: "client certificates but no TLS config",
+				conf: ServerConfig{HttpConfig: HttpConfig{ClientCerts: &ClientCertConfig{}}},
+			},
+			{
+				name: "missing client CA file",
+				conf: ServerConfig{HttpConfig: HttpConfig{
+					TlsConfig:   &tls.Config{},
+					ClientCerts: &ClientCertConfig{CAFiles: []string{"missing.crt"}},
+				}},
+			},
 			{
 				name: "upgrade to HTTPS but not TLS config",
 				conf: ServerConfig{HttpConfig: HttpConfig{UpgradeToHttps: true}},
 			},
+			{
+				name: "https address provided but no TLS config",
+				conf: ServerConfig{HttpConfig: HttpConfig{HttpsAddr: ":443"}},
+			},
+			{
+				name: "http port and address",
+				conf: ServerConfig{HttpConfig: HttpConfig{HttpPort: 8080, HttpAddr: ":8080"}},
+			},
+			{
+				name: "https address and listener",
+				conf: ServerConfig{
+					HttpConfig: HttpConfig{
+						HttpsAddr:     ":443",
+						HttpsListener: &net.TCPListener{},
+						TlsConfig:     &tls.Config{},
+					},
+				},
+			},
+			{
+				name: "invalid proxy protocol trusted range",
+				conf: ServerConfig{HttpConfig: HttpConfig{ProxyProtocolTrustedRanges: []string{"10.0.0.0/33"}}},
+			},
+			{
+				name: "invalid trusted proxy",
+				conf: ServerConfig{TrustedProxies: []string{"not-an-address"}},
+			},
+			{
+				name: "upgrade to HTTPS over a unix domain socket",
+				conf: ServerConfig{
+					HttpConfig: HttpConfig{
+						HttpsAddr:      "/run/app.sock",
+						TlsConfig:      &tls.Config{},
+						UpgradeToHttps: true,
+					},
+				},
+			},
 		}
 
 		for _, tc := range tests {
@@ -239,3 +421,795 @@ func TestAtomicity(t *testing.T) {
 		}
 	})
 }
+
+func TestHandleNewConnection(t *testing.T) {
+	newConnection := func(t *testing.T, conf ServerConfig) (net.Conn, *bufio.Reader, chan struct{}) {
+		t.Helper()
+		conf.Debug = true
+		conf.LoggingHandler = slog.DiscardHandler
+		srv := NewServer(conf)
+		srv.RegisterRoutes(RouteRegistry{
+			"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+				rw.Text("Hello!")
+				return nil
+			}),
+			"/echo": Post(func(rw *ResponseWriter, req *Request) error {
+				body, err := req.BodyFromText()
+				rw.Text(body)
+				return err
+			}),
+		})
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		done := make(chan struct{})
+		go func() {
+			srv.handleNewConnection(server)
+			close(done)
+		}()
+		return client, bufio.NewReader(client), done
+	}
+	send := func(t *testing.T, conn net.Conn, br *bufio.Reader, raw string) *http.Response {
+		t.Helper()
+		if _, err := conn.Write([]byte(raw)); err != nil {
+			t.Fatalf("failed to write request: %v", err)
+		}
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if _, err := io.ReadAll(res.Body); err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		return res
+	}
+	expectClosed := func(t *testing.T, br *bufio.Reader, done chan struct{}) {
+		t.Helper()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected connection to be closed by the server")
+		}
+		if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+			t.Errorf(`ReadByte() error = %v, wanted EOF`, err)
+		}
+	}
+	get := "GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"
+
+	t.Run("keeps connection open between requests", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{})
+
+		for range 3 {
+			res := send(t, conn, br, get)
+
+			if res.StatusCode != 200 {
+				t.Errorf(`status = %d, wanted 200`, res.StatusCode)
+			}
+			if res.Close {
+				t.Error("did not expect server to close the connection")
+			}
+		}
+		select {
+		case <-done:
+			t.Error("expected connection to still be open")
+		default:
+		}
+	})
+
+	t.Run("honours Connection: close", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{})
+
+		res := send(t, conn, br, "GET /hello HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+		if !res.Close {
+			t.Error("expected response to include Connection: close")
+		}
+		expectClosed(t, br, done)
+	})
+
+	t.Run("closes after max requests per connection", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{MaxRequestsPerConnection: 2})
+
+		if res := send(t, conn, br, get); res.Close {
+			t.Error("did not expect first response to close the connection")
+		}
+		if res := send(t, conn, br, get); !res.Close {
+			t.Error("expected second response to close the connection")
+		}
+		expectClosed(t, br, done)
+	})
+
+	t.Run("closes after malformed request", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{})
+
+		res := send(t, conn, br, "GET /hello bad HTTP/1.1\r\nHost: localhost\r\n\r\n")
+
+		if res.StatusCode != 400 {
+			t.Errorf(`status = %d, wanted 400`, res.StatusCode)
+		}
+		if !res.Close {
+			t.Error("expected response to include Connection: close")
+		}
+		expectClosed(t, br, done)
+	})
+
+	t.Run("reads request split across multiple writes", func(t *testing.T) {
+		conn, br, _ := newConnection(t, ServerConfig{RequestSize: 8 * KiB})
+		body := strings.Repeat("a", 4*1024)
+		head := fmt.Sprintf("POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n\r\n", len(body))
+		go func() {
+			for _, part := range []string{head[:10], head[10:], body[:100], body[100:]} {
+				conn.Write([]byte(part))
+			}
+		}()
+
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		got, _ := io.ReadAll(res.Body)
+
+		if res.StatusCode != 201 {
+			t.Errorf(`status = %d, wanted 201`, res.StatusCode)
+		}
+		if string(got) != body {
+			t.Errorf(`len(body) = %d, wanted %d`, len(got), len(body))
+		}
+	})
+
+	t.Run("responds to pipelined requests in order", func(t *testing.T) {
+		conn, br, _ := newConnection(t, ServerConfig{})
+		go conn.Write([]byte(get + "POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nworld"))
+
+		for _, want := range []string{"Hello!", "world"} {
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			got, _ := io.ReadAll(res.Body)
+			if string(got) != want {
+				t.Errorf(`body = %#q, wanted %#q`, got, want)
+			}
+		}
+	})
+
+	t.Run("reads chunked request body", func(t *testing.T) {
+		conn, br, _ := newConnection(t, ServerConfig{})
+		chunked := "POST /echo HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n"
+		go conn.Write([]byte(chunked + get))
+
+		for _, want := range []string{"hello world", "Hello!"} {
+			res, err := http.ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("failed to read response: %v", err)
+			}
+			got, _ := io.ReadAll(res.Body)
+			if string(got) != want {
+				t.Errorf(`body = %#q, wanted %#q`, got, want)
+			}
+		}
+	})
+
+	t.Run("times out incomplete request", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{ReadDeadline: 20 * time.Millisecond})
+		go conn.Write([]byte("GET /hello HTTP/1.1\r\n"))
+
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		io.ReadAll(res.Body)
+
+		if res.StatusCode != 408 {
+			t.Errorf(`status = %d, wanted 408`, res.StatusCode)
+		}
+		expectClosed(t, br, done)
+	})
+
+	t.Run("closes idle connection", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{IdleTimeout: 10 * time.Millisecond})
+
+		send(t, conn, br, get)
+
+		expectClosed(t, br, done)
+	})
+
+	t.Run("closes when client closes", func(t *testing.T) {
+		conn, br, done := newConnection(t, ServerConfig{})
+
+		send(t, conn, br, get)
+		conn.Close()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected server to stop serving the connection")
+		}
+	})
+}
+
+func TestExpectContinue(t *testing.T) {
+	newConnection := func(t *testing.T) (net.Conn, *bufio.Reader, chan struct{}) {
+		t.Helper()
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterMiddleware(func(c Chain, rw *ResponseWriter, req *Request) error {
+			if _, found := req.Headers().First("X-Reject"); found {
+				return ErrUnauthorized()
+			}
+			return c.Proceed(rw, req)
+		})
+		srv.RegisterRoutes(RouteRegistry{
+			"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+				rw.Text("Hello!")
+				return nil
+			}),
+			"/echo": Post(func(rw *ResponseWriter, req *Request) error {
+				body, err := req.BodyFromText()
+				rw.Text(body)
+				return err
+			}),
+		})
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		done := make(chan struct{})
+		go func() {
+			srv.handleNewConnection(server)
+			close(done)
+		}()
+		return client, bufio.NewReader(client), done
+	}
+	read := func(t *testing.T, br *bufio.Reader) (*http.Response, string) {
+		t.Helper()
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		body, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		return res, string(body)
+	}
+	head := func(path string, h ...string) string {
+		return "POST " + path + " HTTP/1.1\r\nHost: localhost\r\nExpect: 100-continue\r\n" +
+			"Content-Type: text/plain\r\nContent-Length: 5\r\n" + strings.Join(h, "") + "\r\n"
+	}
+
+	t.Run("asks for body once request is accepted", func(t *testing.T) {
+		conn, br, _ := newConnection(t)
+		go conn.Write([]byte(head("/echo")))
+
+		interim, _ := read(t, br)
+		if interim.StatusCode != 100 {
+			t.Fatalf(`status = %d, wanted 100`, interim.StatusCode)
+		}
+		go conn.Write([]byte("hello"))
+		res, body := read(t, br)
+
+		if res.StatusCode != 201 {
+			t.Errorf(`status = %d, wanted 201`, res.StatusCode)
+		}
+		if body != "hello" {
+			t.Errorf(`body = %#q, wanted "hello"`, body)
+		}
+		if res.Close {
+			t.Error("did not expect server to close the connection")
+		}
+		go conn.Write([]byte("GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		if next, _ := read(t, br); next.StatusCode != 200 {
+			t.Errorf(`next status = %d, wanted 200`, next.StatusCode)
+		}
+	})
+
+	tests := []struct {
+		name       string
+		raw        string
+		wantStatus int
+	}{
+		{
+			name:       "rejected by middleware",
+			raw:        head("/echo", "X-Reject: yes\r\n"),
+			wantStatus: 401,
+		},
+		{
+			name:       "route does not exist",
+			raw:        head("/missing"),
+			wantStatus: 404,
+		},
+		{
+			name:       "method not allowed",
+			raw:        head("/hello"),
+			wantStatus: 405,
+		},
+		{
+			name:       "unsupported expectation",
+			raw:        strings.Replace(head("/echo"), "100-continue", "200-ok", 1),
+			wantStatus: 417,
+		},
+	}
+	for _, tc := range tests {
+		t.Run("does not ask for body when "+tc.name, func(t *testing.T) {
+			conn, br, done := newConnection(t)
+			go conn.Write([]byte(tc.raw))
+
+			res, _ := read(t, br)
+
+			if res.StatusCode != tc.wantStatus {
+				t.Errorf(`status = %d, wanted %d`, res.StatusCode, tc.wantStatus)
+			}
+			// We do not know whether the client will send the body anyway,
+			// so the connection cannot be reused.
+			if !res.Close {
+				t.Error("expected server to close the connection")
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("expected connection to be closed by the server")
+			}
+		})
+	}
+
+	t.Run("test client", func(t *testing.T) {
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{"/echo": Post(func(rw *ResponseWriter, req *Request) error {
+			body, err := req.BodyFromText()
+			rw.Text(body)
+			return err
+		})})
+
+		res := NewTestClient(srv).PostText("/echo", "hello", "Expect", "100-continue")
+
+		res.AssertStatusCode(t, StatusCreated)
+		res.AssertBodyMatchesString(t, "hello")
+	})
+}
+
+func TestListen(t *testing.T) {
+	start := func(t *testing.T, conf HttpConfig) *Server {
+		t.Helper()
+		srv := NewServer(ServerConfig{
+			Debug:          true,
+			LoggingHandler: slog.DiscardHandler,
+			HttpConfig:     conf,
+		})
+		srv.RegisterRoutes(RouteRegistry{"/hello": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Text("Hello!")
+			return nil
+		})})
+		go srv.Start()
+		t.Cleanup(func() { srv.Shutdown(context.Background()) })
+		return srv
+	}
+	client := func(addr net.Addr) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, addr.Network(), addr.String())
+				},
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+	expectHello := func(t *testing.T, addr net.Addr, scheme string) {
+		t.Helper()
+		res, err := client(addr).Get(scheme + "://localhost/hello")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if res.StatusCode != 200 || string(body) != "Hello!" {
+			t.Errorf(`response = %d %q, wanted 200 "Hello!"`, res.StatusCode, body)
+		}
+	}
+	listen := func(t *testing.T) net.Listener {
+		t.Helper()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		return ln
+	}
+
+	t.Run("ephemeral port", func(t *testing.T) {
+		srv := start(t, HttpConfig{HttpAddr: "127.0.0.1:0"})
+
+		addrs := awaitAddrs(t, srv)
+
+		if len(addrs) != 1 {
+			t.Fatalf(`len(Addrs()) = %d, wanted 1`, len(addrs))
+		}
+		if port := addrs[0].(*net.TCPAddr).Port; port == 0 {
+			t.Error("expected the kernel to have chosen a port")
+		}
+		expectHello(t, addrs[0], "http")
+	})
+
+	t.Run("logs listener addresses", func(t *testing.T) {
+		var logs lockedBuffer
+		srv := NewServer(ServerConfig{
+			LoggingHandler: slog.NewTextHandler(&logs, nil),
+			HttpConfig:     HttpConfig{HttpAddr: "127.0.0.1:0"},
+		})
+		go srv.Start()
+		t.Cleanup(func() { srv.Shutdown(context.Background()) })
+
+		addrs := awaitAddrs(t, srv)
+
+		want := fmt.Sprintf("msg=Listening listener=http network=tcp addr=%s", addrs[0])
+		deadline := time.Now().Add(time.Second)
+		for !strings.Contains(logs.String(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf(`logs = %q, wanted them to contain %q`, logs.String(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	t.Run("unix domain socket", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "app.sock")
+		srv := start(t, HttpConfig{HttpAddr: path})
+
+		addrs := awaitAddrs(t, srv)
+
+		if addrs[0].Network() != "unix" || addrs[0].String() != path {
+			t.Errorf(`addr = %s %s, wanted unix %s`, addrs[0].Network(), addrs[0], path)
+		}
+		expectHello(t, addrs[0], "http")
+	})
+
+	t.Run("existing listener", func(t *testing.T) {
+		ln := listen(t)
+		srv := start(t, HttpConfig{HttpListener: ln})
+
+		addrs := awaitAddrs(t, srv)
+
+		if addrs[0].String() != ln.Addr().String() {
+			t.Errorf(`addr = %s, wanted %s`, addrs[0], ln.Addr())
+		}
+		expectHello(t, addrs[0], "http")
+	})
+
+	t.Run("only https", func(t *testing.T) {
+		srv := start(t, HttpConfig{HttpsAddr: "127.0.0.1:0", TlsConfig: newTestTlsConfig(t)})
+
+		addrs := awaitAddrs(t, srv)
+
+		if len(addrs) != 1 {
+			t.Fatalf(`len(Addrs()) = %d, wanted only the https address`, len(addrs))
+		}
+		expectHello(t, addrs[0], "https")
+	})
+
+	t.Run("https listener upgrades to its port", func(t *testing.T) {
+		ln := listen(t)
+		srv := start(t, HttpConfig{
+			HttpAddr:       "127.0.0.1:0",
+			HttpsListener:  ln,
+			TlsConfig:      newTestTlsConfig(t),
+			UpgradeToHttps: true,
+		})
+
+		addrs := awaitAddrs(t, srv)
+
+		if len(addrs) != 2 || addrs[1].String() != ln.Addr().String() {
+			t.Fatalf(`Addrs() = %v, wanted the https listener second`, addrs)
+		}
+		res, err := client(addrs[0]).Get("http://localhost/hello")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		res.Body.Close()
+		want := fmt.Sprintf("https://localhost:%d/hello", ln.Addr().(*net.TCPAddr).Port)
+		if got := res.Header.Get("Location"); got != want {
+			t.Errorf(`Location = %#q, wanted %#q`, got, want)
+		}
+		expectHello(t, addrs[1], "https")
+	})
+
+	t.Run("certificate manager", func(t *testing.T) {
+		dir := t.TempDir()
+		cm := NewCertManager(CertManagerConfig{
+			Certificates: []CertKeyPaths{
+				writeTestCert(t, dir, "localhost", "localhost"),
+				writeTestCert(t, dir, "example", "example.com"),
+			},
+		})
+		srv := start(t, HttpConfig{HttpsAddr: "127.0.0.1:0", CertManager: cm})
+		addr := awaitAddrs(t, srv)[0]
+
+		for _, name := range []string{"localhost", "example.com"} {
+			conn, err := tls.Dial("tcp", addr.String(), &tls.Config{ServerName: name, InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			conn.Close()
+			if got := conn.ConnectionState().PeerCertificates[0].DNSNames[0]; got != name {
+				t.Errorf(`certificate for %#q = %#q, wanted %#q`, name, got, name)
+			}
+		}
+		expectHello(t, addr, "https")
+	})
+
+	t.Run("proxy protocol", func(t *testing.T) {
+		srv := NewServer(ServerConfig{
+			Debug:          true,
+			LoggingHandler: slog.DiscardHandler,
+			HttpConfig: HttpConfig{
+				HttpAddr:                   "127.0.0.1:0",
+				ProxyProtocolTrustedRanges: []string{"127.0.0.0/8"},
+			},
+		})
+		srv.RegisterRoutes(RouteRegistry{"/ip": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Text(req.ClientIP())
+			return nil
+		})})
+		go srv.Start()
+		t.Cleanup(func() { srv.Shutdown(context.Background()) })
+		addr := awaitAddrs(t, srv)[0]
+		send := func(t *testing.T, raw string) string {
+			t.Helper()
+			conn, err := net.Dial("tcp", addr.String())
+			if err != nil {
+				t.Fatalf("failed to dial: %v", err)
+			}
+			defer conn.Close()
+			conn.Write([]byte(raw))
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			res, _ := io.ReadAll(conn)
+			return string(res)
+		}
+
+		t.Run("reports client address", func(t *testing.T) {
+			res := send(t, "PROXY TCP4 192.0.2.1 127.0.0.1 56324 80\r\nGET /ip HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+			if !strings.HasPrefix(res, "HTTP/1.1 200 OK") || !strings.HasSuffix(res, "192.0.2.1") {
+				t.Errorf(`response = %q, wanted the client address from the header`, res)
+			}
+		})
+
+		t.Run("rejects missing header", func(t *testing.T) {
+			res := send(t, "GET /ip HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n")
+
+			if res != "" {
+				t.Errorf(`response = %q, wanted the connection to be closed`, res)
+			}
+		})
+	})
+
+	t.Run("not started", func(t *testing.T) {
+		srv := NewServer(ServerConfig{HttpConfig: HttpConfig{HttpAddr: "127.0.0.1:0"}})
+
+		if addrs := srv.Addrs(); addrs != nil {
+			t.Errorf(`Addrs() = %v, wanted nil`, addrs)
+		}
+	})
+}
+
+// Waits for the server to start listening, returning the addresses it is
+// listening on.
+func awaitAddrs(t *testing.T, srv *Server) []net.Addr {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if addrs := srv.Addrs(); addrs != nil {
+			return addrs
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start listening")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// A lockedBuffer collects output written from several goroutines, such as the
+// logs of a running server.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// Returns a TLS config with a self-signed certificate for localhost.
+func newTestTlsConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+func TestShutdown(t *testing.T) {
+	start := func(t *testing.T, h HandlerFunc) (*Server, string, chan error) {
+		t.Helper()
+		srv := NewServer(ServerConfig{
+			Debug:          true,
+			LoggingHandler: slog.DiscardHandler,
+			HttpConfig:     HttpConfig{HttpAddr: "127.0.0.1:0"},
+		})
+		srv.RegisterRoutes(RouteRegistry{"/hello": Get(h)})
+		started := make(chan error, 1)
+		go func() { started <- srv.Start() }()
+
+		addr := awaitAddrs(t, srv)[0].String()
+		return srv, addr, started
+	}
+	hello := func(rw *ResponseWriter, req *Request) error {
+		rw.Text("Hello!")
+		return nil
+	}
+	expectReturned := func(t *testing.T, started chan error) {
+		t.Helper()
+		select {
+		case err := <-started:
+			if err != nil {
+				t.Errorf("Start() error = %v, wanted nil", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected Start() to return after shutdown")
+		}
+	}
+
+	t.Run("waits for in-flight requests", func(t *testing.T) {
+		entered := make(chan struct{})
+		release := make(chan struct{})
+		srv, addr, started := start(t, func(rw *ResponseWriter, req *Request) error {
+			close(entered)
+			<-release
+			rw.Text("Hello!")
+			return nil
+		})
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		<-entered
+
+		shutdown := make(chan error, 1)
+		go func() { shutdown <- srv.Shutdown(context.Background()) }()
+
+		deadline := time.Now().Add(time.Second)
+		for {
+			c, err := net.Dial("tcp", addr)
+			if err != nil {
+				break
+			}
+			c.Close()
+			if time.Now().After(deadline) {
+				t.Fatal("expected server to stop accepting connections")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		select {
+		case <-shutdown:
+			t.Fatal("expected Shutdown() to wait for the in-flight request")
+		default:
+		}
+
+		close(release)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		body, _ := io.ReadAll(res.Body)
+
+		if string(body) != "Hello!" {
+			t.Errorf(`body = %#q, wanted "Hello!"`, body)
+		}
+		if !res.Close {
+			t.Error("expected response to include Connection: close")
+		}
+		if err := <-shutdown; err != nil {
+			t.Errorf("Shutdown() error = %v, wanted nil", err)
+		}
+		expectReturned(t, started)
+	})
+
+	t.Run("closes idle connections", func(t *testing.T) {
+		srv, addr, started := start(t, hello)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		br := bufio.NewReader(conn)
+		conn.Write([]byte("GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		res, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		io.ReadAll(res.Body)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown() error = %v, wanted nil", err)
+		}
+
+		if _, err := br.ReadByte(); !errors.Is(err, io.EOF) {
+			t.Errorf(`ReadByte() error = %v, wanted EOF`, err)
+		}
+		expectReturned(t, started)
+	})
+
+	t.Run("forcibly closes connections once context expires", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		entered := make(chan struct{})
+		srv, addr, started := start(t, func(rw *ResponseWriter, req *Request) error {
+			close(entered)
+			<-release
+			return nil
+		})
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET /hello HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		<-entered
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err = srv.Shutdown(ctx)
+
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Shutdown() error = %v, wanted %v", err, context.DeadlineExceeded)
+		}
+		if _, err := bufio.NewReader(conn).ReadByte(); err == nil {
+			t.Error("expected connection to be closed")
+		}
+		expectReturned(t, started)
+	})
+
+	t.Run("can be called multiple times", func(t *testing.T) {
+		srv, _, started := start(t, hello)
+
+		for range 2 {
+			if err := srv.Shutdown(context.Background()); err != nil {
+				t.Errorf("Shutdown() error = %v, wanted nil", err)
+			}
+		}
+		expectReturned(t, started)
+	})
+
+	t.Run("not started", func(t *testing.T) {
+		srv := NewServer(ServerConfig{})
+
+		if err := srv.Shutdown(context.Background()); err == nil {
+			t.Error("expected Shutdown() to return an error when not started")
+		}
+	})
+}
@@ -0,0 +1,436 @@
+package routeit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How static files treat a trailing slash at the end of the request's path.
+type TrailingSlash uint8
+
+const (
+	// Files and directories are served whether or not the path ends with a
+	// slash. This is the default.
+	TrailingSlashIgnore TrailingSlash = iota
+	// Requests for directories are redirected to the path with a trailing
+	// slash, and requests for files to the path without one, using 301: Moved
+	// Permanently. This keeps relative links within index pages working, and
+	// gives each file a single URL. Requests that have been rewritten are not
+	// redirected, since the client did not ask for the path being served.
+	TrailingSlashRedirect
+)
+
+type StaticConfig struct {
+	// The files that are served. An [embed.FS] ships the files within the
+	// binary, while [os.DirFS] serves them from disk. Files are looked up using
+	// the path of the request after the mount's prefix, so [fs.Sub] can be used
+	// to serve a subdirectory of the files, such as the directory named in a
+	// //go:embed directive. Setup will panic if no files are given.
+	Files fs.FS
+	// The name of the file served when a directory is requested, such as
+	// "index.html". Directories are not served by default.
+	Index string
+	// The file served in place of paths that do not exist, for single-page
+	// applications that route on the client, such as "index.html". It is
+	// only served to requests that explicitly accept text/html, which is the
+	// case when browsers navigate to a page, so requests for missing scripts,
+	// images or API responses still receive 404: Not Found. The name is
+	// relative to the root of Files.
+	Fallback string
+	// Lists the contents of directories that have no index file. The listing
+	// is sent as JSON to clients that explicitly accept application/json and
+	// not text/html, and as an HTML page otherwise. This is intended for
+	// internal servers, such as those serving build artefacts, since it
+	// reveals every file that is served.
+	Listing bool
+	// How requests whose path ends with a slash are treated. Defaults to
+	// [TrailingSlashIgnore].
+	TrailingSlash TrailingSlash
+	// Serves a gzip-compressed copy of a file in its place when the client
+	// accepts gzip and the copy exists alongside the file with a ".gz" suffix,
+	// such as "app.js.gz" for "app.js". The copy is sent with the content type
+	// of the original file and a Content-Encoding of gzip. The file itself must
+	// exist, since it is used to find the content type. Responses vary on the
+	// Accept-Encoding header, which caches are told through the Vary header.
+	Precompressed bool
+	// The Cache-Control header sent with each file, chosen by the first policy
+	// whose pattern matches the file's name. Files that match no policy are
+	// sent without a Cache-Control header. Setup will panic if a pattern is
+	// malformed.
+	CachePolicies []CachePolicy
+}
+
+// A CachePolicy sets the Cache-Control header of the static files that match
+// its pattern.
+type CachePolicy struct {
+	// The pattern that the name of the file is matched against, using the
+	// syntax of [path.Match]. Patterns that contain a slash are matched against
+	// the file's path relative to the root of the static files, such as
+	// "assets/*.js", while other patterns are matched against the file's name
+	// in any directory, such as "*.html". The file is the one being served,
+	// so the pattern for an index or fallback document must match its name.
+	Pattern string
+	// The value of the Cache-Control header, such as "no-cache" or "public,
+	// max-age=31536000, immutable".
+	CacheControl string
+}
+
+// A staticMount serves the files under a prefix of the server's paths.
+type staticMount struct {
+	prefix   []string
+	files    fs.FS
+	index    string
+	fallback string
+	listing  bool
+	slash    TrailingSlash
+	gzip     bool
+	policies []CachePolicy
+	handler  *Handler
+	// Set for the mount of [ServerConfig.StaticDir], whose files are served
+	// ahead of the server's routes rather than only when no route matches.
+	beforeRoutes bool
+	// The entity tags of files without a modification time, such as those
+	// embedded in the binary, which are derived from the content of the file
+	// instead. These files are assumed to never change.
+	tags sync.Map
+}
+
+// The entry of a directory listing sent as JSON.
+type staticDirEntry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified,omitzero"`
+}
+
+func newStaticMount(prefix []string, conf StaticConfig) *staticMount {
+	if conf.Files == nil {
+		panic(fmt.Sprintf("static files mounted at [/%s] must have a file system", strings.Join(prefix, "/")))
+	}
+	if conf.Index != "" && (!fs.ValidPath(conf.Index) || strings.Contains(conf.Index, "/")) {
+		panic(fmt.Sprintf("invalid static index [%s] - must be the name of a file", conf.Index))
+	}
+	fallback := strings.TrimPrefix(conf.Fallback, "/")
+	if conf.Fallback != "" && !fs.ValidPath(fallback) {
+		panic(fmt.Sprintf("invalid static fallback [%s] - must not contain back-tracking", conf.Fallback))
+	}
+	for _, p := range conf.CachePolicies {
+		if _, err := path.Match(p.Pattern, ""); err != nil || p.Pattern == "" {
+			panic(fmt.Sprintf("invalid static cache policy pattern [%s]", p.Pattern))
+		}
+	}
+	return &staticMount{
+		prefix:   prefix,
+		files:    conf.Files,
+		index:    conf.Index,
+		fallback: fallback,
+		listing:  conf.Listing,
+		slash:    conf.TrailingSlash,
+		gzip:     conf.Precompressed,
+		policies: conf.CachePolicies,
+	}
+}
+
+// Serves the file or directory named by the part of the path after the
+// mount's prefix.
+func (m *staticMount) serve(rw *ResponseWriter, req *Request, rest []string) error {
+	// Path segments can contain escaped slashes, so the name may have more
+	// elements than the path has segments. Names are not cleaned, so any
+	// attempt at back-tracking is rejected by [fs.ValidPath].
+	name := strings.Join(rest, "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) {
+		return ErrNotFound()
+	}
+
+	info, err := fs.Stat(m.files, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m.serveFallback(rw, req, err)
+	}
+	if err != nil {
+		return err
+	}
+	slash := strings.HasSuffix(req.uri.rawPath, "/")
+	if !info.IsDir() {
+		if slash && m.slash == TrailingSlashRedirect && !req.uri.rewritten {
+			return redirectPath(rw, req, strings.TrimRight(req.uri.rawPath, "/"))
+		}
+		return m.serveFile(rw, req, name)
+	}
+
+	if !slash && m.slash == TrailingSlashRedirect && !req.uri.rewritten {
+		return redirectPath(rw, req, req.uri.rawPath+"/")
+	}
+	if m.index != "" {
+		index := path.Join(name, m.index)
+		if info, err := fs.Stat(m.files, index); err == nil && !info.IsDir() {
+			return m.serveFile(rw, req, index)
+		}
+	}
+	if m.listing {
+		return m.serveListing(rw, req, name)
+	}
+	return m.serveFallback(rw, req, ErrNotFound())
+}
+
+// Serves the fallback document to browsers navigating to a page that does not
+// exist, otherwise responding with the reason the page could not be found.
+func (m *staticMount) serveFallback(rw *ResponseWriter, req *Request, err error) error {
+	if m.fallback == "" || !acceptsExplicitly(req, CTTextHtml) {
+		return err
+	}
+	return m.serveFile(rw, req, m.fallback)
+}
+
+// Lists the entries of the directory, either as JSON or as an HTML page with a
+// link to each entry.
+func (m *staticMount) serveListing(rw *ResponseWriter, req *Request, name string) error {
+	entries, err := fs.ReadDir(m.files, name)
+	if err != nil {
+		return err
+	}
+
+	if acceptsExplicitly(req, CTApplicationJson) && !acceptsExplicitly(req, CTTextHtml) {
+		listing := make([]staticDirEntry, 0, len(entries))
+		for _, e := range entries {
+			entry := staticDirEntry{Name: e.Name(), Dir: e.IsDir()}
+			if info, err := e.Info(); err == nil && !e.IsDir() {
+				entry.Size = info.Size()
+				entry.Modified = info.ModTime().UTC()
+			}
+			listing = append(listing, entry)
+		}
+		return rw.Json(listing)
+	}
+
+	// Links are absolute, so they work whether or not the request's path ends
+	// with a slash.
+	base := strings.TrimRight(req.uri.rawPath, "/") + "/"
+	title := html.EscapeString("Index of " + base)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<!DOCTYPE html>\n<html>\n<head><meta charset=\"utf-8\"><title>%s</title></head>\n<body>\n<h1>%s</h1>\n<ul>\n", title, title)
+	if name != "." {
+		fmt.Fprintf(&sb, "<li><a href=\"%s\">../</a></li>\n", html.EscapeString(base+"../"))
+	}
+	for _, e := range entries {
+		display, href := e.Name(), base+url.PathEscape(e.Name())
+		if e.IsDir() {
+			display += "/"
+			href += "/"
+		}
+		fmt.Fprintf(&sb, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(href), html.EscapeString(display))
+	}
+	sb.WriteString("</ul>\n</body>\n</html>\n")
+	rw.RawWithContentType([]byte(sb.String()), CTTextHtml.WithCharset("utf-8"))
+	return nil
+}
+
+// Serves a single file. Clients can ask for part of a file using the Range
+// header, and clients that already have the current version of a file are
+// told so without it being sent again.
+func (m *staticMount) serveFile(rw *ResponseWriter, req *Request, name string) error {
+	f, content, info, err := m.open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The headers are set before the preconditions are checked, since they
+	// are also sent with 304: Not Modified responses.
+	if cc := m.cacheControl(name); cc != "" {
+		rw.Headers().Set("Cache-Control", cc)
+	}
+
+	// The compressed copy is a different representation of the file, so it
+	// has validators of its own.
+	served, servedName, servedInfo := content, name, info
+	if m.gzip {
+		rw.Headers().Append("Vary", "Accept-Encoding")
+		if acceptsGzip(req) {
+			gz, gzContent, gzInfo, err := m.open(name + ".gz")
+			if err == nil {
+				defer gz.Close()
+				rw.Headers().Set("Content-Encoding", "gzip")
+				served, servedName, servedInfo = gzContent, name+".gz", gzInfo
+			}
+		}
+	}
+
+	// Where possible, the validators are derived from the file's metadata, so
+	// clients that already have the file are answered without reading it.
+	if modified := servedInfo.ModTime(); !modified.IsZero() {
+		rw.ETag(fmt.Sprintf("%x-%x", modified.UnixNano(), servedInfo.Size()))
+		rw.LastModified(modified)
+	} else {
+		tag, err := m.contentTag(servedName, served)
+		if err != nil {
+			return err
+		}
+		rw.ETag(tag)
+	}
+	if notModified, err := rw.CheckPreconditions(req); notModified || err != nil {
+		return err
+	}
+
+	cType, err := sniffContentType(content, 0, info.Size())
+	if err != nil {
+		return err
+	}
+	if cType.part == "text" && cType.subtype == "plain" {
+		// [net/http.DetectContentType] typically cannot infer the content type
+		// of CSS or JS files, and instead returns text/plain. Browsers will
+		// typically not trust stylesheets or scripts that have text/plain
+		// content type, so we need to adjust for the proper content type here.
+		// This works by reading the file extension, so it requires that the
+		// file extension allows for more accurate inference.
+		if strings.HasSuffix(name, ".css") {
+			cType.subtype = "css"
+		} else if strings.HasSuffix(name, ".js") {
+			cType.subtype = "javascript"
+		}
+	}
+	if _, err := served.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return rw.ServeContent(req, served, cType)
+}
+
+// Opens a regular file, returning the file so that it can be closed, along
+// with its content and metadata.
+func (m *staticMount) open(name string) (fs.File, io.ReadSeeker, fs.FileInfo, error) {
+	f, err := m.files.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = ErrNotFound()
+	}
+	var content io.ReadSeeker
+	if err == nil {
+		content, err = readSeeker(f)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	return f, content, info, nil
+}
+
+// Finds the Cache-Control header for the file from the first policy that
+// matches it.
+func (m *staticMount) cacheControl(name string) string {
+	for _, p := range m.policies {
+		target := name
+		if !strings.Contains(p.Pattern, "/") {
+			target = path.Base(name)
+		}
+		if ok, _ := path.Match(p.Pattern, target); ok {
+			return p.CacheControl
+		}
+	}
+	return ""
+}
+
+// Derives an entity tag from the content of the file, remembering it so the
+// file only needs to be read once.
+func (m *staticMount) contentTag(name string, content io.ReadSeeker) (string, error) {
+	if tag, ok := m.tags.Load(name); ok {
+		return tag.(string), nil
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	tag := fmt.Sprintf("%x", h.Sum(nil)[:12])
+	m.tags.Store(name, tag)
+	return tag, nil
+}
+
+// Files from the standard library's file systems can seek, but other file
+// systems are not required to support it, in which case the file is read into
+// memory.
+func readSeeker(f fs.File) (io.ReadSeeker, error) {
+	if rs, ok := f.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(b), nil
+}
+
+// Redirects the client to the given raw path, keeping the request's query.
+func redirectPath(rw *ResponseWriter, req *Request, rawPath string) error {
+	if rawPath == "" {
+		rawPath = "/"
+	}
+	if req.uri.rawQuery != "" {
+		rawPath += "?" + req.uri.rawQuery
+	}
+	rw.Headers().Set("Location", rawPath)
+	rw.Status(StatusMovedPermanently)
+	return nil
+}
+
+// Reports whether the request's Accept header names the content type itself,
+// rather than only accepting it through a wildcard.
+func acceptsExplicitly(req *Request, ct ContentType) bool {
+	for _, acc := range req.accept {
+		if acc.q >= 0 && acc.part == ct.part && acc.subtype == ct.subtype {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether the client accepts gzip-encoded content through the
+// Accept-Encoding header (RFC-9110 Sec 12.5.3), either by name or through a
+// wildcard. Content codings are not accepted when the header is absent, since
+// the client may not understand them.
+func acceptsGzip(req *Request) bool {
+	vals, found := req.Headers().All("Accept-Encoding")
+	if !found {
+		return false
+	}
+	gzip, wildcard := -1.0, -1.0
+	for _, v := range vals {
+		for coding := range strings.SplitSeq(v, ",") {
+			coding, params, _ := strings.Cut(coding, ";")
+			q := 1.0
+			if raw, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				parsed, err := strconv.ParseFloat(raw, 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				gzip = q
+			case "*":
+				wildcard = q
+			}
+		}
+	}
+	if gzip >= 0 {
+		return gzip > 0
+	}
+	return wildcard > 0
+}
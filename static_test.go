@@ -0,0 +1,423 @@
+package routeit
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestRegisterStatic(t *testing.T) {
+	modified := time.Date(2025, time.July, 1, 10, 0, 0, 0, time.UTC)
+	assets := fstest.MapFS{
+		"app.js":         {Data: []byte("console.log('hi');")},
+		"style.css":      {Data: []byte("body { color: red; }"), ModTime: modified},
+		"img/logo.txt":   {Data: []byte("assets logo")},
+		"nested/doc.txt": {Data: []byte("nested")},
+	}
+	images := fstest.MapFS{"logo.txt": {Data: []byte("images logo")}}
+	root := fstest.MapFS{
+		"index.txt":   {Data: []byte("root index")},
+		"api/shadow":  {Data: []byte("shadowed")},
+		"favicon.txt": {Data: []byte("icon")},
+	}
+	srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+	srv.RegisterStatic("/assets", StaticConfig{Files: assets})
+	srv.RegisterStatic("/assets/img/", StaticConfig{Files: images})
+	srv.RegisterStatic("/", StaticConfig{Files: root})
+	srv.RegisterRoutes(RouteRegistry{
+		"/api/shadow": Get(func(rw *ResponseWriter, req *Request) error {
+			rw.Text("route")
+			return nil
+		}),
+	})
+	client := NewTestClient(srv)
+
+	t.Run("found", func(t *testing.T) {
+		tests := []struct {
+			path   string
+			want   string
+			wantCT string
+		}{
+			{"/assets/app.js", "console.log('hi');", "text/javascript; charset=utf-8"},
+			{"/assets/style.css", "body { color: red; }", "text/css; charset=utf-8"},
+			{"/assets/nested/doc.txt", "nested", "text/plain; charset=utf-8"},
+			{"/assets/img/logo.txt", "images logo", "text/plain; charset=utf-8"},
+			{"/index.txt", "root index", "text/plain; charset=utf-8"},
+			{"/api/shadow", "route", "text/plain"},
+		}
+		for _, tc := range tests {
+			t.Run(tc.path, func(t *testing.T) {
+				res := client.Get(tc.path)
+
+				res.AssertStatusCode(t, StatusOK)
+				res.AssertBodyMatchesString(t, tc.want)
+				res.AssertHeaderMatchesString(t, "Content-Type", tc.wantCT)
+			})
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		tests := []string{
+			"/assets/missing.js",
+			"/assets/nested",
+			"/assets",
+			"/assets/../index.txt",
+			"/assets/..%2Findex.txt",
+			"/assets/nested%2F..%2Fapp.js",
+			"/assets/.%2Fapp.js",
+		}
+		for _, path := range tests {
+			t.Run(path, func(t *testing.T) {
+				res := client.Get(path)
+
+				res.AssertStatusCode(t, StatusNotFound)
+			})
+		}
+	})
+
+	t.Run("validators", func(t *testing.T) {
+		res := client.Get("/assets/style.css")
+		res.AssertHeaderMatchesString(t, "Last-Modified", "Tue, 01 Jul 2025 10:00:00 GMT")
+
+		res = client.Get("/assets/app.js")
+		res.RefuteHeaderPresent(t, "Last-Modified")
+		etag, _ := res.rw.headers.headers.All("ETag")
+		if len(etag) != 1 {
+			t.Fatalf(`ETag = %v, wanted a single tag`, etag)
+		}
+
+		res = client.Get("/assets/app.js", "If-None-Match", etag[0])
+		res.AssertStatusCode(t, StatusNotModified)
+		res.AssertBodyEmpty(t)
+	})
+
+	t.Run("method not allowed", func(t *testing.T) {
+		res := client.PostText("/assets/app.js", "body")
+
+		res.AssertStatusCode(t, StatusMethodNotAllowed)
+	})
+
+	t.Run("from disk", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("Hello World!\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		srv := NewServer(ServerConfig{Debug: true, Namespace: "/api", LoggingHandler: slog.DiscardHandler})
+		srv.RegisterStatic("/files", StaticConfig{Files: os.DirFS(dir)})
+
+		res := NewTestClient(srv).Get("/api/files/hello.txt")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t, "Hello World!\n")
+		res.AssertHeaderMatchesString(t, "Accept-Ranges", "bytes")
+	})
+
+	t.Run("invalid mounts", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			prefix string
+			conf   StaticConfig
+		}{
+			{"no files", "/other", StaticConfig{}},
+			{"duplicate prefix", "assets/", StaticConfig{Files: assets}},
+			{"back-tracking", "/assets/../other", StaticConfig{Files: assets}},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				defer func() {
+					if recover() == nil {
+						t.Error("expected panic")
+					}
+				}()
+
+				srv.RegisterStatic(tc.prefix, tc.conf)
+			})
+		}
+	})
+}
+
+func TestStaticOptions(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":          {Data: []byte("<!DOCTYPE html><p>app</p>")},
+		"docs/index.html":     {Data: []byte("<!DOCTYPE html><p>docs</p>")},
+		"docs/guide.txt":      {Data: []byte("guide")},
+		"builds/v1.0/app.zip": {Data: []byte("zip")},
+		"builds/notes & more.txt": {
+			Data:    []byte("notes"),
+			ModTime: time.Date(2025, time.July, 1, 10, 0, 0, 0, time.UTC),
+		},
+	}
+	serve := func(conf StaticConfig) TestClient {
+		conf.Files = files
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterStatic("/app", conf)
+		return NewTestClient(srv)
+	}
+
+	t.Run("directories", func(t *testing.T) {
+		tests := []struct {
+			name     string
+			conf     StaticConfig
+			path     string
+			headers  []string
+			want     HttpStatus
+			wantBody string
+		}{
+			{name: "no index", path: "/app/docs", want: StatusNotFound},
+			{name: "index", conf: StaticConfig{Index: "index.html"}, path: "/app/docs/", want: StatusOK, wantBody: "<!DOCTYPE html><p>docs</p>"},
+			{name: "root index", conf: StaticConfig{Index: "index.html"}, path: "/app", want: StatusOK, wantBody: "<!DOCTYPE html><p>app</p>"},
+			{name: "missing index", conf: StaticConfig{Index: "index.html"}, path: "/app/builds", want: StatusNotFound},
+			{
+				name:     "fallback for page",
+				conf:     StaticConfig{Fallback: "/index.html"},
+				path:     "/app/users/42",
+				headers:  []string{"Accept", "text/html,application/xhtml+xml,*/*;q=0.8"},
+				want:     StatusOK,
+				wantBody: "<!DOCTYPE html><p>app</p>",
+			},
+			{
+				name:     "fallback for directory",
+				conf:     StaticConfig{Fallback: "index.html"},
+				path:     "/app/builds",
+				headers:  []string{"Accept", "text/html"},
+				want:     StatusOK,
+				wantBody: "<!DOCTYPE html><p>app</p>",
+			},
+			{
+				name:    "no fallback for assets",
+				conf:    StaticConfig{Fallback: "index.html"},
+				path:    "/app/missing.js",
+				headers: []string{"Accept", "*/*"},
+				want:    StatusNotFound,
+			},
+			{
+				name:    "no fallback when html refused",
+				conf:    StaticConfig{Fallback: "index.html"},
+				path:    "/app/users/42",
+				headers: []string{"Accept", "text/html;q=0"},
+				want:    StatusNotFound,
+			},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res := serve(tc.conf).Get(tc.path, tc.headers...)
+
+				res.AssertStatusCode(t, tc.want)
+				if tc.wantBody != "" {
+					res.AssertBodyMatchesString(t, tc.wantBody)
+					res.AssertHeaderMatchesString(t, "Content-Type", "text/html; charset=utf-8")
+				}
+			})
+		}
+	})
+
+	t.Run("trailing slash", func(t *testing.T) {
+		tests := []struct {
+			name         string
+			policy       TrailingSlash
+			path         string
+			want         HttpStatus
+			wantLocation string
+		}{
+			{name: "ignored for directory", path: "/app/docs", want: StatusOK},
+			{name: "ignored for file", path: "/app/docs/guide.txt/", want: StatusOK},
+			{name: "directory redirected", policy: TrailingSlashRedirect, path: "/app/docs?q=1", want: StatusMovedPermanently, wantLocation: "/app/docs/?q=1"},
+			{name: "query kept as sent", policy: TrailingSlashRedirect, path: "/app/docs?b=1&a=2&flag&x=a+b%20c", want: StatusMovedPermanently, wantLocation: "/app/docs/?b=1&a=2&flag&x=a+b%20c"},
+			{name: "file redirected", policy: TrailingSlashRedirect, path: "/app/docs/guide.txt/", want: StatusMovedPermanently, wantLocation: "/app/docs/guide.txt"},
+			{name: "directory with slash", policy: TrailingSlashRedirect, path: "/app/docs/", want: StatusOK},
+			{name: "file without slash", policy: TrailingSlashRedirect, path: "/app/docs/guide.txt", want: StatusOK},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res := serve(StaticConfig{Index: "index.html", TrailingSlash: tc.policy}).Get(tc.path)
+
+				res.AssertStatusCode(t, tc.want)
+				if tc.wantLocation != "" {
+					res.AssertHeaderMatchesString(t, "Location", tc.wantLocation)
+				}
+			})
+		}
+	})
+
+	t.Run("html listing", func(t *testing.T) {
+		res := serve(StaticConfig{Listing: true}).Get("/app/builds", "Accept", "text/html")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertHeaderMatchesString(t, "Content-Type", "text/html; charset=utf-8")
+		res.AssertBodyContainsString(t, "<title>Index of /app/builds/</title>")
+		res.AssertBodyContainsString(t, `<a href="/app/builds/../">../</a>`)
+		res.AssertBodyContainsString(t, `<a href="/app/builds/notes%20&amp;%20more.txt">notes &amp; more.txt</a>`)
+		res.AssertBodyContainsString(t, `<a href="/app/builds/v1.0/">v1.0/</a>`)
+	})
+
+	t.Run("json listing", func(t *testing.T) {
+		res := serve(StaticConfig{Listing: true}).Get("/app/builds/", "Accept", "application/json")
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertBodyMatchesString(t,
+			`[{"name":"notes \u0026 more.txt","dir":false,"size":5,"modified":"2025-07-01T10:00:00Z"},{"name":"v1.0","dir":true,"size":0}]`)
+	})
+
+	t.Run("index takes precedence over listing", func(t *testing.T) {
+		res := serve(StaticConfig{Index: "index.html", Listing: true}).Get("/app/docs")
+
+		res.AssertBodyMatchesString(t, "<!DOCTYPE html><p>docs</p>")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		tests := []struct {
+			name string
+			conf StaticConfig
+		}{
+			{"nested index", StaticConfig{Files: files, Index: "docs/index.html"}},
+			{"back-tracking index", StaticConfig{Files: files, Index: ".."}},
+			{"back-tracking fallback", StaticConfig{Files: files, Fallback: "../index.html"}},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				defer func() {
+					if recover() == nil {
+						t.Error("expected panic")
+					}
+				}()
+
+				NewServer(ServerConfig{}).RegisterStatic("/", tc.conf)
+			})
+		}
+	})
+}
+
+func TestStaticCaching(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":       {Data: []byte("<!DOCTYPE html><p>app</p>")},
+		"app.3f9a.js":      {Data: []byte("console.log('app');")},
+		"app.3f9a.js.gz":   {Data: []byte("\x1f\x8b compressed")},
+		"plain.txt":        {Data: []byte("plain")},
+		"assets/logo.txt":  {Data: []byte("logo")},
+		"orphan.css.gz":    {Data: []byte("\x1f\x8b orphan")},
+		"assets/other.txt": {Data: []byte("other")},
+	}
+	srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+	srv.RegisterStatic("/", StaticConfig{
+		Files:         files,
+		Index:         "index.html",
+		Fallback:      "index.html",
+		Precompressed: true,
+		CachePolicies: []CachePolicy{
+			{Pattern: "*.*.js", CacheControl: "public, max-age=31536000, immutable"},
+			{Pattern: "index.html", CacheControl: "no-cache"},
+			{Pattern: "assets/logo.txt", CacheControl: "max-age=60"},
+		},
+	})
+	client := NewTestClient(srv)
+
+	t.Run("precompressed", func(t *testing.T) {
+		tests := []struct {
+			name           string
+			acceptEncoding []string
+			wantGzip       bool
+		}{
+			{name: "no Accept-Encoding"},
+			{name: "gzip", acceptEncoding: []string{"Accept-Encoding", "gzip, deflate, br"}, wantGzip: true},
+			{name: "x-gzip", acceptEncoding: []string{"Accept-Encoding", "x-gzip"}, wantGzip: true},
+			{name: "wildcard", acceptEncoding: []string{"Accept-Encoding", "br;q=1.0, *;q=0.5"}, wantGzip: true},
+			{name: "gzip refused", acceptEncoding: []string{"Accept-Encoding", "gzip;q=0, *"}},
+			{name: "identity only", acceptEncoding: []string{"Accept-Encoding", "identity"}},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				res := client.Get("/app.3f9a.js", tc.acceptEncoding...)
+
+				res.AssertStatusCode(t, StatusOK)
+				res.AssertHeaderMatchesString(t, "Content-Type", "text/javascript; charset=utf-8")
+				res.AssertHeaderMatchesString(t, "Vary", "Accept-Encoding")
+				if tc.wantGzip {
+					res.AssertBodyMatchesString(t, "\x1f\x8b compressed")
+					res.AssertHeaderMatchesString(t, "Content-Encoding", "gzip")
+				} else {
+					res.AssertBodyMatchesString(t, "console.log('app');")
+					res.RefuteHeaderPresent(t, "Content-Encoding")
+				}
+			})
+		}
+	})
+
+	t.Run("no compressed copy", func(t *testing.T) {
+		res := client.Get("/plain.txt", "Accept-Encoding", "gzip")
+
+		res.AssertBodyMatchesString(t, "plain")
+		res.RefuteHeaderPresent(t, "Content-Encoding")
+	})
+
+	t.Run("compressed copy without original", func(t *testing.T) {
+		res := client.Get("/orphan.css", "Accept-Encoding", "gzip")
+
+		res.AssertStatusCode(t, StatusNotFound)
+	})
+
+	t.Run("representations have their own validators", func(t *testing.T) {
+		plain := client.Get("/app.3f9a.js")
+		etag, _ := plain.rw.headers.headers.All("ETag")
+
+		res := client.Get("/app.3f9a.js", "Accept-Encoding", "gzip", "If-None-Match", etag[0])
+
+		res.AssertStatusCode(t, StatusOK)
+		res.AssertHeaderMatchesString(t, "Content-Encoding", "gzip")
+	})
+
+	t.Run("cache policies", func(t *testing.T) {
+		tests := []struct {
+			path    string
+			headers []string
+			want    string
+		}{
+			{path: "/app.3f9a.js", want: "public, max-age=31536000, immutable"},
+			{path: "/app.3f9a.js", headers: []string{"Accept-Encoding", "gzip"}, want: "public, max-age=31536000, immutable"},
+			{path: "/", want: "no-cache"},
+			{path: "/users/42", headers: []string{"Accept", "text/html"}, want: "no-cache"},
+			{path: "/assets/logo.txt", want: "max-age=60"},
+		}
+		for _, tc := range tests {
+			t.Run(tc.path, func(t *testing.T) {
+				res := client.Get(tc.path, tc.headers...)
+
+				res.AssertStatusCode(t, StatusOK)
+				res.AssertHeaderMatchesString(t, "Cache-Control", tc.want)
+			})
+		}
+
+		for _, path := range []string{"/plain.txt", "/assets/other.txt"} {
+			t.Run(path, func(t *testing.T) {
+				res := client.Get(path)
+
+				res.RefuteHeaderPresent(t, "Cache-Control")
+			})
+		}
+	})
+
+	t.Run("not modified keeps cache policy", func(t *testing.T) {
+		etag, _ := client.Get("/index.html").rw.headers.headers.All("ETag")
+
+		res := client.Get("/index.html", "If-None-Match", etag[0])
+
+		res.AssertStatusCode(t, StatusNotModified)
+		res.AssertHeaderMatchesString(t, "Cache-Control", "no-cache")
+		res.AssertHeaderMatchesString(t, "Vary", "Accept-Encoding")
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic")
+			}
+		}()
+
+		NewServer(ServerConfig{}).RegisterStatic("/", StaticConfig{
+			Files:         files,
+			CachePolicies: []CachePolicy{{Pattern: "[", CacheControl: "no-cache"}},
+		})
+	})
+}
@@ -120,3 +120,9 @@ func (s HttpStatus) isValid() bool {
 func (s HttpStatus) isError() bool {
 	return s.Is4xx() || s.Is5xx()
 }
+
+// Responses with 1xx, 204: No Content and 304: Not Modified statuses never
+// include a body (RFC-9110 Sec 6.4.1).
+func (s HttpStatus) allowsBody() bool {
+	return !s.Is1xx() && s != StatusNoContent && s != StatusNotModified
+}
@@ -1,6 +1,7 @@
 package routeit
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/tls"
 	"encoding/json"
@@ -238,10 +239,10 @@ func (tc TestClient) makeRequest(req testRequest) *TestResponse {
 	rb.WriteString("\r\n")
 	rb.Write(req.body)
 
-	rw := tc.s.handleNewRequest(
-		rb.Bytes(),
-		&net.TCPAddr{IP: []byte{127, 0, 0, 1}, Port: 3000},
-		tc.tlsState,
-	)
+	rw := tc.s.handleNewRequest(&conn{
+		br:       bufio.NewReader(&rb),
+		addr:     &net.TCPAddr{IP: []byte{127, 0, 0, 1}, Port: 3000},
+		tlsState: tc.tlsState,
+	})
 	return &TestResponse{rw}
 }
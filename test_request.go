@@ -75,9 +75,14 @@ func NewTestRequest(t testing.TB, path string, m HttpMethod, opts TestRequestOpt
 		uri:      *uri,
 		headers:  headers,
 		body:     opts.Body,
+		trailers: &RequestHeaders{constructTestHeaders()},
 		ip:       opts.Ip,
 		accept:   parseAcceptHeader(headers),
 		tlsState: opts.TlsConnectionState,
+		scheme:   "http",
+	}
+	if opts.TlsConnectionState != nil {
+		req.scheme = "https"
 	}
 
 	if host, hasHost := headers.First("Host"); hasHost {
@@ -93,6 +98,9 @@ func NewTestRequest(t testing.TB, path string, m HttpMethod, opts TestRequestOpt
 	if req.ip == "" {
 		req.ip = "127.0.0.1"
 	}
+	if req.peer == "" {
+		req.peer = req.ip
+	}
 
 	if req.userAgent == "" {
 		req.userAgent = "routeit-test"
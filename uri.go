@@ -18,6 +18,9 @@ type uri struct {
 	edgePath      []string
 	rewrittenPath []string
 	rawPath       string
+	// The query string as sent by the client, plus any query added by URL
+	// rewrites, so it can be passed on without being re-encoded.
+	rawQuery      string
 	rewritten     bool
 	globalOptions bool
 	pathParams    pathParameters
@@ -74,7 +77,7 @@ func parseUri(uriRaw string) (*uri, *HttpError) {
 		rawPath = "/" + rawPath
 	}
 
-	uri := &uri{edgePath: edgePath, rawPath: rawPath, queryParams: newQueryParams()}
+	uri := &uri{edgePath: edgePath, rawPath: rawPath, rawQuery: rawQuery, queryParams: newQueryParams()}
 
 	if hasQuery {
 		if err := parseQueryParams(rawQuery, uri.queryParams); err != nil {
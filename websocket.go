@@ -0,0 +1,420 @@
+package routeit
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sktylr/routeit/internal/websocket"
+)
+
+const (
+	defaultWebSocketMaxMessageSize = MiB
+	defaultWebSocketWriteTimeout   = 10 * time.Second
+	// Appended to the client's key to produce the Sec-WebSocket-Accept header
+	// (RFC-6455 Sec 4.2.2).
+	webSocketGuid = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errWebSocketClosed = errors.New("websocket: close frame already sent")
+
+// The type of a WebSocket message, which tells the client how to interpret
+// its payload.
+type MessageType uint8
+
+const (
+	// Text messages must be valid UTF-8.
+	TextMessage   MessageType = MessageType(websocket.OpText)
+	BinaryMessage MessageType = MessageType(websocket.OpBinary)
+)
+
+// A CloseCode is sent when closing a WebSocket connection to indicate why it
+// is being closed (RFC-6455 Sec 7.4).
+type CloseCode uint16
+
+const (
+	CloseNormalClosure      CloseCode = 1000
+	CloseGoingAway          CloseCode = 1001
+	CloseProtocolError      CloseCode = 1002
+	CloseUnsupportedData    CloseCode = 1003
+	CloseNoStatusReceived   CloseCode = 1005
+	CloseAbnormalClosure    CloseCode = 1006
+	CloseInvalidPayload     CloseCode = 1007
+	ClosePolicyViolation    CloseCode = 1008
+	CloseMessageTooBig      CloseCode = 1009
+	CloseMandatoryExtension CloseCode = 1010
+	CloseInternalError      CloseCode = 1011
+)
+
+// A CloseError is returned when reading from a WebSocket connection that has
+// been closed, either by the client or because the client broke the protocol.
+// Clients that close the connection without giving a reason are reported with
+// [CloseNoStatusReceived].
+type CloseError struct {
+	Code   CloseCode
+	Reason string
+}
+
+type WebSocketConfig struct {
+	// The size of the largest message that will be accepted from the client,
+	// once all of its fragments have been put together. Clients that send
+	// larger messages are disconnected with [CloseMessageTooBig]. Defaults to
+	// 1 MiB.
+	MaxMessageSize RequestSize
+	// The subprotocols the handler supports. The first protocol requested by
+	// the client that is in this list is chosen and can be found using
+	// [WebSocketConn.Subprotocol]. No subprotocol is chosen if the client does
+	// not request one we support, and it is left to the handler to decide
+	// whether to continue.
+	Subprotocols []string
+	// How long each individual write to the connection may take before the
+	// client is considered to be gone. Defaults to 10 seconds.
+	WriteTimeout time.Duration
+}
+
+// The function that handles a WebSocket connection once the handshake has
+// completed. The connection is closed once it returns.
+type WebSocketFunc func(ws *WebSocketConn, req *Request) error
+
+// A WebSocketConn is a WebSocket connection with a client. Messages may be
+// written from multiple goroutines at once, but only one goroutine may read
+// from the connection at a time.
+type WebSocketConn struct {
+	rwc         net.Conn
+	br          *bufio.Reader
+	maxSize     uint64
+	subprotocol string
+	// Once reading fails, the connection cannot be read from again.
+	readErr error
+
+	mu        sync.Mutex
+	bw        *bufio.Writer
+	closeSent bool
+}
+
+// Creates a handler that upgrades GET requests to WebSocket connections
+// (RFC-6455). The handler is routed and passes through middleware like any
+// other handler, so it can be protected by authentication and similar
+// middleware before the connection is upgraded. Browsers do not apply the
+// same-origin policy to WebSockets, so handlers that rely on cookies for
+// authentication should check the Origin header of the request.
+//
+// Requests that are not a valid opening handshake are rejected with 426:
+// Upgrade Required or 400: Bad Request. Otherwise, the server responds with
+// 101: Switching Protocols and fn is called with the upgraded connection.
+// From then on, the connection belongs to fn, as described by
+// [ResponseWriter.Hijack] - it is no longer subject to the server's write
+// deadline, is not waited for by [Server.Shutdown] and the request's context
+// is not cancelled if the client goes away, which is instead reported by
+// [WebSocketConn.ReadMessage]. Errors returned from fn are logged
+// and the connection is closed with [CloseInternalError].
+//
+// The connection cannot be upgraded when using the [TestClient], since there
+// is no connection to take over.
+func WebSocket(conf WebSocketConfig, fn WebSocketFunc) Handler {
+	maxSize := conf.MaxMessageSize
+	if maxSize == 0 {
+		maxSize = defaultWebSocketMaxMessageSize
+	}
+	timeout := conf.WriteTimeout
+	if timeout == 0 {
+		timeout = defaultWebSocketWriteTimeout
+	}
+
+	return Get(func(rw *ResponseWriter, req *Request) error {
+		accept, err := webSocketAccept(req)
+		if err != nil {
+			return err
+		}
+		rwc, br, err := rw.Hijack()
+		if err != nil {
+			return err
+		}
+		defer rwc.Close()
+
+		ws := &WebSocketConn{
+			rwc:         rwc,
+			br:          br,
+			bw:          bufio.NewWriter(deadlineWriter{rwc, timeout}),
+			maxSize:     uint64(maxSize),
+			subprotocol: selectSubprotocol(req, conf.Subprotocols),
+		}
+		rw.clear()
+		rw.Status(StatusSwitchingProtocols)
+		rw.headers.Set("Upgrade", "websocket")
+		rw.headers.Set("Connection", "Upgrade")
+		rw.headers.Set("Sec-WebSocket-Accept", accept)
+		if ws.subprotocol != "" {
+			rw.headers.Set("Sec-WebSocket-Protocol", ws.subprotocol)
+		}
+		if _, err := ws.bw.Write(rw.head()); err != nil {
+			return err
+		}
+		if err := ws.bw.Flush(); err != nil {
+			return err
+		}
+
+		err = fn(ws, req)
+		code := CloseNormalClosure
+		if err != nil {
+			code = CloseInternalError
+		}
+		ws.Close(code, "")
+		return err
+	})
+}
+
+// Validates the client's opening handshake (RFC-6455 Sec 4.2.1), returning the
+// value of the Sec-WebSocket-Accept header the server must respond with.
+func webSocketAccept(req *Request) (string, error) {
+	if req.Method() != GET || !req.headers.headers.ContainsToken("Upgrade", "websocket") {
+		// Plain HTTP requests are told which protocol they should be using.
+		err := ErrUpgradeRequired().WithMessage("Expected a WebSocket handshake")
+		err.headers.Set("Upgrade", "websocket")
+		return "", err
+	}
+	if !req.headers.headers.ContainsToken("Connection", "upgrade") {
+		return "", ErrBadRequest().WithMessage(`Connection header must contain "Upgrade"`)
+	}
+	if version, _ := req.Headers().Last("Sec-WebSocket-Version"); version != "13" {
+		// Clients are told which versions we do support, so they can retry
+		// with one of them (RFC-6455 Sec 4.4).
+		err := ErrUpgradeRequired().WithMessagef("Unsupported WebSocket version %#q", version)
+		err.headers.Set("Sec-WebSocket-Version", "13")
+		return "", err
+	}
+	key, _, err := req.Headers().Only("Sec-WebSocket-Key")
+	if err != nil {
+		return "", err
+	}
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return "", ErrBadRequest().WithMessage("Sec-WebSocket-Key must be a base64 encoded 16 byte value")
+	}
+
+	sum := sha1.Sum([]byte(key + webSocketGuid))
+	return base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// Picks the first of the subprotocols requested by the client that is also
+// supported by the server.
+func selectSubprotocol(req *Request, supported []string) string {
+	requested, _ := req.Headers().All("Sec-WebSocket-Protocol")
+	for _, line := range requested {
+		for p := range strings.SplitSeq(line, ",") {
+			p = strings.TrimSpace(p)
+			for _, s := range supported {
+				if p == s {
+					return p
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Reads the next message sent by the client, putting it back together if it
+// was fragmented. Pings are answered and pongs are discarded while waiting for
+// the message. A [*CloseError] is returned once the connection is closed,
+// which happens when the client closes it, or when the client breaks the
+// protocol or sends a message that is too large, in which case the connection
+// is closed by the server. Once an error has been returned, the same error is
+// returned by all subsequent calls.
+func (ws *WebSocketConn) ReadMessage() (MessageType, []byte, error) {
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+
+	var mt MessageType
+	var msg []byte
+	for {
+		h, err := websocket.ReadHeader(ws.br)
+		if err != nil {
+			return 0, nil, ws.readFailed(err)
+		}
+		// Clients must mask every frame they send (RFC-6455 Sec 5.1).
+		if !h.Masked {
+			return 0, nil, ws.fail(CloseProtocolError, "frame is not masked")
+		}
+		if !h.Opcode.IsControl() && h.Length > ws.maxSize-uint64(len(msg)) {
+			return 0, nil, ws.fail(CloseMessageTooBig, "message is too large")
+		}
+		payload, err := websocket.ReadPayload(ws.br, h)
+		if err != nil {
+			return 0, nil, ws.readFailed(err)
+		}
+
+		switch h.Opcode {
+		case websocket.OpPing:
+			if err := ws.writeFrame(websocket.OpPong, payload); err != nil && !errors.Is(err, errWebSocketClosed) {
+				ws.readErr = err
+				return 0, nil, err
+			}
+			continue
+		case websocket.OpPong:
+			continue
+		case websocket.OpClose:
+			return 0, nil, ws.closeReceived(payload)
+		case websocket.OpText, websocket.OpBinary:
+			if mt != 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "expected continuation frame")
+			}
+			mt = MessageType(h.Opcode)
+		case websocket.OpContinuation:
+			if mt == 0 {
+				return 0, nil, ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		}
+
+		msg = append(msg, payload...)
+		if !h.Fin {
+			continue
+		}
+		if mt == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, ws.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		if msg == nil {
+			msg = []byte{}
+		}
+		return mt, msg, nil
+	}
+}
+
+// Sends a message to the client in a single frame.
+func (ws *WebSocketConn) WriteMessage(mt MessageType, data []byte) error {
+	if mt != TextMessage && mt != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", mt)
+	}
+	return ws.writeFrame(websocket.Opcode(mt), data)
+}
+
+// Sends a ping to the client, which it should answer with a pong carrying the
+// same data. The data may be at most 125 bytes long.
+func (ws *WebSocketConn) Ping(data []byte) error {
+	return ws.writeControl(websocket.OpPing, data)
+}
+
+// Sends an unsolicited pong to the client, which can be used as a one-way
+// heartbeat. The data may be at most 125 bytes long. Pings sent by the client
+// are answered automatically while reading from the connection.
+func (ws *WebSocketConn) Pong(data []byte) error {
+	return ws.writeControl(websocket.OpPong, data)
+}
+
+// Sends a close frame to the client with the given code and reason, after
+// which no more messages can be sent. The client should respond by closing
+// the connection too, which can be waited for by reading from the connection
+// until a [*CloseError] is returned. The underlying connection is closed once
+// the handler returns. Calling Close once a close frame has been sent has no
+// effect.
+func (ws *WebSocketConn) Close(code CloseCode, reason string) error {
+	if !code.sendable() {
+		return fmt.Errorf("websocket: close code %d cannot be sent", code)
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+	err := ws.writeControl(websocket.OpClose, payload)
+	if errors.Is(err, errWebSocketClosed) {
+		return nil
+	}
+	return err
+}
+
+// The subprotocol chosen during the handshake, or an empty string if none was.
+func (ws *WebSocketConn) Subprotocol() string {
+	return ws.subprotocol
+}
+
+// Sets the time after which [WebSocketConn.ReadMessage] gives up waiting for
+// the client. A zero value means reads do not time out, which is the default.
+func (ws *WebSocketConn) SetReadDeadline(t time.Time) error {
+	return ws.rwc.SetReadDeadline(t)
+}
+
+func (ws *WebSocketConn) writeControl(op websocket.Opcode, payload []byte) error {
+	if len(payload) > websocket.MaxControlPayload {
+		return fmt.Errorf("websocket: control frame payload cannot exceed %d bytes", websocket.MaxControlPayload)
+	}
+	return ws.writeFrame(op, payload)
+}
+
+func (ws *WebSocketConn) writeFrame(op websocket.Opcode, payload []byte) error {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if ws.closeSent {
+		return errWebSocketClosed
+	}
+	if op == websocket.OpClose {
+		ws.closeSent = true
+	}
+	if err := websocket.WriteFrame(ws.bw, websocket.Header{Fin: true, Opcode: op}, payload); err != nil {
+		return err
+	}
+	return ws.bw.Flush()
+}
+
+// Handles a close frame from the client, replying with the same status code
+// if we have not already sent a close frame of our own (RFC-6455 Sec 5.5.1).
+func (ws *WebSocketConn) closeReceived(payload []byte) error {
+	if len(payload) == 0 {
+		ws.writeFrame(websocket.OpClose, nil)
+		ws.readErr = &CloseError{Code: CloseNoStatusReceived}
+		return ws.readErr
+	}
+	if len(payload) == 1 {
+		return ws.fail(CloseProtocolError, "invalid close frame")
+	}
+	code := CloseCode(binary.BigEndian.Uint16(payload))
+	if !code.sendable() {
+		return ws.fail(CloseProtocolError, "invalid close code")
+	}
+	reason := payload[2:]
+	if !utf8.Valid(reason) {
+		return ws.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+	}
+	ws.writeFrame(websocket.OpClose, payload[:2])
+	ws.readErr = &CloseError{Code: code, Reason: string(reason)}
+	return ws.readErr
+}
+
+// Fails the connection after the client has broken the protocol, letting the
+// client know why before the connection is closed (RFC-6455 Sec 7.1.7).
+func (ws *WebSocketConn) fail(code CloseCode, reason string) error {
+	ws.Close(code, reason)
+	ws.readErr = &CloseError{Code: code, Reason: reason}
+	return ws.readErr
+}
+
+func (ws *WebSocketConn) readFailed(err error) error {
+	if errors.Is(err, websocket.ErrProtocol) {
+		return ws.fail(CloseProtocolError, "malformed frame")
+	}
+	ws.readErr = err
+	return err
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket closed with code %d", e.Code)
+	}
+	return fmt.Sprintf("websocket closed with code %d: %s", e.Code, e.Reason)
+}
+
+// Reports whether the code may be sent in a close frame. Some codes are
+// reserved for reporting closures that happened without a close frame, while
+// others are reserved for future use (RFC-6455 Sec 7.4).
+func (c CloseCode) sendable() bool {
+	switch {
+	case c >= 1000 && c <= 1014:
+		return c != 1004 && c != CloseNoStatusReceived && c != CloseAbnormalClosure
+	default:
+		return c >= 3000 && c <= 4999
+	}
+}
@@ -0,0 +1,441 @@
+package routeit
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sktylr/routeit/internal/websocket"
+)
+
+func TestWebSocketHandshake(t *testing.T) {
+	valid := []string{
+		"Upgrade", "websocket",
+		"Connection", "Upgrade",
+		"Sec-WebSocket-Version", "13",
+		"Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==",
+	}
+	with := func(key, val string) []string {
+		h := make([]string, 0, len(valid))
+		for i := 0; i < len(valid); i += 2 {
+			if valid[i] != key {
+				h = append(h, valid[i], valid[i+1])
+			}
+		}
+		if val != "" {
+			h = append(h, key, val)
+		}
+		return h
+	}
+
+	tests := []struct {
+		name       string
+		headers    []string
+		head       bool
+		wantStatus HttpStatus
+		wantHeader []string
+	}{
+		{
+			name:       "plain request",
+			wantStatus: StatusUpgradeRequired,
+			wantHeader: []string{"Upgrade", "websocket"},
+		},
+		{
+			name:       "HEAD request",
+			headers:    valid,
+			head:       true,
+			wantStatus: StatusUpgradeRequired,
+			wantHeader: []string{"Upgrade", "websocket"},
+		},
+		{
+			name:       "upgrade to another protocol",
+			headers:    with("Upgrade", "h2c"),
+			wantStatus: StatusUpgradeRequired,
+			wantHeader: []string{"Upgrade", "websocket"},
+		},
+		{
+			name:       "connection not upgraded",
+			headers:    with("Connection", "keep-alive"),
+			wantStatus: StatusBadRequest,
+		},
+		{
+			name:       "unsupported version",
+			headers:    with("Sec-WebSocket-Version", "8"),
+			wantStatus: StatusUpgradeRequired,
+			wantHeader: []string{"Sec-WebSocket-Version", "13"},
+		},
+		{
+			name:       "missing key",
+			headers:    with("Sec-WebSocket-Key", ""),
+			wantStatus: StatusBadRequest,
+		},
+		{
+			name:       "key is not base64",
+			headers:    with("Sec-WebSocket-Key", "not a key"),
+			wantStatus: StatusBadRequest,
+		},
+		{
+			name:       "key is the wrong length",
+			headers:    with("Sec-WebSocket-Key", "c2hvcnQ="),
+			wantStatus: StatusBadRequest,
+		},
+		{
+			name:       "test client cannot upgrade",
+			headers:    valid,
+			wantStatus: StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+			srv.RegisterRoutes(RouteRegistry{
+				"/ws": WebSocket(WebSocketConfig{}, func(ws *WebSocketConn, req *Request) error {
+					t.Error("did not expect the connection to be upgraded")
+					return nil
+				}),
+			})
+			client := NewTestClient(srv)
+
+			var res *TestResponse
+			if tc.head {
+				res = client.Head("/ws", tc.headers...)
+			} else {
+				res = client.Get("/ws", tc.headers...)
+			}
+
+			res.AssertStatusCode(t, tc.wantStatus)
+			if tc.wantHeader != nil {
+				res.AssertHeaderMatchesString(t, tc.wantHeader[0], tc.wantHeader[1])
+			}
+		})
+	}
+}
+
+func TestWebSocketConn(t *testing.T) {
+	type frame struct {
+		fin     bool
+		op      websocket.Opcode
+		payload string
+	}
+	dial := func(t *testing.T, conf WebSocketConfig, fn WebSocketFunc, h ...string) (net.Conn, *bufio.Reader, *http.Response, chan struct{}) {
+		t.Helper()
+		srv := NewServer(ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler})
+		srv.RegisterRoutes(RouteRegistry{"/ws": WebSocket(conf, fn)})
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		done := make(chan struct{})
+		go func() {
+			srv.handleNewConnection(server)
+			close(done)
+		}()
+
+		handshake := "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"
+		for i := 0; i < len(h); i += 2 {
+			handshake += h[i] + ": " + h[i+1] + "\r\n"
+		}
+		go client.Write([]byte(handshake + "\r\n"))
+		br := bufio.NewReader(client)
+		res, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("failed to read handshake response: %v", err)
+		}
+		return client, br, res, done
+	}
+	send := func(t *testing.T, conn net.Conn, frames ...frame) {
+		t.Helper()
+		go func() {
+			for _, f := range frames {
+				h := websocket.Header{Fin: f.fin, Opcode: f.op, Masked: true, Mask: [4]byte{1, 2, 3, 4}}
+				if err := websocket.WriteFrame(conn, h, []byte(f.payload)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+	receive := func(t *testing.T, br *bufio.Reader) frame {
+		t.Helper()
+		h, err := websocket.ReadHeader(br)
+		if err != nil {
+			t.Fatalf("failed to read frame header: %v", err)
+		}
+		if h.Masked {
+			t.Error("server frames must not be masked")
+		}
+		payload, err := websocket.ReadPayload(br, h)
+		if err != nil {
+			t.Fatalf("failed to read frame payload: %v", err)
+		}
+		return frame{fin: h.Fin, op: h.Opcode, payload: string(payload)}
+	}
+	closeFrame := func(code CloseCode, reason string) frame {
+		payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+		return frame{fin: true, op: websocket.OpClose, payload: string(payload) + reason}
+	}
+	echo := func(ws *WebSocketConn, req *Request) error {
+		for {
+			mt, msg, err := ws.ReadMessage()
+			if err != nil {
+				var ce *CloseError
+				if errors.As(err, &ce) {
+					return nil
+				}
+				return err
+			}
+			if err := ws.WriteMessage(mt, msg); err != nil {
+				return err
+			}
+		}
+	}
+
+	t.Run("completes handshake", func(t *testing.T) {
+		_, _, res, _ := dial(t, WebSocketConfig{}, echo)
+
+		if res.StatusCode != 101 {
+			t.Errorf(`status = %d, wanted 101`, res.StatusCode)
+		}
+		if got := res.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf(`Sec-WebSocket-Accept = %#q, wanted "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="`, got)
+		}
+		if got := res.Header.Get("Upgrade"); got != "websocket" {
+			t.Errorf(`Upgrade = %#q, wanted "websocket"`, got)
+		}
+		if res.Header.Get("Sec-WebSocket-Protocol") != "" {
+			t.Error("did not expect a subprotocol to be chosen")
+		}
+	})
+
+	t.Run("negotiates subprotocol", func(t *testing.T) {
+		var got string
+		conf := WebSocketConfig{Subprotocols: []string{"v1.chat", "v2.chat"}}
+		_, br, res, done := dial(t, conf, func(ws *WebSocketConn, req *Request) error {
+			got = ws.Subprotocol()
+			return nil
+		}, "Sec-WebSocket-Protocol", "v3.chat, v2.chat", "Sec-WebSocket-Protocol", "v1.chat")
+		receive(t, br)
+		<-done
+
+		if h := res.Header.Get("Sec-WebSocket-Protocol"); h != "v2.chat" {
+			t.Errorf(`Sec-WebSocket-Protocol = %#q, wanted "v2.chat"`, h)
+		}
+		if got != "v2.chat" {
+			t.Errorf(`Subprotocol() = %#q, wanted "v2.chat"`, got)
+		}
+	})
+
+	t.Run("echoes messages", func(t *testing.T) {
+		conn, br, _, _ := dial(t, WebSocketConfig{}, echo)
+
+		send(t, conn,
+			frame{fin: true, op: websocket.OpText, payload: "hello"},
+			frame{fin: true, op: websocket.OpBinary, payload: "\x00\x01"},
+		)
+
+		if got := receive(t, br); got != (frame{fin: true, op: websocket.OpText, payload: "hello"}) {
+			t.Errorf(`first frame = %+v`, got)
+		}
+		if got := receive(t, br); got != (frame{fin: true, op: websocket.OpBinary, payload: "\x00\x01"}) {
+			t.Errorf(`second frame = %+v`, got)
+		}
+	})
+
+	t.Run("reassembles fragmented messages around control frames", func(t *testing.T) {
+		conn, br, _, _ := dial(t, WebSocketConfig{}, echo)
+
+		send(t, conn,
+			frame{op: websocket.OpText, payload: "hel"},
+			frame{fin: true, op: websocket.OpPing, payload: "are you there?"},
+			frame{op: websocket.OpContinuation, payload: "lo "},
+			frame{fin: true, op: websocket.OpPong},
+			frame{fin: true, op: websocket.OpContinuation, payload: "world"},
+		)
+
+		if got := receive(t, br); got != (frame{fin: true, op: websocket.OpPong, payload: "are you there?"}) {
+			t.Errorf(`first frame = %+v, wanted pong`, got)
+		}
+		if got := receive(t, br); got != (frame{fin: true, op: websocket.OpText, payload: "hello world"}) {
+			t.Errorf(`second frame = %+v, wanted reassembled message`, got)
+		}
+	})
+
+	t.Run("client closes connection", func(t *testing.T) {
+		var readErr error
+		conn, br, _, done := dial(t, WebSocketConfig{}, func(ws *WebSocketConn, req *Request) error {
+			_, _, readErr = ws.ReadMessage()
+			return nil
+		})
+
+		send(t, conn, closeFrame(CloseGoingAway, "bye"))
+
+		if got := receive(t, br); got != closeFrame(CloseGoingAway, "") {
+			t.Errorf(`frame = %+v, wanted close echoing the code`, got)
+		}
+		<-done
+		var ce *CloseError
+		if !errors.As(readErr, &ce) || *ce != (CloseError{Code: CloseGoingAway, Reason: "bye"}) {
+			t.Errorf(`ReadMessage() error = %v, wanted close error with code 1001`, readErr)
+		}
+		if _, err := br.ReadByte(); err != io.EOF {
+			t.Errorf(`expected connection to be closed, got %v`, err)
+		}
+	})
+
+	t.Run("closes connection once handler returns", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			err  error
+			want CloseCode
+		}{
+			"success": {want: CloseNormalClosure},
+			"error":   {err: errors.New("oops"), want: CloseInternalError},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, br, _, _ := dial(t, WebSocketConfig{}, func(ws *WebSocketConn, req *Request) error {
+					return tc.err
+				})
+
+				if got := receive(t, br); got != closeFrame(tc.want, "") {
+					t.Errorf(`frame = %+v, wanted close with code %d`, got, tc.want)
+				}
+				if _, err := br.ReadByte(); err != io.EOF {
+					t.Errorf(`expected connection to be closed, got %v`, err)
+				}
+			})
+		}
+	})
+
+	t.Run("outlives write deadline", func(t *testing.T) {
+		srvConf := ServerConfig{Debug: true, LoggingHandler: slog.DiscardHandler, WriteDeadline: 20 * time.Millisecond}
+		srv := NewServer(srvConf)
+		srv.RegisterRoutes(RouteRegistry{"/ws": WebSocket(WebSocketConfig{}, func(ws *WebSocketConn, req *Request) error {
+			time.Sleep(50 * time.Millisecond)
+			return ws.WriteMessage(TextMessage, []byte("still here"))
+		})})
+		client, server := net.Pipe()
+		defer client.Close()
+		go srv.handleNewConnection(server)
+
+		go client.Write([]byte("GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n"))
+		br := bufio.NewReader(client)
+		if _, err := http.ReadResponse(br, &http.Request{Method: "GET"}); err != nil {
+			t.Fatalf("failed to read handshake response: %v", err)
+		}
+
+		if got := receive(t, br); got != (frame{fin: true, op: websocket.OpText, payload: "still here"}) {
+			t.Errorf(`frame = %+v`, got)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		conf     WebSocketConfig
+		frames   []frame
+		unmasked bool
+		want     CloseCode
+	}{
+		{
+			name:     "unmasked frame",
+			frames:   []frame{{fin: true, op: websocket.OpText, payload: "hello"}},
+			unmasked: true,
+			want:     CloseProtocolError,
+		},
+		{
+			name:   "reserved opcode",
+			frames: []frame{{fin: true, op: 0x3}},
+			want:   CloseProtocolError,
+		},
+		{
+			name:   "unexpected continuation",
+			frames: []frame{{fin: true, op: websocket.OpContinuation, payload: "hello"}},
+			want:   CloseProtocolError,
+		},
+		{
+			name: "new message before previous one finished",
+			frames: []frame{
+				{op: websocket.OpText, payload: "hel"},
+				{fin: true, op: websocket.OpText, payload: "lo"},
+			},
+			want: CloseProtocolError,
+		},
+		{
+			name:   "invalid UTF-8",
+			frames: []frame{{fin: true, op: websocket.OpText, payload: "\xff\xfe"}},
+			want:   CloseInvalidPayload,
+		},
+		{
+			name:   "message too large",
+			conf:   WebSocketConfig{MaxMessageSize: 4},
+			frames: []frame{{fin: true, op: websocket.OpBinary, payload: "hello"}},
+			want:   CloseMessageTooBig,
+		},
+		{
+			name: "fragmented message too large",
+			conf: WebSocketConfig{MaxMessageSize: 4},
+			frames: []frame{
+				{op: websocket.OpBinary, payload: "hel"},
+				{fin: true, op: websocket.OpContinuation, payload: "lo"},
+			},
+			want: CloseMessageTooBig,
+		},
+		{
+			name:   "invalid close code",
+			frames: []frame{closeFrame(CloseAbnormalClosure, "")},
+			want:   CloseProtocolError,
+		},
+	}
+	for _, tc := range tests {
+		t.Run("fails connection on "+tc.name, func(t *testing.T) {
+			var readErr error
+			conn, br, _, done := dial(t, tc.conf, func(ws *WebSocketConn, req *Request) error {
+				_, _, readErr = ws.ReadMessage()
+				return nil
+			})
+
+			if tc.unmasked {
+				go func() {
+					for _, f := range tc.frames {
+						websocket.WriteFrame(conn, websocket.Header{Fin: f.fin, Opcode: f.op}, []byte(f.payload))
+					}
+				}()
+			} else {
+				send(t, conn, tc.frames...)
+			}
+
+			got := receive(t, br)
+			if got.op != websocket.OpClose || len(got.payload) < 2 {
+				t.Fatalf(`frame = %+v, wanted close frame`, got)
+			}
+			if code := CloseCode(binary.BigEndian.Uint16([]byte(got.payload))); code != tc.want {
+				t.Errorf(`close code = %d, wanted %d`, code, tc.want)
+			}
+			go io.Copy(io.Discard, conn)
+			<-done
+			var ce *CloseError
+			if !errors.As(readErr, &ce) || ce.Code != tc.want {
+				t.Errorf(`ReadMessage() error = %v, wanted close error with code %d`, readErr, tc.want)
+			}
+		})
+	}
+
+	t.Run("rejects oversized control frames", func(t *testing.T) {
+		_, br, _, done := dial(t, WebSocketConfig{}, func(ws *WebSocketConn, req *Request) error {
+			if err := ws.Ping([]byte(strings.Repeat("a", 126))); err == nil {
+				t.Error("expected oversized ping to be rejected")
+			}
+			return nil
+		})
+
+		if got := receive(t, br); got.op != websocket.OpClose {
+			t.Errorf(`frame = %+v, wanted close frame`, got)
+		}
+		<-done
+	})
+}